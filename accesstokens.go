@@ -0,0 +1,251 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ErrUnknownKey is returned when a JWT was signed with a key not published by
+// the KeyProvider.
+var ErrUnknownKey = errors.New("oauth2: JWT was signed with an unknown key")
+
+// accessTokenClaims are the claims of self-contained access tokens.
+type accessTokenClaims struct {
+	jwt.Claims
+	ClientID string   `json:"client_id"`
+	Scope    string   `json:"scope,omitempty"`
+	AMR      []string `json:"amr,omitempty"`
+	IDP      string   `json:"idp,omitempty"`
+}
+
+// SetAccessTokenSigningAlgorithm sets the algorithm JWT access tokens are
+// signed with, either RS256, ES256 or EdDSA. Defaults to the algorithm of the
+// current signing key. The algorithm clients register for ID tokens, with
+// id_token_signed_response_alg, does not apply to access tokens, which are
+// meant for resource servers.
+func SetAccessTokenSigningAlgorithm(alg string) option {
+	return func(c *config) {
+		for _, a := range jwt.Algorithms {
+			if a == alg {
+				c.accessTokenAlg = alg
+				return
+			}
+		}
+		log.Fatalf("Unsupported access token signing algorithm: %q", alg)
+	}
+}
+
+// genToken generates an access token in the format chosen by the client.
+func genToken(req *http.Request, cfg config, grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	token, err := cfg.provider.GenToken(grant, client, refreshToken, expiration)
+	if err != nil {
+		return token, err
+	}
+
+	if err := token.Validate(); err != nil {
+		return token, err
+	}
+
+	if err := enforceTokenQuota(req, cfg, token); err != nil {
+		return token, err
+	}
+
+	token, err = formatToken(req, cfg, client, token, expiration)
+	if err != nil {
+		return token, err
+	}
+
+	recordIssuance(req, cfg, token)
+	notifyNewDevice(req, cfg, client, token)
+	return prefixToken(cfg, token), nil
+}
+
+// refreshAccessToken refreshes an access token in the format chosen by the client.
+func refreshAccessToken(req *http.Request, cfg config, client types.Client, refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	var token types.Token
+	var err error
+	if p, ok := asExpiringRefreshProvider(cfg.provider); ok {
+		token, err = p.RefreshTokenWithExpiration(refreshToken, scopes, expiration)
+	} else {
+		token, err = cfg.provider.RefreshToken(refreshToken, scopes)
+		if err == nil {
+			token = limitExpiration(cfg, token, expiration)
+		}
+	}
+	if err != nil {
+		return token, err
+	}
+
+	if err := token.Validate(); err != nil {
+		return token, err
+	}
+
+	token, err = formatToken(req, cfg, client, token, expiration)
+	if err != nil {
+		return token, err
+	}
+
+	recordIssuance(req, cfg, token)
+	return prefixToken(cfg, token), nil
+}
+
+// limitExpiration shortens the lifetime of a token refreshed by a provider
+// not implementing ExpiringRefreshProvider to the given expiration, if it is
+// longer.
+func limitExpiration(cfg config, token types.Token, expiration time.Duration) types.Token {
+	expiresAt := now(cfg).Add(expiration)
+	if expiration <= 0 || (!token.ExpiresAt.IsZero() && !token.ExpiresAt.After(expiresAt)) {
+		return token
+	}
+
+	token.ExpiresAt = expiresAt
+	token.ExpiresIn = strconv.FormatFloat(expiration.Seconds(), 'f', -1, 64)
+	return token
+}
+
+// formatToken turns the access token issued by the provider into a
+// self-contained JWT if the client asked for it. The token issued by the
+// provider becomes the JWT ID, so it can still be looked up in order to
+// revoke it.
+func formatToken(req *http.Request, cfg config, client types.Client, token types.Token, expiration time.Duration) (types.Token, error) {
+	if client.TokenFormat != types.TokenFormatJWT {
+		return token, nil
+	}
+
+	// Tokens act for the resource owner, or for the client itself.
+	subject := token.UserID
+	if subject == "" {
+		subject = client.ID
+	}
+
+	issuedAt := now(cfg)
+	claims := accessTokenClaims{
+		Claims: jwt.Claims{
+			Issuer:    issuerURL(req, cfg),
+			Subject:   subject,
+			IssuedAt:  issuedAt.Unix(),
+			ExpiresAt: issuedAt.Add(expiration).Unix(),
+			ID:        token.Value,
+			Audience:  jwt.Audience(token.Audience),
+		},
+		ClientID: client.ID,
+		Scope:    token.Scopes.Encode(),
+		AMR:      token.AMR,
+		IDP:      token.IdentityProvider,
+	}
+
+	signed, err := signJWT(cfg, cfg.accessTokenAlg, claims)
+	if err != nil {
+		return token, err
+	}
+
+	token.Value = signed
+	return token, nil
+}
+
+// isJWT tells whether a token looks like a JWT rather than a reference token.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// verifyAccessToken verifies a self-contained access token and returns its
+// information.
+func verifyAccessToken(cfg config, raw string) (types.Token, error) {
+	token, err := jwt.Parse(raw)
+	if err != nil {
+		return types.Token{}, err
+	}
+
+	if err := verifySignature(cfg, token); err != nil {
+		return types.Token{}, err
+	}
+
+	if err := token.Claims.Validate(now(cfg), 0); err != nil {
+		return types.Token{}, err
+	}
+
+	var claims accessTokenClaims
+	if err := token.Decode(&claims); err != nil {
+		return types.Token{}, err
+	}
+
+	info := types.Token{
+		ClientID:  claims.ClientID,
+		Value:     raw,
+		Type:      "bearer",
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0),
+		Audience:  claims.Audience,
+
+		AMR:              claims.AMR,
+		IdentityProvider: claims.IDP,
+	}
+
+	if claims.Subject != claims.ClientID {
+		info.UserID = claims.Subject
+	}
+
+	for _, s := range strings.Fields(claims.Scope) {
+		info.Scopes = append(info.Scopes, types.Scope{ID: s})
+	}
+	return info, nil
+}
+
+// accessTokenID returns the identifier of the token as known by the
+// provider, which for self-contained tokens is their JWT ID.
+func accessTokenID(cfg config, token string) string {
+	token = unprefix(cfg.tokenPrefixes.accessToken, token)
+	if cfg.keyProvider == nil || !isJWT(token) {
+		return token
+	}
+
+	parsed, err := jwt.Parse(token)
+	if err != nil {
+		return token
+	}
+
+	if verifySignature(cfg, parsed) != nil {
+		return token
+	}
+	return parsed.Claims.ID
+}
+
+// verifySignature verifies a JWT signed by the authorization server with the
+// key identified by its "kid" header, or with any key if it has none. Keys
+// that expired and algorithms not allowed are not accepted.
+func verifySignature(cfg config, token *jwt.Token) error {
+	if cfg.keyProvider == nil {
+		return ErrNoSigningKey
+	}
+
+	if !allowedAlgorithm(cfg, token.Header.Algorithm) {
+		return ErrSigningAlgorithmNotAllowed
+	}
+
+	keys, err := publicKeys(cfg)
+	if err != nil {
+		return err
+	}
+
+	err = ErrUnknownKey
+	for _, k := range keys {
+		if token.Header.KeyID != "" && k.ID != token.Header.KeyID {
+			continue
+		}
+
+		if err = token.Verify(k.Key); err == nil {
+			return nil
+		}
+	}
+	return err
+}
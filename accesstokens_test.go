@@ -0,0 +1,199 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestJWTAccessTokens tests that clients can get self-contained access
+// tokens, while reference tokens keep working for other clients.
+func TestJWTAccessTokens(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	signingKey := SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})
+
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+	signingKey(&cfg)
+
+	issueToken := func() types.Token {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials&scope=read"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		token := types.Token{}
+		err = json.Unmarshal(w.Body.Bytes(), &token)
+		ok(t, err)
+		return token
+	}
+
+	opaque := issueToken()
+	assert(t, !isJWT(opaque.Value), "expected a reference token, got %s", opaque.Value)
+
+	provider.Client.TokenFormat = types.TokenFormatJWT
+	SetIssuer("https://auth.example.com")(&cfg)
+	token := issueToken()
+	assert(t, isJWT(token.Value), "expected a JWT, got %s", token.Value)
+
+	// Client credentials act for the client itself.
+	parsed, err := jwt.Parse(token.Value)
+	ok(t, err)
+	equals(t, provider.Client.ID, parsed.Claims.Subject)
+	equals(t, "https://auth.example.com", parsed.Claims.Issuer)
+
+	handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	}), provider, signingKey)
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{opaque.Value, http.StatusOK},
+		{token.Value, http.StatusOK},
+		{token.Value[:len(token.Value)-4] + "AAAA", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, tt.status, w.Code)
+	}
+
+	// Self-contained tokens can be revoked using their JWT ID.
+	req, err := http.NewRequest("DELETE", "https://example.com/oauth2/tokens/"+token.Value, nil)
+	ok(t, err)
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	RevokeToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	_, found := provider.AccessTokens[accessTokenID(cfg, token.Value)]
+	assert(t, !found, "expected token to be revoked")
+}
+
+// TestAuthenticationMethodClaims tests that how resource owners logged in is
+// recorded in grants, and sent in JWT access tokens and introspection responses.
+func TestAuthenticationMethodClaims(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	provider := test.NewProvider(true)
+	provider.AMR = []string{"fed", "otp"}
+	provider.IdentityProvider = "https://idp.acme.com"
+	provider.Client.TokenFormat = types.TokenFormatJWT
+	cfg := setupTest()
+	cfg.provider = provider
+	SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})(&cfg)
+
+	body := authzRequest(t, cfg).URL.RawQuery + "&" + ConsentParam + "=approve"
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	grant, err := grantInfo(cfg, u.Query().Get("code"))
+	ok(t, err)
+	equals(t, []string{"fed", "otp"}, grant.AMR)
+	equals(t, "https://idp.acme.com", grant.IdentityProvider)
+
+	values := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {u.Query().Get("code")},
+		"redirect_uri": {provider.Client.RedirectURL.String()},
+	}
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	var token types.Token
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	parsed, err := jwt.Parse(token.Value)
+	ok(t, err)
+
+	var claims accessTokenClaims
+	ok(t, parsed.Decode(&claims))
+	equals(t, []string{"fed", "otp"}, claims.AMR)
+	equals(t, "https://idp.acme.com", claims.IDP)
+	equals(t, "test_user", claims.Subject)
+	equals(t, "https://example.com", claims.Issuer)
+
+	verified, err := verifyAccessToken(cfg, token.Value)
+	ok(t, err)
+	equals(t, "test_user", verified.UserID)
+
+	stored := provider.AccessTokens[parsed.Claims.ID]
+	introspected, err := introspectionClaims(req, cfg, stored, false)
+	ok(t, err)
+	equals(t, []string{"fed", "otp"}, introspected["amr"])
+	equals(t, "https://idp.acme.com", introspected["idp"])
+}
+
+// unboundTokenProvider issues tokens not bound to any client.
+type unboundTokenProvider struct {
+	*test.Provider
+}
+
+func (p unboundTokenProvider) GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	token, err := p.Provider.GenToken(grant, client, refreshToken, expiration)
+	token.ClientID = ""
+	return token, err
+}
+
+// TestInvalidProviderTokens tests that tokens issued by providers are not sent
+// to clients if they violate the invariants of types.Token.
+func TestInvalidProviderTokens(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = unboundTokenProvider{test.NewProvider(true)}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+		bytes.NewBufferString("grant_type=client_credentials&scope=read"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	assert(t, w.Code >= http.StatusBadRequest, "expected an error, got %d: %s", w.Code, w.Body.String())
+
+	authzErr := types.AuthzError{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+	equals(t, "server_error", authzErr.Code)
+}
@@ -0,0 +1,62 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Auditor receives security relevant events, to keep an audit trail or
+// alert operators.
+type Auditor interface {
+	// Audit records an event. It is called synchronously, so it should not
+	// block for long.
+	Audit(event types.AuditEvent)
+}
+
+// SetAuditor sets the auditor receiving security relevant events. See also
+// MultiAuditor and webhook.Dispatcher.
+func SetAuditor(a Auditor) option {
+	return func(c *config) {
+		c.auditor = a
+	}
+}
+
+// MultiAuditor returns an auditor sending events to each of the given
+// auditors, for instance to keep an audit trail and deliver events to a
+// webhook.Dispatcher at the same time.
+func MultiAuditor(auditors ...Auditor) Auditor {
+	return multiAuditor(auditors)
+}
+
+type multiAuditor []Auditor
+
+func (m multiAuditor) Audit(event types.AuditEvent) {
+	for _, a := range m {
+		a.Audit(event)
+	}
+}
+
+// audit timestamps an event and sends it to the configured auditor, if any.
+// Credentials in URLs found in event details are redacted. See RedactURL.
+func audit(req *http.Request, cfg config, event types.AuditEvent) {
+	if cfg.auditor == nil {
+		return
+	}
+
+	if event.Details != nil {
+		details := make(map[string]string, len(event.Details))
+		for k, v := range event.Details {
+			details[k] = RedactURL(v)
+		}
+		event.Details = details
+	}
+
+	event.Time = now(cfg)
+	event.RequestID = RequestID(req)
+	cfg.auditor.Audit(event)
+}
@@ -5,6 +5,7 @@
 package oauth2
 
 import (
+	"log"
 	"net/http"
 	"net/url"
 
@@ -15,10 +16,42 @@ import (
 // Handlers is a map to functions where each function handles a particular HTTP
 // verb or method.
 var AuthzHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
-	"GET":  CreateGrant,
-	"POST": CreateGrant,
+	"GET":  unlessReadOnly(CreateGrant),
+	"HEAD": AuthzHead,
+	"POST": unlessReadOnly(CreateGrant),
 }
 
+// AuthzHead answers HEAD requests to the authorization endpoint, such as
+// those sent by health checkers and some browsers, with the headers of the
+// authorization form. Unlike GET requests, they are not processed as
+// authorization requests, so they never issue codes nor redirect.
+func AuthzHead(w http.ResponseWriter, req *http.Request, cfg config) {
+	render.HTML(w, render.Options{
+		Status:    http.StatusOK,
+		Data:      AuthzData{},
+		Template:  cfg.authzForm,
+		STSMaxAge: cfg.stsMaxAge,
+	})
+}
+
+// ConsentParam is the form field the authorization form sends along with the
+// resource owner's decision. It tells decisions apart from authorization
+// requests that clients send using POST instead of GET, which are handled as
+// if sent using GET. Custom authorization forms have to include it, for
+// instance as the name of their buttons:
+//
+//	<button type="submit" name="consent" value="deny">Deny</button>
+//	<button type="submit" name="consent" value="approve">Authorize</button>
+//
+// Any value other than ConsentDeny approves the request.
+const ConsentParam = "consent"
+
+// Values of ConsentParam.
+const (
+	ConsentApprove = "approve"
+	ConsentDeny    = "deny"
+)
+
 // AuthzData defines properties used to render the authorization form view
 // that asks for authorization to the resource owner when using the web flow.
 type AuthzData struct {
@@ -26,42 +59,176 @@ type AuthzData struct {
 	Client types.Client
 	// Requested scope access from 3rd-party client
 	Scopes types.Scopes
+	// Scopes the resource owner already approved for the client and the
+	// requested ones not approved yet, for forms to only ask for the latter.
+	// Set if SetRememberConsent is enabled.
+	GrantedScopes types.Scopes
+	NewScopes     types.Scopes
+	// Whether the client asked for the scopes approved before to be included
+	// in the grant, along with the requested ones.
+	IncludeGrantedScopes bool
+	// Extension parameters sent by the 3rd-party client app, by name. See
+	// SetExtensionParam.
+	Extensions map[string]string
 	// List of errors to display to the resource owner.
 	Errors []types.AuthzError
 	// Grant type is either "code" or "token" for implicit authorizations.
 	GrantType string
 	// State can be used to store CSRF tokens by the 3rd-party client app
 	State string
+	// PKCE code challenge and its method, if sent by the 3rd-party client app.
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// How the authorization response is sent back to the 3rd-party client app.
+	ResponseMode string
+	// Documents of the authorization server, to link from the form.
+	Server ServerDocuments
+	// Signed authorization request, to send back along with the resource
+	// owner's approval. See SetAuthzRequestKey.
+	Request string
+	// URL starting the authorization request over, when it expired before
+	// the resource owner approved it.
+	RestartURL string
+	// Random value identifying this display of the form, to send back along
+	// with the resource owner's approval so submitting it twice issues a
+	// single code. Set if SetSubmissionCache is enabled.
+	Nonce string
 }
 
 // CreateGrant generates the authorization code for 3rd-party clients to use
 // in order to get access and refresh tokens, asking the resource owner for authorization.
 func CreateGrant(w http.ResponseWriter, req *http.Request, cfg config) {
-	provider := cfg.provider
-	if yes := provider.IsUserAuthenticated(); !yes {
-		u := cfg.loginURL.url
-		query := u.Query()
-		query.Set(cfg.loginURL.redirectParam, req.URL.String())
-		u.RawQuery = query.Encode()
+	yes, err := userAuthenticated(req, cfg)
+	if isUnavailable(err) {
+		render.HTML(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data: AuthzData{
+				Errors: []types.AuthzError{
+					localize(req, cfg, ErrTemporarilyUnavailable),
+				}},
+			Template: cfg.authzForm,
+		})
+		return
+	}
+
+	if !yes {
+		if cfg.broker != nil && upstreamLogin(w, req, cfg) {
+			return
+		}
 
-		http.Redirect(w, req, u.String(), http.StatusFound)
+		redirectToLogin(w, req, cfg)
 		return
 	}
 
-	vars := []string{"client_id", "state", "redirect_uri", "scope", "response_type"}
-	params := make(map[string]string)
-	for _, v := range vars {
-		// FormValue also parses query string if method is GET
-		params[v] = req.FormValue(v)
+	approval, denial := consentApproval(req), consentDenial(req)
+	params, err := authzRequestParams(req, cfg, approval || denial)
+	if err != nil {
+		// The authorization process has to start all over again.
+		var data AuthzData
+		var e types.AuthzError
+		switch err {
+		case errAuthzRequestExpired:
+			// The resource owner took too long, the request can be
+			// started over from its signed parameters.
+			e = localize(req, cfg, ErrAuthzRequestExpired)
+			data.RestartURL = restartURL(cfg, params)
+		case errAuthzRequestInvalid:
+			e = localize(req, cfg, ErrAuthzRequestInvalid)
+		case errRequestObjectInvalid:
+			e = localize(req, cfg, ErrRequestObjectInvalid)
+		default:
+			e = serverError(req, cfg, "", err)
+		}
+
+		data.Errors = []types.AuthzError{e}
+		render.HTML(w, render.Options{
+			Status:    http.StatusOK,
+			Data:      data,
+			Template:  cfg.authzForm,
+			STSMaxAge: cfg.stsMaxAge,
+		})
+		return
 	}
 
-	authzData := authCodeGrant1(w, req, cfg, params)
+	areq := newAuthorizationRequest(params)
+	authzData := authCodeGrant1(w, req, cfg, areq)
 	if authzData == nil {
 		// A response with an error was already sent back
 		return
 	}
 
-	if req.Method == "GET" {
+	if (approval || denial) && !formOriginAllowed(req, cfg) {
+		log.Printf("[WARN] request_id=%s Authorization form for client %s submitted from origin %q, referer %q",
+			RequestID(req), authzData.Client.ID, req.Header.Get("Origin"), req.Header.Get("Referer"))
+		render.HTML(w, render.Options{
+			Status: http.StatusOK,
+			Data: AuthzData{
+				Errors: []types.AuthzError{
+					localize(req, cfg, ErrFormOriginNotAllowed),
+				}},
+			Template:  cfg.authzForm,
+			STSMaxAge: cfg.stsMaxAge,
+		})
+		return
+	}
+
+	if denial {
+		denyConsent(w, req, cfg, authzData)
+		return
+	}
+
+	// Resource owners are not asked again for scopes they already approved.
+	remembered, err := consentRemembered(req, cfg, authzData)
+	if err != nil {
+		render.HTML(w, render.Options{
+			Status: http.StatusOK,
+			Data: AuthzData{
+				Errors: []types.AuthzError{
+					serverError(req, cfg, "", err),
+				}},
+			Template: cfg.authzForm,
+		})
+		return
+	}
+
+	if !approval && !remembered {
+		if cfg.authzRequestKey != nil {
+			// Forms displayed again keep the request they were signed with.
+			authzData.Request = req.FormValue(AuthzRequestParam)
+		}
+
+		if cfg.authzRequestKey != nil && authzData.Request == "" {
+			authzData.Request, err = signAuthzRequest(cfg, params)
+			if err != nil {
+				render.HTML(w, render.Options{
+					Status: http.StatusOK,
+					Data: AuthzData{
+						Errors: []types.AuthzError{
+							serverError(req, cfg, "", err),
+						}},
+					Template: cfg.authzForm,
+				})
+				return
+			}
+		}
+
+		if cfg.submissionCache != nil {
+			authzData.Nonce, err = newID()
+			if err != nil {
+				render.HTML(w, render.Options{
+					Status: http.StatusOK,
+					Data: AuthzData{
+						Errors: []types.AuthzError{
+							serverError(req, cfg, "", err),
+						}},
+					Template: cfg.authzForm,
+				})
+				return
+			}
+		}
+
+		authzData.Server = serverDocuments(cfg)
+
 		// Displays authorization form to resource owner in order for her to
 		// authorize 3rd-party client app.
 		// TODO(c4milo): Figure out how to generate a CSRF token not tied to user's session
@@ -74,7 +241,85 @@ func CreateGrant(w http.ResponseWriter, req *http.Request, cfg config) {
 		return
 	}
 
-	if params["response_type"] == "token" {
+	grantType := "authorization_code"
+	if areq.ResponseType == "token" {
+		grantType = "implicit"
+	}
+
+	// A copy, so forms being displayed do not allocate it.
+	approved := areq
+	user, _ := currentUser(req, cfg)
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:            grantType,
+		Client:               authzData.Client,
+		User:                 user,
+		Scopes:               authzData.Scopes,
+		AuthorizationRequest: &approved,
+	}); ok {
+		e.State = authzData.State
+		redirectErr(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode, e)
+		return
+	}
+
+	// Forms submitted more than once get the code issued to the first
+	// submission. See SetSubmissionCache.
+	var sub *formSubmission
+	nonce := req.FormValue(FormNonceParam)
+	if approval && cfg.submissionCache != nil && areq.ResponseType != "token" && nonce != "" {
+		s := newFormSubmission(req, cfg, user, nonce, params)
+		code, err := s.start(cfg)
+		if err != nil {
+			render.HTML(w, render.Options{
+				Status: http.StatusOK,
+				Data: AuthzData{
+					Errors: []types.AuthzError{
+						serverError(req, cfg, "", err),
+					}},
+				Template: cfg.authzForm,
+			})
+			return
+		}
+
+		if code != "" {
+			respondWithCode(w, req, cfg, authzData, code)
+			return
+		}
+		sub = &s
+	}
+
+	// The resource owner approved the request, keeps a receipt of it and
+	// remembers the approved scopes.
+	if !remembered {
+		if err := saveConsentReceipt(req, cfg, authzData, types.ConsentApproved); err != nil {
+			render.HTML(w, render.Options{
+				Status: http.StatusOK,
+				Data: AuthzData{
+					Errors: []types.AuthzError{
+						serverError(req, cfg, "", err),
+					}},
+				Template: cfg.authzForm,
+			})
+			return
+		}
+
+		if err := saveConsent(req, cfg, authzData); err != nil {
+			render.HTML(w, render.Options{
+				Status: http.StatusOK,
+				Data: AuthzData{
+					Errors: []types.AuthzError{
+						serverError(req, cfg, "", err),
+					}},
+				Template: cfg.authzForm,
+			})
+			return
+		}
+	}
+
+	// Incremental authorization: the grant covers the scopes approved before
+	// too, if the client asks for it.
+	includeGrantedScopes(authzData)
+
+	if areq.ResponseType == "token" {
 		// Continue with implicit grant flow
 		implicitGrant(w, req, cfg, authzData)
 		return
@@ -87,43 +332,68 @@ func CreateGrant(w http.ResponseWriter, req *http.Request, cfg config) {
 	// redirection URI using the "application/x-www-form-urlencoded" format,
 	// per Appendix B:
 	// http://tools.ietf.org/html/rfc6749#section-4.2.1
-	grant, err := provider.GenGrant(authzData.Client, authzData.Scopes, cfg.authzExpiration)
+	grant, err := genGrant(cfg, authzData.Client, types.Grant{
+		ClientID:             authzData.Client.ID,
+		RedirectURL:          authzData.Client.RedirectURL,
+		RequestedRedirectURI: areq.RedirectURI,
+		Scopes:               authzData.Scopes,
+		CodeChallenge:        authzData.CodeChallenge,
+		CodeChallengeMethod:  authzData.CodeChallengeMethod,
+		Extensions:           authzData.Extensions,
+		UserID:               user.ID,
+		AMR:                  user.AMR,
+		IdentityProvider:     user.IdentityProvider,
+	})
 	if err != nil {
 		render.HTML(w, render.Options{
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrServerError("", err),
+					serverError(req, cfg, "", err),
 				}},
 			Template: cfg.authzForm,
 		})
 		return
 	}
 
-	u := authzData.Client.RedirectURL
-	query := u.Query()
-	query.Set("code", grant.Code)
-	query.Set("state", authzData.State)
-	u.RawQuery = query.Encode()
+	if sub != nil {
+		if err := sub.finish(cfg, grant.Code); err != nil {
+			log.Printf("[ERROR] request_id=%s Error recording authorization form submission: %+v", RequestID(req), err)
+		}
+	}
 
-	// log.Printf("[DEBUG] Redirect to: %s", u.String())
-	http.Redirect(w, req, u.String(), http.StatusFound)
+	respondWithCode(w, req, cfg, authzData, grant.Code)
+}
+
+// respondWithCode sends the authorization code back to the client, or
+// displays it to the resource owner for out-of-band clients.
+func respondWithCode(w http.ResponseWriter, req *http.Request, cfg config, authzData *AuthzData, code string) {
+	if isOOB(cfg, authzData.Client.RedirectURL) {
+		displayCode(w, cfg, authzData, code)
+		return
+	}
+
+	query := url.Values{"code": {code}}
+	if authzData.State != "" {
+		query.Set("state", authzData.State)
+	}
+	redirect(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode, query)
 }
 
 // AuthCodeGrant1 implements http://tools.ietf.org/html/rfc6749#section-4.1.1 and
 // http://tools.ietf.org/html/rfc6749#section-4.2.1
-func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params map[string]string) *AuthzData {
+func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, areq AuthorizationRequest) *AuthzData {
 	provider := cfg.provider
 	// If the client identifier is missing or invalid, the authorization server
 	// SHOULD inform the resource owner of the error and MUST NOT automatically
 	// redirect the user-agent to the invalid redirection URI.
-	clientID := params["client_id"]
+	clientID := areq.ClientID
 	if clientID == "" {
 		render.HTML(w, render.Options{
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrClientIDMissing,
+					localize(req, cfg, ErrClientIDMissing),
 				},
 			},
 			Template: cfg.authzForm,
@@ -137,7 +407,7 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrServerError("", err),
+					serverError(req, cfg, "", err),
 				},
 			},
 			Template: cfg.authzForm,
@@ -145,12 +415,12 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 		return nil
 	}
 
-	if cinfo == (types.Client{}) {
+	if cinfo.ID == "" {
 		render.HTML(w, render.Options{
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrClientIDNotFound,
+					localize(req, cfg, ErrClientIDNotFound),
 				},
 			},
 			Template: cfg.authzForm,
@@ -158,14 +428,28 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 		return nil
 	}
 
+	// Clients pending review or suspended are not redirected back to, as they
+	// can not be trusted yet or anymore.
+	if e, inactive := inactiveClient(req, cfg, cinfo); inactive {
+		render.HTML(w, render.Options{
+			Status: http.StatusOK,
+			Data: AuthzData{
+				Errors: []types.AuthzError{e},
+			},
+			Template: cfg.authzForm,
+		})
+		return nil
+	}
+
 	// If the request fails due to a missing, invalid, or mismatching
 	// redirection URI, the authorization server SHOULD inform the resource
 	// owner of the error and MUST NOT automatically redirect the user-agent to the
 	// invalid redirection URI.
+	policy := redirectPolicy(cfg)
 	var redirectURL *url.URL
-	if u, ok := params["redirect_uri"]; ok {
+	if u := areq.RedirectURI; u != "" {
 		var err error
-		redirectURL, err = url.Parse(u)
+		redirectURL, err = policy.Parse(u)
 		if err != nil {
 			// We are deliberately avoiding sending client original parameters,
 			// so the authorization process is forced to start all over again.
@@ -173,23 +457,25 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 				Status: http.StatusOK,
 				Data: AuthzData{
 					Errors: []types.AuthzError{
-						ErrRedirectURLInvalid,
+						localize(req, cfg, ErrRedirectURLInvalid),
 					},
 				},
 				Template: cfg.authzForm,
 			})
 			return nil
 		}
-	} else {
-		redirectURL = cinfo.RedirectURL
+	} else if registered := cinfo.RegisteredRedirectURLs(); len(registered) == 1 {
+		// The redirect_uri parameter is only required if several redirect
+		// URLs were registered. http://tools.ietf.org/html/rfc6749#section-3.1.2.3
+		redirectURL = registered[0]
 	}
 
-	if redirectURL.Scheme != "https" {
+	if redirectURL == nil || policy.Validate(redirectURL) != nil {
 		render.HTML(w, render.Options{
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrRedirectURLInvalid,
+					localize(req, cfg, ErrRedirectURLInvalid),
 				},
 			},
 			Template: cfg.authzForm,
@@ -200,12 +486,13 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 	// The authorization server MUST verify that the redirection URI to which
 	// it will redirect the authorization code or access token matches a redirection URI registered
 	// by the client as described in Section 3.1.2.
-	if redirectURL.String() != cinfo.RedirectURL.String() {
+	registered, err := policy.Match(cinfo.RegisteredRedirectURLs(), redirectURL.String())
+	if err != nil {
 		render.HTML(w, render.Options{
 			Status: http.StatusOK,
 			Data: AuthzData{
 				Errors: []types.AuthzError{
-					ErrRedirectURLMismatch,
+					localize(req, cfg, ErrRedirectURLMismatch),
 				},
 			},
 			Template: cfg.authzForm,
@@ -213,62 +500,157 @@ func authCodeGrant1(w http.ResponseWriter, req *http.Request, cfg config, params
 		return nil
 	}
 
+	// The response is sent to the redirect URL matched, which codes are also
+	// bound to.
+	cinfo.RedirectURL = registered
+	redirectURL = registered
+
+	// Errors are sent back the same way successful responses would be.
+	mode, modeSupported := responseMode(areq)
+
 	// An opaque value used by the client to maintain state between the request
 	// and callback.  The authorization server includes this value when redirecting
 	// the user-agent back to the client.  The parameter SHOULD be used for preventing
 	// cross-site request forgery as described in Section 10.12.
-	state := params["state"]
-	if state == "" {
-		EncodeErrInURI(redirectURL, ErrStateRequired(state))
-		http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+	state := areq.State
+	if !stateAccepted(req, cfg, areq) {
+		redirectErr(w, req, cfg, redirectURL, mode, ErrStateRequired(state))
 		return nil
 	}
 
 	// response_type
 	// Value MUST be set to "code" or "token" for implicit authorizations.
-	grantType := params["response_type"]
-	if grantType != "code" && grantType != "token" {
-		EncodeErrInURI(redirectURL, ErrUnsupportedResponseType(state))
-		http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+	// Access tokens are never displayed out-of-band.
+	grantType := areq.ResponseType
+	flow := "authorization_code"
+	if grantType == "token" {
+		flow = "implicit"
+	}
+	if (grantType != "code" && grantType != "token") ||
+		(grantType == "token" && isOOB(cfg, redirectURL)) || !grantTypeAllowed(cfg, flow) {
+		redirectErr(w, req, cfg, redirectURL, mode, ErrUnsupportedResponseType(state))
+		return nil
+	}
+
+	if checkDeprecation(w, req, cfg, flow, cinfo.ID) {
+		redirectErr(w, req, cfg, redirectURL, mode, ErrUnsupportedResponseType(state))
+		return nil
+	}
+
+	if !modeSupported {
+		redirectErr(w, req, cfg, redirectURL, mode, ErrResponseModeUnsupported(state))
 		return nil
 	}
 
+	if grantType == "code" {
+		if e := checkCodeChallenge(cfg, areq); e != nil {
+			redirectErr(w, req, cfg, redirectURL, mode, *e)
+			return nil
+		}
+	}
+
 	// The scope of the access request as described by Section 3.3.
-	scope := params["scope"]
+	scope := requestedScope(cfg, cinfo, areq.Scope)
 	if scope == "" {
-		EncodeErrInURI(redirectURL, ErrScopeRequired(state))
-		http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+		redirectErr(w, req, cfg, redirectURL, mode, ErrScopeRequired(state))
 		return nil
 	}
 
 	scopes, err := provider.ScopesInfo(scope)
 	if err != nil {
-		EncodeErrInURI(redirectURL, ErrServerError(state, err))
-		http.Redirect(w, req, redirectURL.String(), http.StatusFound)
+		redirectErr(w, req, cfg, redirectURL, mode, serverError(req, cfg, state, err))
+		return nil
+	}
+
+	exts, e := extensions(req, cfg, cinfo, areq)
+	if e != nil {
+		redirectErr(w, req, cfg, redirectURL, mode, *e)
 		return nil
 	}
 
 	return &AuthzData{
-		Client:    cinfo,
-		Scopes:    scopes,
-		GrantType: grantType,
-		State:     state,
+		Client:               cinfo,
+		Scopes:               scopes,
+		GrantType:            grantType,
+		State:                state,
+		ResponseMode:         mode,
+		CodeChallenge:        areq.CodeChallenge,
+		CodeChallengeMethod:  areq.CodeChallengeMethod,
+		IncludeGrantedScopes: areq.IncludeGrantedScopes,
+		Extensions:           exts,
 	}
 }
 
+// redirectToLogin sends the resource owner to the login URL, to be sent back
+// to the requested URL once authenticated.
+func redirectToLogin(w http.ResponseWriter, req *http.Request, cfg config) {
+	u := *cfg.loginURL.url
+	query := u.Query()
+	query.Set(cfg.loginURL.redirectParam, req.URL.String())
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, req, u.String(), http.StatusFound)
+}
+
+// consentApproval tells whether the request carries the resource owner's
+// approval, rather than being an authorization request sent by the client.
+func consentApproval(req *http.Request) bool {
+	v := req.PostFormValue(ConsentParam)
+	return req.Method == "POST" && v != "" && v != ConsentDeny
+}
+
+// consentDenial tells whether the resource owner denied the request.
+func consentDenial(req *http.Request) bool {
+	return req.Method == "POST" && req.PostFormValue(ConsentParam) == ConsentDeny
+}
+
+// denyConsent records the denial of the authorization request by the
+// resource owner and tells the client, as required by
+// http://tools.ietf.org/html/rfc6749#section-4.1.2.1
+func denyConsent(w http.ResponseWriter, req *http.Request, cfg config, authzData *AuthzData) {
+	if err := saveConsentReceipt(req, cfg, authzData, types.ConsentDenied); err != nil {
+		render.HTML(w, render.Options{
+			Status: http.StatusOK,
+			Data: AuthzData{
+				Errors: []types.AuthzError{
+					serverError(req, cfg, "", err),
+				}},
+			Template: cfg.authzForm,
+		})
+		return
+	}
+
+	user, _ := currentUser(req, cfg)
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditConsentDenied,
+		ClientID: authzData.Client.ID,
+		UserID:   user.ID,
+		Details: map[string]string{
+			"scope": authzData.Scopes.Encode(),
+		},
+	})
+
+	e := ErrConsentDenied
+	e.State = authzData.State
+	redirectErr(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode, e)
+}
+
 // ImplicitGrant implements http://tools.ietf.org/html/rfc6749#section-4.2
 func implicitGrant(w http.ResponseWriter, req *http.Request, cfg config, authzData *AuthzData) {
-	provider := cfg.provider
-	u := authzData.Client.RedirectURL
-
+	user, _ := currentUser(req, cfg)
 	noAuthzGrant := types.Grant{
-		Scopes: authzData.Scopes,
+		UserID:           user.ID,
+		Scopes:           authzData.Scopes,
+		Extensions:       authzData.Extensions,
+		AMR:              user.AMR,
+		IdentityProvider: user.IdentityProvider,
 	}
 
-	token, err := provider.GenToken(noAuthzGrant, authzData.Client, false, cfg.tokenExpiration)
+	expiration, _ := tokenPolicy(cfg, noAuthzGrant.Scopes)
+	token, err := genToken(req, cfg, noAuthzGrant, authzData.Client, false, expiration)
 	if err != nil {
-		EncodeErrInURI(u, ErrServerError(authzData.State, err))
-		http.Redirect(w, req, u.String(), http.StatusFound)
+		redirectErr(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode,
+			serverError(req, cfg, authzData.State, err))
 		return
 	}
 
@@ -277,9 +659,10 @@ func implicitGrant(w http.ResponseWriter, req *http.Request, cfg config, authzDa
 		"token_type":   {token.Type},
 		"expires_in":   {token.ExpiresIn},
 		"scope":        {token.Scopes.Encode()},
-		"state":        {authzData.State},
+	}
+	if authzData.State != "" {
+		query.Set("state", authzData.State)
 	}
 
-	u.Fragment = "#" + query.Encode()
-	http.Redirect(w, req, u.String(), http.StatusFound)
+	redirect(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode, query)
 }
@@ -6,6 +6,9 @@ package oauth2
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -14,7 +17,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/hooklift/oauth2/internal/jwt"
 	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/tokengen"
 	"github.com/hooklift/oauth2/types"
 )
 
@@ -51,17 +56,22 @@ func setupTest() config {
 			 <input type="hidden" name="redirect_uri" value="{{.Client.RedirectURL}}"/>
 			 <input type="hidden" name="scope" value="{{.Scopes.Encode}}"/>
 			 <input type="hidden" name="state" value="{{.State}}"/>
+			 <input type="hidden" name="authz_request" value="{{.Request}}"/>
+			 {{with .Nonce}}<input type="hidden" name="form_nonce" value="{{.}}"/>{{end}}
 			</form>
 		{{end}}
 		</body>
 	</html>
 	`
 	cfg := config{
-		tokenEndpoint:   "/oauth2/tokens",
-		authzEndpoint:   "/oauth2/authzs",
-		stsMaxAge:       time.Duration(0) * time.Second,
-		authzExpiration: time.Duration(1) * time.Minute,
-		tokenExpiration: time.Duration(10) * time.Minute,
+		tokenEndpoint:         "/oauth2/tokens",
+		authzEndpoint:         "/oauth2/authzs",
+		grantsEndpoint:        "/oauth2/grants",
+		jwksEndpoint:          "/oauth2/jwks",
+		introspectionEndpoint: "/oauth2/introspect",
+		stsMaxAge:             time.Duration(0) * time.Second,
+		authzExpiration:       time.Duration(1) * time.Minute,
+		tokenExpiration:       time.Duration(10) * time.Minute,
 	}
 
 	SetAuthzForm(authzForm)(&cfg)
@@ -116,11 +126,13 @@ func getTestAuthzCode(t *testing.T) (config, string) {
 	}
 
 	// Sending post to acquire authorization token
-	buffer := bytes.NewBufferString(queryStr)
+	values.Set(ConsentParam, "approve")
+	buffer := bytes.NewBufferString(values.Encode())
 	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
 	ok(t, err)
 
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
 
 	w = httptest.NewRecorder()
 	CreateGrant(w, req, cfg)
@@ -232,11 +244,13 @@ func TestImplicitGrant(t *testing.T) {
 	}
 
 	// Sending post to acquire authorization token
-	buffer := bytes.NewBufferString(queryStr)
+	values.Set(ConsentParam, "approve")
+	buffer := bytes.NewBufferString(values.Encode())
 	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
 	ok(t, err)
 
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
 
 	w = httptest.NewRecorder()
 	CreateGrant(w, req, cfg)
@@ -245,10 +259,11 @@ func TestImplicitGrant(t *testing.T) {
 	equals(t, http.StatusFound, w.Code)
 
 	redirectTo := w.Header().Get("Location")
+	equals(t, 1, strings.Count(redirectTo, "#"))
 	u, err := url.Parse(redirectTo)
 	ok(t, err)
 
-	fragment, err := url.ParseQuery(strings.TrimPrefix(u.Fragment, "#"))
+	fragment, err := url.ParseQuery(u.Fragment)
 	ok(t, err)
 	accessToken := fragment.Get("access_token")
 	assert(t, accessToken != "", "It looks like the authorization code came back empty: ->%s<-", accessToken)
@@ -256,6 +271,7 @@ func TestImplicitGrant(t *testing.T) {
 	equals(t, "600", fragment.Get("expires_in"))
 	equals(t, scopes, fragment.Get("scope"))
 	equals(t, "bearer", fragment.Get("token_type"))
+	equals(t, "test_user", provider.AccessTokens[accessToken].UserID)
 
 	// Implict flow should not emit refresh tokens
 	refreshToken := fragment.Get("refresh_token")
@@ -324,12 +340,14 @@ func TestRedirectURLMatch(t *testing.T) {
 
 	// Sending post to acquire authorization token
 	values.Set("redirect_uri", "https://attacker.com/callback")
+	values.Set(ConsentParam, "approve")
 	queryStr2 := values.Encode()
 	buffer := bytes.NewBufferString(queryStr2)
 	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
 	ok(t, err)
 
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
 
 	w2 := httptest.NewRecorder()
 	CreateGrant(w2, req, cfg)
@@ -338,15 +356,191 @@ func TestRedirectURLMatch(t *testing.T) {
 	assert(t, strings.Contains(body, "3rd-party client app provided a redirect_uri that does not match the URI registered for this client in our database."), "unexpected error description.")
 }
 
-// TestAccessTokenOwnership makes sure a token belongs to the client_id making
-// the request with it. This mitigates account hijacking as well.
+// TestAccessTokenOwnership makes sure tokens are only issued to the client_id
+// the authorization code was issued to, presenting the PKCE code verifier the
+// code is bound to. This mitigates account hijacking as well.
 func TestAccessTokenOwnership(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	grant, err := provider.GenBoundGrant(types.Grant{
+		ClientID:            provider.Client.ID,
+		RedirectURL:         provider.Client.RedirectURL,
+		Scopes:              types.Scopes{types.Scope{ID: "read"}},
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: CodeChallengeS256,
+	}, cfg.authzExpiration)
+	ok(t, err)
+
+	tests := []struct {
+		client      string
+		verifier    string
+		status      int
+		description string
+	}{
+		{"boo", verifier, http.StatusBadRequest, ErrGrantClientIDMismatch.Description},
+		{"testclient", "", http.StatusBadRequest, ErrCodeVerifierInvalid.Description},
+		{"testclient", "wrong", http.StatusBadRequest, ErrCodeVerifierInvalid.Description},
+		{"testclient", verifier, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		queryStr := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {grant.Code},
+			"code_verifier": {tt.verifier},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(queryStr.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(tt.client, tt.client)
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+
+		if tt.description != "" {
+			authzErr := types.AuthzError{}
+			ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+			equals(t, "invalid_grant", authzErr.Code)
+			equals(t, tt.description, authzErr.Description)
+		}
+	}
+}
+
+// TestAuthzRequestPOST tests that authorization requests sent by clients
+// using POST display the authorization form, as if sent using GET, whereas
+// approvals have to carry the consent field sent by the form.
+func TestAuthzRequestPOST(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	body := authzRequest(t, cfg).URL.RawQuery
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 0, len(provider.Grants))
+	assert(t, strings.Contains(w.Body.String(), "state-test"), "Does not look like we got an authorization form: %s", w.Body.String())
+
+	// The consent field is ignored in query strings.
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs?"+ConsentParam+"=approve", bytes.NewBufferString(body))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 0, len(provider.Grants))
+
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body+"&"+ConsentParam+"=approve"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(provider.Grants))
+}
+
+// TestCodeGenerator tests that authorization codes can be generated by the
+// configured generator instead of the provider.
+func TestCodeGenerator(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetCodeGenerator(tokengen.Random{Bytes: 16, Encoding: tokengen.Hex})(&cfg)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read"},
+		ConsentParam:    {"approve"},
+	}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	code := u.Query().Get("code")
+	equals(t, 32, len(code))
+	equals(t, code, provider.Grants[code].Code)
+}
+
+// fakeClock is a Clock that only moves when told so.
+type fakeClock struct {
+	now time.Time
+}
 
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
 }
 
 // TestAccessTokenExpiration makes sure that access tokens are actually expired.
 func TestAccessTokenExpiration(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+	cfg.provider = provider
+	cfg.clock = clock
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+		bytes.NewBufferString("grant_type=client_credentials"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	err = json.Unmarshal(w.Body.Bytes(), &token)
+	ok(t, err)
+
+	handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	}), provider, SetClock(clock))
+
+	req, err = http.NewRequest("GET", "https://example.com/protected_resource", nil)
+	ok(t, err)
+	req.Header.Set("Authorization", "Bearer "+token.Value)
 
+	clock.Advance(cfg.tokenExpiration - time.Second)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	clock.Advance(time.Second)
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusUnauthorized, w.Code)
+	assert(t, strings.Contains(w.Header().Get("WWW-Authenticate"), "invalid_token"), "expected invalid_token error")
 }
 
 // TestScopeIsRequired makes sure it requires clients to provide access scopes when
@@ -477,3 +671,209 @@ func TestRedirectURIScheme(t *testing.T) {
 	assert(t, strings.Contains(body, "access_denied") == true, "access-denied was not found in response body")
 	assert(t, strings.Contains(body, "3rd-party client app provided an invalid redirect_uri. It does not comply with http://tools.ietf.org/html/rfc3986#section-4.3 or does not use HTTPS") == true, "error description does not match.")
 }
+
+// TestDisplayCode tests that clients registered with the out-of-band redirect
+// URI get the authorization code displayed to the resource owner instead of redirected.
+func TestDisplayCode(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.RedirectURL, _ = url.Parse(OOBRedirectURI)
+	cfg.provider = provider
+	SetDisplayCodeForm(OOBRedirectURI, `<html><body><pre id="code">{{.Code}}</pre></body></html>`)(&cfg)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {OOBRedirectURI},
+		"scope":         {"read write identity"},
+		ConsentParam:    {"approve"},
+	}
+
+	buffer := bytes.NewBufferString(values.Encode())
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 1, len(provider.Grants))
+
+	for code := range provider.Grants {
+		assert(t, strings.Contains(w.Body.String(), `<pre id="code">`+code+`</pre>`), "authorization code was not displayed: %s", w.Body.String())
+	}
+
+	// Implicit grants are not allowed out-of-band and errors are displayed
+	// instead of redirected.
+	values.Set("response_type", "token")
+	buffer = bytes.NewBufferString(values.Encode())
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "unsupported_response_type"), "unsupported_response_type was expected: %s", w.Body.String())
+}
+
+// TestMultipleRedirectURLs tests that clients registering several redirect
+// URLs have to choose one, which codes are then bound to.
+func TestMultipleRedirectURLs(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	extra, err := types.ParseRedirectURLs("https://example.com/other/callback")
+	ok(t, err)
+	provider.Client.RedirectURLs = extra
+
+	authorize := func(redirectURI string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {redirectURI},
+			"scope":         {"read"},
+			ConsentParam:    {"approve"},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	// The redirect URL has to be chosen.
+	w := authorize("")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrRedirectURLInvalid.Description), "expected an error: %s", w.Body.String())
+
+	w = authorize("https://example.com/unknown/callback")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrRedirectURLMismatch.Description), "expected an error: %s", w.Body.String())
+
+	w = authorize("https://example.com/other/callback")
+	equals(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "/other/callback", u.Path)
+	equals(t, "https://example.com/oauth2/callback", provider.Client.RedirectURL.String())
+
+	values := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {u.Query().Get("code")},
+		"redirect_uri": {"https://example.com/other/callback"},
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	// Clients with a single redirect URL don't have to send it.
+	provider.Client.RedirectURLs = nil
+	w = authorize("")
+	equals(t, http.StatusFound, w.Code)
+	assert(t, strings.HasPrefix(w.Header().Get("Location"), "https://example.com/oauth2/callback?"), "unexpected redirect: %s", w.Header().Get("Location"))
+}
+
+func TestClientValidate(t *testing.T) {
+	_, err := types.ParseRedirectURLs("https://example.com/callback#fragment")
+	equals(t, types.ErrRedirectURLInvalid, err)
+	_, err = types.ParseRedirectURLs("/callback")
+	equals(t, types.ErrRedirectURLInvalid, err)
+
+	client := types.Client{ID: "client"}
+	client.RedirectURLs, err = types.ParseRedirectURLs("https://example.com/callback", OOBRedirectURI)
+	ok(t, err)
+	ok(t, client.Validate())
+
+	client.RedirectURL, _ = url.Parse("callback")
+	equals(t, types.ErrRedirectURLInvalid, client.Validate())
+	equals(t, types.ErrClientIDRequired, types.Client{}.Validate())
+}
+
+// TestConsentDenied tests that denying an authorization request redirects to
+// the client with access_denied, and that the denial is recorded.
+func TestConsentDenied(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})(&cfg)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read"},
+		ConsentParam:    {ConsentDeny},
+	}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, types.ErrorAccessDenied, u.Query().Get("error"))
+	equals(t, "state-test", u.Query().Get("state"))
+	equals(t, "", u.Query().Get("code"))
+	equals(t, 0, len(provider.Grants))
+
+	equals(t, 1, len(provider.Receipts))
+	equals(t, types.ConsentDenied, provider.Receipts[0].Decision)
+
+	equals(t, 1, len(*events))
+	equals(t, types.AuditConsentDenied, (*events)[0].Type)
+	equals(t, "read", (*events)[0].Details["scope"])
+}
+
+// TestAuthzHead tests that HEAD requests to the authorization endpoint get
+// the headers of the authorization form, without being processed as
+// authorization requests.
+func TestAuthzHead(t *testing.T) {
+	provider := test.NewProvider(true)
+	handler := Handler(nil, SetProvider(provider), SetRememberConsent(true))
+	provider.Consents["test_user:"+provider.Client.ID] = types.Consent{
+		UserID:   "test_user",
+		ClientID: provider.Client.ID,
+		Scopes:   types.Scopes{{ID: "read"}},
+	}
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"scope":         {"read"},
+	}
+	req, err := http.NewRequest("HEAD", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "SAMEORIGIN", w.Header().Get("X-Frame-Options"))
+	equals(t, "no-store", w.Header().Get("Cache-Control"))
+	equals(t, 0, len(provider.Grants))
+}
@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AuthzRequestParam is the form field carrying the signed authorization request
+// from the authorization form back to the authorization endpoint.
+const AuthzRequestParam = "authz_request"
+
+// Parameters of authorization requests.
+var authzRequestVars = []string{"client_id", "state", "redirect_uri", "scope", "response_type", "code_challenge", "code_challenge_method", "response_mode", "include_granted_scopes"}
+
+// Maximum time the resource owner has to approve an authorization request.
+const authzRequestMaxAge = time.Duration(10) * time.Minute
+
+// Errors verifying signed authorization requests.
+var (
+	errAuthzRequestInvalid = errors.New("oauth2: authorization request is malformed or its signature is invalid")
+	errAuthzRequestExpired = errors.New("oauth2: authorization request expired")
+)
+
+// SetAuthzRequestKey sets the key used to sign authorization requests once
+// validated, so they can be approved by the resource owner on any instance
+// of the authorization server. It has to be the same for all instances and
+// at least 32 bytes long.
+//
+// Once set, the authorization form has to send back the signed request in
+// a field named after AuthzRequestParam:
+//
+//	<input type="hidden" name="authz_request" value="{{.Request}}"/>
+//
+// Approvals are processed using the parameters in the signed request only,
+// client_id, redirect_uri, scope and such can't be tampered with between
+// showing the form and its submission.
+//
+// The form can also be displayed again from the signed request, for instance
+// when the resource owner reloads it or goes back to it, with:
+//
+//	GET /oauth2/authzs?authz_request=<signed request>
+//
+// The signed request keeps its original expiration, no matter how many times
+// the form is displayed. Displaying the form never issues codes nor tokens.
+func SetAuthzRequestKey(key []byte) option {
+	return func(c *config) {
+		if len(key) < minAuthzRequestKeySize {
+			log.Fatalf("The authorization request key has to be at least %d bytes long", minAuthzRequestKeySize)
+		}
+		c.authzRequestKey = key
+	}
+}
+
+// minAuthzRequestKeySize is the minimum size of the key signing authorization
+// requests, which also signs grant revocation nonces and keys form submissions.
+const minAuthzRequestKeySize = 32
+
+// signedAuthzRequest is the payload of signed authorization requests.
+type signedAuthzRequest struct {
+	Params    map[string]string `json:"params"`
+	ExpiresAt int64             `json:"exp"`
+}
+
+// signAuthzRequest returns the given authorization request parameters in a
+// signed and stateless blob.
+func signAuthzRequest(cfg config, params map[string]string) (string, error) {
+	payload, err := json.Marshal(signedAuthzRequest{
+		Params:    params,
+		ExpiresAt: now(cfg).Add(authzRequestMaxAge).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + authzRequestMAC(cfg, encoded), nil
+}
+
+// verifyAuthzRequest returns the parameters of a signed authorization request.
+// Parameters of expired requests are returned along with
+// errAuthzRequestExpired, so they can be started over.
+func verifyAuthzRequest(cfg config, blob string) (map[string]string, error) {
+	parts := strings.Split(blob, ".")
+	if len(parts) != 2 {
+		return nil, errAuthzRequestInvalid
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(authzRequestMAC(cfg, parts[0]))) {
+		return nil, errAuthzRequestInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errAuthzRequestInvalid
+	}
+
+	var r signedAuthzRequest
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return nil, errAuthzRequestInvalid
+	}
+
+	if !now(cfg).Before(time.Unix(r.ExpiresAt, 0)) {
+		return r.Params, errAuthzRequestExpired
+	}
+	return r.Params, nil
+}
+
+// restartURL returns the URL of the authorization endpoint starting the
+// given authorization request over. Its parameters are validated again.
+func restartURL(cfg config, params map[string]string) string {
+	values := url.Values{}
+	for k, v := range params {
+		if v != "" {
+			values.Set(k, v)
+		}
+	}
+	return cfg.authzEndpoint + "?" + values.Encode()
+}
+
+func authzRequestMAC(cfg config, payload string) string {
+	mac := hmac.New(sha256.New, cfg.authzRequestKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// authzRequestParams returns the parameters of the authorization request
+// being processed. When approving signed requests or displaying them again,
+// they only come from the signed blob, and when sent as a request object,
+// only from the request object.
+func authzRequestParams(req *http.Request, cfg config, approval bool) (map[string]string, error) {
+	if cfg.authzRequestKey != nil && (approval || req.FormValue(AuthzRequestParam) != "") {
+		return verifyAuthzRequest(cfg, req.FormValue(AuthzRequestParam))
+	}
+
+	if req.FormValue(RequestObjectParam) != "" {
+		return requestObjectParams(req, cfg)
+	}
+
+	// Parameters not sent are left out, missing keys read as empty anyway.
+	params := make(map[string]string)
+	for _, v := range authzRequestVars {
+		// FormValue also parses query string if method is GET
+		if value := req.FormValue(v); value != "" {
+			params[v] = value
+		}
+	}
+
+	// Other parameters are kept as extras, see AuthorizationRequest.
+	for k, v := range req.Form {
+		if len(v) > 0 && v[0] != "" && !authzFormVars[k] && !isAuthzRequestVar(k) {
+			params[k] = v[0]
+		}
+	}
+	return params, nil
+}
@@ -0,0 +1,188 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestSignedAuthzRequest tests that approvals are processed with the
+// parameters validated when showing the authorization form, even if they
+// are handled by a different instance.
+func TestSignedAuthzRequest(t *testing.T) {
+	key := []byte("01234567890123456789012345678901")
+	clock := &fakeClock{now: time.Now()}
+
+	newConfig := func() config {
+		cfg := setupTest()
+		cfg.provider = test.NewProvider(true)
+		cfg.clock = clock
+		SetAuthzRequestKey(key)(&cfg)
+		return cfg
+	}
+
+	// Shows authorization form.
+	cfg := newConfig()
+	req := authzRequest(t, cfg)
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	matches := regexp.MustCompile(`name="authz_request" value="([^"]+)"`).FindStringSubmatch(w.Body.String())
+	assert(t, len(matches) == 2, "signed authorization request not found in form: %s", w.Body.String())
+	signed := matches[1]
+
+	approve := func(values url.Values) *httptest.ResponseRecorder {
+		values.Set(ConsentParam, "approve")
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, newConfig())
+		return w
+	}
+
+	// Tampered parameters are ignored.
+	w = approve(url.Values{
+		AuthzRequestParam: {signed},
+		"scope":           {"admin"},
+		"state":           {"forged"},
+	})
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "state-test", u.Query().Get("state"))
+	assert(t, u.Query().Get("code") != "", "expected an authorization code")
+
+	// Requests with invalid signatures or expired are rejected.
+	tampered := strings.Replace(signed, signed[:4], "AAAA", 1)
+	w = approve(url.Values{AuthzRequestParam: {tampered}})
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "invalid_request"), "expected invalid_request error: %s", w.Body.String())
+
+	w = approve(url.Values{})
+	assert(t, strings.Contains(w.Body.String(), "invalid_request"), "expected invalid_request error: %s", w.Body.String())
+
+	clock.Advance(authzRequestMaxAge)
+	w = approve(url.Values{AuthzRequestParam: {signed}})
+	assert(t, strings.Contains(w.Body.String(), "invalid_request"), "expected invalid_request error: %s", w.Body.String())
+}
+
+// TestAuthzRequestRedisplay tests that the authorization form can be displayed
+// again from the signed request, without issuing anything nor extending its
+// expiration.
+func TestAuthzRequestRedisplay(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+	cfg.clock = clock
+	SetAuthzRequestKey([]byte("01234567890123456789012345678901"))(&cfg)
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, authzRequest(t, cfg), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	form := regexp.MustCompile(`name="authz_request" value="([^"]+)"`)
+	signed := form.FindStringSubmatch(w.Body.String())[1]
+
+	redisplay := func(blob string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+url.Values{
+			AuthzRequestParam: {blob},
+		}.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	clock.Advance(authzRequestMaxAge / 2)
+	for i := 0; i < 2; i++ {
+		w = redisplay(signed)
+		equals(t, http.StatusOK, w.Code)
+		equals(t, "no-store", w.Header().Get("Cache-Control"))
+
+		matches := form.FindStringSubmatch(w.Body.String())
+		assert(t, len(matches) == 2, "form not displayed again: %s", w.Body.String())
+		equals(t, signed, matches[1])
+		assert(t, strings.Contains(w.Body.String(), `name="state" value="state-test"`), "state not kept: %s", w.Body.String())
+	}
+	equals(t, 0, len(provider.Grants))
+
+	tampered := strings.Replace(signed, signed[:4], "AAAA", 1)
+	w = redisplay(tampered)
+	assert(t, strings.Contains(w.Body.String(), "invalid_request"), "expected invalid_request error: %s", w.Body.String())
+
+	clock.Advance(authzRequestMaxAge / 2)
+	w = redisplay(signed)
+	assert(t, strings.Contains(w.Body.String(), "invalid_request"), "expected invalid_request error: %s", w.Body.String())
+}
+
+// TestAuthzRequestRestart tests that approving an expired authorization
+// request offers to start it over, with the same parameters.
+func TestAuthzRequestRestart(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+	cfg.clock = clock
+	SetAuthzForm(DefaultAuthzForm)(&cfg)
+	SetAuthzRequestKey([]byte("01234567890123456789012345678901"))(&cfg)
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, authzRequest(t, cfg), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	signed := regexp.MustCompile(`name="authz_request" value="([^"]+)"`).FindStringSubmatch(w.Body.String())[1]
+
+	clock.Advance(authzRequestMaxAge)
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(url.Values{
+		AuthzRequestParam: {signed},
+		ConsentParam:      {ConsentApprove},
+	}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 0, len(provider.Grants))
+
+	body := w.Body.String()
+	assert(t, strings.Contains(body, ErrAuthzRequestExpired.Description), "expected expiration error: %s", body)
+
+	matches := regexp.MustCompile(`<a href="([^"]+)">Restart authorization</a>`).FindStringSubmatch(body)
+	assert(t, len(matches) == 2, "restart link not found: %s", body)
+
+	restart, err := url.Parse(html.UnescapeString(matches[1]))
+	ok(t, err)
+	equals(t, cfg.authzEndpoint, restart.Path)
+	equals(t, provider.Client.ID, restart.Query().Get("client_id"))
+	equals(t, "state-test", restart.Query().Get("state"))
+
+	// Following the link shows the form again.
+	req, err = http.NewRequest("GET", "https://example.com"+restart.String(), nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), `name="authz_request"`), "form not displayed: %s", w.Body.String())
+}
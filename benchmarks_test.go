@@ -0,0 +1,155 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// Allocation budgets. Raising any of them requires a good reason. They are
+// only enforced without the race detector, and not in short mode.
+const (
+	// Requests not addressed to OAuth2 endpoints must go through for free.
+	dispatchAllocs = 0
+	// Rendering the authorization form, measured at 150 with some headroom
+	// for differences between Go versions.
+	createGrantGETAllocs = 165
+)
+
+// nopWriter is a http.ResponseWriter discarding everything, so benchmarks
+// do not measure httptest.ResponseRecorder.
+type nopWriter struct {
+	header http.Header
+}
+
+func (w *nopWriter) Header() http.Header         { return w.header }
+func (w *nopWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *nopWriter) WriteHeader(int)             {}
+
+func newNopWriter() *nopWriter {
+	return &nopWriter{header: make(http.Header)}
+}
+
+func benchHandler() http.Handler {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	return Handler(next,
+		SetProvider(test.NewProvider(true)),
+		SetAuthzForm("<html>{{.Client.Name}}</html>"),
+	)
+}
+
+// skipAllocs skips allocation budget tests when allocations are not
+// representative, or when only quick tests are run.
+func skipAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates on its own")
+	}
+	if testing.Short() {
+		t.Skip("skipping allocation budgets in short mode")
+	}
+}
+
+func authzRequest(tb testing.TB, cfg config) *http.Request {
+	provider := cfg.provider.(*test.Provider)
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read write identity"},
+	}
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return req
+}
+
+func TestDispatchAllocs(t *testing.T) {
+	skipAllocs(t)
+	handler := benchHandler()
+	req, err := http.NewRequest("GET", "https://example.com/resource", nil)
+	ok(t, err)
+	w := newNopWriter()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		handler.ServeHTTP(w, req)
+	})
+	assert(t, allocs <= dispatchAllocs, "dispatch allocated %v times, budget is %d", allocs, dispatchAllocs)
+}
+
+func TestCreateGrantGETAllocs(t *testing.T) {
+	skipAllocs(t)
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	req := authzRequest(t, cfg)
+	w := newNopWriter()
+
+	allocs := testing.AllocsPerRun(100, func() {
+		CreateGrant(w, req, cfg)
+	})
+	assert(t, allocs <= createGrantGETAllocs, "CreateGrant GET allocated %v times, budget is %d", allocs, createGrantGETAllocs)
+}
+
+func BenchmarkHandlerDispatch(b *testing.B) {
+	handler := benchHandler()
+	req, _ := http.NewRequest("GET", "https://example.com/resource", nil)
+	w := newNopWriter()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkCreateGrantGET(b *testing.B) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	req := authzRequest(b, cfg)
+	w := newNopWriter()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CreateGrant(w, req, cfg)
+	}
+}
+
+func BenchmarkCreateGrantPOST(b *testing.B) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	body := authzRequest(b, cfg).URL.RawQuery + "&" + ConsentParam + "=approve"
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+		CreateGrant(newNopWriter(), req, cfg)
+	}
+}
+
+func BenchmarkIssueToken(b *testing.B) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	body := url.Values{"grant_type": {"client_credentials"}}.Encode()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req, _ := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(body))
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+		IssueToken(newNopWriter(), req, cfg)
+	}
+}
@@ -0,0 +1,441 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Upstream is an external OpenID Connect provider resource owners can log in
+// with, instead of the login page of the host application.
+type Upstream struct {
+	// Identifies the upstream provider among the ones of the broker.
+	ID string
+	// Issuer identifier of the upstream, checked against the iss claim of
+	// its ID tokens.
+	Issuer string
+	// Authorization endpoint resource owners are redirected to.
+	AuthorizationEndpoint string
+	// Token endpoint codes are exchanged at. It has to use HTTPS.
+	TokenEndpoint string
+	// Credentials of the authorization server as a client of the upstream.
+	ClientID     string
+	ClientSecret string
+	// Scopes requested along with "openid", such as "email" or "profile".
+	Scopes []string
+}
+
+// UpstreamIdentity is a resource owner as authenticated by an upstream
+// provider.
+type UpstreamIdentity struct {
+	// Identifier of the upstream provider.
+	Upstream string
+	// Issuer and subject of the ID token, identifying the resource owner at
+	// the upstream.
+	Issuer  string
+	Subject string
+	// Profile claims, if the upstream sent them.
+	Email         string
+	EmailVerified bool
+	Name          string
+	// Every claim of the ID token.
+	Claims map[string]interface{}
+	// Local resource owner linked to the identity, or provisioned for it,
+	// if the broker is a Provisioner.
+	User types.User
+}
+
+// Broker delegates the login step of authorization requests to upstream
+// OpenID Connect providers, configured per tenant. Once the upstream
+// authenticates the resource owner, the authorization request continues
+// with the local consent and grant flow. See SetBroker.
+type Broker interface {
+	// UpstreamFor returns the upstream provider resource owners log in with
+	// for the authorization request, for instance, depending on its host or
+	// on a tenant extension parameter. A zero Upstream falls back to the
+	// login URL. See SetLoginURL.
+	UpstreamFor(req *http.Request) (Upstream, error)
+	// Upstream returns the upstream provider with the given identifier.
+	Upstream(id string) (Upstream, error)
+	// SignIn maps the upstream identity to a local resource owner, unless
+	// the broker is a Provisioner, and starts their session, for instance,
+	// setting a cookie on w, so they are authenticated when sent back to
+	// the authorization endpoint.
+	SignIn(w http.ResponseWriter, req *http.Request, identity UpstreamIdentity) (types.User, error)
+}
+
+// SetBroker delegates the login of resource owners to the upstream
+// providers of the given broker. Upstream providers redirect resource
+// owners back to the broker endpoint, which needs to be registered with
+// them. See SetBrokerEndpoint.
+func SetBroker(b Broker) option {
+	return func(c *config) {
+		c.broker = b
+	}
+}
+
+// SetBrokerEndpoint allows setting the endpoint upstream providers redirect
+// resource owners back to. Defaults to "/oauth2/broker".
+func SetBrokerEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.brokerEndpoint = endpoint
+	}
+}
+
+// BrokerHandlers is a map to functions where each function handles a particular HTTP
+// verb or method.
+var BrokerHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET": unlessReadOnly(BrokerCallback),
+}
+
+// brokerCookie keeps the login through an upstream provider bound to the
+// browser that started it, for the callback to resume it.
+const brokerCookie = "oauth2_broker"
+
+// brokerLoginMaxAge is how long resource owners have to log in upstream.
+const brokerLoginMaxAge = time.Duration(10) * time.Minute
+
+// brokerClient sends token requests to upstream providers.
+var brokerClient = &http.Client{Timeout: time.Duration(10) * time.Second}
+
+// Key signing broker logins, if no authorization request key is set.
+var brokerKey struct {
+	once sync.Once
+	key  []byte
+}
+
+// brokerLogin is the login in progress through an upstream provider, kept
+// in a signed cookie.
+type brokerLogin struct {
+	Upstream     string `json:"u"`
+	State        string `json:"s"`
+	Nonce        string `json:"n"`
+	CodeVerifier string `json:"v"`
+	// Authorization request to resume once logged in.
+	ReturnURL string `json:"r"`
+	ExpiresAt int64  `json:"e"`
+}
+
+// upstreamLogin redirects the resource owner to the upstream provider of
+// the authorization request, if there is one, telling whether it did.
+func upstreamLogin(w http.ResponseWriter, req *http.Request, cfg config) bool {
+	upstream, err := cfg.broker.UpstreamFor(req)
+	if err != nil {
+		renderBrokerError(w, req, cfg, serverError(req, cfg, "", err))
+		return true
+	}
+
+	if upstream.ID == "" {
+		return false
+	}
+
+	login := brokerLogin{
+		Upstream:  upstream.ID,
+		ReturnURL: req.URL.RequestURI(),
+		ExpiresAt: now(cfg).Add(brokerLoginMaxAge).Unix(),
+	}
+
+	for _, v := range []*string{&login.State, &login.Nonce, &login.CodeVerifier} {
+		if *v, err = tokengen.Default.Generate(); err != nil {
+			renderBrokerError(w, req, cfg, serverError(req, cfg, "", err))
+			return true
+		}
+	}
+
+	cookie, err := signBrokerLogin(cfg, login)
+	if err != nil {
+		renderBrokerError(w, req, cfg, serverError(req, cfg, "", err))
+		return true
+	}
+
+	// Lax, so the cookie comes along with the redirect back from the upstream.
+	http.SetCookie(w, &http.Cookie{
+		Name:     brokerCookie,
+		Value:    cookie,
+		Path:     cfg.brokerEndpoint,
+		MaxAge:   int(brokerLoginMaxAge.Seconds()),
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	challenge := sha256.Sum256([]byte(login.CodeVerifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {upstream.ClientID},
+		"redirect_uri":          {brokerRedirectURI(req, cfg)},
+		"scope":                 {strings.Join(append([]string{"openid"}, upstream.Scopes...), " ")},
+		"state":                 {login.State},
+		"nonce":                 {login.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {CodeChallengeS256},
+	}
+
+	u := upstream.AuthorizationEndpoint + "?" + query.Encode()
+	if strings.Contains(upstream.AuthorizationEndpoint, "?") {
+		u = upstream.AuthorizationEndpoint + "&" + query.Encode()
+	}
+
+	log.Printf("[INFO] request_id=%s Sending resource owner to upstream %s to log in", RequestID(req), upstream.ID)
+	http.Redirect(w, req, u, http.StatusFound)
+	return true
+}
+
+// BrokerCallback receives resource owners back from upstream providers,
+// exchanging the code for an ID token, and signs them in with the identity
+// it asserts before resuming their authorization request.
+func BrokerCallback(w http.ResponseWriter, req *http.Request, cfg config) {
+	if cfg.broker == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	login, err := verifyBrokerLogin(req, cfg)
+	if err != nil {
+		log.Printf("[WARN] request_id=%s Invalid upstream login: %v", RequestID(req), err)
+		renderBrokerError(w, req, cfg, ErrUpstreamStateInvalid)
+		return
+	}
+
+	// Logins can only be resumed once.
+	http.SetCookie(w, &http.Cookie{
+		Name:     brokerCookie,
+		Path:     cfg.brokerEndpoint,
+		MaxAge:   -1,
+		Secure:   true,
+		HttpOnly: true,
+	})
+
+	if e := req.FormValue("error"); e != "" {
+		log.Printf("[WARN] request_id=%s Upstream %s refused to log in resource owner: %s", RequestID(req), login.Upstream, e)
+		renderBrokerError(w, req, cfg, ErrUpstreamLoginFailed)
+		return
+	}
+
+	upstream, err := cfg.broker.Upstream(login.Upstream)
+	if err != nil {
+		renderBrokerError(w, req, cfg, serverError(req, cfg, "", err))
+		return
+	}
+
+	identity, err := upstreamIdentity(req, cfg, upstream, login)
+	if err != nil {
+		log.Printf("[WARN] request_id=%s Error logging in through upstream %s: %v", RequestID(req), upstream.ID, err)
+		renderBrokerError(w, req, cfg, ErrUpstreamLoginFailed)
+		return
+	}
+
+	if p, ok := cfg.broker.(Provisioner); ok {
+		identity.User, err = linkedUser(req, cfg, p, identity)
+		if err != nil {
+			log.Printf("[WARN] request_id=%s Error provisioning %s of upstream %s: %v", RequestID(req), identity.Subject, upstream.ID, err)
+			resumeWithError(w, req, cfg, login.ReturnURL, provisioningError(req, cfg, err))
+			return
+		}
+	}
+
+	user, err := cfg.broker.SignIn(w, req, identity)
+	if err != nil {
+		log.Printf("[WARN] request_id=%s Error signing in %s of upstream %s: %v", RequestID(req), identity.Subject, upstream.ID, err)
+		renderBrokerError(w, req, cfg, ErrUpstreamLoginFailed)
+		return
+	}
+
+	audit(req, cfg, types.AuditEvent{
+		Type:   types.AuditUpstreamLogin,
+		UserID: user.ID,
+		Details: map[string]string{
+			"upstream": upstream.ID,
+			"issuer":   identity.Issuer,
+			"subject":  identity.Subject,
+		},
+	})
+
+	http.Redirect(w, req, login.ReturnURL, http.StatusFound)
+}
+
+// upstreamIdentity exchanges the code sent back by the upstream provider
+// for an ID token, and returns the identity it asserts.
+func upstreamIdentity(req *http.Request, cfg config, upstream Upstream, login brokerLogin) (UpstreamIdentity, error) {
+	endpoint, err := url.Parse(upstream.TokenEndpoint)
+	if err != nil || endpoint.Scheme != "https" {
+		return UpstreamIdentity{}, fmt.Errorf("token endpoint %q does not use HTTPS", upstream.TokenEndpoint)
+	}
+
+	body := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {req.FormValue("code")},
+		"redirect_uri":  {brokerRedirectURI(req, cfg)},
+		"code_verifier": {login.CodeVerifier},
+	}
+
+	treq, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(body.Encode()))
+	if err != nil {
+		return UpstreamIdentity{}, err
+	}
+	treq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	treq.Header.Set("Accept", "application/json")
+	treq.SetBasicAuth(url.QueryEscape(upstream.ClientID), url.QueryEscape(upstream.ClientSecret))
+
+	res, err := brokerClient.Do(treq)
+	if err != nil {
+		return UpstreamIdentity{}, err
+	}
+	defer res.Body.Close()
+
+	var tres struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tres); err != nil {
+		return UpstreamIdentity{}, fmt.Errorf("malformed token response: %v", err)
+	}
+
+	if res.StatusCode != http.StatusOK || tres.IDToken == "" {
+		return UpstreamIdentity{}, fmt.Errorf("token request failed with status %d: %s", res.StatusCode, tres.Error)
+	}
+
+	// The ID token comes straight from the token endpoint of the upstream,
+	// over TLS, which is enough to trust it came from the issuer. See
+	// http://openid.net/specs/openid-connect-core-1_0.html#IDTokenValidation
+	token, err := jwt.Parse(tres.IDToken)
+	if err != nil {
+		return UpstreamIdentity{}, err
+	}
+
+	if err := token.Claims.Validate(now(cfg), assertionLeeway); err != nil {
+		return UpstreamIdentity{}, err
+	}
+
+	var claims struct {
+		Nonce         string `json:"nonce"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := token.Decode(&claims); err != nil {
+		return UpstreamIdentity{}, err
+	}
+
+	switch {
+	case token.Claims.Issuer != upstream.Issuer:
+		return UpstreamIdentity{}, fmt.Errorf("ID token issued by %q", token.Claims.Issuer)
+	case !token.Claims.Audience.Contains(upstream.ClientID):
+		return UpstreamIdentity{}, errors.New("ID token issued to another client")
+	case !hmac.Equal([]byte(claims.Nonce), []byte(login.Nonce)):
+		return UpstreamIdentity{}, errors.New("ID token nonce mismatch")
+	case token.Claims.Subject == "":
+		return UpstreamIdentity{}, errors.New("ID token without subject")
+	}
+
+	identity := UpstreamIdentity{
+		Upstream:      upstream.ID,
+		Issuer:        token.Claims.Issuer,
+		Subject:       token.Claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}
+	if err := token.Decode(&identity.Claims); err != nil {
+		return UpstreamIdentity{}, err
+	}
+	return identity, nil
+}
+
+// brokerRedirectURI returns the URI upstream providers send resource owners
+// back to.
+func brokerRedirectURI(req *http.Request, cfg config) string {
+	return "https://" + req.Host + cfg.brokerEndpoint
+}
+
+func renderBrokerError(w http.ResponseWriter, req *http.Request, cfg config, err types.AuthzError) {
+	render.HTML(w, render.Options{
+		Status: http.StatusOK,
+		Data: AuthzData{
+			Errors: []types.AuthzError{localize(req, cfg, err)},
+		},
+		Template:  cfg.authzForm,
+		STSMaxAge: cfg.stsMaxAge,
+	})
+}
+
+// signBrokerLogin returns the login as the value of the broker cookie.
+func signBrokerLogin(cfg config, login brokerLogin) (string, error) {
+	payload, err := json.Marshal(login)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + brokerLoginMAC(cfg, encoded), nil
+}
+
+// verifyBrokerLogin returns the login started by the browser sending the
+// request, if its state matches the one sent back by the upstream provider
+// and it has not expired yet.
+func verifyBrokerLogin(req *http.Request, cfg config) (brokerLogin, error) {
+	var login brokerLogin
+	cookie, err := req.Cookie(brokerCookie)
+	if err != nil {
+		return login, errors.New("no login in progress")
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 2 || !hmac.Equal([]byte(parts[1]), []byte(brokerLoginMAC(cfg, parts[0]))) {
+		return login, errors.New("invalid login cookie")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return login, errors.New("invalid login cookie")
+	}
+
+	if err := json.Unmarshal(payload, &login); err != nil {
+		return login, errors.New("invalid login cookie")
+	}
+
+	if !now(cfg).Before(time.Unix(login.ExpiresAt, 0)) {
+		return login, errors.New("login expired")
+	}
+
+	if !hmac.Equal([]byte(req.FormValue("state")), []byte(login.State)) {
+		return login, errors.New("state mismatch")
+	}
+	return login, nil
+}
+
+func brokerLoginMAC(cfg config, payload string) string {
+	key := cfg.authzRequestKey
+	if key == nil {
+		brokerKey.once.Do(func() {
+			brokerKey.key = make([]byte, 32)
+			if _, err := rand.Read(brokerKey.key); err != nil {
+				log.Fatalf("Error generating broker login key: %v", err)
+			}
+		})
+		key = brokerKey.key
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("broker-login\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
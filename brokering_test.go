@@ -0,0 +1,166 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+type testBroker struct {
+	upstream   Upstream
+	identities []UpstreamIdentity
+}
+
+func (b *testBroker) UpstreamFor(req *http.Request) (Upstream, error) {
+	if req.URL.Query().Get("tenant") != "acme" {
+		return Upstream{}, nil
+	}
+	return b.upstream, nil
+}
+
+func (b *testBroker) Upstream(id string) (Upstream, error) {
+	if id != b.upstream.ID {
+		return Upstream{}, errors.New("unknown upstream")
+	}
+	return b.upstream, nil
+}
+
+func (b *testBroker) SignIn(w http.ResponseWriter, req *http.Request, identity UpstreamIdentity) (types.User, error) {
+	b.identities = append(b.identities, identity)
+	return types.User{ID: "local-" + identity.Subject}, nil
+}
+
+// TestBrokerLogin tests that resource owners of tenants with an upstream
+// provider log in through it, and are sent back to their authorization
+// request once signed in.
+func TestBrokerLogin(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	var nonce, challenge string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id, secret, _ := req.BasicAuth()
+		sum := sha256.Sum256([]byte(req.FormValue("code_verifier")))
+		if id != "broker" || secret != "s3cret" || req.FormValue("code") != "upstream-code" ||
+			base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		idToken, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256}, map[string]interface{}{
+			"iss":   "https://idp.acme.com",
+			"sub":   "jdoe",
+			"aud":   "broker",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": nonce,
+			"email": "jdoe@acme.com",
+		}, key)
+		ok(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	}))
+	defer upstream.Close()
+
+	client := brokerClient
+	brokerClient = upstream.Client()
+	defer func() { brokerClient = client }()
+
+	broker := &testBroker{upstream: Upstream{
+		ID:                    "acme",
+		Issuer:                "https://idp.acme.com",
+		AuthorizationEndpoint: "https://idp.acme.com/authorize",
+		TokenEndpoint:         upstream.URL + "/token",
+		ClientID:              "broker",
+		ClientSecret:          "s3cret",
+		Scopes:                []string{"email"},
+	}}
+
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(false)
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+	SetBroker(broker)(&cfg)
+	SetBrokerEndpoint("/oauth2/broker")(&cfg)
+
+	// Tenants without an upstream log in locally.
+	w := httptest.NewRecorder()
+	CreateGrant(w, authzRequest(t, cfg), cfg)
+	equals(t, http.StatusFound, w.Code)
+	assert(t, strings.HasPrefix(w.Header().Get("Location"), "https://api.hooklift.io/accounts/login?"), "expected the login URL, got %s", w.Header().Get("Location"))
+
+	areq := authzRequest(t, cfg)
+	areq.URL.RawQuery += "&tenant=acme"
+	w = httptest.NewRecorder()
+	CreateGrant(w, areq, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "idp.acme.com", u.Host)
+	equals(t, "broker", u.Query().Get("client_id"))
+	equals(t, "https://example.com/oauth2/broker", u.Query().Get("redirect_uri"))
+	equals(t, "openid email", u.Query().Get("scope"))
+	equals(t, CodeChallengeS256, u.Query().Get("code_challenge_method"))
+	nonce, challenge = u.Query().Get("nonce"), u.Query().Get("code_challenge")
+
+	cookies := w.Result().Cookies()
+	equals(t, 1, len(cookies))
+	equals(t, brokerCookie, cookies[0].Name)
+
+	callback := func(state string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/broker?code=upstream-code&state="+url.QueryEscape(state), nil)
+		ok(t, err)
+		req.AddCookie(cookies[0])
+
+		w := httptest.NewRecorder()
+		BrokerCallback(w, req, cfg)
+		return w
+	}
+
+	// Callbacks not matching the login started by the browser are refused.
+	w = callback("forged")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrUpstreamStateInvalid.Description), "expected an invalid state error: %s", w.Body)
+	equals(t, 0, len(broker.identities))
+
+	w = callback(u.Query().Get("state"))
+	equals(t, http.StatusFound, w.Code)
+	equals(t, areq.URL.RequestURI(), w.Header().Get("Location"))
+
+	equals(t, 1, len(broker.identities))
+	identity := broker.identities[0]
+	equals(t, "acme", identity.Upstream)
+	equals(t, "https://idp.acme.com", identity.Issuer)
+	equals(t, "jdoe", identity.Subject)
+	equals(t, "jdoe@acme.com", identity.Email)
+
+	equals(t, 1, len(*events))
+	equals(t, types.AuditUpstreamLogin, (*events)[0].Type)
+	equals(t, "local-jdoe", (*events)[0].UserID)
+	equals(t, "jdoe", (*events)[0].Details["subject"])
+
+	// ID tokens with another nonce are refused.
+	nonce = "replayed"
+	w = callback(u.Query().Get("state"))
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrUpstreamLoginFailed.Description), "expected a login error: %s", w.Body)
+	equals(t, 1, len(broker.identities))
+}
@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Capabilities reports the grant types, response types, client
+// authentication methods, token formats and policies the authorization
+// server supports, as currently configured. It is what the authorization
+// server metadata is generated from, and lets applications assert, in their
+// own tests, that the server is configured as expected:
+//
+//	caps := s.Capabilities()
+//	if !caps.Policies.PKCERequired {
+//		t.Error("PKCE is expected to be required")
+//	}
+//
+// Options overridden with SetEndpointOptions are not taken into account.
+//
+// It panics if a resource server created with AuthzHandler does not enforce
+// the OAuth 2.1 mode of the authorization server, which happens when
+// SetOAuth21 is reloaded without creating the resource server again.
+func (s *Server) Capabilities() types.Capabilities {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cfg := s.current.Load().(*snapshot).cfg
+	for _, rs := range s.resourceServers {
+		if rs.oauth21 != cfg.oauth21 {
+			panic(fmt.Sprintf("oauth2: OAuth 2.1 mode of the authorization server (%t) and of a resource server (%t) disagree", cfg.oauth21, rs.oauth21))
+		}
+	}
+	return capabilities(cfg, now(cfg))
+}
+
+// capabilities returns what the configuration supports at the given time.
+// Disabled flows, and those past their sunset, are left out.
+func capabilities(cfg config, t time.Time) types.Capabilities {
+	caps := types.Capabilities{
+		ResponseModes:            []string{ResponseModeQuery, ResponseModeFragment, ResponseModeFormPost},
+		TokenEndpointAuthMethods: []string{"client_secret_basic"},
+		TokenFormats:             []string{types.TokenFormatOpaque},
+		CodeChallengeMethods:     codeChallengeMethods(cfg),
+		Policies: types.CapabilityPolicies{
+			PKCERequired:                pkceRequired(cfg),
+			PasswordGrantFirstPartyOnly: cfg.passwordGrantPolicy == PasswordGrantFirstParty,
+			RefreshTokenRotation:        !cfg.refreshRotationDisabled,
+			RememberConsent:             cfg.rememberConsent,
+			RedirectQuarantine:          cfg.redirectQuarantine,
+			SecretHashing:               cfg.secretHashing.enabled,
+			Strict:                      cfg.strict,
+			ReadOnly:                    t.Before(cfg.readOnlyUntil),
+			OAuth21:                     cfg.oauth21,
+		},
+	}
+
+	if cfg.keyProvider != nil {
+		caps.TokenFormats = append(caps.TokenFormats, types.TokenFormatJWT)
+	}
+
+	grants := []string{"authorization_code", "implicit", "password", "client_credentials", "refresh_token", JWTBearerGrantType}
+	if _, ok := unwrap(cfg.provider).(DeviceCodeProvider); ok {
+		grants = append(grants, DeviceCodeGrantType)
+	}
+	if _, ok := unwrap(cfg.provider).(TokenExchangeProvider); ok {
+		grants = append(grants, TokenExchangeGrantType)
+	}
+
+	supported := func(grantType string) bool {
+		if grantType == "password" && cfg.passwordGrantPolicy == PasswordGrantDisabled {
+			return false
+		}
+		return grantTypeAllowed(cfg, grantType) && !retiredAt(cfg, grantType, t)
+	}
+
+	caps.GrantTypes = make([]string, 0, len(grants))
+	for _, g := range grants {
+		if supported(g) {
+			caps.GrantTypes = append(caps.GrantTypes, g)
+		}
+	}
+
+	caps.ResponseTypes = make([]string, 0, 2)
+	if supported("authorization_code") {
+		caps.ResponseTypes = append(caps.ResponseTypes, "code")
+	}
+	if supported("implicit") {
+		caps.ResponseTypes = append(caps.ResponseTypes, "token")
+	}
+	return caps
+}
+
+// getCapabilities returns the capabilities of the authorization server, as
+// configured by the options given to AdminHandler.
+func getCapabilities(w http.ResponseWriter, req *http.Request, cfg config, _ string) {
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   capabilities(cfg, now(cfg)),
+	})
+}
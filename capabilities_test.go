@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestCapabilities tests that the capabilities reported match the
+// configuration, and the authorization server metadata.
+func TestCapabilities(t *testing.T) {
+	provider := test.NewProvider(true)
+	s := Handler(nil, SetProvider(provider))
+
+	caps := s.Capabilities()
+	equals(t, []string{"authorization_code", "implicit", "password", "client_credentials", "refresh_token", JWTBearerGrantType, DeviceCodeGrantType}, caps.GrantTypes)
+	equals(t, []string{"code", "token"}, caps.ResponseTypes)
+	equals(t, []string{"client_secret_basic"}, caps.TokenEndpointAuthMethods)
+	equals(t, []string{types.TokenFormatOpaque}, caps.TokenFormats)
+	equals(t, types.CapabilityPolicies{RefreshTokenRotation: true}, caps.Policies)
+
+	s.Reload(
+		SetProvider(provider),
+		SetAllowedGrantTypes("authorization_code", "refresh_token"),
+		SetPKCEPolicy(PKCERequiredS256),
+		SetRefreshTokenRotation(false),
+		SetReadOnlyUntil(time.Now().Add(time.Hour)),
+	)
+
+	caps = s.Capabilities()
+	equals(t, []string{"authorization_code", "refresh_token"}, caps.GrantTypes)
+	equals(t, []string{"code"}, caps.ResponseTypes)
+	equals(t, []string{CodeChallengeS256}, caps.CodeChallengeMethods)
+	equals(t, types.CapabilityPolicies{PKCERequired: true, ReadOnly: true}, caps.Policies)
+
+	req, err := http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+	ok(t, err)
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	var metadata struct {
+		GrantTypes           []string `json:"grant_types_supported"`
+		ResponseTypes        []string `json:"response_types_supported"`
+		AuthMethods          []string `json:"token_endpoint_auth_methods_supported"`
+		CodeChallengeMethods []string `json:"code_challenge_methods_supported"`
+	}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+	equals(t, caps.GrantTypes, metadata.GrantTypes)
+	equals(t, caps.ResponseTypes, metadata.ResponseTypes)
+	equals(t, caps.TokenEndpointAuthMethods, metadata.AuthMethods)
+	equals(t, caps.CodeChallengeMethods, metadata.CodeChallengeMethods)
+
+	// The admin API reports them as JSON.
+	req, err = http.NewRequest("GET", "/capabilities", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	AdminHandler(provider, SetPasswordGrantPolicy(PasswordGrantDisabled), SetRememberConsent(true)).ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	var reported types.Capabilities
+	ok(t, json.Unmarshal(w.Body.Bytes(), &reported))
+	equals(t, []string{"authorization_code", "implicit", "client_credentials", "refresh_token", JWTBearerGrantType, DeviceCodeGrantType}, reported.GrantTypes)
+	equals(t, types.CapabilityPolicies{RefreshTokenRotation: true, RememberConsent: true}, reported.Policies)
+}
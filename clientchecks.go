@@ -0,0 +1,347 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ClientCheckProvider is an optional interface that providers can implement
+// in order to have the endpoints registered by clients revalidated
+// periodically. It is required by SetClientRevalidation.
+type ClientCheckProvider interface {
+	// Clients returns every registered client.
+	Clients() ([]types.Client, error)
+	// SaveClientCheck stores the outcome of the last revalidation of a client.
+	SaveClientCheck(check types.ClientCheck) error
+	// ClientCheck returns the outcome of the last revalidation of a client.
+	// Its ClientID is empty if the client was never checked.
+	ClientCheck(clientID string) (types.ClientCheck, error)
+}
+
+// ErrClientCheckProviderRequired is returned when revalidating clients with
+// a provider that does not implement ClientCheckProvider.
+var ErrClientCheckProviderRequired = errors.New("oauth2: provider does not implement oauth2.ClientCheckProvider")
+
+// SetClientRevalidation revalidates the HTTPS endpoints registered by clients
+// every given interval, in the background: their redirect URLs, and the
+// URLs of their logo, homepage, terms of service and privacy policy. An
+// endpoint is failing if its host does not resolve or does not answer over
+// HTTPS, whatever the response. Outcomes are stored with the provider, which
+// has to implement ClientCheckProvider, and returned by the admin API.
+//
+// Clients whose endpoints keep failing for suspendAfter are suspended, as
+// their domains may have expired and been registered by someone else, which
+// requires the provider to implement ClientLifecycleProvider. Clients are
+// never suspended if suspendAfter is zero.
+func SetClientRevalidation(interval, suspendAfter time.Duration) option {
+	return func(c *config) {
+		c.clientRevalidation.interval = interval
+		c.clientRevalidation.suspendAfter = suspendAfter
+	}
+}
+
+// clientCheckTimeout bounds how long checking an endpoint can take.
+const clientCheckTimeout = time.Duration(10) * time.Second
+
+// clientCheckClient sends the requests checking client endpoints. Redirects
+// are not followed, answering with one is enough.
+var clientCheckClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// clientRevalidator revalidates the endpoints of every client periodically.
+// It is a Worker of the Server returned by Handler.
+type clientRevalidator struct {
+	cfg      config
+	provider ClientCheckProvider
+	started  sync.Once
+	stopped  sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func newClientRevalidator(cfg config, provider ClientCheckProvider) *clientRevalidator {
+	return &clientRevalidator{
+		cfg:      cfg,
+		provider: provider,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start revalidates clients in the background, until Shutdown.
+func (r *clientRevalidator) Start() {
+	r.started.Do(func() {
+		go r.run()
+	})
+}
+
+// Shutdown stops revalidating clients once the client being checked is done.
+func (r *clientRevalidator) Shutdown(ctx context.Context) error {
+	r.started.Do(func() {
+		close(r.done)
+	})
+	r.stopped.Do(func() {
+		close(r.stop)
+	})
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *clientRevalidator) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.cfg.clientRevalidation.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.revalidate()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// revalidate checks every client, unless stopped.
+func (r *clientRevalidator) revalidate() {
+	clients, err := r.provider.Clients()
+	if err != nil {
+		log.Printf("[ERROR] Error listing clients to revalidate: %+v", err)
+		return
+	}
+
+	for _, client := range clients {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		if clientStatus(client) == types.ClientDeleted {
+			continue
+		}
+
+		if _, err := revalidateClient(nil, r.cfg, r.provider, client); err != nil {
+			log.Printf("[ERROR] Error revalidating client %s: %+v", client.ID, err)
+		}
+	}
+}
+
+// revalidateClient checks the endpoints of a client, suspending it if they
+// have been failing for too long, and stores the outcome.
+func revalidateClient(req *http.Request, cfg config, provider ClientCheckProvider, client types.Client) (types.ClientCheck, error) {
+	prev, err := provider.ClientCheck(client.ID)
+	if err != nil {
+		return types.ClientCheck{}, err
+	}
+
+	check := types.ClientCheck{
+		ClientID:  client.ID,
+		CheckedAt: now(cfg),
+		Failures:  checkClientEndpoints(client),
+	}
+
+	if len(check.Failures) > 0 {
+		check.FailingSince = prev.FailingSince
+		check.Suspended = prev.Suspended
+		if check.FailingSince.IsZero() {
+			check.FailingSince = check.CheckedAt
+			log.Printf("[WARN] request_id=%s Endpoints of client %s started failing: %d", RequestID(req), client.ID, len(check.Failures))
+			auditClientCheck(req, cfg, check)
+		}
+
+		suspendAfter := cfg.clientRevalidation.suspendAfter
+		if suspendAfter > 0 && clientStatus(client) == types.ClientApproved &&
+			!check.CheckedAt.Before(check.FailingSince.Add(suspendAfter)) {
+			p, ok := asClientLifecycleProvider(cfg.provider)
+			if !ok {
+				return check, ErrClientLifecycleProviderRequired
+			}
+
+			if err := p.SetClientStatus(client.ID, types.ClientSuspended); err != nil {
+				return check, err
+			}
+
+			check.Suspended = true
+			log.Printf("[WARN] request_id=%s Client %s suspended, its endpoints have been failing since %s",
+				RequestID(req), client.ID, check.FailingSince.Format(time.RFC3339))
+			auditClientCheck(req, cfg, check)
+		}
+	}
+	return check, provider.SaveClientCheck(check)
+}
+
+func auditClientCheck(req *http.Request, cfg config, check types.ClientCheck) {
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditClientEndpointsFailing,
+		ClientID: check.ClientID,
+		Details: map[string]string{
+			"failures":  strconv.Itoa(len(check.Failures)),
+			"suspended": strconv.FormatBool(check.Suspended),
+		},
+	})
+}
+
+// checkClientEndpoints returns the HTTPS endpoints of a client that can not
+// be reached. Endpoints with other schemes, such as the loopback or custom
+// scheme redirect URLs of native apps, and wildcard ones are left out.
+func checkClientEndpoints(client types.Client) []types.EndpointFailure {
+	type endpoint struct {
+		metadata string
+		u        *url.URL
+	}
+
+	var endpoints []endpoint
+	for _, u := range client.RegisteredRedirectURLs() {
+		endpoints = append(endpoints, endpoint{"redirect_uris", u})
+	}
+	endpoints = append(endpoints,
+		endpoint{"logo_uri", client.LogoURL},
+		endpoint{"client_uri", client.HomepageURL},
+		endpoint{"tos_uri", client.TermsOfServiceURL},
+		endpoint{"policy_uri", client.PolicyURL},
+	)
+
+	var failures []types.EndpointFailure
+	checked := make(map[string]bool)
+	for _, e := range endpoints {
+		if e.u == nil || e.u.Scheme != "https" || strings.Contains(e.u.Host, "*") || checked[e.u.String()] {
+			continue
+		}
+		checked[e.u.String()] = true
+
+		if err := checkEndpoint(e.u); err != nil {
+			failures = append(failures, types.EndpointFailure{
+				Metadata: e.metadata,
+				URL:      e.u.String(),
+				Error:    err.Error(),
+			})
+		}
+	}
+	return failures
+}
+
+// checkEndpoint tells whether the endpoint's host resolves and answers over HTTPS.
+func checkEndpoint(u *url.URL) error {
+	ctx, cancel := context.WithTimeout(context.Background(), clientCheckTimeout)
+	defer cancel()
+
+	if _, err := net.DefaultResolver.LookupHost(ctx, u.Hostname()); err != nil {
+		return fmt.Errorf("dns: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := clientCheckClient.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// getClientCheck returns the outcome of the last revalidation of a client.
+func getClientCheck(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientCheckProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientCheckProviderRequired),
+		})
+		return
+	}
+
+	check, err := provider.ClientCheck(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if check.ClientID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrNotFound),
+		})
+		return
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   check,
+	})
+}
+
+// checkClient revalidates a client right away, for operators to confirm its
+// endpoints were fixed, and returns the outcome.
+func checkClient(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientCheckProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientCheckProviderRequired),
+		})
+		return
+	}
+
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if client.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrClientIDNotFound),
+		})
+		return
+	}
+
+	check, err := revalidateClient(req, cfg, provider, client)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   check,
+	})
+}
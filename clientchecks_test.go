@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestClientRevalidation tests that clients whose endpoints keep failing are
+// reported, and suspended once failing for too long.
+func TestClientRevalidation(t *testing.T) {
+	endpoint := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, "/elsewhere", http.StatusFound)
+	}))
+	defer endpoint.Close()
+
+	client := clientCheckClient
+	tlsClient := *endpoint.Client()
+	tlsClient.CheckRedirect = client.CheckRedirect
+	clientCheckClient = &tlsClient
+	defer func() { clientCheckClient = client }()
+
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	redirectURL, err := url.Parse(endpoint.URL + "/callback")
+	ok(t, err)
+	provider.Client.RedirectURL = redirectURL
+	provider.Client.LogoURL = nil
+	provider.Client.HomepageURL = nil
+
+	events := &auditLog{}
+	admin := AdminHandler(provider, SetClock(clock), SetAuditor(events), SetClientRevalidation(time.Hour, 48*time.Hour))
+	adminRequest := func(method string) (*httptest.ResponseRecorder, types.ClientCheck) {
+		req, err := http.NewRequest(method, "https://example.com/clients/test_client_id/check", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+
+		var check types.ClientCheck
+		if w.Code == http.StatusOK {
+			ok(t, json.Unmarshal(w.Body.Bytes(), &check))
+		}
+		return w, check
+	}
+
+	w, _ := adminRequest("GET")
+	equals(t, http.StatusNotFound, w.Code)
+
+	// Redirects are answers as good as any other.
+	w, check := adminRequest("POST")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, len(check.Failures) == 0 && check.FailingSince.IsZero(), "expected endpoints not to be failing: %+v", check)
+
+	provider.Client.PolicyURL, err = url.Parse("https://policy.example.invalid/privacy")
+	ok(t, err)
+	_, check = adminRequest("POST")
+	equals(t, 1, len(check.Failures))
+	equals(t, "policy_uri", check.Failures[0].Metadata)
+	equals(t, clock.now, check.FailingSince)
+	equals(t, 1, len(*events))
+	equals(t, types.AuditClientEndpointsFailing, (*events)[0].Type)
+
+	clock.Advance(24 * time.Hour)
+	_, check = adminRequest("POST")
+	equals(t, clock.now.Add(-24*time.Hour), check.FailingSince)
+	equals(t, types.ClientApproved, clientStatus(provider.Client))
+
+	clock.Advance(24 * time.Hour)
+	_, check = adminRequest("POST")
+	assert(t, check.Suspended, "expected the client to be suspended: %+v", check)
+	equals(t, types.ClientSuspended, provider.Client.Status)
+	equals(t, 2, len(*events))
+	equals(t, "true", (*events)[1].Details["suspended"])
+
+	w, check = adminRequest("GET")
+	equals(t, http.StatusOK, w.Code)
+	assert(t, check.Suspended, "expected the last check to be returned: %+v", check)
+
+	// Fixed endpoints stop failing, clients are approved again by operators.
+	provider.Client.PolicyURL = nil
+	_, check = adminRequest("POST")
+	assert(t, check.FailingSince.IsZero() && !check.Suspended, "expected endpoints not to be failing: %+v", check)
+}
@@ -0,0 +1,173 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ClientDeletionProvider is an optional interface that providers can
+// implement in order to delete clients through the admin API.
+type ClientDeletionProvider interface {
+	// DeleteClient deletes a client for good, along with its grants and tokens.
+	DeleteClient(clientID string) error
+
+	// SoftDeleteClient sets the status of a client to types.ClientDeleted
+	// and its PurgeAt time. Its grants and tokens are kept until then, when
+	// the provider is expected to delete them along with the client.
+	SoftDeleteClient(clientID string, purgeAt time.Time) error
+
+	// RestoreClient sets the status of a soft-deleted client to
+	// types.ClientApproved and clears its PurgeAt time.
+	RestoreClient(clientID string) error
+}
+
+// ErrClientDeletionProviderRequired is returned when deleting clients with a
+// provider that does not implement ClientDeletionProvider.
+var ErrClientDeletionProviderRequired = errors.New("oauth2: provider does not implement oauth2.ClientDeletionProvider")
+
+// SetClientDeletionGrace soft-deletes clients deleted through the admin API,
+// instead of deleting them right away. Soft-deleted clients can not be
+// authorized anymore, but their tokens keep working and can be refreshed
+// for the given grace period, during which they can be restored. It
+// prevents outages caused by deleting the wrong client.
+func SetClientDeletionGrace(grace time.Duration) option {
+	return func(c *config) {
+		c.clientDeletionGrace = grace
+	}
+}
+
+// gracefulRefresh tells whether a soft-deleted client is refreshing tokens
+// within its grace period.
+func gracefulRefresh(req *http.Request, cfg config, client types.Client) bool {
+	return clientStatus(client) == types.ClientDeleted &&
+		grantType(req, cfg) == "refresh_token" &&
+		now(cfg).Before(client.PurgeAt)
+}
+
+// deleteClient deletes a client, or soft-deletes it if a grace period is set.
+func deleteClient(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientDeletionProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientDeletionProviderRequired),
+		})
+		return
+	}
+
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if client.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrClientIDNotFound),
+		})
+		return
+	}
+
+	if cfg.clientDeletionGrace <= 0 {
+		if err := provider.DeleteClient(client.ID); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+		publishRevocation(cfg, "")
+
+		log.Printf("[INFO] request_id=%s Client %s deleted", RequestID(req), client.ID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Deleting a soft-deleted client again does not extend its grace period.
+	if clientStatus(client) != types.ClientDeleted {
+		client.Status = types.ClientDeleted
+		client.PurgeAt = now(cfg).Add(cfg.clientDeletionGrace)
+		if err := provider.SoftDeleteClient(client.ID, client.PurgeAt); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+
+		log.Printf("[INFO] request_id=%s Client %s soft-deleted, it will be purged at %s",
+			RequestID(req), client.ID, client.PurgeAt.Format(time.RFC3339))
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   client,
+	})
+}
+
+// restoreClient restores a soft-deleted client within its grace period.
+func restoreClient(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientDeletionProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientDeletionProviderRequired),
+		})
+		return
+	}
+
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if client.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrClientIDNotFound),
+		})
+		return
+	}
+
+	if clientStatus(client) != types.ClientDeleted || !now(cfg).Before(client.PurgeAt) {
+		render.JSON(w, render.Options{
+			Status: http.StatusConflict,
+			Data:   localize(req, cfg, ErrClientStatusTransition),
+		})
+		return
+	}
+
+	if err := provider.RestoreClient(client.ID); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	log.Printf("[INFO] request_id=%s Client %s restored", RequestID(req), client.ID)
+
+	client.Status = types.ClientApproved
+	client.PurgeAt = time.Time{}
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   client,
+	})
+}
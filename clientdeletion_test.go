@@ -0,0 +1,114 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestClientSoftDeletion tests that soft-deleted clients can not get new
+// authorizations, but keep refreshing their tokens until purged, and can be
+// restored in the meantime.
+func TestClientSoftDeletion(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+	cfg.provider = provider
+	cfg.clock = clock
+
+	admin := AdminHandler(provider, SetClientDeletionGrace(24*time.Hour), SetClock(clock))
+	adminRequest := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "https://example.com"+path, nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	tokenRequest := func(cfg config, values url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	refresh := func() *httptest.ResponseRecorder {
+		token, err := provider.GenToken(types.Grant{
+			Scopes: types.Scopes{types.Scope{ID: "read"}},
+		}, provider.Client, true, 10*time.Minute)
+		ok(t, err)
+
+		return tokenRequest(cfg, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+		})
+	}
+
+	w := adminRequest("DELETE", "/clients/test_client_id")
+	equals(t, http.StatusOK, w.Code)
+
+	var client types.Client
+	ok(t, json.Unmarshal(w.Body.Bytes(), &client))
+	equals(t, types.ClientDeleted, client.Status)
+	equals(t, clock.now.Add(24*time.Hour), provider.Client.PurgeAt)
+
+	w = tokenRequest(cfg, url.Values{"grant_type": {"client_credentials"}})
+	equals(t, http.StatusBadRequest, w.Code)
+	e := types.AuthzError{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+	equals(t, ErrClientDeleted.Description, e.Description)
+
+	equals(t, http.StatusOK, refresh().Code)
+
+	w = adminRequest("POST", "/clients/test_client_id/restore")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, types.ClientApproved, provider.Client.Status)
+	equals(t, http.StatusOK, tokenRequest(cfg, url.Values{"grant_type": {"client_credentials"}}).Code)
+
+	// Once the grace period is over, tokens can not be refreshed and the
+	// client can not be restored anymore.
+	equals(t, http.StatusOK, adminRequest("DELETE", "/clients/test_client_id").Code)
+	clock.Advance(25 * time.Hour)
+	equals(t, http.StatusBadRequest, refresh().Code)
+	equals(t, http.StatusConflict, adminRequest("POST", "/clients/test_client_id/restore").Code)
+}
+
+// TestClientDeletion tests that clients are deleted right away if no grace
+// period is set.
+func TestClientDeletion(t *testing.T) {
+	provider := test.NewProvider(true)
+	admin := AdminHandler(provider)
+
+	req, err := http.NewRequest("DELETE", "https://example.com/clients/test_client_id", nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	equals(t, http.StatusNoContent, w.Code)
+	equals(t, "", provider.Client.ID)
+
+	req, err = http.NewRequest("POST", "https://example.com/clients/test_client_id/restore", nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	equals(t, http.StatusNotFound, w.Code)
+}
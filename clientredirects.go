@@ -0,0 +1,176 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ClientRedirectProvider is an optional interface that providers can
+// implement in order to change the redirect URL of clients through the
+// admin API.
+type ClientRedirectProvider interface {
+	// SetClientRedirectURL stores the new redirect URL of a client.
+	SetClientRedirectURL(clientID string, u *url.URL) error
+}
+
+// GrantQuarantineProvider is an optional interface that providers can
+// implement in order to quarantine grants. It is required by SetRedirectQuarantine.
+type GrantQuarantineProvider interface {
+	// QuarantineGrants marks the outstanding authorization codes of a client
+	// as types.GrantQuarantined and stops honoring the refresh tokens issued
+	// to it, so resource owners have to authorize it again.
+	QuarantineGrants(clientID string) error
+}
+
+// Errors returned when changing redirect URLs.
+var (
+	ErrClientRedirectProviderRequired  = errors.New("oauth2: provider does not implement oauth2.ClientRedirectProvider")
+	ErrGrantQuarantineProviderRequired = errors.New("oauth2: provider does not implement oauth2.GrantQuarantineProvider")
+)
+
+// SetRedirectQuarantine quarantines the outstanding grants of a client when
+// its redirect URL is changed to a different host through the admin API.
+// Whoever takes over a client registration could otherwise collect codes
+// and tokens meant for the legitimate client. Resource owners have to
+// authorize the client again afterwards. It requires the provider to
+// implement GrantQuarantineProvider.
+//
+// Either way, redirect URL changes are reported to the auditor, flagged as
+// suspicious when the host changes.
+func SetRedirectQuarantine(enabled bool) option {
+	return func(c *config) {
+		c.redirectQuarantine = enabled
+	}
+}
+
+// suspiciousRedirectChange tells whether a new redirect URL sends codes and
+// tokens somewhere else than the previous one.
+func suspiciousRedirectChange(from, to *url.URL) bool {
+	if from == nil {
+		return false
+	}
+	return from.Scheme != to.Scheme || !strings.EqualFold(from.Host, to.Host)
+}
+
+// setClientRedirectURL changes the redirect URL of a client, quarantining its
+// grants if the change is suspicious.
+func setClientRedirectURL(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientRedirectProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientRedirectProviderRequired),
+		})
+		return
+	}
+
+	var body struct {
+		RedirectURL string `json:"redirect_url"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRedirectURLInvalid),
+		})
+		return
+	}
+
+	redirectURL, err := redirectPolicy(cfg).Parse(body.RedirectURL)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRedirectURLInvalid),
+		})
+		return
+	}
+
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if client.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrClientIDNotFound),
+		})
+		return
+	}
+
+	if err := verifyAppAssociations(cfg, client, redirectURL); err != nil {
+		log.Printf("[INFO] request_id=%s Redirect URL %s rejected for client %s: %v",
+			RequestID(req), RedactURL(redirectURL.String()), client.ID, redactError(err))
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRedirectURLNotAssociated),
+		})
+		return
+	}
+
+	// Grants are quarantined before the change, so no code or token issued
+	// under the previous registration can reach the new redirect URL.
+	suspicious := suspiciousRedirectChange(client.RedirectURL, redirectURL)
+	quarantined := suspicious && cfg.redirectQuarantine
+	if quarantined {
+		q, ok := asGrantQuarantineProvider(cfg.provider)
+		if !ok {
+			err = ErrGrantQuarantineProviderRequired
+		} else {
+			err = q.QuarantineGrants(client.ID)
+		}
+
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+	}
+
+	if err := provider.SetClientRedirectURL(client.ID, redirectURL); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	oldRedirectURL := ""
+	if client.RedirectURL != nil {
+		oldRedirectURL = client.RedirectURL.String()
+	}
+
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditRedirectURLChanged,
+		ClientID: client.ID,
+		Details: map[string]string{
+			"old_redirect_url": oldRedirectURL,
+			"new_redirect_url": redirectURL.String(),
+			"suspicious":       strconv.FormatBool(suspicious),
+			"quarantined":      strconv.FormatBool(quarantined),
+		},
+	})
+
+	client.RedirectURL = redirectURL
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   client,
+	})
+}
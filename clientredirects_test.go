@@ -0,0 +1,126 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/redirecturi"
+	"github.com/hooklift/oauth2/types"
+)
+
+// auditLog is an Auditor keeping events in memory.
+type auditLog []types.AuditEvent
+
+func (a *auditLog) Audit(event types.AuditEvent) {
+	*a = append(*a, event)
+}
+
+// TestRedirectQuarantine makes sure outstanding grants are quarantined when a
+// client's redirect URL moves to another host.
+func TestRedirectQuarantine(t *testing.T) {
+	provider := test.NewProvider(true)
+	events := &auditLog{}
+	admin := AdminHandler(provider, SetAuditor(events), SetRedirectQuarantine(true))
+
+	changeRedirectURL := func(u string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "https://example.com/clients/test_client_id/redirect_url",
+			bytes.NewBufferString(`{"redirect_url": "`+u+`"}`))
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	grant, err := provider.GenGrant(provider.Client, types.Scopes{}, time.Duration(1)*time.Minute)
+	ok(t, err)
+
+	w := changeRedirectURL("http://example.com/callback")
+	equals(t, http.StatusBadRequest, w.Code)
+
+	// Same host, nothing to worry about.
+	w = changeRedirectURL("https://example.com/oauth2/v2/callback")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "https://example.com/oauth2/v2/callback", provider.Client.RedirectURL.String())
+	equals(t, types.GrantStatus(""), provider.Grants[grant.Code].Status)
+	equals(t, 1, len(*events))
+	equals(t, "false", (*events)[0].Details["suspicious"])
+
+	w = changeRedirectURL("https://attacker.example.net/callback")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, types.GrantQuarantined, provider.Grants[grant.Code].Status)
+	equals(t, 2, len(*events))
+
+	event := (*events)[1]
+	equals(t, types.AuditRedirectURLChanged, event.Type)
+	equals(t, "test_client_id", event.ClientID)
+	equals(t, "https://example.com/oauth2/v2/callback", event.Details["old_redirect_url"])
+	equals(t, "true", event.Details["suspicious"])
+	equals(t, "true", event.Details["quarantined"])
+
+	// Quarantined codes can not be exchanged for tokens.
+	cfg := setupTest()
+	cfg.provider = provider
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+		bytes.NewBufferString("grant_type=authorization_code&code="+grant.Code))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusBadRequest, w.Code)
+}
+
+// TestAppAssociationVerification makes sure native apps can only register
+// HTTPS redirect URLs whose host associates them with the apps.
+func TestAppAssociationVerification(t *testing.T) {
+	associated := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != redirecturi.AssetLinksPath {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(`[{
+			"relation": ["delegate_permission/common.handle_all_urls"],
+			"target": {"namespace": "android_app", "package_name": "com.example.app", "sha256_cert_fingerprints": ["14:6D:E9:83"]}
+		}]`))
+	}))
+	defer associated.Close()
+
+	unrelated := httptest.NewTLSServer(http.NotFoundHandler())
+	defer unrelated.Close()
+
+	provider := test.NewProvider(true)
+	provider.Client.AppAssociations = []types.AppAssociation{
+		{Platform: types.PlatformAndroid, AppID: "com.example.app", CertFingerprints: []string{"14:6D:E9:83"}},
+	}
+	admin := AdminHandler(provider, SetAppAssociationVerification(redirecturi.AppAssociations{
+		Client: associated.Client(),
+	}))
+
+	changeRedirectURL := func(u string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "https://example.com/clients/test_client_id/redirect_url",
+			bytes.NewBufferString(`{"redirect_url": "`+u+`"}`))
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	w := changeRedirectURL(unrelated.URL + "/oauth2/callback")
+	equals(t, http.StatusBadRequest, w.Code)
+	assert(t, bytes.Contains(w.Body.Bytes(), []byte(ErrRedirectURLNotAssociated.Description)), "unexpected error: %s", w.Body.String())
+
+	w = changeRedirectURL(associated.URL + "/oauth2/callback")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, associated.URL+"/oauth2/callback", provider.Client.RedirectURL.String())
+}
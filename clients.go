@@ -0,0 +1,306 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ClientLifecycleProvider is an optional interface that providers can
+// implement in order to review, approve and suspend clients through the
+// admin API.
+type ClientLifecycleProvider interface {
+	// SetClientStatus stores the new lifecycle status of a client.
+	SetClientStatus(clientID string, status types.ClientStatus) error
+}
+
+// ErrClientLifecycleProviderRequired is returned when changing the status of
+// clients with a provider that does not implement ClientLifecycleProvider.
+var ErrClientLifecycleProviderRequired = errors.New("oauth2: provider does not implement oauth2.ClientLifecycleProvider")
+
+// clientTransitions defines the statuses a client can go to from each status.
+var clientTransitions = map[types.ClientStatus][]types.ClientStatus{
+	types.ClientPending:   {types.ClientApproved, types.ClientSuspended},
+	types.ClientApproved:  {types.ClientSuspended},
+	types.ClientSuspended: {types.ClientApproved},
+}
+
+// clientStatus returns the lifecycle status of a client, clients registered
+// before statuses existed are approved.
+func clientStatus(client types.Client) types.ClientStatus {
+	if client.Status == "" {
+		return types.ClientApproved
+	}
+	return client.Status
+}
+
+// inactiveClient returns the error to reply with if the client is not
+// allowed to be authorized nor to get tokens.
+func inactiveClient(req *http.Request, cfg config, client types.Client) (types.AuthzError, bool) {
+	switch clientStatus(client) {
+	case types.ClientApproved:
+		return types.AuthzError{}, false
+	case types.ClientPending:
+		return localize(req, cfg, ErrClientPending), true
+	case types.ClientDeleted:
+		return localize(req, cfg, ErrClientDeleted), true
+	default:
+		return localize(req, cfg, ErrClientSuspended), true
+	}
+}
+
+// canTransition tells whether a client can go from one status to another.
+func canTransition(from, to types.ClientStatus) bool {
+	for _, s := range clientTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// adminHandlers maps admin API routes to the handlers of each HTTP method,
+// which get the identifier found in the path. Routes are paths with the
+// identifier left out.
+var adminHandlers = map[string]map[string]func(http.ResponseWriter, *http.Request, config, string){
+	"clients/status":       {"PUT": setClientStatus},
+	"clients/redirect_url": {"PUT": setClientRedirectURL},
+	"clients":              {"DELETE": deleteClient},
+	"clients/restore":      {"POST": restoreClient},
+	"resource_servers":     {"PUT": saveResourceServer},
+	"token_families":       {"GET": getTokenFamily},
+	"clients/check":        {"GET": getClientCheck, "POST": checkClient},
+}
+
+// adminCollectionHandlers maps admin API routes without identifier to the
+// handlers of each HTTP method, which get an empty identifier.
+var adminCollectionHandlers = map[string]map[string]func(http.ResponseWriter, *http.Request, config, string){
+	"keys":     {"POST": rotateKey},
+	"stats":    {"GET": getStats},
+	"simulate": {"POST": simulate},
+
+	"capabilities": {"GET": getCapabilities},
+}
+
+// AdminHandler returns the admin API, meant to be used by the operators of
+// the authorization server. It does not authenticate callers, so it must be
+// mounted behind the host application's own access controls. Paths are
+// relative to where it is mounted, use http.StripPrefix if needed.
+//
+// Changes the lifecycle status of a client and returns the updated client:
+//
+//	PUT /clients/<client id>/status
+//	Content-Type: application/json
+//
+//	{"status": "suspended"}
+//
+// Pending clients can be approved or suspended, approved clients suspended and
+// suspended clients approved again.
+//
+// Deletes a client, if the provider implements ClientDeletionProvider:
+//
+//	DELETE /clients/<client id>
+//
+// See SetClientDeletionGrace for soft-deleting clients instead. Soft-deleted
+// clients are restored, approved, and returned by:
+//
+//	POST /clients/<client id>/restore
+//
+// Changes the redirect URL of a client and returns the updated client:
+//
+//	PUT /clients/<client id>/redirect_url
+//	Content-Type: application/json
+//
+//	{"redirect_url": "https://example.com/oauth2/callback"}
+//
+// See SetRedirectQuarantine for how suspicious changes are handled, and
+// SetAppAssociationVerification for redirect URLs claimed by native apps.
+//
+// Registers or updates a resource server and returns it, without its secret:
+//
+//	PUT /resource_servers/<resource server id>
+//	Content-Type: application/json
+//
+//	{
+//		"audience": "https://api.example.com",
+//		"secret": "s3cr3t",
+//		"scope": "read write",
+//		"introspection_claims": ["scope", "client_id", "exp"]
+//	}
+//
+// Returns a token family, for incident response, if the provider implements
+// TokenFamilyProvider:
+//
+//	GET /token_families/<family id>
+//
+// Returns the outcome of the last revalidation of the endpoints of a client,
+// if the provider implements ClientCheckProvider, or revalidates them right
+// away. See SetClientRevalidation.
+//
+//	GET /clients/<client id>/check
+//	POST /clients/<client id>/check
+//
+// Returns approximate statistics for capacity planning and dashboards, if the
+// provider implements StatsProvider. Grants are counted for the given number
+// of days, 30 by default:
+//
+//	GET /stats?days=7
+//
+// Introduces a new signing key, if the key provider implements KeyRotator,
+// and returns its identifier. See RotatingKeys.
+//
+//	POST /keys
+//
+// Evaluates whether a hypothetical token request would succeed, without
+// issuing anything, and returns the checks it would go through, for debugging
+// scope and policy configuration. See types.Simulation.
+//
+//	POST /simulate
+//	Content-Type: application/json
+//
+//	{
+//		"client_id": "client",
+//		"user_id": "user",
+//		"scope": "read write",
+//		"grant_type": "authorization_code"
+//	}
+//
+// Simulations are only as faithful as the options given to AdminHandler,
+// which have to match the ones given to Handler.
+//
+// Returns the grant types, response types, token formats and policies
+// supported, as configured by the options given to AdminHandler. See
+// Server.Capabilities.
+//
+//	GET /capabilities
+//
+// Options other than SetMessages, SetClock, SetAuditor, SetRedirectQuarantine,
+// SetRedirectPolicy, SetAppAssociationVerification, SetClientDeletionGrace,
+// SetKeyProvider, SetRevocationBus, SetClientRevalidation and, for
+// simulations, SetPolicy, SetScopePolicy, SetDefaultScope,
+// SetPasswordGrantPolicy, SetDeprecation and SetTokenExpiration are ignored
+// by all but capabilities.
+func AdminHandler(provider Provider, opts ...option) http.Handler {
+	if provider == nil {
+		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
+	}
+
+	cfg := config{provider: provider}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = withRequestID(w, req)
+
+		segments := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+
+		var id string
+		handlers, ok := adminCollectionHandlers[segments[0]]
+		if len(segments) > 1 {
+			route := segments[0]
+			id = segments[1]
+			if len(segments) > 2 {
+				route += "/" + strings.Join(segments[2:], "/")
+			}
+			handlers, ok = adminHandlers[route]
+		}
+
+		if !ok {
+			render.JSON(w, render.Options{
+				Status: http.StatusNotFound,
+				Data:   localize(req, cfg, ErrNotFound),
+			})
+			return
+		}
+
+		handler, ok := handlers[req.Method]
+		if !ok {
+			methods := make([]string, 0, len(handlers))
+			for m := range handlers {
+				methods = append(methods, m)
+			}
+			sort.Strings(methods)
+			w.Header().Set("Allow", strings.Join(methods, ", "))
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		handler(w, req, cfg, id)
+	})
+}
+
+// setClientStatus transitions a client to the requested lifecycle status.
+func setClientStatus(w http.ResponseWriter, req *http.Request, cfg config, clientID string) {
+	provider, ok := asClientLifecycleProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrClientLifecycleProviderRequired),
+		})
+		return
+	}
+
+	var body struct {
+		Status types.ClientStatus `json:"status"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrClientStatusTransition),
+		})
+		return
+	}
+
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if client.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrClientIDNotFound),
+		})
+		return
+	}
+
+	if !canTransition(clientStatus(client), body.Status) {
+		render.JSON(w, render.Options{
+			Status: http.StatusConflict,
+			Data:   localize(req, cfg, ErrClientStatusTransition),
+		})
+		return
+	}
+
+	if err := provider.SetClientStatus(client.ID, body.Status); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	log.Printf("[INFO] request_id=%s Client %s transitioned from %s to %s",
+		RequestID(req), client.ID, clientStatus(client), body.Status)
+
+	client.Status = body.Status
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   client,
+	})
+}
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestClientLifecycle makes sure only approved clients can be authorized and
+// get tokens, and that statuses change through the admin API.
+func TestClientLifecycle(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.Status = types.ClientPending
+	cfg.provider = provider
+
+	admin := AdminHandler(provider)
+	transition := func(status types.ClientStatus) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PUT", "https://example.com/clients/test_client_id/status",
+			bytes.NewBufferString(`{"status": "`+string(status)+`"}`))
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	issueToken := func() types.AuthzError {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+
+		e := types.AuthzError{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return e
+	}
+
+	authorize := func() string {
+		values := url.Values{
+			"client_id":     {"test_client_id"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"scope":         {"read"},
+		}
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	e := issueToken()
+	equals(t, "unauthorized_client", e.Code)
+	equals(t, ErrClientPending.Description, e.Description)
+	assert(t, strings.Contains(authorize(), ErrClientPending.Description), "pending clients should not be authorized")
+
+	w := transition(types.ClientApproved)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, types.ClientApproved, provider.Client.Status)
+	equals(t, "", issueToken().Code)
+	assert(t, strings.Contains(authorize(), "state-test"), "approved clients should be authorized")
+
+	w = transition(types.ClientSuspended)
+	equals(t, http.StatusOK, w.Code)
+	e = issueToken()
+	equals(t, "unauthorized_client", e.Code)
+	equals(t, ErrClientSuspended.Description, e.Description)
+	assert(t, strings.Contains(authorize(), ErrClientSuspended.Description), "suspended clients should not be authorized")
+
+	// Suspended clients do not go back to review.
+	w = transition(types.ClientPending)
+	equals(t, http.StatusConflict, w.Code)
+	equals(t, types.ClientSuspended, provider.Client.Status)
+}
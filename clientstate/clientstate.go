@@ -0,0 +1,131 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package clientstate helps client apps mint and verify the state parameter
+// they send along authorization requests, as recommended by
+// http://tools.ietf.org/html/rfc6749#section-10.12.
+//
+// States are encrypted and authenticated with the envelope package. They
+// carry the URL to return the resource owner to once authorized, and are
+// bound to the resource owner's session with the client, usually a value
+// kept in a cookie, so they can't be replayed from another browser to mount
+// CSRF attacks.
+package clientstate
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hooklift/oauth2/envelope"
+	"github.com/hooklift/oauth2/tokengen"
+)
+
+// DefaultMaxAge is how long states are valid for if no MaxAge is given.
+const DefaultMaxAge = 10 * time.Minute
+
+// Errors
+var (
+	ErrInvalid       = errors.New("clientstate: invalid state")
+	ErrExpired       = errors.New("clientstate: state expired")
+	ErrBindingNeeded = errors.New("clientstate: states have to be bound to a session")
+	ErrReturnURL     = errors.New("clientstate: return URL has to be a local path")
+)
+
+// State is the information carried by a state value.
+type State struct {
+	// Local path to return the resource owner to once authorized.
+	ReturnURL string `json:"return_url"`
+	// Random value making every state unique.
+	Nonce string `json:"nonce"`
+	// When the state was minted.
+	IssuedAt time.Time `json:"iat"`
+}
+
+// Codec mints and verifies states.
+type Codec struct {
+	// Keys encrypting states.
+	Keyring envelope.Keyring
+	// How long states are valid for. Defaults to DefaultMaxAge.
+	MaxAge time.Duration
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Encode mints a state returning the resource owner to the given local path,
+// bound to the given session value.
+func (c Codec) Encode(binding, returnURL string) (string, error) {
+	if binding == "" {
+		return "", ErrBindingNeeded
+	}
+
+	if !isLocal(returnURL) {
+		return "", ErrReturnURL
+	}
+
+	nonce, err := tokengen.Random{Bytes: 16}.Generate()
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(State{
+		ReturnURL: returnURL,
+		Nonce:     nonce,
+		IssuedAt:  c.now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return envelope.Seal(c.Keyring, payload, []byte(binding))
+}
+
+// Decode verifies a state sent back by the authorization server against the
+// session value it was bound to, returning the information it carries.
+func (c Codec) Decode(binding, state string) (State, error) {
+	var s State
+	if binding == "" {
+		return s, ErrBindingNeeded
+	}
+
+	payload, err := envelope.Open(c.Keyring, state, []byte(binding))
+	if err != nil {
+		return s, ErrInvalid
+	}
+
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return s, ErrInvalid
+	}
+
+	if !isLocal(s.ReturnURL) {
+		return s, ErrInvalid
+	}
+
+	maxAge := c.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+
+	if c.now().After(s.IssuedAt.Add(maxAge)) {
+		return s, ErrExpired
+	}
+	return s, nil
+}
+
+func (c Codec) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// isLocal tells whether a return URL is a path on the client's own site,
+// preventing states from being used as open redirectors.
+func isLocal(u string) bool {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return false
+	}
+	return !strings.ContainsAny(u, "\r\n")
+}
@@ -0,0 +1,81 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package clientstate
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/envelope"
+)
+
+func testCodec(now time.Time) Codec {
+	return Codec{
+		Keyring: envelope.StaticKeyring{
+			Current: "k1",
+			Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+		},
+		Now: func() time.Time { return now },
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	c := testCodec(now)
+
+	state, err := c.Encode("session-1", "/dashboard?tab=apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(state, "dashboard") {
+		t.Errorf("state leaks its return URL: %s", state)
+	}
+
+	s, err := c.Decode("session-1", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ReturnURL != "/dashboard?tab=apps" {
+		t.Errorf("unexpected return URL: %s", s.ReturnURL)
+	}
+
+	other, err := c.Encode("session-1", "/dashboard?tab=apps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == state {
+		t.Error("expected states to be unique")
+	}
+
+	if _, err := c.Decode("session-2", state); err != ErrInvalid {
+		t.Errorf("expected %v decoding state bound to another session, got %v", ErrInvalid, err)
+	}
+
+	if _, err := c.Decode("session-1", state[:len(state)-2]+"AA"); err != ErrInvalid {
+		t.Errorf("expected %v decoding tampered state, got %v", ErrInvalid, err)
+	}
+
+	c = testCodec(now.Add(DefaultMaxAge + time.Second))
+	if _, err := c.Decode("session-1", state); err != ErrExpired {
+		t.Errorf("expected %v, got %v", ErrExpired, err)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	c := testCodec(time.Now())
+
+	if _, err := c.Encode("", "/"); err != ErrBindingNeeded {
+		t.Errorf("expected %v, got %v", ErrBindingNeeded, err)
+	}
+
+	for _, u := range []string{"", "https://evil.example.com", "//evil.example.com", "/\\evil.example.com", "dashboard"} {
+		if _, err := c.Encode("session-1", u); err != ErrReturnURL {
+			t.Errorf("expected %v for %q, got %v", ErrReturnURL, u, err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import "time"
+
+// Clock tells the current time. Every expiration check made by this package
+// goes through it, allowing tests to move time forward deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+// SetClock sets the clock used to check expirations. Defaults to the system clock.
+func SetClock(c Clock) option {
+	return func(cfg *config) {
+		cfg.clock = c
+	}
+}
+
+// now returns the current time according to the configured clock.
+func now(cfg config) time.Time {
+	if cfg.clock == nil {
+		return time.Now()
+	}
+	return cfg.clock.Now()
+}
@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package conformance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2"
+	"github.com/hooklift/oauth2/providers/test"
+	xoauth2 "golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// server starts the authorization server, protecting a /hello resource, and
+// returns a context carrying the HTTP client trusting its certificate, as
+// expected by golang.org/x/oauth2.
+func server(t *testing.T) (*httptest.Server, context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("Hello World!"))
+	})
+
+	provider := test.NewProvider(true)
+	ts := httptest.NewTLSServer(oauth2.Handler(oauth2.AuthzHandler(mux, provider),
+		oauth2.SetProvider(provider),
+		oauth2.SetAuthzExpiration(time.Minute),
+		oauth2.SetTokenExpiration(10*time.Minute),
+	))
+	t.Cleanup(ts.Close)
+
+	return ts, context.WithValue(context.Background(), xoauth2.HTTPClient, ts.Client())
+}
+
+func config(ts *httptest.Server) *xoauth2.Config {
+	return &xoauth2.Config{
+		ClientID:     "test_client_id",
+		ClientSecret: "test_client_secret",
+		RedirectURL:  "https://example.com/oauth2/callback",
+		Scopes:       []string{"read", "identity"},
+		Endpoint: xoauth2.Endpoint{
+			AuthURL:   ts.URL + "/oauth2/authzs",
+			TokenURL:  ts.URL + "/oauth2/tokens",
+			AuthStyle: xoauth2.AuthStyleInHeader,
+		},
+	}
+}
+
+// authorize approves the authorization request sent to authURL on behalf of
+// the resource owner, returning the redirect URL with the response.
+func authorize(t *testing.T, ts *httptest.Server, authURL string) *url.URL {
+	client := *ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected the authorization form, got status %d", res.StatusCode)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	form := u.Query()
+	form.Set(oauth2.ConsentParam, "approve")
+
+	// Browsers send the origin of the authorization form along with it.
+	req, err := http.NewRequest("POST", ts.URL+u.Path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", ts.URL)
+
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect to the client, got status %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return location
+}
+
+// get requests the protected resource with the given token source.
+func get(t *testing.T, ctx context.Context, ts *httptest.Server, src xoauth2.TokenSource) {
+	res, err := xoauth2.NewClient(ctx, src).Get(ts.URL + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected the resource to be served, got status %d", res.StatusCode)
+	}
+}
+
+// TestAuthorizationCode tests the authorization code flow, with PKCE, and
+// refreshing the access token.
+func TestAuthorizationCode(t *testing.T) {
+	ts, ctx := server(t)
+	conf := config(ts)
+
+	verifier := xoauth2.GenerateVerifier()
+	location := authorize(t, ts, conf.AuthCodeURL("state-test", xoauth2.S256ChallengeOption(verifier)))
+	if state := location.Query().Get("state"); state != "state-test" {
+		t.Fatalf("unexpected state %q", state)
+	}
+
+	token, err := conf.Exchange(ctx, location.Query().Get("code"), xoauth2.VerifierOption(verifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.Valid() || token.RefreshToken == "" || !strings.EqualFold(token.TokenType, "bearer") {
+		t.Fatalf("unexpected token %+v", token)
+	}
+	get(t, ctx, ts, conf.TokenSource(ctx, token))
+
+	// Forces a refresh by expiring the access token.
+	expired := *token
+	expired.Expiry = time.Now().Add(-time.Minute)
+	refreshed, err := conf.TokenSource(ctx, &expired).Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.AccessToken == token.AccessToken {
+		t.Error("expected a new access token")
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == token.RefreshToken {
+		t.Error("expected the refresh token to be rotated")
+	}
+	get(t, ctx, ts, xoauth2.StaticTokenSource(refreshed))
+
+	// Codes can not be exchanged twice.
+	if _, err := conf.Exchange(ctx, location.Query().Get("code"), xoauth2.VerifierOption(verifier)); err == nil {
+		t.Error("expected the code not to be exchanged twice")
+	}
+}
+
+// TestClientCredentials tests the client credentials flow.
+func TestClientCredentials(t *testing.T) {
+	ts, ctx := server(t)
+
+	conf := clientcredentials.Config{
+		ClientID:     "test_client_id",
+		ClientSecret: "test_client_secret",
+		TokenURL:     ts.URL + "/oauth2/tokens",
+		Scopes:       []string{"read"},
+		AuthStyle:    xoauth2.AuthStyleInHeader,
+	}
+
+	token, err := conf.Token(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.Valid() {
+		t.Fatalf("unexpected token %+v", token)
+	}
+	get(t, ctx, ts, conf.TokenSource(ctx))
+}
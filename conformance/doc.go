@@ -0,0 +1,9 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package conformance holds smoke tests driving the whole authorization
+// server, as returned by oauth2.Handler and backed by the in-memory test
+// provider, with golang.org/x/oauth2 as client. They catch interoperability
+// regressions with the most common Go client library.
+package conformance
@@ -0,0 +1,144 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// ConsentProvider is an optional interface that providers can implement in
+// order to keep track of the scopes each resource owner has approved for each
+// client. It is required by SetRememberConsent and lets resource owners look
+// up their consent through the self-service grants API.
+type ConsentProvider interface {
+	// SaveConsent stores the consent of a resource owner for a client,
+	// replacing the previous one, if any.
+	SaveConsent(consent types.Consent) error
+
+	// GetConsent returns the consent of a resource owner for a client. An
+	// empty types.Consent is expected if there is none.
+	GetConsent(userID, clientID string) (types.Consent, error)
+
+	// RevokeConsent forgets the consent of a resource owner for a client.
+	RevokeConsent(userID, clientID string) error
+}
+
+// SetRememberConsent skips the authorization form when the resource owner
+// has already approved every requested scope for the client. It requires the
+// provider to implement ConsentProvider.
+//
+// Clients sending include_granted_scopes=true get grants covering the scopes
+// approved before as well as the requested ones.
+func SetRememberConsent(enabled bool) option {
+	return func(c *config) {
+		c.rememberConsent = enabled
+	}
+}
+
+// saveConsent adds the scopes just approved by the resource owner to their
+// consent for the client, notifying them if it is the first one. It does
+// nothing if the provider does not keep track of consent.
+func saveConsent(req *http.Request, cfg config, authzData *AuthzData) error {
+	provider, ok := asConsentProvider(cfg.provider)
+	if !ok {
+		return nil
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		return err
+	}
+
+	consent, err := provider.GetConsent(user.ID, authzData.Client.ID)
+	if err != nil {
+		return err
+	}
+
+	isNew := consent.UserID == ""
+	if isNew {
+		consent = types.Consent{
+			UserID:    user.ID,
+			ClientID:  authzData.Client.ID,
+			GrantedAt: now(cfg),
+		}
+	}
+
+	for _, s := range authzData.Scopes {
+		if !consent.Scopes.Contains(s.ID) {
+			consent.Scopes = append(consent.Scopes, s)
+		}
+	}
+	consent.UpdatedAt = now(cfg)
+
+	if err := provider.SaveConsent(consent); err != nil {
+		return err
+	}
+
+	if isNew {
+		notify(req, cfg, types.Notification{
+			Type:       types.NotificationNewClient,
+			UserID:     user.ID,
+			ClientID:   authzData.Client.ID,
+			ClientName: authzData.Client.Name,
+			Scopes:     authzData.Scopes,
+		})
+	}
+	return nil
+}
+
+// consentRemembered tells whether the resource owner has already approved
+// every requested scope for the client, if SetRememberConsent is enabled.
+// It sets the scopes already approved and the new ones in authzData, so
+// forms only ask for the latter.
+func consentRemembered(req *http.Request, cfg config, authzData *AuthzData) (bool, error) {
+	provider, ok := asConsentProvider(cfg.provider)
+	if !cfg.rememberConsent || !ok {
+		return false, nil
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	consent, err := provider.GetConsent(user.ID, authzData.Client.ID)
+	if err != nil {
+		return false, err
+	}
+
+	if consent.UserID == "" {
+		return false, nil
+	}
+
+	authzData.GrantedScopes = consent.Scopes
+	authzData.NewScopes = nil
+	for _, s := range authzData.Scopes {
+		if !consent.Scopes.Contains(s.ID) {
+			authzData.NewScopes = append(authzData.NewScopes, s)
+		}
+	}
+	return len(authzData.NewScopes) == 0, nil
+}
+
+// includeGrantedScopes adds the scopes the resource owner approved before to
+// the requested ones, if the client sent include_granted_scopes=true. Tokens
+// issued for the grant carry both, so clients asking for scopes incrementally
+// do not have to juggle a token per scope.
+//
+// It relies on consentRemembered having looked up the scopes approved before,
+// so it has no effect unless SetRememberConsent is enabled.
+func includeGrantedScopes(authzData *AuthzData) {
+	if !authzData.IncludeGrantedScopes {
+		return
+	}
+
+	for _, s := range authzData.GrantedScopes {
+		if !authzData.Scopes.Contains(s.ID) {
+			authzData.Scopes = append(authzData.Scopes, s)
+		}
+	}
+}
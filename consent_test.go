@@ -0,0 +1,177 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRememberedConsent tests that approved scopes are merged into the
+// resource owner's consent, that the authorization form is skipped for
+// scopes already approved, and that consent can be looked up through the
+// grants API.
+func TestRememberedConsent(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetRememberConsent(true)(&cfg)
+
+	authorize := func(method, scope string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {scope},
+		}
+
+		var req *http.Request
+		var err error
+		if method == "GET" {
+			req, err = http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		} else {
+			values.Set(ConsentParam, "approve")
+			req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+			req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+			req.Header.Set("Origin", "https://example.com")
+		}
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	// Nothing approved yet, the form is displayed.
+	w := authorize("GET", "read")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 0, len(provider.Grants))
+
+	w = authorize("POST", "read")
+	equals(t, http.StatusFound, w.Code)
+	w = authorize("POST", "identity")
+	equals(t, http.StatusFound, w.Code)
+
+	consent := provider.Consents["test_user:"+provider.Client.ID]
+	equals(t, "read identity", consent.Scopes.Encode())
+	assert(t, !consent.GrantedAt.IsZero(), "consent should record when it was first given")
+
+	// Every scope was approved already, a code is issued right away.
+	w = authorize("GET", "identity read")
+	equals(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	assert(t, u.Query().Get("code") != "", "a code should be issued without asking again")
+
+	// New scopes still require approval.
+	grants := len(provider.Grants)
+	w = authorize("GET", "read write")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, grants, len(provider.Grants))
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/grants?client_id="+provider.Client.ID, nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	found := types.Consent{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &found))
+	equals(t, consent.Scopes.Encode(), found.Scopes.Encode())
+
+	ok(t, provider.RevokeConsent("test_user", provider.Client.ID))
+	w = httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusNotFound, w.Code)
+}
+
+// TestIncrementalConsent tests that only scopes not approved yet are shown
+// when a client asks for more, and that include_granted_scopes merges the
+// scopes approved before into the new grant.
+func TestIncrementalConsent(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetRememberConsent(true)(&cfg)
+	SetAuthzForm(`{{range .GrantedScopes}}granted:{{.ID}} {{end}}{{range .NewScopes}}new:{{.ID}} {{end}}`)(&cfg)
+
+	authorize := func(method, scope string, include bool) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {scope},
+		}
+		if include {
+			values.Set("include_granted_scopes", "true")
+		}
+
+		var req *http.Request
+		var err error
+		if method == "GET" {
+			req, err = http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		} else {
+			values.Set(ConsentParam, "approve")
+			req, err = http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+			req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+			req.Header.Set("Origin", "https://example.com")
+		}
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	grantScopes := func(w *httptest.ResponseRecorder) string {
+		equals(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		return provider.Grants[u.Query().Get("code")].Scopes.Encode()
+	}
+
+	equals(t, "read", grantScopes(authorize("POST", "read", false)))
+
+	w := authorize("GET", "read write", true)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "granted:read new:write ", w.Body.String())
+
+	equals(t, "write read", grantScopes(authorize("POST", "write", true)))
+	equals(t, "write", grantScopes(authorize("POST", "write", false)))
+
+	// Access tokens issued right away through the implicit flow carry them too.
+	values := url.Values{
+		"client_id":              {provider.Client.ID},
+		"response_type":          {"token"},
+		"state":                  {"state-test"},
+		"redirect_uri":           {provider.Client.RedirectURL.String()},
+		"scope":                  {"identity"},
+		"include_granted_scopes": {"true"},
+		ConsentParam:             {"approve"},
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	fragment, err := url.ParseQuery(u.Fragment)
+	ok(t, err)
+	equals(t, "identity read write", fragment.Get("scope"))
+}
@@ -0,0 +1,151 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+// DefaultAuthzForm is the authorization form shown to resource owners when
+// none is set with SetAuthzForm. Along with the requested scopes, it shows
+// the client's branding, whether its publisher was verified and links to its
+// terms of service and privacy policy, so resource owners can make informed
+// decisions. Use it as a starting point for custom forms.
+const DefaultAuthzForm = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Authorize {{.Client.Name}}</title>
+</head>
+<body>
+{{if .Errors}}
+	<div id="errors">
+		<ul>
+		{{range .Errors}}
+			<li>{{.Description}}</li>
+		{{end}}
+		</ul>
+		{{with .RestartURL}}<p><a href="{{.}}">Restart authorization</a></p>{{end}}
+	</div>
+{{else}}
+	<div id="client">
+		{{with .Client.LogoURL}}<figure><img src="{{.}}" alt=""/></figure>{{end}}
+		<h2>{{.Client.Name}}</h2>
+		{{if .Client.PublisherVerified}}
+			<p class="publisher verified">Published by {{.Client.Publisher}} &#10003; Verified publisher</p>
+		{{else}}
+			<p class="publisher unverified">{{with .Client.Publisher}}Published by {{.}}, not verified.{{else}}Publisher not verified.{{end}} Make sure you trust this application.</p>
+		{{end}}
+		{{with .Client.Description}}<p>{{.}}</p>{{end}}
+		{{with .Client.HomepageURL}}<a href="{{.}}">{{.}}</a>{{end}}
+	</div>
+	<div id="scopes">
+	{{if .GrantedScopes}}
+		<p>{{.Client.Name}} already has access to your account and would also like to:</p>
+		<ul>
+		{{range .NewScopes}}
+			<li>{{.Description}}</li>
+		{{end}}
+		</ul>
+	{{else}}
+		<p>{{.Client.Name}} will be able to:</p>
+		<ul>
+		{{range .Scopes}}
+			<li>{{.Description}}</li>
+		{{end}}
+		</ul>
+	{{end}}
+	</div>
+	{{if or .Client.TermsOfServiceURL .Client.PolicyURL}}
+	<p id="legal">
+		Review {{.Client.Name}}'s
+		{{with .Client.TermsOfServiceURL}}<a href="{{.}}">terms of service</a>{{end}}
+		{{if and .Client.TermsOfServiceURL .Client.PolicyURL}}and{{end}}
+		{{with .Client.PolicyURL}}<a href="{{.}}">privacy policy</a>{{end}}
+		before authorizing it.
+	</p>
+	{{end}}
+	<form method="post">
+		<input type="hidden" name="client_id" value="{{.Client.ID}}"/>
+		<input type="hidden" name="response_type" value="{{.GrantType}}"/>
+		<input type="hidden" name="redirect_uri" value="{{.Client.RedirectURL}}"/>
+		<input type="hidden" name="scope" value="{{.Scopes.Encode}}"/>
+		<input type="hidden" name="state" value="{{.State}}"/>
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}"/>
+		<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}"/>
+		<input type="hidden" name="response_mode" value="{{.ResponseMode}}"/>
+		{{if .IncludeGrantedScopes}}<input type="hidden" name="include_granted_scopes" value="true"/>{{end}}
+		{{range $name, $value := .Extensions}}<input type="hidden" name="{{$name}}" value="{{$value}}"/>{{end}}
+		<input type="hidden" name="authz_request" value="{{.Request}}"/>
+		{{with .Nonce}}<input type="hidden" name="form_nonce" value="{{.}}"/>{{end}}
+		<button type="submit" name="consent" value="deny">Deny</button>
+		<button type="submit" name="consent" value="approve">Authorize</button>
+	</form>
+	{{if or .Server.PolicyURL .Server.TermsOfServiceURL}}
+	<footer>
+		{{with .Server.PolicyURL}}<a href="{{.}}">Privacy policy</a>{{end}}
+		{{with .Server.TermsOfServiceURL}}<a href="{{.}}">Terms of service</a>{{end}}
+	</footer>
+	{{end}}
+{{end}}
+</body>
+</html>
+`
+
+// DefaultDeviceForm is the page resource owners enter the user code shown by
+// their device at, when none is set with SetDeviceForm. Once the code is
+// found, it shows the client and scopes for resource owners to check they
+// are the ones of the device they are holding before approving them.
+const DefaultDeviceForm = `<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Connect a device</title>
+</head>
+<body>
+{{if .Errors}}
+	<div id="errors">
+		<ul>
+		{{range .Errors}}
+			<li>{{.Description}}</li>
+		{{end}}
+		</ul>
+	</div>
+{{end}}
+{{if eq .Status "approved"}}
+	<p>{{.Client.Name}} is now connected, you can go back to your device.</p>
+{{else if eq .Status "denied"}}
+	<p>{{.Client.Name}} was not connected.</p>
+{{else if and .Client.ID (not .Errors)}}
+	<div id="client">
+		{{with .Client.LogoURL}}<figure><img src="{{.}}" alt=""/></figure>{{end}}
+		<h2>{{.Client.Name}}</h2>
+		<p>Make sure the code {{.UserCode}} is the one shown on your device.</p>
+	</div>
+	<div id="scopes">
+		<p>{{.Client.Name}} will be able to:</p>
+		<ul>
+		{{range .Scopes}}
+			<li>{{.Description}}</li>
+		{{end}}
+		</ul>
+	</div>
+	<form method="post">
+		<input type="hidden" name="user_code" value="{{.UserCode}}"/>
+		<button type="submit" name="consent" value="deny">Deny</button>
+		<button type="submit" name="consent" value="approve">Connect</button>
+	</form>
+{{else}}
+	<form method="post">
+		<label for="user_code">Enter the code shown on your device</label>
+		<input type="text" id="user_code" name="user_code" value="{{.UserCode}}" autocomplete="off" autocapitalize="characters" autofocus/>
+		<button type="submit">Continue</button>
+	</form>
+{{end}}
+{{if or .Server.PolicyURL .Server.TermsOfServiceURL}}
+	<footer>
+		{{with .Server.PolicyURL}}<a href="{{.}}">Privacy policy</a>{{end}}
+		{{with .Server.TermsOfServiceURL}}<a href="{{.}}">Terms of service</a>{{end}}
+	</footer>
+{{end}}
+</body>
+</html>
+`
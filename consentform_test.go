@@ -0,0 +1,58 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestDefaultAuthzForm makes sure the default form shows the client's
+// publisher and legal documents to resource owners.
+func TestDefaultAuthzForm(t *testing.T) {
+	provider := test.NewProvider(true)
+	provider.Client.Publisher = "Example Inc."
+	provider.Client.TermsOfServiceURL, _ = url.Parse("https://example.com/tos")
+	provider.Client.PolicyURL, _ = url.Parse("https://example.com/privacy")
+
+	handler := Handler(http.NotFoundHandler(), SetProvider(provider))
+
+	authorize := func() string {
+		values := url.Values{
+			"client_id":     {"test_client_id"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"scope":         {"read write"},
+		}
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	body := authorize()
+	for _, s := range []string{
+		`href="https://example.com/tos"`,
+		`href="https://example.com/privacy"`,
+		"Published by Example Inc., not verified.",
+		`name="scope" value="read write"`,
+	} {
+		assert(t, strings.Contains(body, s), "'%s' was not found in %v", s, body)
+	}
+
+	provider.Client.PublisherVerified = true
+	body = authorize()
+	assert(t, strings.Contains(body, "Verified publisher"), "Verified publisher badge was not found in %v", body)
+	assert(t, !strings.Contains(body, "not verified"), "Verified publisher shown as not verified in %v", body)
+}
@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+type authzContextKey struct{}
+
+// authzContext is what AuthzHandler learned about a request, for the
+// handlers behind it. A single value is stored so letting a request through
+// costs one context allocation, whatever handlers end up asking for.
+type authzContext struct {
+	token    types.Token
+	provider Provider
+
+	// The client is only looked up if asked for.
+	clientOnce sync.Once
+	client     types.Client
+}
+
+// withToken attaches the access token a request was authorized with to its context.
+func withToken(req *http.Request, provider Provider, token types.Token) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), authzContextKey{}, &authzContext{
+		token:    token,
+		provider: provider,
+	}))
+}
+
+// TokenFromContext returns the access token of a request let through by
+// AuthzHandler, for handlers behind it.
+func TokenFromContext(ctx context.Context) (types.Token, bool) {
+	c, ok := ctx.Value(authzContextKey{}).(*authzContext)
+	if !ok {
+		return types.Token{}, false
+	}
+	return c.token, true
+}
+
+// ClientFromContext returns the client the access token of a request let
+// through by AuthzHandler was issued to. The client is looked up with the
+// provider the first time it is asked for, and it is not found if that fails.
+func ClientFromContext(ctx context.Context) (types.Client, bool) {
+	c, ok := ctx.Value(authzContextKey{}).(*authzContext)
+	if !ok || c.token.ClientID == "" {
+		return types.Client{}, false
+	}
+
+	c.clientOnce.Do(func() {
+		client, err := c.provider.ClientInfo(c.token.ClientID)
+		if err != nil {
+			id, _ := ctx.Value(requestIDKey{}).(string)
+			log.Printf("[ERROR] request_id=%s Error looking up client %s: %v", id, c.token.ClientID, err)
+			return
+		}
+		c.client = client
+	})
+	return c.client, c.client.ID != ""
+}
+
+// UserFromContext returns the resource owner who authorized the access token
+// of a request let through by AuthzHandler. Only the user's ID and how they
+// authenticated are known, not their name. It is not found for tokens
+// issued to clients on their own behalf, such as with client credentials.
+func UserFromContext(ctx context.Context) (types.User, bool) {
+	c, ok := ctx.Value(authzContextKey{}).(*authzContext)
+	if !ok || c.token.UserID == "" {
+		return types.User{}, false
+	}
+
+	return types.User{
+		ID:               c.token.UserID,
+		AMR:              c.token.AMR,
+		IdentityProvider: c.token.IdentityProvider,
+	}, true
+}
@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestContextValues tests that handlers behind AuthzHandler get the token,
+// client and user of the requests let through.
+func TestContextValues(t *testing.T) {
+	_, found := TokenFromContext(context.Background())
+	equals(t, false, found)
+	_, found = ClientFromContext(context.Background())
+	equals(t, false, found)
+	_, found = UserFromContext(context.Background())
+	equals(t, false, found)
+
+	provider := test.NewProvider(true)
+	provider.AMR = []string{"pwd", "otp"}
+	grant := types.Grant{
+		Scopes:           types.Scopes{types.Scope{ID: "read"}},
+		AMR:              provider.AMR,
+		IdentityProvider: "https://accounts.example.com",
+	}
+
+	var tokens []types.Token
+	var clients []types.Client
+	var users []types.User
+	handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token, _ := TokenFromContext(req.Context())
+		client, _ := ClientFromContext(req.Context())
+		user, _ := UserFromContext(req.Context())
+		tokens = append(tokens, token)
+		clients = append(clients, client)
+		users = append(users, user)
+	}), provider)
+
+	get := func(token types.Token) {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer "+token.Value)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+	}
+
+	token, err := provider.GenToken(grant, provider.Client, false, time.Hour)
+	ok(t, err)
+	stored := provider.AccessTokens[token.Value]
+	stored.UserID = "test_user"
+	provider.AccessTokens[token.Value] = stored
+	get(token)
+
+	equals(t, token.Value, tokens[0].Value)
+	equals(t, provider.Client.Name, clients[0].Name)
+	equals(t, types.User{ID: "test_user", AMR: []string{"pwd", "otp"}, IdentityProvider: "https://accounts.example.com"}, users[0])
+
+	// Tokens issued to clients on their own behalf have no user.
+	token, err = provider.GenToken(grant, provider.Client, false, time.Hour)
+	ok(t, err)
+	get(token)
+	equals(t, provider.Client.ID, clients[1].ID)
+	equals(t, types.User{}, users[1])
+}
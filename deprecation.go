@@ -0,0 +1,163 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Deprecation describes a flow or endpoint being retired. Until its sunset,
+// requests still succeed, but responses carry Deprecation and Sunset headers
+// and uses are logged and counted, so clients can be told to migrate.
+// http://tools.ietf.org/html/rfc9745 and http://tools.ietf.org/html/rfc8594
+type Deprecation struct {
+	// When it was deprecated, sent in the Deprecation header.
+	Since time.Time
+	// When requests start being rejected, sent in the Sunset header. Zero
+	// means requests keep succeeding.
+	Sunset time.Time
+	// Page documenting how to migrate, sent in a Link header, if any.
+	Link string
+}
+
+// SetDeprecation deprecates a flow, given by its grant type, such as
+// "implicit" or "password", or an endpoint, given by its path, such as
+// "/oauth2/authzs". Once past its sunset, requests for a flow are rejected
+// as unsupported and the flow is left out of the authorization server
+// metadata, while requests to an endpoint are answered with 410 Gone. See
+// Server.DeprecatedUses for which clients still rely on them. Unknown flows
+// and paths are fatal.
+func SetDeprecation(target string, d Deprecation) option {
+	return func(c *config) {
+		if c.deprecations == nil {
+			c.deprecations = make(map[string]Deprecation)
+		}
+		c.deprecations[target] = d
+	}
+}
+
+// checkDeprecation sends the deprecation headers of a flow or endpoint, if
+// deprecated, and records its use by the client, if known. It returns
+// whether the flow or endpoint is past its sunset, in which case the request
+// has to be rejected.
+func checkDeprecation(w http.ResponseWriter, req *http.Request, cfg config, target, clientID string) bool {
+	d, ok := cfg.deprecations[target]
+	if !ok {
+		return false
+	}
+
+	h := w.Header()
+	h.Set("Deprecation", "@"+strconv.FormatInt(d.Since.Unix(), 10))
+	if !d.Sunset.IsZero() {
+		h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if d.Link != "" {
+		h.Add("Link", "<"+d.Link+">; rel=\"deprecation\"")
+	}
+
+	t := now(cfg)
+	retired := retiredAt(cfg, target, t)
+	if cfg.deprecationUses != nil {
+		cfg.deprecationUses.record(target, clientID, t)
+	}
+
+	if retired {
+		log.Printf("[INFO] request_id=%s Client %q rejected for using %s, retired on %s", RequestID(req), clientID, target, d.Sunset.Format(time.RFC3339))
+	} else {
+		log.Printf("[WARN] request_id=%s Client %q used deprecated %s", RequestID(req), clientID, target)
+	}
+	return retired
+}
+
+// retiredAt tells whether a flow or endpoint is past its sunset at the given time.
+func retiredAt(cfg config, target string, t time.Time) bool {
+	d, ok := cfg.deprecations[target]
+	return ok && !d.Sunset.IsZero() && !t.Before(d.Sunset)
+}
+
+// validateDeprecations makes sure deprecations target flows or endpoints
+// that exist.
+func validateDeprecations(cfg config, registry map[string]map[string]func(http.ResponseWriter, *http.Request, config)) {
+	for target, d := range cfg.deprecations {
+		if strings.HasPrefix(target, "/") {
+			if _, ok := registry[target]; !ok {
+				log.Fatalf("Deprecation given for unknown endpoint %s", target)
+			}
+		} else if !grantTypes[target] {
+			log.Fatalf("Deprecation given for unknown flow %s", target)
+		}
+
+		if d.Since.IsZero() || (!d.Sunset.IsZero() && d.Sunset.Before(d.Since)) {
+			log.Fatalf("Deprecation of %s has to start before its sunset", target)
+		}
+	}
+}
+
+// retireEndpoint answers requests to an endpoint past its sunset.
+func retireEndpoint(w http.ResponseWriter, req *http.Request, cfg config) {
+	render.JSON(w, render.Options{
+		Status: http.StatusGone,
+		Data:   localize(req, cfg, ErrEndpointRetired),
+	})
+}
+
+// deprecationUses counts the uses of deprecated flows and endpoints by
+// client. It is kept across reloads.
+type deprecationUses struct {
+	sync.Mutex
+	uses map[[2]string]types.DeprecatedUse
+}
+
+func (d *deprecationUses) record(target, clientID string, t time.Time) {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.uses == nil {
+		d.uses = make(map[[2]string]types.DeprecatedUse)
+	}
+
+	key := [2]string{target, clientID}
+	use := d.uses[key]
+	use.Target = target
+	use.ClientID = clientID
+	use.Count++
+	use.LastUsed = t
+	d.uses[key] = use
+}
+
+func (d *deprecationUses) list() []types.DeprecatedUse {
+	d.Lock()
+	defer d.Unlock()
+
+	uses := make([]types.DeprecatedUse, 0, len(d.uses))
+	for _, use := range d.uses {
+		uses = append(uses, use)
+	}
+
+	sort.Slice(uses, func(i, j int) bool {
+		if uses[i].Target != uses[j].Target {
+			return uses[i].Target < uses[j].Target
+		}
+		return uses[i].ClientID < uses[j].ClientID
+	})
+	return uses
+}
+
+// DeprecatedUses returns how often each client used deprecated flows and
+// endpoints since the server started, sorted by flow or endpoint and client,
+// to tell who has yet to migrate. Clients are unknown for requests to
+// deprecated endpoints, and counted under an empty ID.
+func (s *Server) DeprecatedUses() []types.DeprecatedUse {
+	return s.current.Load().(*snapshot).cfg.deprecationUses.list()
+}
@@ -0,0 +1,98 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestDeprecation tests that deprecated flows and endpoints keep working
+// with deprecation headers until their sunset, and are rejected afterwards,
+// while their uses are counted.
+func TestDeprecation(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)}
+	since := clock.now.Add(-24 * time.Hour)
+	sunset := clock.now.Add(time.Hour)
+
+	s := Handler(nil,
+		SetProvider(test.NewProvider(true)),
+		SetClock(clock),
+		SetTokenExpiration(time.Hour),
+		SetAuthzExpiration(time.Minute),
+		SetDeprecation("password", Deprecation{Since: since, Sunset: sunset, Link: "https://example.com/migrate"}),
+		SetDeprecation("/oauth2/introspect", Deprecation{Since: since, Sunset: sunset}),
+	)
+
+	token := func(grantType string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"grant_type": {grantType},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	send := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "https://example.com"+path, nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := token("password")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "@"+strconv.FormatInt(since.Unix(), 10), w.Header().Get("Deprecation"))
+	equals(t, "Sun, 01 Mar 2026 01:00:00 GMT", w.Header().Get("Sunset"))
+	equals(t, `<https://example.com/migrate>; rel="deprecation"`, w.Header().Get("Link"))
+
+	w = token("client_credentials")
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "", w.Header().Get("Deprecation"))
+
+	body := send("GET", "/.well-known/oauth-authorization-server").Body.String()
+	assert(t, strings.Contains(body, `"password"`), "expected password grant until its sunset: %s", body)
+
+	clock.Advance(time.Hour)
+
+	w = token("password")
+	equals(t, http.StatusBadRequest, w.Code)
+	assert(t, strings.Contains(w.Body.String(), types.ErrorUnsupportedGrantType), "unexpected response %s", w.Body.String())
+	equals(t, "@"+strconv.FormatInt(since.Unix(), 10), w.Header().Get("Deprecation"))
+
+	body = send("GET", "/.well-known/oauth-authorization-server").Body.String()
+	assert(t, !strings.Contains(body, `"password"`), "unexpected password grant past its sunset: %s", body)
+
+	w = send("POST", "/oauth2/introspect")
+	equals(t, http.StatusGone, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrEndpointRetired.Description), "unexpected response %s", w.Body.String())
+
+	uses := s.DeprecatedUses()
+	equals(t, 2, len(uses))
+	equals(t, "/oauth2/introspect", uses[0].Target)
+	equals(t, "", uses[0].ClientID)
+	equals(t, int64(1), uses[0].Count)
+	equals(t, "password", uses[1].Target)
+	equals(t, "test_client_id", uses[1].ClientID)
+	equals(t, int64(2), uses[1].Count)
+	equals(t, clock.now, uses[1].LastUsed)
+}
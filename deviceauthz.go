@@ -0,0 +1,560 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// DeviceCodeGrantType is the grant type devices poll the token endpoint with.
+// http://tools.ietf.org/html/rfc8628#section-3.4
+const DeviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Defaults of device authorizations.
+const (
+	// DefaultDeviceCodeExpiration is how long device and user codes last if
+	// SetDeviceCodeExpiration is not used.
+	DefaultDeviceCodeExpiration = 10 * time.Minute
+	// DefaultDevicePollInterval is how long devices wait between polls of
+	// the token endpoint if SetDevicePollInterval is not used.
+	DefaultDevicePollInterval = 5 * time.Second
+)
+
+// DefaultUserCode is how user codes are generated if SetUserCodeGenerator is
+// not used, such as "WDJB-MJHT".
+var DefaultUserCode = tokengen.UserCode{Length: 8, GroupSize: 4}
+
+// DeviceCodeProvider is an optional interface that providers can implement in
+// order to support the Device Authorization Grant, for devices that can not
+// redirect resource owners to the authorization endpoint, such as TVs or
+// CLIs. http://tools.ietf.org/html/rfc8628
+//
+// Tokens are issued with GenToken, given a grant whose code is the device
+// code, for providers to look up the resource owner who approved it.
+type DeviceCodeProvider interface {
+	// SaveDeviceAuthorization stores a new device authorization, or updates
+	// an existing one with the same device code.
+	SaveDeviceAuthorization(authz types.DeviceAuthorization) error
+	// DeviceAuthorization returns the device authorization with the given
+	// device code. Its DeviceCode is empty if not found.
+	DeviceAuthorization(deviceCode string) (types.DeviceAuthorization, error)
+	// DeviceAuthorizationByUserCode returns the device authorization with
+	// the given user code. Its DeviceCode is empty if not found.
+	DeviceAuthorizationByUserCode(userCode string) (types.DeviceAuthorization, error)
+	// DeleteDeviceAuthorization deletes the device authorization once
+	// exchanged for tokens, so device codes are only used once.
+	DeleteDeviceAuthorization(deviceCode string) error
+}
+
+// ErrDeviceCodeProviderRequired is returned by the device authorization
+// endpoint when the provider does not implement DeviceCodeProvider.
+var ErrDeviceCodeProviderRequired = errors.New("oauth2: provider does not implement oauth2.DeviceCodeProvider")
+
+// SetDeviceEndpoint allows setting the endpoint devices request device and
+// user codes from. Defaults to "/oauth2/device_authorizations".
+func SetDeviceEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.deviceEndpoint = endpoint
+	}
+}
+
+// SetDeviceVerificationEndpoint allows setting the page resource owners
+// enter user codes at, shown by devices along with the user code. It is
+// kept short, as resource owners type it. Defaults to "/oauth2/device".
+func SetDeviceVerificationEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.deviceVerificationEndpoint = endpoint
+	}
+}
+
+// SetDeviceForm sets the page resource owners enter user codes at, and
+// approve or deny the device authorization. It is rendered with DeviceData.
+// Defaults to DefaultDeviceForm.
+func SetDeviceForm(form string) option {
+	return func(c *config) {
+		tpl, err := template.New("deviceform").Parse(form)
+		if err != nil {
+			log.Fatalf("Error parsing device form: %v", err)
+		}
+		c.deviceForm = tpl
+	}
+}
+
+// SetUserCodeGenerator sets how user codes are generated, for instance, with
+// digits only for devices without a full keyboard:
+//
+//	SetUserCodeGenerator(tokengen.UserCode{Length: 9, Charset: tokengen.UserCodeDigits, GroupSize: 3})
+//
+// Codes entered by resource owners are normalized with it before being looked
+// up. Defaults to DefaultUserCode.
+func SetUserCodeGenerator(g tokengen.UserCode) option {
+	return func(c *config) {
+		c.userCode = &g
+	}
+}
+
+// SetDeviceCodeExpiration sets how long device and user codes last. Defaults
+// to DefaultDeviceCodeExpiration.
+func SetDeviceCodeExpiration(e time.Duration) option {
+	return func(c *config) {
+		c.deviceCodeExpiration = e
+	}
+}
+
+// SetDevicePollInterval sets how long devices have to wait between polls of
+// the token endpoint. Defaults to DefaultDevicePollInterval.
+func SetDevicePollInterval(i time.Duration) option {
+	return func(c *config) {
+		c.devicePollInterval = i
+	}
+}
+
+// DeviceAuthorizationHandlers is a map to functions where each function handles a particular HTTP
+// verb or method.
+var DeviceAuthorizationHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"POST": noMethodOverride(unlessReadOnly(CreateDeviceAuthorization)),
+}
+
+// DeviceVerificationHandlers is a map to functions where each function handles a particular HTTP
+// verb or method.
+var DeviceVerificationHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET":  unlessReadOnly(VerifyUserCode),
+	"POST": unlessReadOnly(VerifyUserCode),
+}
+
+// deviceAuthorizationResponse is defined by
+// http://tools.ietf.org/html/rfc8628#section-3.2
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// CreateDeviceAuthorization issues device and user codes to authenticated
+// clients, as described in http://tools.ietf.org/html/rfc8628#section-3.1
+func CreateDeviceAuthorization(w http.ResponseWriter, req *http.Request, cfg config) {
+	key := clientKey(req)
+	if throttle(w, req, cfg, key) || lockedOut(w, req, cfg, key) {
+		return
+	}
+
+	username, password, ok := req.BasicAuth()
+	cinfo, err := cfg.provider.AuthenticateClient(username, password)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
+	if !ok || err != nil {
+		authFailed(cfg, key)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnauthorizedClient),
+		})
+		return
+	}
+	authSucceeded(cfg, key)
+
+	if e, inactive := inactiveClient(req, cfg, cinfo); inactive {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	provider, ok := asDeviceCodeProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrDeviceCodeProviderRequired),
+		})
+		return
+	}
+
+	scope := requestedScope(cfg, cinfo, req.PostFormValue("scope"))
+	if scope == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrScopeRequired("")),
+		})
+		return
+	}
+
+	scopes, err := cfg.provider.ScopesInfo(scope)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType: DeviceCodeGrantType,
+		Client:    cinfo,
+		Scopes:    scopes,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	authz, err := newDeviceAuthorization(cfg, provider, cinfo, scopes)
+	if err == nil {
+		err = provider.SaveDeviceAuthorization(authz)
+	}
+
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	verificationURI := "https://" + req.Host + cfg.deviceVerificationEndpoint
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data: deviceAuthorizationResponse{
+			DeviceCode:              authz.DeviceCode,
+			UserCode:                authz.UserCode,
+			VerificationURI:         verificationURI,
+			VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(authz.UserCode),
+			ExpiresIn:               int(deviceCodeExpiration(cfg).Seconds()),
+			Interval:                authz.Interval,
+		},
+	})
+}
+
+// newDeviceAuthorization generates the codes of a device authorization. User
+// codes have little entropy, so codes already in use are not handed out again.
+func newDeviceAuthorization(cfg config, provider DeviceCodeProvider, client types.Client, scopes types.Scopes) (types.DeviceAuthorization, error) {
+	deviceCode, err := tokengen.Default.Generate()
+	if err != nil {
+		return types.DeviceAuthorization{}, err
+	}
+
+	userCode, err := tokengen.Unique{
+		Generator: userCodeGenerator(cfg),
+		Exists: func(code string) (bool, error) {
+			authz, err := provider.DeviceAuthorizationByUserCode(code)
+			return authz.DeviceCode != "", err
+		},
+	}.Generate()
+	if err != nil {
+		return types.DeviceAuthorization{}, err
+	}
+
+	return types.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ClientID:   client.ID,
+		Scopes:     scopes,
+		ExpiresAt:  now(cfg).Add(deviceCodeExpiration(cfg)),
+		Interval:   int(devicePollInterval(cfg).Seconds()),
+		Status:     types.DeviceAuthorizationPending,
+	}, nil
+}
+
+// DeviceData defines properties used to render the page resource owners
+// enter user codes at.
+type DeviceData struct {
+	// User code entered by the resource owner, or sent in the
+	// verification_uri_complete shown by the device.
+	UserCode string
+	// Client and scopes of the device authorization, once the user code is
+	// found, for the resource owner to approve or deny them.
+	Client types.Client
+	Scopes types.Scopes
+	// Status of the device authorization once the resource owner approved
+	// or denied it.
+	Status types.DeviceAuthorizationStatus
+	// List of errors to display to the resource owner.
+	Errors []types.AuthzError
+	// Documents of the authorization server, to link from the form.
+	Server ServerDocuments
+}
+
+// VerifyUserCode handles the page resource owners enter the user code shown
+// by the device at, and approve or deny the device authorization after
+// checking the client and scopes, as described in
+// http://tools.ietf.org/html/rfc8628#section-3.3
+func VerifyUserCode(w http.ResponseWriter, req *http.Request, cfg config) {
+	yes, err := userAuthenticated(req, cfg)
+	if err == nil && !yes {
+		redirectToLogin(w, req, cfg)
+		return
+	}
+
+	data := DeviceData{
+		UserCode: userCodeGenerator(cfg).Normalize(req.FormValue("user_code")),
+		Server:   serverDocuments(cfg),
+	}
+	if isUnavailable(err) {
+		data.Errors = append(data.Errors, localize(req, cfg, ErrTemporarilyUnavailable))
+		render.HTML(w, render.Options{
+			Status:    http.StatusServiceUnavailable,
+			Data:      data,
+			Template:  deviceForm(cfg),
+			STSMaxAge: cfg.stsMaxAge,
+		})
+		return
+	}
+
+	renderForm := func(errs ...types.AuthzError) {
+		data.Errors = errs
+		render.HTML(w, render.Options{
+			Status:    http.StatusOK,
+			Data:      data,
+			Template:  deviceForm(cfg),
+			STSMaxAge: cfg.stsMaxAge,
+		})
+	}
+
+	provider, ok := asDeviceCodeProvider(cfg.provider)
+	if !ok {
+		renderForm(serverError(req, cfg, "", ErrDeviceCodeProviderRequired))
+		return
+	}
+
+	if data.UserCode == "" {
+		renderForm()
+		return
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		renderForm(serverError(req, cfg, "", err))
+		return
+	}
+
+	// User codes are easy to guess, attempts are limited per resource owner.
+	key := "user_code:" + user.ID
+	if lockedOut(w, req, cfg, key) {
+		return
+	}
+
+	authz, err := provider.DeviceAuthorizationByUserCode(data.UserCode)
+	if err != nil {
+		renderForm(serverError(req, cfg, "", err))
+		return
+	}
+
+	if authz.DeviceCode == "" || authz.Status != types.DeviceAuthorizationPending || !now(cfg).Before(authz.ExpiresAt) {
+		authFailed(cfg, key)
+		renderForm(localize(req, cfg, ErrUserCodeInvalid))
+		return
+	}
+
+	data.Client, err = cfg.provider.ClientInfo(authz.ClientID)
+	if err != nil {
+		renderForm(serverError(req, cfg, "", err))
+		return
+	}
+	data.Scopes = authz.Scopes
+
+	approval, denial := consentApproval(req), consentDenial(req)
+	if !approval && !denial {
+		// The resource owner makes sure the client and scopes are the ones
+		// of the device they are holding, as user codes can be phished.
+		renderForm()
+		return
+	}
+
+	// The form is only submitted by this page.
+	if !formOriginAllowed(req, cfg) {
+		log.Printf("[WARN] request_id=%s Device form submitted from origin %q, referer %q",
+			RequestID(req), req.Header.Get("Origin"), req.Header.Get("Referer"))
+		renderForm(localize(req, cfg, ErrFormOriginNotAllowed))
+		return
+	}
+
+	authz.Status = types.DeviceAuthorizationDenied
+	if approval {
+		authz.Status = types.DeviceAuthorizationApproved
+	}
+	authz.UserID = user.ID
+	authz.AMR = user.AMR
+	authz.IdentityProvider = user.IdentityProvider
+
+	if err := provider.SaveDeviceAuthorization(authz); err != nil {
+		renderForm(serverError(req, cfg, "", err))
+		return
+	}
+	authSucceeded(cfg, key)
+
+	log.Printf("[INFO] request_id=%s Device authorization for client %s %s by %s", RequestID(req), authz.ClientID, authz.Status, user.ID)
+	data.Status = authz.Status
+	renderForm()
+}
+
+// Implements http://tools.ietf.org/html/rfc8628#section-3.4 and
+// http://tools.ietf.org/html/rfc8628#section-3.5
+func deviceCodeGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
+	provider, ok := asDeviceCodeProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
+		})
+		return
+	}
+
+	fail := func(e types.AuthzError) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, e),
+		})
+	}
+
+	if treq.DeviceCode == "" {
+		fail(ErrDeviceCodeRequired)
+		return
+	}
+
+	authz, err := provider.DeviceAuthorization(treq.DeviceCode)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if authz.DeviceCode == "" || authz.ClientID != cinfo.ID {
+		fail(ErrInvalidGrant)
+		return
+	}
+
+	t := now(cfg)
+	if !t.Before(authz.ExpiresAt) {
+		fail(ErrDeviceCodeExpired)
+		return
+	}
+
+	switch authz.Status {
+	case types.DeviceAuthorizationApproved:
+	case types.DeviceAuthorizationDenied:
+		fail(ErrDeviceAccessDenied)
+		return
+	default:
+		// Devices polling faster than the interval have to wait 5 more
+		// seconds between polls from now on.
+		e := ErrAuthorizationPending
+		if !authz.LastPolledAt.IsZero() && t.Before(authz.LastPolledAt.Add(time.Duration(authz.Interval)*time.Second)) {
+			e = ErrSlowDown
+			authz.Interval += 5
+		}
+
+		authz.LastPolledAt = t
+		if err := provider.SaveDeviceAuthorization(authz); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+		fail(e)
+		return
+	}
+
+	if err := provider.DeleteDeviceAuthorization(authz.DeviceCode); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	audience, ok := requestedAudience(w, req, cfg, treq.Resources, authz.Scopes)
+	if !ok {
+		return
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    DeviceCodeGrantType,
+		Client:       cinfo,
+		User:         types.User{ID: authz.UserID, AMR: authz.AMR, IdentityProvider: authz.IdentityProvider},
+		Scopes:       authz.Scopes,
+		TokenRequest: &treq,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	grant := types.Grant{
+		Code:             authz.DeviceCode,
+		ClientID:         authz.ClientID,
+		UserID:           authz.UserID,
+		Scopes:           authz.Scopes,
+		Audience:         audience,
+		AMR:              authz.AMR,
+		IdentityProvider: authz.IdentityProvider,
+		KeyThumbprint:    treq.KeyThumbprint,
+	}
+
+	expiration, refreshable := tokenPolicy(cfg, grant.Scopes)
+	token, err := genToken(req, cfg, grant, cinfo, refreshable, expiration)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	renderToken(w, req, cfg, cinfo, token)
+}
+
+func userCodeGenerator(cfg config) tokengen.UserCode {
+	if cfg.userCode != nil {
+		return *cfg.userCode
+	}
+	return DefaultUserCode
+}
+
+func deviceCodeExpiration(cfg config) time.Duration {
+	if cfg.deviceCodeExpiration > 0 {
+		return cfg.deviceCodeExpiration
+	}
+	return DefaultDeviceCodeExpiration
+}
+
+func devicePollInterval(cfg config) time.Duration {
+	if cfg.devicePollInterval > 0 {
+		return cfg.devicePollInterval
+	}
+	return DefaultDevicePollInterval
+}
+
+var defaultDeviceForm = template.Must(template.New("deviceform").Parse(DefaultDeviceForm))
+
+func deviceForm(cfg config) *template.Template {
+	if cfg.deviceForm != nil {
+		return cfg.deviceForm
+	}
+	return defaultDeviceForm
+}
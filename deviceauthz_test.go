@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+func requestDeviceAuthorization(t *testing.T, cfg config) deviceAuthorizationResponse {
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/device_authorizations", bytes.NewBufferString("scope=read"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	CreateDeviceAuthorization(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	var res deviceAuthorizationResponse
+	ok(t, json.Unmarshal(w.Body.Bytes(), &res))
+	return res
+}
+
+func pollDeviceCode(t *testing.T, cfg config, deviceCode string) *httptest.ResponseRecorder {
+	values := url.Values{
+		"grant_type":  {DeviceCodeGrantType},
+		"device_code": {deviceCode},
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	return w
+}
+
+func pollError(t *testing.T, w *httptest.ResponseRecorder) string {
+	equals(t, http.StatusBadRequest, w.Code)
+	var e types.AuthzError
+	ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+	return e.Code
+}
+
+func verifyUserCode(t *testing.T, cfg config, userCode, consent, origin string) *httptest.ResponseRecorder {
+	values := url.Values{"user_code": {userCode}}
+	if consent != "" {
+		values.Set(ConsentParam, consent)
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/device", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", origin)
+
+	w := httptest.NewRecorder()
+	VerifyUserCode(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	return w
+}
+
+// TestDeviceAuthorizationGrant tests that devices get tokens once the
+// resource owner enters the user code and approves the authorization.
+func TestDeviceAuthorizationGrant(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	provider.AMR = []string{"pwd"}
+	cfg := setupTest()
+	cfg.provider = provider
+	SetClock(clock)(&cfg)
+	SetDeviceVerificationEndpoint("/oauth2/device")(&cfg)
+
+	res := requestDeviceAuthorization(t, cfg)
+	equals(t, "https://example.com/oauth2/device", res.VerificationURI)
+	equals(t, res.VerificationURI+"?user_code="+res.UserCode, res.VerificationURIComplete)
+	equals(t, 600, res.ExpiresIn)
+	equals(t, 5, res.Interval)
+	equals(t, 9, len(res.UserCode))
+	equals(t, "-", res.UserCode[4:5])
+
+	equals(t, types.ErrorAuthorizationPending, pollError(t, pollDeviceCode(t, cfg, res.DeviceCode)))
+	equals(t, types.ErrorSlowDown, pollError(t, pollDeviceCode(t, cfg, res.DeviceCode)))
+	equals(t, 10, provider.DeviceAuthorizations[res.DeviceCode].Interval)
+
+	// Resource owners are asked to check the client before approving it.
+	// Codes are normalized as typed.
+	typed := strings.ToLower(strings.Replace(res.UserCode, "-", " ", 1))
+	w := verifyUserCode(t, cfg, typed, "", "https://example.com")
+	assert(t, strings.Contains(w.Body.String(), provider.Client.Name), "expected the client to be shown: %s", w.Body)
+	assert(t, strings.Contains(w.Body.String(), `value="approve"`), "expected the approval form: %s", w.Body)
+
+	w = verifyUserCode(t, cfg, "BCDF-GHJK", ConsentApprove, "https://example.com")
+	assert(t, strings.Contains(w.Body.String(), ErrUserCodeInvalid.Description), "expected an invalid code error: %s", w.Body)
+
+	w = verifyUserCode(t, cfg, res.UserCode, ConsentApprove, "https://evil.example.com")
+	assert(t, strings.Contains(w.Body.String(), ErrFormOriginNotAllowed.Description), "expected an origin error: %s", w.Body)
+	equals(t, types.DeviceAuthorizationPending, provider.DeviceAuthorizations[res.DeviceCode].Status)
+
+	verifyUserCode(t, cfg, res.UserCode, ConsentApprove, "https://example.com")
+	authz := provider.DeviceAuthorizations[res.DeviceCode]
+	equals(t, types.DeviceAuthorizationApproved, authz.Status)
+	equals(t, "test_user", authz.UserID)
+	equals(t, []string{"pwd"}, authz.AMR)
+
+	clock.Advance(10 * time.Second)
+	w = pollDeviceCode(t, cfg, res.DeviceCode)
+	equals(t, http.StatusOK, w.Code)
+	var token types.Token
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, []string{"pwd"}, provider.AccessTokens[token.Value].AMR)
+
+	// Device codes are exchanged only once.
+	equals(t, types.ErrorInvalidGrant, pollError(t, pollDeviceCode(t, cfg, res.DeviceCode)))
+}
+
+// TestDeviceAuthorizationDenied tests that devices are told when resource
+// owners deny them, or when they took too long.
+func TestDeviceAuthorizationDenied(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+	SetClock(clock)(&cfg)
+
+	res := requestDeviceAuthorization(t, cfg)
+	verifyUserCode(t, cfg, res.UserCode, ConsentDeny, "https://example.com")
+	equals(t, types.ErrorAccessDenied, pollError(t, pollDeviceCode(t, cfg, res.DeviceCode)))
+
+	// Codes already denied can not be entered again.
+	w := verifyUserCode(t, cfg, res.UserCode, ConsentApprove, "https://example.com")
+	assert(t, strings.Contains(w.Body.String(), ErrUserCodeInvalid.Description), "expected an invalid code error: %s", w.Body)
+
+	res = requestDeviceAuthorization(t, cfg)
+	clock.Advance(DefaultDeviceCodeExpiration)
+	equals(t, types.ErrorExpiredToken, pollError(t, pollDeviceCode(t, cfg, res.DeviceCode)))
+
+	// Resource owners log in before entering codes.
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/device?user_code="+res.UserCode, nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	cfg.provider = test.NewProvider(false)
+	VerifyUserCode(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+	assert(t, strings.HasPrefix(w.Header().Get("Location"), "https://api.hooklift.io/accounts/login"), "expected a login redirect: %s", w.Header().Get("Location"))
+}
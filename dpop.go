@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// DPoPHeader is the HTTP header clients send DPoP proofs in, JWTs signed with
+// a key of their own, carrying its public key, proving they hold it.
+// http://tools.ietf.org/html/rfc9449#section-4
+const DPoPHeader = "DPoP"
+
+// dpopProofMaxAge is how far the issuance time of DPoP proofs can be from
+// the current time. Proofs are meant to be created right before sending
+// requests.
+const dpopProofMaxAge = time.Duration(1) * time.Minute
+
+var errDPoPProofInvalid = errors.New("oauth2: DPoP proof is malformed, expired or not meant for this request")
+
+// dpopClaims are the claims of DPoP proofs.
+type dpopClaims struct {
+	jwt.Claims
+	Method string `json:"htm"`
+	URI    string `json:"htu"`
+}
+
+// proveKey verifies the DPoP proof sent along with a token request, if any,
+// recording the thumbprint of its key in the token request. Clients whose
+// refresh tokens are bound to keys have to send one, unless asking for
+// client credentials, which come without refresh token. Only refresh tokens
+// are bound to keys, access tokens are still bearer tokens.
+// http://tools.ietf.org/html/rfc9449#section-5
+func proveKey(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq *TokenRequest) bool {
+	proof := req.Header.Get(DPoPHeader)
+	if proof == "" {
+		if cinfo.DPoPBoundRefreshTokens && treq.GrantType != "client_credentials" {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrDPoPProofRequired),
+			})
+			return false
+		}
+		return true
+	}
+
+	thumbprint, claims, err := verifyDPoPProof(req, cfg, proof)
+	if err != nil {
+		log.Printf("[INFO] request_id=%s DPoP proof of client %s rejected: %v", RequestID(req), cinfo.ID, err)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrDPoPProofInvalid),
+		})
+		return false
+	}
+
+	// Proofs can only be used once if a replay cache is set.
+	if cfg.replayCache != nil {
+		seen, err := cfg.replayCache.Seen("dpop:"+thumbprint+":"+claims.ID, time.Unix(claims.IssuedAt, 0).Add(dpopProofMaxAge))
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return false
+		}
+
+		if seen {
+			log.Printf("[INFO] request_id=%s DPoP proof of client %s replayed", RequestID(req), cinfo.ID)
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrDPoPProofInvalid),
+			})
+			return false
+		}
+	}
+
+	treq.KeyThumbprint = thumbprint
+	return true
+}
+
+// verifyDPoPProof checks that the proof is signed by the key it carries, and
+// was created for this request, and returns the JWK thumbprint of the key
+// along with the claims of the proof.
+// http://tools.ietf.org/html/rfc9449#section-4.3
+func verifyDPoPProof(req *http.Request, cfg config, proof string) (string, dpopClaims, error) {
+	var claims dpopClaims
+	token, err := jwt.Parse(proof)
+	if err != nil {
+		return "", claims, err
+	}
+
+	if token.Header.Type != "dpop+jwt" || !allowedAlgorithm(cfg, token.Header.Algorithm) {
+		return "", claims, errDPoPProofInvalid
+	}
+
+	key, err := fromJWK(token.Header.JWK)
+	if err != nil {
+		return "", claims, err
+	}
+
+	if err := token.Verify(key); err != nil {
+		return "", claims, err
+	}
+
+	if err := token.Decode(&claims); err != nil {
+		return "", claims, errDPoPProofInvalid
+	}
+
+	// The query and fragment of the target URI are left out.
+	uri := claims.URI
+	if i := strings.IndexAny(uri, "?#"); i >= 0 {
+		uri = uri[:i]
+	}
+
+	age := now(cfg).Sub(time.Unix(claims.IssuedAt, 0))
+	if claims.ID == "" || claims.IssuedAt == 0 || age > dpopProofMaxAge || age < -dpopProofMaxAge ||
+		claims.Method != req.Method || uri != "https://"+req.Host+req.URL.Path {
+		return "", claims, errDPoPProofInvalid
+	}
+	return keyID("", key), claims, nil
+}
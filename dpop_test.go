@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/replay"
+	"github.com/hooklift/oauth2/types"
+)
+
+func dpopProof(t *testing.T, key *ecdsa.PrivateKey, htu, jti string, issuedAt time.Time) string {
+	pub, _ := toJWK(key.Public())
+	raw, err := json.Marshal(pub)
+	ok(t, err)
+
+	proof, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256, Type: "dpop+jwt", JWK: raw}, dpopClaims{
+		Claims: jwt.Claims{ID: jti, IssuedAt: issuedAt.Unix()},
+		Method: "POST",
+		URI:    htu,
+	}, key)
+	ok(t, err)
+	return proof
+}
+
+// TestDPoPBoundRefreshTokens tests that refresh tokens of clients requiring
+// it are bound to the key of the DPoP proof sent when they were issued, and
+// can not be refreshed without it.
+func TestDPoPBoundRefreshTokens(t *testing.T) {
+	// Replay caches expire proofs by the system clock.
+	clock := &fakeClock{now: time.Now()}
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.DPoPBoundRefreshTokens = true
+	cfg.provider = provider
+	SetClock(clock)(&cfg)
+	SetReplayCache(replay.NewMemoryCache())(&cfg)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	stolen, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	endpoint := "https://example.com/oauth2/tokens"
+	tokenRequest := func(values url.Values, proof string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+		if proof != "" {
+			req.Header.Set(DPoPHeader, proof)
+		}
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+	errorOf := func(w *httptest.ResponseRecorder) types.AuthzError {
+		equals(t, http.StatusBadRequest, w.Code)
+		var e types.AuthzError
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return e
+	}
+
+	password := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test_user"},
+		"password":   {"test_password"},
+	}
+	equals(t, ErrDPoPProofRequired.Description, errorOf(tokenRequest(password, "")).Description)
+
+	// Proofs have to be fresh and meant for the token endpoint.
+	old := dpopProof(t, key, endpoint, "1", clock.now.Add(-2*time.Minute))
+	equals(t, ErrDPoPProofInvalid.Description, errorOf(tokenRequest(password, old)).Description)
+	elsewhere := dpopProof(t, key, "https://example.com/oauth2/authzs", "2", clock.now)
+	equals(t, ErrDPoPProofInvalid.Description, errorOf(tokenRequest(password, elsewhere)).Description)
+
+	proof := dpopProof(t, key, endpoint+"?ignored=1", "3", clock.now)
+	w := tokenRequest(password, proof)
+	equals(t, http.StatusOK, w.Code)
+	var token types.Token
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	assert(t, token.RefreshToken != "", "expected a refresh token")
+	thumbprint := keyID("", key.Public())
+	equals(t, thumbprint, provider.AccessTokens[token.Value].KeyThumbprint)
+
+	// Proofs are only accepted once.
+	equals(t, ErrDPoPProofInvalid.Description, errorOf(tokenRequest(password, proof)).Description)
+
+	refresh := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	}
+	equals(t, ErrDPoPProofRequired.Description, errorOf(tokenRequest(refresh, "")).Description)
+	e := errorOf(tokenRequest(refresh, dpopProof(t, stolen, endpoint, "4", clock.now)))
+	equals(t, types.ErrorInvalidGrant, e.Code)
+	equals(t, ErrRefreshTokenKeyMismatch.Description, e.Description)
+
+	w = tokenRequest(refresh, dpopProof(t, key, endpoint, "5", clock.now))
+	equals(t, http.StatusOK, w.Code)
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, thumbprint, provider.RefreshTokens[token.RefreshToken].KeyThumbprint)
+
+	// Client credentials come without refresh token, no proof is needed.
+	equals(t, http.StatusOK, tokenRequest(url.Values{"grant_type": {"client_credentials"}}, "").Code)
+}
+
+// TestFromJWK tests that public keys survive the round trip to JSON Web Keys,
+// and that private or weak keys are rejected.
+func TestFromJWK(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	ok(t, err)
+
+	for _, pub := range []crypto.PublicKey{rsaKey.Public(), ecKey.Public(), edPub} {
+		key, _ := toJWK(pub)
+		raw, err := json.Marshal(key)
+		ok(t, err)
+
+		parsed, err := fromJWK(raw)
+		ok(t, err)
+		equals(t, keyID("", pub), keyID("", parsed))
+	}
+
+	weak, err := rsa.GenerateKey(rand.Reader, 1024)
+	ok(t, err)
+	key, _ := toJWK(weak.Public())
+	raw, err := json.Marshal(key)
+	ok(t, err)
+	_, err = fromJWK(raw)
+	equals(t, errJWKInvalid, err)
+
+	_, err = fromJWK([]byte(`{"kty":"OKP","crv":"Ed25519","x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo","d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A"}`))
+	equals(t, errJWKInvalid, err)
+}
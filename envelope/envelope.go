@@ -0,0 +1,225 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package envelope implements envelope encryption for providers to protect
+// token values, client secrets and consent records at rest.
+//
+// Every value is encrypted with its own random data key using AES-GCM. The
+// data key is in turn encrypted with a key-encryption key from a Keyring and
+// stored alongside the value, together with the identifier of the
+// key-encryption key. Rotating key-encryption keys only requires
+// re-wrapping data keys, which Rewrap does without decrypting values.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// Version prefix of sealed values.
+const version = "v1"
+
+// Errors
+var (
+	ErrMalformed    = errors.New("envelope: malformed sealed value")
+	ErrUnknownKey   = errors.New("envelope: unknown key-encryption key")
+	ErrInvalidKey   = errors.New("envelope: key-encryption keys must be 16, 24 or 32 bytes long")
+	ErrInvalidKeyID = errors.New("envelope: key identifiers can not contain dots")
+)
+
+// Keyring supplies key-encryption keys.
+type Keyring interface {
+	// CurrentKey returns the key used to encrypt new values and its
+	// identifier, which can not contain dots.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given identifier. Keys used to encrypt
+	// values still stored have to be kept around until those values are rewrapped.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyring is an in-memory Keyring.
+type StaticKeyring struct {
+	// Identifier of the key used to encrypt new values.
+	Current string
+	// Keys indexed by their identifiers.
+	Keys map[string][]byte
+}
+
+// CurrentKey implements Keyring.
+func (k StaticKeyring) CurrentKey() (string, []byte, error) {
+	key, err := k.Key(k.Current)
+	return k.Current, key, err
+}
+
+// Key implements Keyring.
+func (k StaticKeyring) Key(id string) ([]byte, error) {
+	key, ok := k.Keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// Seal encrypts plaintext with a new data key wrapped by the current
+// key-encryption key. Additional data, such as the identifier of the record
+// the value belongs to, is authenticated but not encrypted, preventing
+// sealed values from being swapped between records.
+func Seal(kr Keyring, plaintext, additionalData []byte) (string, error) {
+	kid, kek, err := kr.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(kid, ".") {
+		return "", ErrInvalidKeyID
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return "", err
+	}
+
+	wrappedKey, err := encrypt(kek, dek, []byte(kid))
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, err := encrypt(dek, plaintext, additionalData)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		version,
+		kid,
+		base64.RawURLEncoding.EncodeToString(wrappedKey),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+	}, "."), nil
+}
+
+// Open decrypts a value sealed with Seal. The same additional data given
+// when sealing is required.
+func Open(kr Keyring, sealed string, additionalData []byte) ([]byte, error) {
+	kid, wrappedKey, ciphertext, err := parse(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := unwrap(kr, kid, wrappedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return decrypt(dek, ciphertext, additionalData)
+}
+
+// KeyID returns the identifier of the key-encryption key a value was sealed
+// with, allowing providers to find values that still need to be rewrapped.
+func KeyID(sealed string) (string, error) {
+	kid, _, _, err := parse(sealed)
+	return kid, err
+}
+
+// Rewrap re-encrypts the data key of a sealed value with the current
+// key-encryption key. The value itself is not decrypted.
+func Rewrap(kr Keyring, sealed string) (string, error) {
+	kid, wrappedKey, ciphertext, err := parse(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := unwrap(kr, kid, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+
+	currentID, kek, err := kr.CurrentKey()
+	if err != nil {
+		return "", err
+	}
+
+	if strings.Contains(currentID, ".") {
+		return "", ErrInvalidKeyID
+	}
+
+	wrappedKey, err = encrypt(kek, dek, []byte(currentID))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		version,
+		currentID,
+		base64.RawURLEncoding.EncodeToString(wrappedKey),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+	}, "."), nil
+}
+
+func parse(sealed string) (kid string, wrappedKey, ciphertext []byte, err error) {
+	parts := strings.Split(sealed, ".")
+	if len(parts) != 4 || parts[0] != version {
+		return "", nil, nil, ErrMalformed
+	}
+
+	wrappedKey, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+
+	ciphertext, err = base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[1], wrappedKey, ciphertext, nil
+}
+
+func unwrap(kr Keyring, kid string, wrappedKey []byte) ([]byte, error) {
+	kek, err := kr.Key(kid)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(kek, wrappedKey, []byte(kid))
+}
+
+// encrypt seals plaintext with AES-GCM, prepending the random nonce used.
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce := ciphertext[:aead.NonceSize()]
+	return aead.Open(nil, nonce, ciphertext[aead.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package envelope
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSealAndRotate(t *testing.T) {
+	kr := StaticKeyring{
+		Current: "2015-01",
+		Keys: map[string][]byte{
+			"2015-01": bytes.Repeat([]byte{1}, 32),
+		},
+	}
+
+	secret := []byte("refresh-token-value")
+	sealed, err := Seal(kr, secret, []byte("token-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Contains([]byte(sealed), secret) {
+		t.Fatalf("sealed value leaks plaintext: %s", sealed)
+	}
+
+	// Additional data binds values to their records.
+	if _, err := Open(kr, sealed, []byte("other-token-id")); err == nil {
+		t.Error("expected an error opening value with different additional data")
+	}
+
+	// Introduces a new key-encryption key, the previous one is kept to
+	// be able to open values sealed with it.
+	kr.Keys["2015-06"] = bytes.Repeat([]byte{2}, 32)
+	kr.Current = "2015-06"
+
+	opened, err := Open(kr, sealed, []byte("token-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, opened) {
+		t.Errorf("unexpected plaintext: %s", opened)
+	}
+
+	rewrapped, err := Rewrap(kr, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kid, err := KeyID(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid != "2015-06" {
+		t.Errorf("expected value to be rewrapped with the current key, got %s", kid)
+	}
+
+	// Retires the old key.
+	delete(kr.Keys, "2015-01")
+	if _, err := Open(kr, sealed, []byte("token-id")); err != ErrUnknownKey {
+		t.Errorf("expected unknown key error, got %v", err)
+	}
+
+	opened, err = Open(kr, rewrapped, []byte("token-id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret, opened) {
+		t.Errorf("unexpected plaintext: %s", opened)
+	}
+}
@@ -19,79 +19,412 @@ import (
 // Errors returned to resource owner in accordance with spec.
 var (
 	ErrRedirectURLMismatch = types.AuthzError{
-		Code:        "access_denied",
+		Code:        types.ErrorAccessDenied,
 		Description: "3rd-party client app provided a redirect_uri that does not match the URI registered for this client in our database.",
+		MessageID:   "redirect_uri_mismatch",
 	}
 
 	ErrRedirectURLInvalid = types.AuthzError{
-		Code:        "access_denied",
+		Code:        types.ErrorAccessDenied,
 		Description: "3rd-party client app provided an invalid redirect_uri. It does not comply with http://tools.ietf.org/html/rfc3986#section-4.3 or does not use HTTPS.",
+		MessageID:   "redirect_uri_invalid",
+	}
+
+	ErrRedirectURLNotAssociated = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Redirect URL host does not associate it with the client's native apps.",
+		MessageID:   "redirect_url_not_associated",
+	}
+
+	ErrFormOriginNotAllowed = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Authorization form was submitted from an origin not allowed for this client.",
+		MessageID:   "form_origin_not_allowed",
 	}
 
 	ErrClientIDMissing = types.AuthzError{
-		Code:        "unauthorized_client",
+		Code:        types.ErrorUnauthorizedClient,
 		Description: "3rd-party client app didn't send us its client ID.",
+		MessageID:   "client_id_missing",
 	}
 
 	ErrClientIDNotFound = types.AuthzError{
-		Code:        "unauthorized_client",
+		Code:        types.ErrorUnauthorizedClient,
 		Description: "3rd-party client app requesting access to your resources was not found in our database.",
+		MessageID:   "client_id_not_found",
 	}
 
 	ErrUnauthorizedClient = types.AuthzError{
-		Code:        "unauthorized_client",
+		Code:        types.ErrorUnauthorizedClient,
 		Description: "You must provide an authorization header with your client credentials.",
+		MessageID:   "client_credentials_required",
+	}
+
+	ErrClientPending = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Client application is pending review.",
+		MessageID:   "client_pending",
+	}
+
+	ErrClientSuspended = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Client application is suspended.",
+		MessageID:   "client_suspended",
+	}
+
+	ErrClientDeleted = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Client application was deleted.",
+		MessageID:   "client_deleted",
+	}
+
+	ErrClientStatusTransition = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Client can not transition to the requested status.",
+		MessageID:   "client_status_transition",
+	}
+
+	ErrStatsDaysInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "days must be a number between 1 and 366.",
+		MessageID:   "stats_days_invalid",
 	}
 
 	ErrUnsupportedGrantType = types.AuthzError{
-		Code:        "unsupported_grant_type",
+		Code:        types.ErrorUnsupportedGrantType,
 		Description: "grant_type provided is not supported by this authorization server.",
 	}
 
 	ErrInvalidGrant = types.AuthzError{
-		Code:        "invalid_grant",
+		Code:        types.ErrorInvalidGrant,
 		Description: "The provided authorization grant (e.g., authorization code, resource owner credentials) or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client.",
 	}
 
+	ErrConsentDenied = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "The resource owner denied the authorization request.",
+		MessageID:   "consent_denied",
+	}
+
 	ErrUnathorizedUser = types.AuthzError{
-		Code:        "access_denied",
+		Code:        types.ErrorAccessDenied,
 		Description: "Resource owner credentials are invalid.",
+		MessageID:   "user_credentials_invalid",
+	}
+
+	ErrLoginRequired = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Resource owner has to be logged in.",
+		MessageID:   "login_required",
+	}
+
+	ErrNotFound = types.AuthzError{
+		Code:        types.ErrorNotFound,
+		Description: "The requested resource was not found.",
+	}
+
+	ErrEndpointRetired = types.AuthzError{
+		Code:        types.ErrorNotFound,
+		Description: "The requested endpoint has been retired.",
+		MessageID:   "endpoint_retired",
+	}
+
+	ErrTemporarilyUnavailable = types.AuthzError{
+		Code:        types.ErrorTemporarilyUnavailable,
+		Description: "The authorization server is currently unable to handle the request due to a temporary overloading or maintenance.",
+	}
+
+	ErrReadOnly = types.AuthzError{
+		Code:        types.ErrorTemporarilyUnavailable,
+		Description: "The authorization server is in read-only mode. Try again later.",
+		MessageID:   "read_only",
+	}
+
+	ErrTooManyRequests = types.AuthzError{
+		Code:        types.ErrorTemporarilyUnavailable,
+		Description: "Too many requests or failed authentication attempts, try again later.",
+		MessageID:   "too_many_requests",
+	}
+
+	ErrInvalidTarget = types.AuthzError{
+		Code:        types.ErrorInvalidTarget,
+		Description: "The requested resource is invalid, unknown, or malformed.",
 	}
 
 	ErrInvalidScope = types.AuthzError{
-		Code:        "invalid_scope",
+		Code:        types.ErrorInvalidScope,
 		Description: "Scope exceeds the scope granted by the resource owner.",
 	}
 
 	ErrClientIDMismatch = types.AuthzError{
-		Code:        "invalid_request",
+		Code:        types.ErrorInvalidRequest,
 		Description: "Authenticated client did not generate token used.",
+		MessageID:   "client_id_mismatch",
+	}
+
+	ErrGrantNonceInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Grant revocation nonce is missing, invalid or expired.",
+		MessageID:   "grant_nonce_invalid",
+	}
+
+	ErrMethodOverride = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "HTTP method overrides are not allowed.",
+		MessageID:   "method_override",
 	}
 
 	ErrUnsupportedTokenType = types.AuthzError{
-		Code:        "invalid_token",
+		Code:        types.ErrorInvalidToken,
 		Description: "Unsupported token type.",
+		MessageID:   "unsupported_token_type",
 	}
 
 	ErrAccessTokenRequired = types.AuthzError{
-		Code:        "invalid_request",
+		Code:        types.ErrorInvalidRequest,
 		Description: "An access token is required to access this resource.",
+		MessageID:   "access_token_required",
+	}
+
+	ErrPolicyDenied = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "The request is not allowed by the authorization server policies.",
+		MessageID:   "policy_denied",
+	}
+
+	ErrAuthzRequestInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Authorization request expired or was tampered with, please start over.",
+		MessageID:   "authz_request_invalid",
+	}
+
+	ErrAuthzRequestExpired = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Authorization request expired, please restart the authorization.",
+		MessageID:   "authz_request_expired",
+	}
+
+	ErrRequestObjectInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequestObject,
+		Description: "Request object could not be decrypted or verified, has expired or was already used.",
+	}
+
+	ErrSimulationMalformed = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Simulation is malformed, it requires a client ID and a grant type.",
+		MessageID:   "simulation_malformed",
+	}
+
+	ErrCredentialEventMalformed = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Credential event is malformed, it requires a type and a user ID.",
+		MessageID:   "credential_event_malformed",
+	}
+
+	ErrLeakReportMalformed = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Leaked token report is malformed, it requires a list of tokens.",
+		MessageID:   "leak_report_malformed",
+	}
+
+	ErrAuthzCodeRequired = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Authorization code can't be empty.",
+		MessageID:   "authz_code_required",
+	}
+
+	ErrGrantCodeUsed = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Grant code was revoked, expired or already used.",
+		MessageID:   "grant_code_used",
+	}
+
+	ErrGrantRedirectURLMismatch = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Grant code was generated for a different redirect URI.",
+		MessageID:   "grant_redirect_uri_mismatch",
+	}
+
+	ErrCodeVerifierInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "PKCE code verifier is missing or does not match the code challenge.",
+		MessageID:   "code_verifier_invalid",
+	}
+
+	ErrGrantClientIDMismatch = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Grant code was generated for a different client ID.",
+		MessageID:   "grant_client_id_mismatch",
+	}
+
+	ErrRefreshTokenRequired = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Refresh token can't be empty.",
+		MessageID:   "refresh_token_required",
+	}
+
+	ErrRefreshTokenInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Refresh token is invalid, expired or revoked.",
+		MessageID:   "refresh_token_invalid",
+	}
+
+	ErrRefreshClientIDMismatch = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Refresh token was issued to a different client.",
+		MessageID:   "refresh_client_id_mismatch",
+	}
+
+	ErrRefreshNotAllowed = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Tokens with the requested scope can not be refreshed.",
+		MessageID:   "refresh_not_allowed",
+	}
+
+	ErrPasswordGrantNotAllowed = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Client is not allowed to authenticate resource owners with their password.",
+		MessageID:   "password_grant_not_allowed",
+	}
+
+	ErrSubjectTokenRequired = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Subject token and its type can't be empty.",
+		MessageID:   "subject_token_required",
+	}
+
+	ErrTokenTypeUnsupported = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Only access tokens can be exchanged, for access tokens.",
+		MessageID:   "token_type_unsupported",
+	}
+
+	ErrSubjectTokenInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Subject token is invalid, expired or revoked.",
+		MessageID:   "subject_token_invalid",
+	}
+
+	ErrTokenExchangeDenied = types.AuthzError{
+		Code:        types.ErrorUnauthorizedClient,
+		Description: "Client is not allowed to exchange this token.",
+		MessageID:   "token_exchange_denied",
+	}
+
+	ErrServiceAccountScope = types.AuthzError{
+		Code:        types.ErrorInvalidScope,
+		Description: "Scope exceeds the scope allowed for this service account.",
+		MessageID:   "service_account_scope",
+	}
+
+	ErrClientCredentialsScope = types.AuthzError{
+		Code:        types.ErrorInvalidScope,
+		Description: "Scope exceeds the scope allowed for this client.",
+		MessageID:   "client_credentials_scope",
+	}
+
+	ErrUpstreamStateInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Login through the identity provider expired or was not started by this browser.",
+		MessageID:   "upstream_state_invalid",
+	}
+
+	ErrUpstreamLoginFailed = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Login through the identity provider failed.",
+		MessageID:   "upstream_login_failed",
+	}
+
+	ErrUserProvisioningDenied = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Resource owner is not allowed an account.",
+		MessageID:   "user_provisioning_denied",
+	}
+
+	ErrUserAccountConflict = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Resource owner's identity conflicts with an existing account.",
+		MessageID:   "user_account_conflict",
+	}
+
+	ErrUserCodeInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "The code is invalid or expired. Check the code shown on your device and try again.",
+		MessageID:   "user_code_invalid",
+	}
+
+	ErrDeviceCodeRequired = types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "Device code can't be empty.",
+		MessageID:   "device_code_required",
+	}
+
+	ErrAuthorizationPending = types.AuthzError{
+		Code:        types.ErrorAuthorizationPending,
+		Description: "Resource owner has not approved the device authorization yet.",
+		MessageID:   "authorization_pending",
+	}
+
+	ErrSlowDown = types.AuthzError{
+		Code:        types.ErrorSlowDown,
+		Description: "Device is polling too fast, the interval has to be increased by 5 seconds.",
+		MessageID:   "slow_down",
+	}
+
+	ErrDeviceCodeExpired = types.AuthzError{
+		Code:        types.ErrorExpiredToken,
+		Description: "Device code expired, a new device authorization has to be requested.",
+		MessageID:   "device_code_expired",
+	}
+
+	ErrDeviceAccessDenied = types.AuthzError{
+		Code:        types.ErrorAccessDenied,
+		Description: "Resource owner denied the device authorization.",
+		MessageID:   "device_access_denied",
+	}
+
+	ErrDPoPProofRequired = types.AuthzError{
+		Code:        types.ErrorInvalidDPoPProof,
+		Description: "Client is required to send a DPoP proof.",
+		MessageID:   "dpop_proof_required",
+	}
+
+	ErrDPoPProofInvalid = types.AuthzError{
+		Code:        types.ErrorInvalidDPoPProof,
+		Description: "DPoP proof is malformed, expired, replayed or not meant for this request.",
+		MessageID:   "dpop_proof_invalid",
+	}
+
+	ErrRefreshTokenKeyMismatch = types.AuthzError{
+		Code:        types.ErrorInvalidGrant,
+		Description: "Refresh token is bound to another key.",
+		MessageID:   "refresh_token_key_mismatch",
 	}
 
 	ErrInvalidToken = types.AuthzError{
-		Code:        "invalid_token",
+		Code:        types.ErrorInvalidToken,
 		Description: "Access token expired or was revoked.",
 	}
 
 	ErrInsufficientScope = types.AuthzError{
-		Code:        "insufficient_scope",
+		Code:        types.ErrorInsufficientScope,
 		Description: "The request requires higher privileges than provided by the access token.",
 	}
 )
 
 // Encodes errors as query string values in accordance to http://tools.ietf.org/html/rfc6749#section-4.1.2.1
+// The given URL is updated in place, keeping its existing query parameters.
 func EncodeErrInURI(u *url.URL, err types.AuthzError) {
 	queryStr := u.Query()
+	for name, values := range errParams(err) {
+		queryStr[name] = values
+	}
+	u.RawQuery = queryStr.Encode()
+}
+
+// errParams returns the parameters of an error authorization response.
+func errParams(err types.AuthzError) url.Values {
+	queryStr := url.Values{}
 	queryStr.Set("error", err.Code)
 
 	if err.Description != "" {
@@ -106,13 +439,16 @@ func EncodeErrInURI(u *url.URL, err types.AuthzError) {
 		queryStr.Set("error_uri", err.URI)
 	}
 
-	u.RawQuery = queryStr.Encode()
+	if err.RequestID != "" {
+		queryStr.Set("request_id", err.RequestID)
+	}
+	return queryStr
 }
 
 // Errors returned to 3rd-party client apps in accordance to spec.
 func ErrUnsupportedResponseType(state string) types.AuthzError {
 	return types.AuthzError{
-		Code:        "unsupported_response_type",
+		Code:        types.ErrorUnsupportedResponseType,
 		Description: "Authorization server does not support obtaining an authorization code using this authorization flow.",
 		State:       state,
 	}
@@ -120,25 +456,72 @@ func ErrUnsupportedResponseType(state string) types.AuthzError {
 
 func ErrStateRequired(state string) types.AuthzError {
 	return types.AuthzError{
-		Code:        "invalid_request",
+		Code:        types.ErrorInvalidRequest,
 		Description: "state parameter is required by this authorization server.",
 		State:       state,
+		MessageID:   "state_required",
 	}
 }
 
 func ErrScopeRequired(state string) types.AuthzError {
 	return types.AuthzError{
-		Code:        "invalid_request",
+		Code:        types.ErrorInvalidRequest,
 		Description: "scope parameter is required by this authorization server.",
 		State:       state,
+		MessageID:   "scope_required",
+	}
+}
+
+func ErrExtensionParamInvalid(state string) types.AuthzError {
+	return types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "An extension parameter is invalid.",
+		State:       state,
+		MessageID:   "extension_param_invalid",
+	}
+}
+
+func ErrCodeChallengeRequired(state string) types.AuthzError {
+	return types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "code_challenge parameter is required by this authorization server.",
+		State:       state,
+		MessageID:   "code_challenge_required",
+	}
+}
+
+func ErrCodeChallengeInvalid(state string) types.AuthzError {
+	return types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "code_challenge is malformed or code_challenge_method is not supported.",
+		State:       state,
+		MessageID:   "code_challenge_invalid",
+	}
+}
+
+func ErrResponseModeUnsupported(state string) types.AuthzError {
+	return types.AuthzError{
+		Code:        types.ErrorInvalidRequest,
+		Description: "response_mode is not supported for this response_type.",
+		State:       state,
+		MessageID:   "response_mode_unsupported",
 	}
 }
 
 func ErrServerError(state string, err error) types.AuthzError {
-	log.Printf("[ERROR] Internal server error: %v", err)
+	log.Printf("[ERROR] Internal server error: %v", redactError(err))
+	return errServerError(state, err)
+}
+
+func errServerError(state string, err error) types.AuthzError {
+	if isUnavailable(err) {
+		e := ErrTemporarilyUnavailable
+		e.State = state
+		return e
+	}
 
 	return types.AuthzError{
-		Code: "server_error",
+		Code: types.ErrorServerError,
 		Description: `The authorization server encountered an unexpected condition that
 		prevented it from fulfilling the request.`,
 		State: state,
@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestErrorCodes tests that every error sent by this package uses a code from
+// the catalog.
+func TestErrorCodes(t *testing.T) {
+	errs := []types.AuthzError{
+		ErrRedirectURLMismatch, ErrRedirectURLInvalid, ErrRedirectURLNotAssociated,
+		ErrFormOriginNotAllowed, ErrClientIDMissing, ErrClientIDNotFound, ErrUnauthorizedClient, ErrClientPending,
+		ErrClientSuspended, ErrClientDeleted, ErrClientStatusTransition, ErrStatsDaysInvalid, ErrUnsupportedGrantType,
+		ErrInvalidGrant, ErrConsentDenied, ErrUnathorizedUser, ErrLoginRequired, ErrNotFound, ErrEndpointRetired,
+		ErrTemporarilyUnavailable, ErrReadOnly, ErrTooManyRequests, ErrInvalidTarget,
+		ErrInvalidScope, ErrClientIDMismatch, ErrMethodOverride, ErrGrantNonceInvalid, ErrUnsupportedTokenType,
+		ErrAccessTokenRequired, ErrPolicyDenied, ErrAuthzRequestInvalid,
+		ErrAuthzRequestExpired, ErrRequestObjectInvalid, ErrCredentialEventMalformed, ErrSimulationMalformed,
+		ErrLeakReportMalformed, ErrAuthzCodeRequired,
+		ErrGrantCodeUsed, ErrGrantRedirectURLMismatch, ErrCodeVerifierInvalid,
+		ErrGrantClientIDMismatch, ErrRefreshTokenRequired, ErrRefreshTokenInvalid,
+		ErrRefreshClientIDMismatch, ErrRefreshNotAllowed, ErrServiceAccountScope, ErrClientCredentialsScope, ErrPasswordGrantNotAllowed,
+		ErrSubjectTokenRequired, ErrTokenTypeUnsupported, ErrSubjectTokenInvalid, ErrTokenExchangeDenied,
+		ErrUpstreamStateInvalid, ErrUpstreamLoginFailed, ErrUserProvisioningDenied, ErrUserAccountConflict,
+		ErrUserCodeInvalid, ErrDeviceCodeRequired, ErrAuthorizationPending, ErrSlowDown,
+		ErrDeviceCodeExpired, ErrDeviceAccessDenied, ErrInvalidToken, ErrInsufficientScope,
+		ErrDPoPProofRequired, ErrDPoPProofInvalid, ErrRefreshTokenKeyMismatch,
+		ErrUnsupportedResponseType(""), ErrStateRequired(""), ErrScopeRequired(""),
+		ErrResponseModeUnsupported(""), ErrExtensionParamInvalid(""),
+		ErrCodeChallengeRequired(""), ErrCodeChallengeInvalid(""),
+		errServerError("", errors.New("boom")),
+	}
+
+	for _, e := range errs {
+		_, ok := types.ErrorCodes[e.Code]
+		assert(t, ok, "error code %q is not in the catalog", e.Code)
+	}
+}
+
+func TestNewAuthzError(t *testing.T) {
+	e := types.NewAuthzError(types.ErrorInvalidScope, "state-test", "")
+	equals(t, types.ErrorInvalidScope, e.Code)
+	equals(t, "state-test", e.State)
+	equals(t, types.ErrorCodes[types.ErrorInvalidScope].Description, e.Description)
+
+	e = types.NewAuthzError(types.ErrorInvalidScope, "", "Custom description.")
+	equals(t, "Custom description.", e.Description)
+
+	equals(t, http.StatusUnauthorized, types.ErrorStatus(types.ErrorInvalidToken))
+	equals(t, http.StatusBadRequest, types.ErrorStatus("custom_error"))
+}
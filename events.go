@@ -0,0 +1,122 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// EventRevocationProvider is an optional interface that providers can
+// implement in order to revoke everything a resource owner authorized when
+// their credentials change or their account is deactivated.
+type EventRevocationProvider interface {
+	// RevokeByEvent revokes all the sessions, authorization grants and refresh
+	// token families of event.UserID, along with the access tokens issued
+	// from them. It has to be atomic: either everything is revoked or an
+	// error is returned and nothing is.
+	RevokeByEvent(event types.CredentialEvent) error
+}
+
+// Errors returned when revoking by event.
+var (
+	ErrEventRevocationProviderRequired = errors.New("oauth2: provider does not implement oauth2.EventRevocationProvider")
+	ErrCredentialEventInvalid          = errors.New("oauth2: credential event requires a type and a user ID")
+)
+
+// RevokeByEvent revokes everything the affected resource owner authorized so
+// far. Host applications are expected to call it whenever a resource owner
+// changes their password or is deactivated. Self-contained access tokens can
+// not be revoked, they remain valid until they expire.
+func RevokeByEvent(provider Provider, event types.CredentialEvent) error {
+	return revokeByEvent(config{provider: provider}, event)
+}
+
+func revokeByEvent(cfg config, event types.CredentialEvent) error {
+	if event.Type == "" || event.UserID == "" {
+		return ErrCredentialEventInvalid
+	}
+
+	provider, ok := asEventRevocationProvider(cfg.provider)
+	if !ok {
+		return ErrEventRevocationProviderRequired
+	}
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = now(cfg)
+	}
+
+	if err := provider.RevokeByEvent(event); err != nil {
+		return err
+	}
+	publishRevocation(cfg, "")
+
+	log.Printf("[INFO] Revoked authorizations of user %s due to %s event", event.UserID, event.Type)
+	return nil
+}
+
+// EventsHandler returns an inbound hook for host applications to report
+// credential events over HTTP, in case they run in a different process. It
+// accepts POST requests with a JSON encoded types.CredentialEvent and replies
+// with 204 No Content once everything was revoked. For example:
+//
+//	POST /internal/oauth2/events
+//	Content-Type: application/json
+//
+//	{"type": "password_changed", "user_id": "4c2a6e"}
+//
+// It does not authenticate callers, it must only be reachable by the host
+// application. Options other than SetClock, SetMessages and SetRevocationBus
+// are ignored.
+func EventsHandler(provider Provider, opts ...option) http.Handler {
+	if provider == nil {
+		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
+	}
+
+	cfg := config{provider: provider}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = withRequestID(w, req)
+
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		var event types.CredentialEvent
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrCredentialEventMalformed),
+			})
+			return
+		}
+
+		err := revokeByEvent(cfg, event)
+		switch err {
+		case nil:
+			w.WriteHeader(http.StatusNoContent)
+		case ErrCredentialEventInvalid:
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrCredentialEventMalformed),
+			})
+		default:
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+		}
+	})
+}
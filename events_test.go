@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRevokeByEvent makes sure credential events revoke grants and token
+// families, either through the API or the inbound hook.
+func TestRevokeByEvent(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+
+	token, err := provider.GenToken(types.Grant{}, provider.Client, true, time.Duration(10)*time.Minute)
+	ok(t, err)
+
+	handler := EventsHandler(provider, SetClock(clock))
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/internal/events", bytes.NewBufferString(body))
+		ok(t, err)
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(`{"type": "password_changed"}`)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	e := types.AuthzError{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+	equals(t, "invalid_request", e.Code)
+	equals(t, 0, len(provider.Events))
+
+	w = post(`{"type": "password_changed", "user_id": "test_user"}`)
+	equals(t, http.StatusNoContent, w.Code)
+	equals(t, 1, len(provider.Events))
+	equals(t, types.EventPasswordChanged, provider.Events[0].Type)
+	equals(t, clock.now, provider.Events[0].OccurredAt)
+
+	_, found := provider.AccessTokens[token.Value]
+	equals(t, false, found)
+	_, found = provider.RefreshTokens[token.RefreshToken]
+	equals(t, false, found)
+
+	err = RevokeByEvent(provider, types.CredentialEvent{
+		Type:   types.EventUserDeactivated,
+		UserID: "test_user",
+	})
+	ok(t, err)
+	equals(t, 2, len(provider.Events))
+	assert(t, !provider.Events[1].OccurredAt.IsZero(), "event time should default to now")
+
+	err = RevokeByEvent(struct{ Provider }{provider}, types.CredentialEvent{
+		Type:   types.EventUserDeactivated,
+		UserID: "test_user",
+	})
+	equals(t, ErrEventRevocationProviderRequired, err)
+}
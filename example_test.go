@@ -46,6 +46,8 @@ func ExampleExamples_basic() {
 			 <input type="hidden" name="redirect_uri" value="{{.Client.RedirectURL}}"/>
 			 <input type="hidden" name="scope" value="{{.Scopes.Encode}}"/>
 			 <input type="hidden" name="state" value="{{.State}}"/>
+			 <input type="hidden" name="authz_request" value="{{.Request}}"/>
+			 {{with .Nonce}}<input type="hidden" name="form_nonce" value="{{.}}"/>{{end}}
 			</form>
 		{{end}}
 		</body>
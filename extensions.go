@@ -0,0 +1,75 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// ExtensionValidator validates the value of an extension parameter sent by a
+// client. Returning a *types.AuthzError sends it back to the client, any other
+// error is sent back as ErrExtensionParamInvalid.
+type ExtensionValidator func(client types.Client, value string) error
+
+// SetExtensionParam registers a parameter of authorization requests not
+// defined by the specs, such as "tenant" or a vendor-specific one. Once
+// validated, it is kept in the Extensions of the grant and of the tokens
+// issued with it, for providers to store and to enrich claims with, for
+// instance through IntrospectionClaimsProvider. Unregistered parameters are
+// dropped. The validator may be nil to accept any value.
+//
+// Grants with extensions require the provider to implement
+// BoundGrantProvider, and the authorization form to send them back, which
+// DefaultAuthzForm does:
+//
+//	{{range $name, $value := .Extensions}}
+//	<input type="hidden" name="{{$name}}" value="{{$value}}"/>
+//	{{end}}
+func SetExtensionParam(name string, validate ExtensionValidator) option {
+	return func(c *config) {
+		if isAuthzRequestVar(name) || authzFormVars[name] {
+			log.Fatalf("Extension parameter %q is already defined", name)
+		}
+
+		if c.extensionParams == nil {
+			c.extensionParams = make(map[string]ExtensionValidator)
+		}
+		c.extensionParams[name] = validate
+	}
+}
+
+// extensions returns the registered extension parameters of an authorization
+// request, once validated, or the error to send back to the client.
+func extensions(req *http.Request, cfg config, client types.Client, areq AuthorizationRequest) (map[string]string, *types.AuthzError) {
+	var exts map[string]string
+	for name, validate := range cfg.extensionParams {
+		value, ok := areq.Extra[name]
+		if !ok {
+			continue
+		}
+
+		if validate != nil {
+			if err := validate(client, value); err != nil {
+				e := ErrExtensionParamInvalid(areq.State)
+				if authzErr, ok := err.(*types.AuthzError); ok {
+					e = *authzErr
+					e.State = areq.State
+				} else {
+					log.Printf("[INFO] request_id=%s Client %s sent an invalid %s parameter: %v", RequestID(req), client.ID, name, err)
+				}
+				return nil, &e
+			}
+		}
+
+		if exts == nil {
+			exts = make(map[string]string)
+		}
+		exts[name] = value
+	}
+	return exts, nil
+}
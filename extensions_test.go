@@ -0,0 +1,80 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestExtensionParams tests that registered extension parameters are
+// validated and kept in grants, while others are dropped.
+func TestExtensionParams(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetExtensionParam("tenant", func(client types.Client, value string) error {
+		if value != "acme" {
+			return errors.New("unknown tenant")
+		}
+		return nil
+	})(&cfg)
+
+	approve := func(extra string) *httptest.ResponseRecorder {
+		body := authzRequest(t, cfg).URL.RawQuery + extra + "&" + ConsentParam + "=approve"
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusFound, w.Code)
+		return w
+	}
+
+	w := approve("&tenant=acme&vendor_param=1")
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	code := u.Query().Get("code")
+	assert(t, code != "", "expected a code: %s", u)
+
+	grant, err := grantInfo(cfg, code)
+	ok(t, err)
+	equals(t, map[string]string{"tenant": "acme"}, grant.Extensions)
+
+	w = approve("&tenant=evil")
+	u, err = url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "invalid_request", u.Query().Get("error"))
+	equals(t, "state-test", u.Query().Get("state"))
+	equals(t, "", u.Query().Get("code"))
+}
+
+// TestExtensionParamsForm tests that the authorization form sends extension
+// parameters back along with the approval.
+func TestExtensionParamsForm(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	SetAuthzForm(DefaultAuthzForm)(&cfg)
+	SetExtensionParam("tenant", nil)(&cfg)
+
+	req := authzRequest(t, cfg)
+	q := req.URL.Query()
+	q.Set("tenant", "acme")
+	req.URL.RawQuery = q.Encode()
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, bytes.Contains(w.Body.Bytes(), []byte(`name="tenant" value="acme"`)), "expected the tenant in the form: %s", w.Body)
+}
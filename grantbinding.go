@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// BoundGrantProvider is an optional interface that providers can implement in
+// order to store everything authorization codes are bound to. Providers not
+// implementing it are expected to record the client ID and redirect URL in
+// GenGrant, codes presented by a different client are rejected either way.
+type BoundGrantProvider interface {
+	// GenBoundGrant issues and stores an authorization grant code, like
+	// GenGrant does, keeping the client ID, redirect URL, requested
+	// redirect URI, scopes, PKCE code challenge and extensions of the given
+	// grant. The
+	// code is already set if SetCodeGenerator was used, providers generate
+	// it otherwise.
+	GenBoundGrant(grant types.Grant, expiration time.Duration) (types.Grant, error)
+}
+
+// ErrBoundGrantProviderRequired is returned when a grant has to be bound to a
+// PKCE code challenge, or its code generated by SetCodeGenerator, and the
+// provider does not implement BoundGrantProvider.
+var ErrBoundGrantProviderRequired = errors.New("oauth2: provider does not implement oauth2.BoundGrantProvider")
+
+// PKCE code challenge methods. http://tools.ietf.org/html/rfc7636#section-4.2
+const (
+	CodeChallengePlain = "plain"
+	CodeChallengeS256  = "S256"
+)
+
+// SetCodeGenerator sets how authorization codes are generated, instead of
+// leaving it to the provider. It requires the provider to implement
+// BoundGrantProvider. For example, for codes with 128 bits of entropy:
+//
+//	SetCodeGenerator(tokengen.Random{Bytes: 16})
+func SetCodeGenerator(g tokengen.Generator) option {
+	return func(c *config) {
+		c.codeGenerator = g
+	}
+}
+
+// genGrant issues an authorization code bound to the given grant's client,
+// redirect URL and code challenge.
+func genGrant(cfg config, client types.Client, grant types.Grant) (types.Grant, error) {
+	issued, err := issueGrant(cfg, client, grant)
+	if err != nil {
+		return issued, err
+	}
+	return issued, issued.Validate()
+}
+
+// issueGrant has the provider issue and store the grant.
+func issueGrant(cfg config, client types.Client, grant types.Grant) (types.Grant, error) {
+	if p, ok := asBoundGrantProvider(cfg.provider); ok {
+		if cfg.codeGenerator != nil {
+			code, err := cfg.codeGenerator.Generate()
+			if err != nil {
+				return types.Grant{}, err
+			}
+			grant.Code = code
+		}
+		return p.GenBoundGrant(grant, cfg.authzExpiration)
+	}
+
+	if cfg.codeGenerator != nil {
+		return types.Grant{}, ErrBoundGrantProviderRequired
+	}
+
+	if grant.CodeChallenge != "" || len(grant.Extensions) > 0 {
+		return types.Grant{}, ErrBoundGrantProviderRequired
+	}
+	return cfg.provider.GenGrant(client, grant.Scopes, cfg.authzExpiration)
+}
+
+// verifyGrant makes sure an authorization code is presented by the client it
+// was issued to, along with everything else it is bound to.
+func verifyGrant(req *http.Request, cfg config, grant types.Grant, client types.Client, treq TokenRequest) (types.AuthzError, bool) {
+	if grant.ClientID == "" || grant.ClientID != client.ID {
+		return localize(req, cfg, ErrGrantClientIDMismatch), false
+	}
+
+	if grant.RedirectURL == nil {
+		return localize(req, cfg, ErrGrantRedirectURLMismatch), false
+	}
+
+	if _, err := redirectPolicy(cfg).Match(client.RegisteredRedirectURLs(), grant.RedirectURL.String()); err != nil {
+		return localize(req, cfg, ErrGrantRedirectURLMismatch), false
+	}
+
+	// If the redirect_uri parameter was included in the authorization
+	// request, their values MUST be identical.
+	// -- http://tools.ietf.org/html/rfc6749#section-4.1.3
+	if grant.RequestedRedirectURI != "" && !sameRedirectURI(cfg, treq.RedirectURI, grant.RequestedRedirectURI) {
+		return localize(req, cfg, ErrGrantRedirectURLMismatch), false
+	}
+
+	if grant.CodeChallenge == "" {
+		return types.AuthzError{}, true
+	}
+
+	verifier := treq.CodeVerifier
+	if !validCodeVerifier(verifier) || !verifyCodeChallenge(grant.CodeChallenge, grant.CodeChallengeMethod, verifier) {
+		return localize(req, cfg, ErrCodeVerifierInvalid), false
+	}
+	return types.AuthzError{}, true
+}
+
+// verifyCodeChallenge implements http://tools.ietf.org/html/rfc7636#section-4.6
+func verifyCodeChallenge(challenge, method, verifier string) bool {
+	switch method {
+	case CodeChallengeS256:
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	case CodeChallengePlain, "":
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(verifier)) == 1
+}
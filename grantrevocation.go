@@ -0,0 +1,203 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// GrantRevocationProvider is an optional interface that providers can
+// implement in order to let resource owners revoke the authorization they
+// gave a client, from the grants page of the host application.
+type GrantRevocationProvider interface {
+	// RevokeGrants revokes the authorization grants a resource owner gave a
+	// client, along with the access and refresh tokens issued from them.
+	RevokeGrants(userID, clientID string) error
+}
+
+// GrantNonceParam is the parameter carrying the nonce of grant revocation
+// requests. See RevokeGrant.
+const GrantNonceParam = "nonce"
+
+// Maximum time a grant revocation nonce is valid for.
+const grantNonceMaxAge = time.Duration(10) * time.Minute
+
+// Key signing grant revocation nonces if no authorization request key is
+// set, only valid for this process.
+var grantNonceKey struct {
+	once sync.Once
+	key  []byte
+}
+
+// RevokeGrant revokes the authorization the authenticated resource owner
+// gave a client, addressed by its identifier:
+//
+//	DELETE /oauth2/grants?client_id=<client id>&nonce=<nonce>
+//
+// The nonce proves the request comes from the grants page, rather than from
+// a page forging it on behalf of the resource owner. It is returned as
+// "revocation_nonce" by GET /oauth2/grants?client_id=<client id>, bound to
+// the resource owner and the client, and expires after 10 minutes. Nonces
+// are signed with the key set by SetAuthzRequestKey, which is required for
+// them to be accepted by every instance of the authorization server.
+//
+// The consent of the resource owner is forgotten if the provider implements
+// ConsentProvider, and their grants and tokens revoked if it implements
+// GrantRevocationProvider. Clients revoke their own tokens through the token
+// endpoint instead.
+//
+// It responds with:
+//   - 204 No Content once the authorization is revoked.
+//   - 400 Bad Request if client_id is missing.
+//   - 401 Unauthorized if the resource owner is not authenticated.
+//   - 403 Forbidden if the nonce is missing, invalid or expired.
+//   - 404 Not Found if the provider can't revoke authorizations.
+func RevokeGrant(w http.ResponseWriter, req *http.Request, cfg config) {
+	provider := cfg.provider
+	yes, err := userAuthenticated(req, cfg)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
+	if !yes {
+		render.JSON(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   localize(req, cfg, ErrLoginRequired),
+		})
+		return
+	}
+
+	consents, canForget := asConsentProvider(provider)
+	grants, canRevoke := asGrantRevocationProvider(provider)
+	if !canForget && !canRevoke {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrNotFound),
+		})
+		return
+	}
+
+	clientID := req.URL.Query().Get("client_id")
+	if clientID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrClientIDMissing),
+		})
+		return
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if !verifyGrantNonce(cfg, user.ID, clientID, req.URL.Query().Get(GrantNonceParam)) {
+		render.JSON(w, render.Options{
+			Status: http.StatusForbidden,
+			Data:   localize(req, cfg, ErrGrantNonceInvalid),
+		})
+		return
+	}
+
+	var scope string
+	if canForget {
+		consent, err := consents.GetConsent(user.ID, clientID)
+		if err == nil {
+			err = consents.RevokeConsent(user.ID, clientID)
+		}
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+		scope = consent.Scopes.Encode()
+	}
+
+	if canRevoke {
+		if err := grants.RevokeGrants(user.ID, clientID); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+		publishRevocation(cfg, "")
+	}
+
+	log.Printf("[INFO] request_id=%s User %s revoked the authorization of client %s", RequestID(req), user.ID, clientID)
+
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditGrantRevoked,
+		ClientID: clientID,
+		UserID:   user.ID,
+		Details: map[string]string{
+			"scope": scope,
+		},
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// grantNonce returns a nonce allowing the resource owner to revoke the
+// authorization they gave a client.
+func grantNonce(cfg config, userID, clientID string) string {
+	exp := strconv.FormatInt(now(cfg).Add(grantNonceMaxAge).Unix(), 10)
+	return exp + "." + grantNonceMAC(cfg, userID, clientID, exp)
+}
+
+// verifyGrantNonce tells whether a nonce was issued to the resource owner
+// for the client, and has not expired yet.
+func verifyGrantNonce(cfg config, userID, clientID, nonce string) bool {
+	parts := strings.Split(nonce, ".")
+	if len(parts) != 2 {
+		return false
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(grantNonceMAC(cfg, userID, clientID, parts[0]))) {
+		return false
+	}
+
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	return err == nil && now(cfg).Before(time.Unix(exp, 0))
+}
+
+func grantNonceMAC(cfg config, userID, clientID, exp string) string {
+	key := cfg.authzRequestKey
+	if key == nil {
+		grantNonceKey.once.Do(func() {
+			grantNonceKey.key = make([]byte, 32)
+			if _, err := rand.Read(grantNonceKey.key); err != nil {
+				log.Fatalf("Error generating grant revocation nonce key: %v", err)
+			}
+		})
+		key = grantNonceKey.key
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("grant-revocation\x00" + userID + "\x00" + clientID + "\x00" + exp))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
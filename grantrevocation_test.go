@@ -0,0 +1,115 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRevokeGrant tests that resource owners revoke the authorization they
+// gave a client with the nonce returned along with their consent, and that
+// requests without a valid nonce are rejected.
+func TestRevokeGrant(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	clock := &fakeClock{now: time.Now()}
+	SetClock(clock)(&cfg)
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+
+	clientID := provider.Client.ID
+	provider.Consents["test_user:"+clientID] = types.Consent{
+		UserID:   "test_user",
+		ClientID: clientID,
+		Scopes:   types.Scopes{{ID: "read"}},
+	}
+	provider.AccessTokens["access"] = types.Token{Value: "access", ClientID: clientID, UserID: "test_user"}
+	provider.AccessTokens["other"] = types.Token{Value: "other", ClientID: clientID, UserID: "other_user"}
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/grants?client_id="+clientID, nil)
+	ok(t, err)
+	w := httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	var consent struct {
+		RevocationNonce string `json:"revocation_nonce"`
+	}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &consent))
+	assert(t, consent.RevocationNonce != "", "expected a revocation nonce")
+
+	revoke := func(clientID, nonce string) int {
+		q := url.Values{"client_id": {clientID}, GrantNonceParam: {nonce}}
+		req, err := http.NewRequest("DELETE", "https://example.com/oauth2/grants?"+q.Encode(), nil)
+		ok(t, err)
+		w := httptest.NewRecorder()
+		RevokeGrant(w, req, cfg)
+		return w.Code
+	}
+
+	equals(t, http.StatusBadRequest, revoke("", consent.RevocationNonce))
+	equals(t, http.StatusForbidden, revoke(clientID, ""))
+	equals(t, http.StatusForbidden, revoke("other_client", consent.RevocationNonce))
+	equals(t, http.StatusForbidden, revoke(clientID, consent.RevocationNonce+"x"))
+	equals(t, 1, len(provider.Consents))
+
+	equals(t, http.StatusNoContent, revoke(clientID, consent.RevocationNonce))
+	equals(t, 0, len(provider.Consents))
+	equals(t, types.TokenRevoked, provider.AccessTokens["access"].Status)
+	equals(t, types.TokenStatus(""), provider.AccessTokens["other"].Status)
+
+	equals(t, 1, len(*events))
+	equals(t, types.AuditGrantRevoked, (*events)[0].Type)
+	equals(t, "read", (*events)[0].Details["scope"])
+
+	// Nonces expire.
+	clock.Advance(grantNonceMaxAge)
+	equals(t, http.StatusForbidden, revoke(clientID, consent.RevocationNonce))
+
+	// Resource owners have to be authenticated.
+	cfg.provider = test.NewProvider(false)
+	equals(t, http.StatusUnauthorized, revoke(clientID, consent.RevocationNonce))
+}
+
+// TestRevokeGrantCodeFlow tests that revoking a grant revokes the tokens
+// issued through the authorization code flow, which are issued to the
+// resource owner who approved it.
+func TestRevokeGrantCodeFlow(t *testing.T) {
+	cfg, authzCode := getTestAuthzCode(t)
+	provider := cfg.provider.(*test.Provider)
+
+	req := AuthzGrantTokenRequestTest(t, "authorization_code", authzCode)
+	req.SetBasicAuth("testclient", "testclient")
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, "test_user", provider.AccessTokens[token.Value].UserID)
+
+	clientID := provider.Client.ID
+	provider.Consents["test_user:"+clientID] = types.Consent{
+		UserID:   "test_user",
+		ClientID: clientID,
+		Scopes:   types.Scopes{{ID: "read"}},
+	}
+	q := url.Values{"client_id": {clientID}, GrantNonceParam: {grantNonce(cfg, "test_user", clientID)}}
+	req, err := http.NewRequest("DELETE", "https://example.com/oauth2/grants?"+q.Encode(), nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	RevokeGrant(w, req, cfg)
+	equals(t, http.StatusNoContent, w.Code)
+	equals(t, types.TokenRevoked, provider.AccessTokens[token.Value].Status)
+}
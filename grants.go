@@ -0,0 +1,144 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// GrantsHandlers is a map to functions where each function handles a particular HTTP
+// verb or method of the self-service grants API.
+var GrantsHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET":    ListGrants,
+	"DELETE": RevokeGrant,
+}
+
+// ListGrants returns the consent receipts of the authenticated resource owner.
+// A single receipt is returned if its identifier is given as the last segment
+// of the path. For example: GET /oauth2/grants/<receipt id>
+//
+// If the provider implements ConsentProvider, the scopes approved so far for
+// a client are returned instead when its identifier is given, along with the
+// nonce to revoke them. See RevokeGrant.
+// For example: GET /oauth2/grants?client_id=<client id>
+//
+// If the provider implements UsageProvider, the usage of the resource owner's
+// access tokens is returned by GET /oauth2/grants/usage. See SetUsageTracking.
+func ListGrants(w http.ResponseWriter, req *http.Request, cfg config) {
+	provider := cfg.provider
+	yes, err := userAuthenticated(req, cfg)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
+	if !yes {
+		render.JSON(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   localize(req, cfg, ErrLoginRequired),
+		})
+		return
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if clientID := req.URL.Query().Get("client_id"); clientID != "" {
+		listConsent(w, req, cfg, user.ID, clientID)
+		return
+	}
+
+	if path.Base(req.URL.Path) == "usage" {
+		listUsage(w, req, cfg, user.ID)
+		return
+	}
+
+	receipts := []types.ConsentReceipt{}
+	if p, ok := asConsentReceiptProvider(provider); ok {
+		receipts, err = p.ConsentReceipts(user.ID)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+	}
+
+	if strings.TrimSuffix(req.URL.Path, "/") == cfg.grantsEndpoint {
+		render.JSON(w, render.Options{
+			Status: http.StatusOK,
+			Data:   receipts,
+		})
+		return
+	}
+
+	id := path.Base(req.URL.Path)
+	for _, r := range receipts {
+		if r.ID == id {
+			render.JSON(w, render.Options{
+				Status: http.StatusOK,
+				Data:   r,
+			})
+			return
+		}
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusNotFound,
+		Data:   localize(req, cfg, ErrNotFound),
+	})
+}
+
+// listConsent returns the consent of the resource owner for a client.
+func listConsent(w http.ResponseWriter, req *http.Request, cfg config, userID, clientID string) {
+	provider, ok := asConsentProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrNotFound),
+		})
+		return
+	}
+
+	consent, err := provider.GetConsent(userID, clientID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if consent.UserID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrNotFound),
+		})
+		return
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data: struct {
+			types.Consent
+			RevocationNonce string `json:"revocation_nonce"`
+		}{consent, grantNonce(cfg, userID, clientID)},
+	})
+}
@@ -0,0 +1,53 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import "log"
+
+// grantTypes are the flows supported by this package, by grant type. The
+// implicit grant stands for the "token" response type, and the
+// authorization code grant for the "code" one.
+var grantTypes = map[string]bool{
+	"authorization_code":   true,
+	"implicit":             true,
+	"password":             true,
+	"client_credentials":   true,
+	"refresh_token":        true,
+	JWTBearerGrantType:     true,
+	DeviceCodeGrantType:    true,
+	TokenExchangeGrantType: true,
+}
+
+// SetAllowedGrantTypes only enables the given grant types, such as
+// "authorization_code", "client_credentials" and "refresh_token". Requests
+// for other grant types are answered with unsupported_grant_type, and
+// authorization requests for other response types with
+// unsupported_response_type, the "code" response type being enabled by
+// "authorization_code" and the "token" one by "implicit". Disabled grant
+// types are left out of the authorization server metadata, device
+// authorization endpoints are only served if the device code grant is
+// enabled, and tokens come without refresh token if the refresh token grant
+// is disabled. Defaults to every grant type supported. Unknown grant types
+// are fatal.
+func SetAllowedGrantTypes(grants ...string) option {
+	return func(c *config) {
+		allowed := make(map[string]bool, len(grants))
+		for _, t := range grants {
+			if !grantTypes[t] {
+				log.Fatalf("Unknown grant type %s", t)
+			}
+			allowed[t] = true
+		}
+		c.allowedGrantTypes = allowed
+	}
+}
+
+// grantTypeAllowed tells whether the grant type is enabled.
+func grantTypeAllowed(cfg config, grantType string) bool {
+	if cfg.oauth21 && (grantType == "implicit" || grantType == "password") {
+		return false
+	}
+	return cfg.allowedGrantTypes == nil || cfg.allowedGrantTypes[grantType]
+}
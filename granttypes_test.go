@@ -0,0 +1,83 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestAllowedGrantTypes tests that requests for disabled grant and response
+// types are rejected as unsupported, and that they are not advertised.
+func TestAllowedGrantTypes(t *testing.T) {
+	provider := test.NewProvider(true)
+	s := Handler(nil,
+		SetProvider(provider),
+		SetTokenExpiration(time.Hour),
+		SetAllowedGrantTypes("authorization_code", "client_credentials"),
+	)
+
+	token := func(grantType string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"grant_type": {grantType},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+
+	w := token("password")
+	equals(t, http.StatusBadRequest, w.Code)
+	var e types.AuthzError
+	ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+	equals(t, types.ErrorUnsupportedGrantType, e.Code)
+
+	w = token("client_credentials")
+	equals(t, http.StatusOK, w.Code)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"token"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"state":         {"state"},
+		"scope":         {"read"},
+	}
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	equals(t, http.StatusFound, w.Code)
+	assert(t, strings.Contains(w.Header().Get("Location"), "error="+types.ErrorUnsupportedResponseType), "unexpected redirect %s", w.Header().Get("Location"))
+
+	req, err = http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	var metadata serverMetadata
+	ok(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+	equals(t, []string{"authorization_code", "client_credentials"}, metadata.GrantTypesSupported)
+	equals(t, []string{"code"}, metadata.ResponseTypesSupported)
+
+	// Tokens come without refresh token.
+	_, refreshable := tokenPolicy(s.current.Load().(*snapshot).cfg, nil)
+	equals(t, false, refreshable)
+}
@@ -0,0 +1,299 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Errors returned by the provider guard.
+var (
+	ErrProviderTimeout     = errors.New("oauth2: provider call timed out")
+	ErrProviderUnavailable = errors.New("oauth2: provider is unavailable, circuit breaker is open")
+)
+
+// SetProviderTimeout limits how long every Provider call can take, including
+// calls to the optional interfaces it implements, such as ConsentProvider.
+// Calls taking longer fail with ErrProviderTimeout and clients get
+// a temporarily_unavailable error right away, instead of piling up
+// requests behind a hung storage backend.
+func SetProviderTimeout(timeout time.Duration) option {
+	return func(c *config) {
+		c.guard.timeout = timeout
+	}
+}
+
+// SetCircuitBreaker stops calling the Provider for the given cooldown after
+// the given number of consecutive calls timed out. In the meantime, calls fail
+// with ErrProviderUnavailable. Once the cooldown is over, calls are let
+// through again: a call finishing in time closes the circuit and a timeout
+// opens it right away. It requires SetProviderTimeout.
+//
+// Errors returned by the Provider itself do not count, as they are also used
+// to report invalid credentials, unknown codes and such.
+func SetCircuitBreaker(failures int, cooldown time.Duration) option {
+	return func(c *config) {
+		c.guard.failures = failures
+		c.guard.cooldown = cooldown
+	}
+}
+
+// guardConfig defines how Provider calls are guarded.
+type guardConfig struct {
+	timeout  time.Duration
+	failures int
+	cooldown time.Duration
+}
+
+// guardedProvider decorates a Provider with timeouts and a circuit breaker.
+// Optional provider interfaces are not exposed by it, their accessors in
+// guardedproviders.go guard them the same way.
+type guardedProvider struct {
+	Provider
+	cfg guardConfig
+	now func() time.Time
+
+	mu        sync.Mutex
+	failed    int
+	openUntil time.Time
+}
+
+// guard decorates the given provider if timeouts or a circuit breaker are configured.
+func guard(p Provider, cfg config) Provider {
+	if cfg.guard.timeout <= 0 {
+		return p
+	}
+
+	return &guardedProvider{
+		Provider: p,
+		cfg:      cfg.guard,
+		now: func() time.Time {
+			return now(cfg)
+		},
+	}
+}
+
+// unwrap returns the provider implemented by users of this package.
+func unwrap(p Provider) Provider {
+	if g, ok := p.(*guardedProvider); ok {
+		return g.Provider
+	}
+	return p
+}
+
+// isUnavailable tells whether an error was produced by the guard.
+func isUnavailable(err error) bool {
+	return err == ErrProviderTimeout || err == ErrProviderUnavailable
+}
+
+// checkUser runs fn, a provider call telling whether a resource owner is
+// authenticated, guarded as the given provider is. Errors of the guard are
+// returned rather than false, so an outage is not taken for invalid
+// credentials or a missing session.
+func checkUser(p Provider, fn func() bool) (bool, error) {
+	g, ok := p.(*guardedProvider)
+	if !ok {
+		return fn(), nil
+	}
+
+	var yes bool
+	err := g.call(func() error {
+		yes = fn()
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return yes, nil
+}
+
+// call runs fn enforcing the configured timeout and circuit breaker.
+func (g *guardedProvider) call(fn func() error) error {
+	if g.cfg.failures > 0 {
+		g.mu.Lock()
+		open := g.now().Before(g.openUntil)
+		g.mu.Unlock()
+
+		if open {
+			return ErrProviderUnavailable
+		}
+	}
+
+	// Buffered, so the goroutine can finish even if nobody is listening anymore.
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(g.cfg.timeout):
+		err = ErrProviderTimeout
+	}
+
+	g.record(err)
+	return err
+}
+
+// record keeps track of consecutive timeouts to decide when to open the circuit.
+func (g *guardedProvider) record(err error) {
+	if g.cfg.failures <= 0 {
+		return
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err != ErrProviderTimeout {
+		g.failed = 0
+		return
+	}
+
+	g.failed++
+	if g.failed >= g.cfg.failures {
+		g.openUntil = g.now().Add(g.cfg.cooldown)
+	}
+}
+
+// Results are only read after the call succeeds, when the goroutine running
+// it already finished writing them.
+
+func (g *guardedProvider) AuthenticateClient(username, password string) (types.Client, error) {
+	var client types.Client
+	err := g.call(func() (err error) {
+		client, err = g.Provider.AuthenticateClient(username, password)
+		return err
+	})
+	if err != nil {
+		return types.Client{}, err
+	}
+	return client, nil
+}
+
+func (g *guardedProvider) AuthenticateUser(username, password string) bool {
+	var valid bool
+	err := g.call(func() error {
+		valid = g.Provider.AuthenticateUser(username, password)
+		return nil
+	})
+	return err == nil && valid
+}
+
+func (g *guardedProvider) ClientInfo(clientID string) (types.Client, error) {
+	var client types.Client
+	err := g.call(func() (err error) {
+		client, err = g.Provider.ClientInfo(clientID)
+		return err
+	})
+	if err != nil {
+		return types.Client{}, err
+	}
+	return client, nil
+}
+
+func (g *guardedProvider) GrantInfo(code string) (types.Grant, error) {
+	var grant types.Grant
+	err := g.call(func() (err error) {
+		grant, err = g.Provider.GrantInfo(code)
+		return err
+	})
+	if err != nil {
+		return types.Grant{}, err
+	}
+	return grant, nil
+}
+
+func (g *guardedProvider) TokenInfo(token string) (types.Token, error) {
+	var info types.Token
+	err := g.call(func() (err error) {
+		info, err = g.Provider.TokenInfo(token)
+		return err
+	})
+	if err != nil {
+		return types.Token{}, err
+	}
+	return info, nil
+}
+
+func (g *guardedProvider) ScopesInfo(scopes string) (types.Scopes, error) {
+	var info types.Scopes
+	err := g.call(func() (err error) {
+		info, err = g.Provider.ScopesInfo(scopes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (g *guardedProvider) ResourceScopes(u *url.URL) (types.Scopes, error) {
+	var scopes types.Scopes
+	err := g.call(func() (err error) {
+		scopes, err = g.Provider.ResourceScopes(u)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scopes, nil
+}
+
+func (g *guardedProvider) GenGrant(client types.Client, scopes types.Scopes, expiration time.Duration) (types.Grant, error) {
+	var grant types.Grant
+	err := g.call(func() (err error) {
+		grant, err = g.Provider.GenGrant(client, scopes, expiration)
+		return err
+	})
+	if err != nil {
+		return types.Grant{}, err
+	}
+	return grant, nil
+}
+
+func (g *guardedProvider) GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	var token types.Token
+	err := g.call(func() (err error) {
+		token, err = g.Provider.GenToken(grant, client, refreshToken, expiration)
+		return err
+	})
+	if err != nil {
+		return types.Token{}, err
+	}
+	return token, nil
+}
+
+func (g *guardedProvider) RevokeToken(token string) error {
+	return g.call(func() error {
+		return g.Provider.RevokeToken(token)
+	})
+}
+
+func (g *guardedProvider) RefreshToken(refreshToken types.Token, scopes types.Scopes) (types.Token, error) {
+	var token types.Token
+	err := g.call(func() (err error) {
+		token, err = g.Provider.RefreshToken(refreshToken, scopes)
+		return err
+	})
+	if err != nil {
+		return types.Token{}, err
+	}
+	return token, nil
+}
+
+func (g *guardedProvider) IsUserAuthenticated() bool {
+	var authenticated bool
+	err := g.call(func() error {
+		authenticated = g.Provider.IsUserAuthenticated()
+		return nil
+	})
+	return err == nil && authenticated
+}
@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// slowProvider takes as long as told to authenticate clients.
+type slowProvider struct {
+	*test.Provider
+	delay int64
+	calls int64
+}
+
+func (p *slowProvider) AuthenticateClient(username, password string) (types.Client, error) {
+	atomic.AddInt64(&p.calls, 1)
+	time.Sleep(time.Duration(atomic.LoadInt64(&p.delay)))
+	return p.Provider.AuthenticateClient(username, password)
+}
+
+func TestProviderGuard(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	provider := &slowProvider{
+		Provider: test.NewProvider(true),
+		delay:    int64(time.Duration(100) * time.Millisecond),
+	}
+
+	cfg := setupTest()
+	cfg.clock = clock
+	SetProviderTimeout(time.Duration(10) * time.Millisecond)(&cfg)
+	SetCircuitBreaker(2, time.Duration(1)*time.Minute)(&cfg)
+	cfg.provider = guard(provider, cfg)
+
+	issueToken := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		w := issueToken()
+		equals(t, http.StatusServiceUnavailable, w.Code)
+
+		authzErr := types.AuthzError{}
+		err := json.Unmarshal(w.Body.Bytes(), &authzErr)
+		ok(t, err)
+		equals(t, "temporarily_unavailable", authzErr.Code)
+	}
+
+	// The third request did not reach the provider, the circuit was open.
+	equals(t, int64(2), atomic.LoadInt64(&provider.calls))
+
+	atomic.StoreInt64(&provider.delay, 0)
+	clock.Advance(time.Duration(1) * time.Minute)
+
+	w := issueToken()
+	equals(t, http.StatusOK, w.Code)
+	equals(t, int64(3), atomic.LoadInt64(&provider.calls))
+}
+
+// hungProvider never answers whether resource owners are authenticated, nor
+// issues bound authorization codes, until released.
+type hungProvider struct {
+	*test.Provider
+	release chan struct{}
+}
+
+func (p *hungProvider) IsUserAuthenticated() bool {
+	<-p.release
+	return p.Provider.IsUserAuthenticated()
+}
+
+func (p *hungProvider) AuthenticateUser(username, password string) bool {
+	<-p.release
+	return p.Provider.AuthenticateUser(username, password)
+}
+
+func (p *hungProvider) GenBoundGrant(grant types.Grant, expiration time.Duration) (types.Grant, error) {
+	<-p.release
+	return p.Provider.GenBoundGrant(grant, expiration)
+}
+
+// TestProviderGuardOptional tests that optional provider interfaces are
+// guarded too, and that a hung provider is reported as unavailable rather
+// than as an unauthenticated resource owner or invalid credentials.
+func TestProviderGuardOptional(t *testing.T) {
+	provider := &hungProvider{Provider: test.NewProvider(true), release: make(chan struct{})}
+	defer close(provider.release)
+
+	cfg := setupTest()
+	SetProviderTimeout(time.Duration(10) * time.Millisecond)(&cfg)
+	cfg.provider = guard(provider, cfg)
+
+	p, found := asBoundGrantProvider(cfg.provider)
+	equals(t, true, found)
+	_, err := p.GenBoundGrant(types.Grant{ClientID: provider.Client.ID}, time.Minute)
+	equals(t, ErrProviderTimeout, err)
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?client_id="+provider.Client.ID, nil)
+	ok(t, err)
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusServiceUnavailable, w.Code)
+	equals(t, "", w.Header().Get("Location"))
+
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/tokens",
+		bytes.NewBufferString("grant_type=password&username=test&password=test_password&scope=read"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusServiceUnavailable, w.Code)
+
+	authzErr := types.AuthzError{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+	equals(t, types.ErrorTemporarilyUnavailable, authzErr.Code)
+}
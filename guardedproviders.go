@@ -0,0 +1,707 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Optional provider interfaces are looked up with the accessors below, rather
+// than with unwrap, so their calls are guarded by the timeout and circuit
+// breaker of the Provider as well.
+
+// asBoundGrantProvider returns the BoundGrantProvider implemented by the provider, if any.
+func asBoundGrantProvider(p Provider) (BoundGrantProvider, bool) {
+	impl, ok := unwrap(p).(BoundGrantProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedBoundGrantProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedBoundGrantProvider struct {
+	BoundGrantProvider
+	g *guardedProvider
+}
+
+func (p guardedBoundGrantProvider) GenBoundGrant(grant types.Grant, expiration time.Duration) (types.Grant, error) {
+	var bound types.Grant
+	err := p.g.call(func() (err error) {
+		bound, err = p.BoundGrantProvider.GenBoundGrant(grant, expiration)
+		return err
+	})
+	if err != nil {
+		return types.Grant{}, err
+	}
+	return bound, nil
+}
+
+// asExpiringRefreshProvider returns the ExpiringRefreshProvider implemented by the provider, if any.
+func asExpiringRefreshProvider(p Provider) (ExpiringRefreshProvider, bool) {
+	impl, ok := unwrap(p).(ExpiringRefreshProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedExpiringRefreshProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedExpiringRefreshProvider struct {
+	ExpiringRefreshProvider
+	g *guardedProvider
+}
+
+func (p guardedExpiringRefreshProvider) RefreshTokenWithExpiration(refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	var token types.Token
+	err := p.g.call(func() (err error) {
+		token, err = p.ExpiringRefreshProvider.RefreshTokenWithExpiration(refreshToken, scopes, expiration)
+		return err
+	})
+	if err != nil {
+		return types.Token{}, err
+	}
+	return token, nil
+}
+
+// asUserProvider returns the UserProvider implemented by the provider, if any.
+func asUserProvider(p Provider) (UserProvider, bool) {
+	impl, ok := unwrap(p).(UserProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedUserProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedUserProvider struct {
+	UserProvider
+	g *guardedProvider
+}
+
+func (p guardedUserProvider) CurrentUser(req *http.Request) (types.User, error) {
+	var user types.User
+	err := p.g.call(func() (err error) {
+		user, err = p.UserProvider.CurrentUser(req)
+		return err
+	})
+	if err != nil {
+		return types.User{}, err
+	}
+	return user, nil
+}
+
+// asConsentProvider returns the ConsentProvider implemented by the provider, if any.
+func asConsentProvider(p Provider) (ConsentProvider, bool) {
+	impl, ok := unwrap(p).(ConsentProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedConsentProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedConsentProvider struct {
+	ConsentProvider
+	g *guardedProvider
+}
+
+func (p guardedConsentProvider) SaveConsent(consent types.Consent) error {
+	return p.g.call(func() error {
+		return p.ConsentProvider.SaveConsent(consent)
+	})
+}
+
+func (p guardedConsentProvider) GetConsent(userID, clientID string) (types.Consent, error) {
+	var consent types.Consent
+	err := p.g.call(func() (err error) {
+		consent, err = p.ConsentProvider.GetConsent(userID, clientID)
+		return err
+	})
+	if err != nil {
+		return types.Consent{}, err
+	}
+	return consent, nil
+}
+
+func (p guardedConsentProvider) RevokeConsent(userID, clientID string) error {
+	return p.g.call(func() error {
+		return p.ConsentProvider.RevokeConsent(userID, clientID)
+	})
+}
+
+// asConsentReceiptProvider returns the ConsentReceiptProvider implemented by the provider, if any.
+func asConsentReceiptProvider(p Provider) (ConsentReceiptProvider, bool) {
+	impl, ok := unwrap(p).(ConsentReceiptProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedConsentReceiptProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedConsentReceiptProvider struct {
+	ConsentReceiptProvider
+	g *guardedProvider
+}
+
+func (p guardedConsentReceiptProvider) SaveConsentReceipt(receipt types.ConsentReceipt) error {
+	return p.g.call(func() error {
+		return p.ConsentReceiptProvider.SaveConsentReceipt(receipt)
+	})
+}
+
+func (p guardedConsentReceiptProvider) ConsentReceipts(userID string) ([]types.ConsentReceipt, error) {
+	var receipts []types.ConsentReceipt
+	err := p.g.call(func() (err error) {
+		receipts, err = p.ConsentReceiptProvider.ConsentReceipts(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// asDeviceCodeProvider returns the DeviceCodeProvider implemented by the provider, if any.
+func asDeviceCodeProvider(p Provider) (DeviceCodeProvider, bool) {
+	impl, ok := unwrap(p).(DeviceCodeProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedDeviceCodeProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedDeviceCodeProvider struct {
+	DeviceCodeProvider
+	g *guardedProvider
+}
+
+func (p guardedDeviceCodeProvider) SaveDeviceAuthorization(authz types.DeviceAuthorization) error {
+	return p.g.call(func() error {
+		return p.DeviceCodeProvider.SaveDeviceAuthorization(authz)
+	})
+}
+
+func (p guardedDeviceCodeProvider) DeviceAuthorization(deviceCode string) (types.DeviceAuthorization, error) {
+	var authz types.DeviceAuthorization
+	err := p.g.call(func() (err error) {
+		authz, err = p.DeviceCodeProvider.DeviceAuthorization(deviceCode)
+		return err
+	})
+	if err != nil {
+		return types.DeviceAuthorization{}, err
+	}
+	return authz, nil
+}
+
+func (p guardedDeviceCodeProvider) DeviceAuthorizationByUserCode(userCode string) (types.DeviceAuthorization, error) {
+	var authz types.DeviceAuthorization
+	err := p.g.call(func() (err error) {
+		authz, err = p.DeviceCodeProvider.DeviceAuthorizationByUserCode(userCode)
+		return err
+	})
+	if err != nil {
+		return types.DeviceAuthorization{}, err
+	}
+	return authz, nil
+}
+
+func (p guardedDeviceCodeProvider) DeleteDeviceAuthorization(deviceCode string) error {
+	return p.g.call(func() error {
+		return p.DeviceCodeProvider.DeleteDeviceAuthorization(deviceCode)
+	})
+}
+
+// asTokenQuotaProvider returns the TokenQuotaProvider implemented by the provider, if any.
+func asTokenQuotaProvider(p Provider) (TokenQuotaProvider, bool) {
+	impl, ok := unwrap(p).(TokenQuotaProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedTokenQuotaProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedTokenQuotaProvider struct {
+	TokenQuotaProvider
+	g *guardedProvider
+}
+
+func (p guardedTokenQuotaProvider) ActiveRefreshTokens(userID, clientID string) ([]types.Token, error) {
+	var tokens []types.Token
+	err := p.g.call(func() (err error) {
+		tokens, err = p.TokenQuotaProvider.ActiveRefreshTokens(userID, clientID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// asUsageProvider returns the UsageProvider implemented by the provider, if any.
+func asUsageProvider(p Provider) (UsageProvider, bool) {
+	impl, ok := unwrap(p).(UsageProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedUsageProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedUsageProvider struct {
+	UsageProvider
+	g *guardedProvider
+}
+
+func (p guardedUsageProvider) RecordUsage(usage []types.TokenUsage) error {
+	return p.g.call(func() error {
+		return p.UsageProvider.RecordUsage(usage)
+	})
+}
+
+func (p guardedUsageProvider) TokenUsage(tokenID string) (types.TokenUsage, error) {
+	var usage types.TokenUsage
+	err := p.g.call(func() (err error) {
+		usage, err = p.UsageProvider.TokenUsage(tokenID)
+		return err
+	})
+	if err != nil {
+		return types.TokenUsage{}, err
+	}
+	return usage, nil
+}
+
+func (p guardedUsageProvider) UserTokenUsage(userID string) ([]types.TokenUsage, error) {
+	var usage []types.TokenUsage
+	err := p.g.call(func() (err error) {
+		usage, err = p.UsageProvider.UserTokenUsage(userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// asResourceOwnerAuthenticator returns the ResourceOwnerAuthenticator implemented by the provider, if any.
+func asResourceOwnerAuthenticator(p Provider) (ResourceOwnerAuthenticator, bool) {
+	impl, ok := unwrap(p).(ResourceOwnerAuthenticator)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedResourceOwnerAuthenticator{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedResourceOwnerAuthenticator struct {
+	ResourceOwnerAuthenticator
+	g *guardedProvider
+}
+
+func (p guardedResourceOwnerAuthenticator) AuthenticateResourceOwner(username, password string) (types.User, error) {
+	var user types.User
+	err := p.g.call(func() (err error) {
+		user, err = p.ResourceOwnerAuthenticator.AuthenticateResourceOwner(username, password)
+		return err
+	})
+	if err != nil {
+		return types.User{}, err
+	}
+	return user, nil
+}
+
+// asTokenFamilyProvider returns the TokenFamilyProvider implemented by the provider, if any.
+func asTokenFamilyProvider(p Provider) (TokenFamilyProvider, bool) {
+	impl, ok := unwrap(p).(TokenFamilyProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedTokenFamilyProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedTokenFamilyProvider struct {
+	TokenFamilyProvider
+	g *guardedProvider
+}
+
+func (p guardedTokenFamilyProvider) TokenFamily(familyID string) (types.TokenFamily, error) {
+	var family types.TokenFamily
+	err := p.g.call(func() (err error) {
+		family, err = p.TokenFamilyProvider.TokenFamily(familyID)
+		return err
+	})
+	if err != nil {
+		return types.TokenFamily{}, err
+	}
+	return family, nil
+}
+
+// asTokenFamilyRevoker returns the TokenFamilyRevoker implemented by the provider, if any.
+func asTokenFamilyRevoker(p Provider) (TokenFamilyRevoker, bool) {
+	impl, ok := unwrap(p).(TokenFamilyRevoker)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedTokenFamilyRevoker{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedTokenFamilyRevoker struct {
+	TokenFamilyRevoker
+	g *guardedProvider
+}
+
+func (p guardedTokenFamilyRevoker) RevokeTokenFamily(familyID string) error {
+	return p.g.call(func() error {
+		return p.TokenFamilyRevoker.RevokeTokenFamily(familyID)
+	})
+}
+
+// asIntrospectionClaimsProvider returns the IntrospectionClaimsProvider implemented by the provider, if any.
+func asIntrospectionClaimsProvider(p Provider) (IntrospectionClaimsProvider, bool) {
+	impl, ok := unwrap(p).(IntrospectionClaimsProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedIntrospectionClaimsProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedIntrospectionClaimsProvider struct {
+	IntrospectionClaimsProvider
+	g *guardedProvider
+}
+
+func (p guardedIntrospectionClaimsProvider) IntrospectionClaims(token types.Token) (map[string]interface{}, error) {
+	var claims map[string]interface{}
+	err := p.g.call(func() (err error) {
+		claims, err = p.IntrospectionClaimsProvider.IntrospectionClaims(token)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// asDeviceProvider returns the DeviceProvider implemented by the provider, if any.
+func asDeviceProvider(p Provider) (DeviceProvider, bool) {
+	impl, ok := unwrap(p).(DeviceProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedDeviceProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedDeviceProvider struct {
+	DeviceProvider
+	g *guardedProvider
+}
+
+func (p guardedDeviceProvider) SeenDevice(userID, deviceID string) (bool, error) {
+	var seen bool
+	err := p.g.call(func() (err error) {
+		seen, err = p.DeviceProvider.SeenDevice(userID, deviceID)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return seen, nil
+}
+
+// asTokenExchangeProvider returns the TokenExchangeProvider implemented by the provider, if any.
+func asTokenExchangeProvider(p Provider) (TokenExchangeProvider, bool) {
+	impl, ok := unwrap(p).(TokenExchangeProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedTokenExchangeProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedTokenExchangeProvider struct {
+	TokenExchangeProvider
+	g *guardedProvider
+}
+
+func (p guardedTokenExchangeProvider) AllowTokenExchange(client types.Client, subject types.Token, scopes types.Scopes, audience []string) (bool, error) {
+	var allowed bool
+	err := p.g.call(func() (err error) {
+		allowed, err = p.TokenExchangeProvider.AllowTokenExchange(client, subject, scopes, audience)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	return allowed, nil
+}
+
+// asGrantRevocationProvider returns the GrantRevocationProvider implemented by the provider, if any.
+func asGrantRevocationProvider(p Provider) (GrantRevocationProvider, bool) {
+	impl, ok := unwrap(p).(GrantRevocationProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedGrantRevocationProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedGrantRevocationProvider struct {
+	GrantRevocationProvider
+	g *guardedProvider
+}
+
+func (p guardedGrantRevocationProvider) RevokeGrants(userID, clientID string) error {
+	return p.g.call(func() error {
+		return p.GrantRevocationProvider.RevokeGrants(userID, clientID)
+	})
+}
+
+// asEventRevocationProvider returns the EventRevocationProvider implemented by the provider, if any.
+func asEventRevocationProvider(p Provider) (EventRevocationProvider, bool) {
+	impl, ok := unwrap(p).(EventRevocationProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedEventRevocationProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedEventRevocationProvider struct {
+	EventRevocationProvider
+	g *guardedProvider
+}
+
+func (p guardedEventRevocationProvider) RevokeByEvent(event types.CredentialEvent) error {
+	return p.g.call(func() error {
+		return p.EventRevocationProvider.RevokeByEvent(event)
+	})
+}
+
+// asClientCheckProvider returns the ClientCheckProvider implemented by the provider, if any.
+func asClientCheckProvider(p Provider) (ClientCheckProvider, bool) {
+	impl, ok := unwrap(p).(ClientCheckProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedClientCheckProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedClientCheckProvider struct {
+	ClientCheckProvider
+	g *guardedProvider
+}
+
+func (p guardedClientCheckProvider) Clients() ([]types.Client, error) {
+	var clients []types.Client
+	err := p.g.call(func() (err error) {
+		clients, err = p.ClientCheckProvider.Clients()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (p guardedClientCheckProvider) SaveClientCheck(check types.ClientCheck) error {
+	return p.g.call(func() error {
+		return p.ClientCheckProvider.SaveClientCheck(check)
+	})
+}
+
+func (p guardedClientCheckProvider) ClientCheck(clientID string) (types.ClientCheck, error) {
+	var check types.ClientCheck
+	err := p.g.call(func() (err error) {
+		check, err = p.ClientCheckProvider.ClientCheck(clientID)
+		return err
+	})
+	if err != nil {
+		return types.ClientCheck{}, err
+	}
+	return check, nil
+}
+
+// asClientLifecycleProvider returns the ClientLifecycleProvider implemented by the provider, if any.
+func asClientLifecycleProvider(p Provider) (ClientLifecycleProvider, bool) {
+	impl, ok := unwrap(p).(ClientLifecycleProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedClientLifecycleProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedClientLifecycleProvider struct {
+	ClientLifecycleProvider
+	g *guardedProvider
+}
+
+func (p guardedClientLifecycleProvider) SetClientStatus(clientID string, status types.ClientStatus) error {
+	return p.g.call(func() error {
+		return p.ClientLifecycleProvider.SetClientStatus(clientID, status)
+	})
+}
+
+// asClientDeletionProvider returns the ClientDeletionProvider implemented by the provider, if any.
+func asClientDeletionProvider(p Provider) (ClientDeletionProvider, bool) {
+	impl, ok := unwrap(p).(ClientDeletionProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedClientDeletionProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedClientDeletionProvider struct {
+	ClientDeletionProvider
+	g *guardedProvider
+}
+
+func (p guardedClientDeletionProvider) DeleteClient(clientID string) error {
+	return p.g.call(func() error {
+		return p.ClientDeletionProvider.DeleteClient(clientID)
+	})
+}
+
+func (p guardedClientDeletionProvider) SoftDeleteClient(clientID string, purgeAt time.Time) error {
+	return p.g.call(func() error {
+		return p.ClientDeletionProvider.SoftDeleteClient(clientID, purgeAt)
+	})
+}
+
+func (p guardedClientDeletionProvider) RestoreClient(clientID string) error {
+	return p.g.call(func() error {
+		return p.ClientDeletionProvider.RestoreClient(clientID)
+	})
+}
+
+// asClientRedirectProvider returns the ClientRedirectProvider implemented by the provider, if any.
+func asClientRedirectProvider(p Provider) (ClientRedirectProvider, bool) {
+	impl, ok := unwrap(p).(ClientRedirectProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedClientRedirectProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedClientRedirectProvider struct {
+	ClientRedirectProvider
+	g *guardedProvider
+}
+
+func (p guardedClientRedirectProvider) SetClientRedirectURL(clientID string, u *url.URL) error {
+	return p.g.call(func() error {
+		return p.ClientRedirectProvider.SetClientRedirectURL(clientID, u)
+	})
+}
+
+// asGrantQuarantineProvider returns the GrantQuarantineProvider implemented by the provider, if any.
+func asGrantQuarantineProvider(p Provider) (GrantQuarantineProvider, bool) {
+	impl, ok := unwrap(p).(GrantQuarantineProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedGrantQuarantineProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedGrantQuarantineProvider struct {
+	GrantQuarantineProvider
+	g *guardedProvider
+}
+
+func (p guardedGrantQuarantineProvider) QuarantineGrants(clientID string) error {
+	return p.g.call(func() error {
+		return p.GrantQuarantineProvider.QuarantineGrants(clientID)
+	})
+}
+
+// asResourceServerProvider returns the ResourceServerProvider implemented by the provider, if any.
+func asResourceServerProvider(p Provider) (ResourceServerProvider, bool) {
+	impl, ok := unwrap(p).(ResourceServerProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedResourceServerProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedResourceServerProvider struct {
+	ResourceServerProvider
+	g *guardedProvider
+}
+
+func (p guardedResourceServerProvider) ResourceServerInfo(audience string) (types.ResourceServer, error) {
+	var rs types.ResourceServer
+	err := p.g.call(func() (err error) {
+		rs, err = p.ResourceServerProvider.ResourceServerInfo(audience)
+		return err
+	})
+	if err != nil {
+		return types.ResourceServer{}, err
+	}
+	return rs, nil
+}
+
+func (p guardedResourceServerProvider) AuthenticateResourceServer(id, secret string) (types.ResourceServer, error) {
+	var rs types.ResourceServer
+	err := p.g.call(func() (err error) {
+		rs, err = p.ResourceServerProvider.AuthenticateResourceServer(id, secret)
+		return err
+	})
+	if err != nil {
+		return types.ResourceServer{}, err
+	}
+	return rs, nil
+}
+
+func (p guardedResourceServerProvider) SaveResourceServer(rs types.ResourceServer) error {
+	return p.g.call(func() error {
+		return p.ResourceServerProvider.SaveResourceServer(rs)
+	})
+}
+
+// asServiceAccountProvider returns the ServiceAccountProvider implemented by the provider, if any.
+func asServiceAccountProvider(p Provider) (ServiceAccountProvider, bool) {
+	impl, ok := unwrap(p).(ServiceAccountProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedServiceAccountProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedServiceAccountProvider struct {
+	ServiceAccountProvider
+	g *guardedProvider
+}
+
+func (p guardedServiceAccountProvider) ServiceAccountInfo(id string) (types.ServiceAccount, error) {
+	var account types.ServiceAccount
+	err := p.g.call(func() (err error) {
+		account, err = p.ServiceAccountProvider.ServiceAccountInfo(id)
+		return err
+	})
+	if err != nil {
+		return types.ServiceAccount{}, err
+	}
+	return account, nil
+}
+
+// asStatsProvider returns the StatsProvider implemented by the provider, if any.
+func asStatsProvider(p Provider) (StatsProvider, bool) {
+	impl, ok := unwrap(p).(StatsProvider)
+	if g, guarded := p.(*guardedProvider); ok && guarded {
+		return guardedStatsProvider{impl, g}, true
+	}
+	return impl, ok
+}
+
+type guardedStatsProvider struct {
+	StatsProvider
+	g *guardedProvider
+}
+
+func (p guardedStatsProvider) Stats(since time.Time) (types.Stats, error) {
+	var stats types.Stats
+	err := p.g.call(func() (err error) {
+		stats, err = p.StatsProvider.Stats(since)
+		return err
+	})
+	if err != nil {
+		return types.Stats{}, err
+	}
+	return stats, nil
+}
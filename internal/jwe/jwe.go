@@ -0,0 +1,219 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package jwe implements the subset of JSON Web Encryption
+// (http://tools.ietf.org/html/rfc7516) needed by the oauth2 package to
+// decrypt request objects. Only the compact serialization, RSA-OAEP key
+// encryption and AES-GCM content encryption are supported.
+package jwe
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha1"   // registers crypto.SHA1
+	_ "crypto/sha256" // registers crypto.SHA256
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Supported key encryption algorithms, as defined in
+// http://tools.ietf.org/html/rfc7518#section-4.3
+const (
+	RSAOAEP    = "RSA-OAEP"
+	RSAOAEP256 = "RSA-OAEP-256"
+)
+
+// Supported content encryption algorithms, as defined in
+// http://tools.ietf.org/html/rfc7518#section-5.3
+const (
+	A128GCM = "A128GCM"
+	A256GCM = "A256GCM"
+)
+
+// Algorithms lists the supported key encryption algorithms.
+var Algorithms = []string{RSAOAEP, RSAOAEP256}
+
+// Encryptions lists the supported content encryption algorithms.
+var Encryptions = []string{A128GCM, A256GCM}
+
+// Errors
+var (
+	ErrMalformed            = errors.New("jwe: malformed token")
+	ErrUnsupportedAlgorithm = errors.New("jwe: unsupported encryption algorithm")
+	ErrDecryption           = errors.New("jwe: decryption failed")
+)
+
+// Header represents the JOSE header of an encrypted token.
+type Header struct {
+	Algorithm   string `json:"alg"`
+	Encryption  string `json:"enc"`
+	KeyID       string `json:"kid,omitempty"`
+	ContentType string `json:"cty,omitempty"`
+}
+
+// IsEncrypted tells whether a token looks like a JWE rather than a JWS.
+func IsEncrypted(raw string) bool {
+	return strings.Count(raw, ".") == 4
+}
+
+// ParseHeader decodes the header of a JWE in compact serialization, so the
+// decryption key can be chosen.
+func ParseHeader(raw string) (Header, error) {
+	var header Header
+	parts := strings.Split(raw, ".")
+	if len(parts) != 5 {
+		return header, ErrMalformed
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return header, ErrMalformed
+	}
+
+	if err := json.Unmarshal(b, &header); err != nil {
+		return header, ErrMalformed
+	}
+	return header, nil
+}
+
+// Decrypt decrypts a JWE in compact serialization with the given RSA private
+// key and returns its plaintext.
+func Decrypt(raw string, key crypto.Decrypter) ([]byte, error) {
+	header, err := ParseHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	segments := make([][]byte, 4)
+	for i, p := range parts[1:] {
+		if segments[i], err = base64.RawURLEncoding.DecodeString(p); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := segments[0], segments[1], segments[2], segments[3]
+
+	oaepHash, err := oaepHash(header.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := keySize(header.Encryption)
+	if err != nil {
+		return nil, err
+	}
+
+	// A random key is used if the encrypted key can not be decrypted, so
+	// failures can not be told apart, as recommended by
+	// http://tools.ietf.org/html/rfc7516#section-11.5
+	cek := make([]byte, size)
+	if _, err := rand.Read(cek); err != nil {
+		return nil, err
+	}
+
+	decrypted, err := key.Decrypt(rand.Reader, encryptedKey, &rsa.OAEPOptions{Hash: oaepHash})
+	if err == nil && len(decrypted) == size {
+		subtle.ConstantTimeCopy(1, cek, decrypted)
+	}
+
+	aead, err := newGCM(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(iv) != aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	plaintext, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return nil, ErrDecryption
+	}
+	return plaintext, nil
+}
+
+// Encrypt encrypts plaintext to the given RSA public key, using the key and
+// content encryption algorithms of the header.
+func Encrypt(plaintext []byte, header Header, key *rsa.PublicKey) (string, error) {
+	oaepHash, err := oaepHash(header.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	size, err := keySize(header.Encryption)
+	if err != nil {
+		return "", err
+	}
+
+	cek := make([]byte, size)
+	if _, err := rand.Read(cek); err != nil {
+		return "", err
+	}
+
+	encryptedKey, err := rsa.EncryptOAEP(oaepHash.New(), rand.Reader, key, cek, nil)
+	if err != nil {
+		return "", err
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	encodedHeader := base64.RawURLEncoding.EncodeToString(headerBytes)
+
+	aead, err := newGCM(cek)
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nil, iv, plaintext, []byte(encodedHeader))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+
+	return strings.Join([]string{
+		encodedHeader,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+func oaepHash(alg string) (crypto.Hash, error) {
+	switch alg {
+	case RSAOAEP:
+		return crypto.SHA1, nil
+	case RSAOAEP256:
+		return crypto.SHA256, nil
+	}
+	return 0, ErrUnsupportedAlgorithm
+}
+
+func keySize(enc string) (int, error) {
+	switch enc {
+	case A128GCM:
+		return 16, nil
+	case A256GCM:
+		return 32, nil
+	}
+	return 0, ErrUnsupportedAlgorithm
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
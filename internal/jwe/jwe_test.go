@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package jwe
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+)
+
+func TestEncryptAndDecrypt(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, alg := range Algorithms {
+		for _, enc := range Encryptions {
+			raw, err := Encrypt([]byte("request object"), Header{Algorithm: alg, Encryption: enc, KeyID: "1"}, &key.PublicKey)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", alg, enc, err)
+			}
+
+			if !IsEncrypted(raw) {
+				t.Errorf("%s/%s: expected 5 segments: %s", alg, enc, raw)
+			}
+
+			header, err := ParseHeader(raw)
+			if err != nil || header.KeyID != "1" {
+				t.Errorf("%s/%s: unexpected header %+v: %v", alg, enc, header, err)
+			}
+
+			plaintext, err := Decrypt(raw, key)
+			if err != nil {
+				t.Fatalf("%s/%s: %v", alg, enc, err)
+			}
+			if string(plaintext) != "request object" {
+				t.Errorf("%s/%s: unexpected plaintext %q", alg, enc, plaintext)
+			}
+
+			if _, err := Decrypt(raw, other); err != ErrDecryption {
+				t.Errorf("%s/%s: expected %v decrypting with another key, got %v", alg, enc, ErrDecryption, err)
+			}
+
+			// Tampering with the header has to fail authentication.
+			parts := strings.Split(raw, ".")
+			parts[0] = parts[0][:len(parts[0])-2] + "fQ"
+			if _, err := Decrypt(strings.Join(parts, "."), key); err == nil {
+				t.Errorf("%s/%s: expected an error decrypting tampered token", alg, enc)
+			}
+		}
+	}
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Encrypt([]byte("x"), Header{Algorithm: "RSA1_5", Encryption: A128GCM}, &key.PublicKey); err != ErrUnsupportedAlgorithm {
+		t.Errorf("expected %v, got %v", ErrUnsupportedAlgorithm, err)
+	}
+}
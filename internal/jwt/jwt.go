@@ -0,0 +1,294 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package jwt implements the subset of JSON Web Tokens (http://tools.ietf.org/html/rfc7519)
+// and JSON Web Signatures (http://tools.ietf.org/html/rfc7515) needed by the
+// oauth2 package. Only the compact serialization is supported.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms, as defined in http://tools.ietf.org/html/rfc7518#section-3.1
+// and http://tools.ietf.org/html/rfc8037#section-3.1
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Algorithms lists the supported signing algorithms.
+var Algorithms = []string{RS256, ES256, EdDSA}
+
+// Errors
+var (
+	ErrMalformed            = errors.New("jwt: malformed token")
+	ErrUnsupportedAlgorithm = errors.New("jwt: unsupported signing algorithm")
+	ErrInvalidSignature     = errors.New("jwt: invalid signature")
+	ErrInvalidKey           = errors.New("jwt: key does not match signing algorithm")
+	ErrExpired              = errors.New("jwt: token is expired")
+	ErrNotValidYet          = errors.New("jwt: token is not valid yet")
+	ErrMissingExpiration    = errors.New("jwt: exp claim is required")
+)
+
+// Header represents the JOSE header of a signed token.
+type Header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ,omitempty"`
+	KeyID     string `json:"kid,omitempty"`
+	// Public key the token is signed with, as a JSON Web Key, for tokens
+	// proving possession of it. http://tools.ietf.org/html/rfc7515#section-4.1.3
+	JWK json.RawMessage `json:"jwk,omitempty"`
+}
+
+// Audience holds the "aud" claim, which can be either a single string or an
+// array of strings.
+type Audience []string
+
+// UnmarshalJSON accepts both forms of the "aud" claim.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var l []string
+	if err := json.Unmarshal(b, &l); err != nil {
+		return err
+	}
+	*a = Audience(l)
+	return nil
+}
+
+// Contains returns whether the audience includes the given value.
+func (a Audience) Contains(v string) bool {
+	for _, aud := range a {
+		if aud == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Claims are the registered claims defined in http://tools.ietf.org/html/rfc7519#section-4.1
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// Validate checks time based claims against the given time, tolerating
+// the given clock skew. The exp claim is required.
+func (c Claims) Validate(now time.Time, leeway time.Duration) error {
+	if c.ExpiresAt == 0 {
+		return ErrMissingExpiration
+	}
+
+	if now.Add(-leeway).After(time.Unix(c.ExpiresAt, 0)) {
+		return ErrExpired
+	}
+
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return ErrNotValidYet
+	}
+	return nil
+}
+
+// Token is a parsed, but not yet verified, JWT.
+type Token struct {
+	Header Header
+	Claims Claims
+
+	payload      []byte
+	signingInput string
+	signature    []byte
+}
+
+// Parse decodes a JWT in compact serialization. It does not verify its signature.
+func Parse(raw string) (*Token, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+
+	headerBytes, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	signature, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	t := &Token{
+		payload:      payload,
+		signingInput: parts[0] + "." + parts[1],
+		signature:    signature,
+	}
+
+	if err := json.Unmarshal(headerBytes, &t.Header); err != nil {
+		return nil, ErrMalformed
+	}
+
+	if err := json.Unmarshal(payload, &t.Claims); err != nil {
+		return nil, ErrMalformed
+	}
+
+	return t, nil
+}
+
+// Decode unmarshals the token payload into v, allowing callers to read
+// private claims.
+func (t *Token) Decode(v interface{}) error {
+	return json.Unmarshal(t.payload, v)
+}
+
+// Verify checks the token signature using the given public key. The "none"
+// algorithm is never accepted.
+func (t *Token) Verify(key crypto.PublicKey) error {
+	digest := sha256.Sum256([]byte(t.signingInput))
+
+	switch t.Header.Algorithm {
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], t.signature); err != nil {
+			return ErrInvalidSignature
+		}
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if len(t.signature) != 64 {
+			return ErrInvalidSignature
+		}
+		r := new(big.Int).SetBytes(t.signature[:32])
+		s := new(big.Int).SetBytes(t.signature[32:])
+		if !ecdsa.Verify(pub, digest[:], r, s) {
+			return ErrInvalidSignature
+		}
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return ErrInvalidKey
+		}
+		if !ed25519.Verify(pub, []byte(t.signingInput), t.signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return ErrUnsupportedAlgorithm
+	}
+	return nil
+}
+
+// Sign serializes and signs the given claims. The algorithm is taken from
+// the header and has to match the signer's key type.
+func Sign(header Header, claims interface{}, signer crypto.Signer) (string, error) {
+	if header.Type == "" {
+		header.Type = "JWT"
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encodeSegment(headerBytes) + "." + encodeSegment(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch header.Algorithm {
+	case RS256:
+		if _, ok := signer.Public().(*rsa.PublicKey); !ok {
+			return "", ErrInvalidKey
+		}
+		signature, err = signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return "", err
+		}
+	case ES256:
+		if _, ok := signer.Public().(*ecdsa.PublicKey); !ok {
+			return "", ErrInvalidKey
+		}
+		der, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return "", err
+		}
+		signature, err = ecdsaRawSignature(der)
+		if err != nil {
+			return "", err
+		}
+	case EdDSA:
+		if _, ok := signer.Public().(ed25519.PublicKey); !ok {
+			return "", ErrInvalidKey
+		}
+		// Ed25519 signs the message itself, not a digest.
+		signature, err = signer.Sign(rand.Reader, []byte(signingInput), crypto.Hash(0))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", ErrUnsupportedAlgorithm
+	}
+
+	return signingInput + "." + encodeSegment(signature), nil
+}
+
+// ecdsaRawSignature converts an ASN.1 DER encoded ECDSA signature, as returned
+// by crypto.Signer, into the fixed size R || S form required by
+// http://tools.ietf.org/html/rfc7518#section-3.4
+func ecdsaRawSignature(der []byte) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	raw := make([]byte, 64)
+	rBytes, sBytes := sig.R.Bytes(), sig.S.Bytes()
+	copy(raw[32-len(rBytes):32], rBytes)
+	copy(raw[64-len(sBytes):], sBytes)
+	return raw, nil
+}
+
+func encodeSegment(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeSegment(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
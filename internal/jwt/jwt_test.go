@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		alg    string
+		signer crypto.Signer
+	}{
+		{RS256, rsaKey},
+		{ES256, ecKey},
+		{EdDSA, edKey},
+	}
+
+	for _, tt := range tests {
+		claims := Claims{
+			Issuer:    "issuer",
+			Audience:  Audience{"audience"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}
+
+		raw, err := Sign(Header{Algorithm: tt.alg, KeyID: "1"}, claims, tt.signer)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+
+		token, err := Parse(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+
+		if err := token.Verify(tt.signer.Public()); err != nil {
+			t.Errorf("%s: unexpected error verifying signature: %v", tt.alg, err)
+		}
+
+		if token.Header.KeyID != "1" || token.Claims.Issuer != "issuer" || !token.Claims.Audience.Contains("audience") {
+			t.Errorf("%s: unexpected token contents: %+v", tt.alg, token)
+		}
+
+		if err := token.Claims.Validate(time.Now(), 0); err != nil {
+			t.Errorf("%s: unexpected error validating claims: %v", tt.alg, err)
+		}
+
+		// Tampering with the payload has to invalidate the signature.
+		parts := strings.Split(raw, ".")
+		tampered, err := Parse(parts[0] + "." + encodeSegment([]byte(`{"iss":"attacker"}`)) + "." + parts[2])
+		if err != nil {
+			t.Fatalf("%s: %v", tt.alg, err)
+		}
+
+		if err := tampered.Verify(tt.signer.Public()); err != ErrInvalidSignature {
+			t.Errorf("%s: expected invalid signature, got %v", tt.alg, err)
+		}
+	}
+}
+
+func TestNoneAlgorithm(t *testing.T) {
+	raw := encodeSegment([]byte(`{"alg":"none"}`)) + "." + encodeSegment([]byte(`{"iss":"attacker"}`)) + "."
+	token, err := Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := token.Verify(key.Public()); err != ErrUnsupportedAlgorithm {
+		t.Errorf("expected unsupported algorithm error, got %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/hooklift/oauth2/types"
@@ -21,8 +22,16 @@ import (
 var (
 	ErrNilResponseWriter = errors.New("You must provide a valid http.ResponseWriter")
 	ErrNilHTMLTemplate   = errors.New("You must provide a valid HTML template")
+	ErrNotJOSE           = errors.New("You must provide a compact serialized JWS")
 )
 
+// Buffers used to render HTML templates, reused across requests.
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
 // Options represents the set of values to pass when rendering content.
 type Options struct {
 	// HTTP status to return.
@@ -55,6 +64,12 @@ func JSON(w http.ResponseWriter, opts Options) error {
 	headers.Set("Content-Type", "application/json; charset=utf-8")
 	cache(headers, opts)
 
+	// Correlates errors with server logs.
+	if err, ok := opts.Data.(types.AuthzError); ok && err.RequestID == "" {
+		err.RequestID = headers.Get("X-Request-ID")
+		opts.Data = err
+	}
+
 	jsonBytes, err := json.Marshal(opts.Data)
 	if err != nil {
 		return err
@@ -70,6 +85,32 @@ func JSON(w http.ResponseWriter, opts Options) error {
 	return nil
 }
 
+// JOSE sends a compact serialized JWS, such as a signed response, back to
+// the HTTP client. Data has to be a string.
+func JOSE(w http.ResponseWriter, opts Options) error {
+	if w == nil {
+		return ErrNilResponseWriter
+	}
+
+	jws, ok := opts.Data.(string)
+	if !ok {
+		return ErrNotJOSE
+	}
+
+	headers := w.Header()
+	headers.Set("Content-Type", "application/jose")
+	cache(headers, opts)
+
+	headers.Set("Content-Length", strconv.Itoa(len(jws)))
+	if opts.Status <= 0 {
+		opts.Status = http.StatusOK
+	}
+	w.WriteHeader(opts.Status)
+	w.Write([]byte(jws))
+
+	return nil
+}
+
 // HTML renders HTML content and sends it back to the HTTP client.
 func HTML(w http.ResponseWriter, opts Options) error {
 	if w == nil {
@@ -95,7 +136,10 @@ func HTML(w http.ResponseWriter, opts Options) error {
 		opts.Status = http.StatusOK
 	}
 
-	buf := new(bytes.Buffer)
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	defer bufPool.Put(buf)
+
 	if err := opts.Template.Execute(buf, opts.Data); err != nil {
 		log.Printf("[ERROR] %v", err)
 	}
@@ -0,0 +1,243 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// IntrospectionHandlers is a map to functions where each function handles a particular HTTP
+// verb or method of the token introspection endpoint.
+var IntrospectionHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"POST": IntrospectToken,
+}
+
+// IntrospectionClaimsProvider is an optional interface that providers can
+// implement in order to add their own claims to introspection responses,
+// such as entitlements.
+type IntrospectionClaimsProvider interface {
+	// IntrospectionClaims returns additional claims of an active token.
+	IntrospectionClaims(token types.Token) (map[string]interface{}, error)
+}
+
+// SetIntrospectionEndpoint allows setting the token introspection endpoint. Defaults to "/oauth2/introspect".
+func SetIntrospectionEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.introspectionEndpoint = endpoint
+	}
+}
+
+// SetIntrospectionClaims limits the claims the given caller receives when
+// introspecting tokens. For instance, the following hides the resource
+// owner from low trust callers and lets a single one see entitlements:
+//
+//	SetIntrospectionClaims("", "scope", "client_id", "exp")
+//	SetIntrospectionClaims("billing", "scope", "client_id", "exp", "sub", "entitlements")
+//
+// An empty caller identifier configures callers with no claims of their
+// own. Callers are sent every claim if neither is configured. The "active"
+// claim is always sent.
+func SetIntrospectionClaims(callerID string, claims ...string) option {
+	return func(c *config) {
+		if c.introspectionClaims == nil {
+			c.introspectionClaims = make(map[string][]string)
+		}
+		c.introspectionClaims[callerID] = claims
+	}
+}
+
+// IntrospectToken implements http://tools.ietf.org/html/rfc7662
+//
+// Implementation notes:
+//   - Callers authenticate with their resource server credentials if the
+//     provider implements ResourceServerProvider, or their client credentials.
+//   - Tokens restricted to other resource servers are reported as inactive to
+//     resource servers.
+//   - Idle tokens are reported as inactive. See SetIdleTimeout and SetRefreshIdleTimeout.
+//   - token_type_hint is ignored, access and refresh tokens are looked up the same way.
+func IntrospectToken(w http.ResponseWriter, req *http.Request, cfg config) {
+	provider := cfg.provider
+
+	username, password, ok := req.BasicAuth()
+	rs, err := authenticateResourceServer(cfg, username, password)
+	callerID := rs.ID
+	if err == nil && rs.ID == "" {
+		var client types.Client
+		client, err = provider.AuthenticateClient(username, password)
+		callerID = client.ID
+	}
+
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
+	if !ok || err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   localize(req, cfg, ErrUnauthorizedClient),
+		})
+		return
+	}
+
+	raw := req.FormValue("token")
+	if raw == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrAccessTokenRequired),
+		})
+		return
+	}
+
+	inactive := map[string]interface{}{"active": false}
+
+	// Self-contained tokens have to be genuine before looking them up.
+	if isJWT(raw) && cfg.keyProvider != nil {
+		if _, err := verifyAccessToken(cfg, raw); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusOK,
+				Data:   inactive,
+			})
+			return
+		}
+	}
+
+	token, id, err := lookupToken(cfg, raw)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	timeout := cfg.idleTimeout
+	if token.RefreshToken == id {
+		timeout = cfg.refreshIdleTimeout
+	}
+
+	isIdle, err := idle(cfg, provider, id, timeout)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	expired := !token.ExpiresAt.IsZero() && !now(cfg).Before(token.ExpiresAt)
+	if token.Value == "" || expired || isIdle || token.Status == types.TokenExpired || token.Status == types.TokenRevoked ||
+		token.Status == types.TokenRotated || !audienceAllowed(token, rs.Audience) {
+		render.JSON(w, render.Options{
+			Status: http.StatusOK,
+			Data:   inactive,
+		})
+		return
+	}
+
+	claims, err := introspectionClaims(req, cfg, token, token.RefreshToken == id)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if rs.IntrospectionClaims != nil {
+		claims = keepClaims(rs.IntrospectionClaims, claims)
+	} else {
+		claims = filterClaims(cfg, callerID, claims)
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   claims,
+	})
+}
+
+// introspectionClaims returns every claim known about an active token.
+// Refresh tokens are also described by their family.
+func introspectionClaims(req *http.Request, cfg config, token types.Token, refresh bool) (map[string]interface{}, error) {
+	claims := map[string]interface{}{
+		"iss":        "https://" + req.Host,
+		"client_id":  token.ClientID,
+		"token_type": token.Type,
+	}
+
+	if scope := token.Scopes.Encode(); scope != "" {
+		claims["scope"] = scope
+	}
+
+	if token.UserID != "" {
+		claims["sub"] = token.UserID
+	}
+
+	if len(token.Audience) > 0 {
+		claims["aud"] = token.Audience
+	}
+
+	if len(token.AMR) > 0 {
+		claims["amr"] = token.AMR
+	}
+
+	if token.IdentityProvider != "" {
+		claims["idp"] = token.IdentityProvider
+	}
+
+	if !token.ExpiresAt.IsZero() {
+		claims["exp"] = token.ExpiresAt.Unix()
+	}
+
+	if refresh {
+		if err := familyClaims(cfg, token, claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if p, ok := asIntrospectionClaimsProvider(cfg.provider); ok {
+		extra, err := p.IntrospectionClaims(token)
+		if err != nil {
+			return nil, err
+		}
+
+		for k, v := range extra {
+			claims[k] = v
+		}
+	}
+
+	claims["active"] = true
+	return claims, nil
+}
+
+// filterClaims keeps the claims the caller is allowed to receive.
+func filterClaims(cfg config, callerID string, claims map[string]interface{}) map[string]interface{} {
+	allowed, ok := cfg.introspectionClaims[callerID]
+	if !ok {
+		allowed, ok = cfg.introspectionClaims[""]
+	}
+
+	if !ok {
+		return claims
+	}
+	return keepClaims(allowed, claims)
+}
+
+// keepClaims returns the allowed claims, along with "active".
+func keepClaims(allowed []string, claims map[string]interface{}) map[string]interface{} {
+	filtered := map[string]interface{}{"active": claims["active"]}
+	for _, c := range allowed {
+		if v, ok := claims[c]; ok {
+			filtered[c] = v
+		}
+	}
+	return filtered
+}
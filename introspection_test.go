@@ -0,0 +1,78 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// entitledProvider adds entitlements to introspection responses.
+type entitledProvider struct {
+	*test.Provider
+}
+
+func (p entitledProvider) IntrospectionClaims(token types.Token) (map[string]interface{}, error) {
+	return map[string]interface{}{"entitlements": []string{"premium"}}, nil
+}
+
+// TestIntrospectToken tests http://tools.ietf.org/html/rfc7662 and the
+// filtering of claims per caller.
+func TestIntrospectToken(t *testing.T) {
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = entitledProvider{provider}
+	SetIntrospectionClaims("", "scope", "client_id")(&cfg)
+	SetIntrospectionClaims("boo", "scope", "client_id", "sub", "entitlements")(&cfg)
+
+	token, err := provider.GenToken(types.Grant{
+		Scopes: types.Scopes{types.Scope{ID: "read"}},
+	}, provider.Client, false, time.Duration(10)*time.Minute)
+	ok(t, err)
+
+	token.UserID = "test_user"
+	provider.AccessTokens[token.Value] = token
+
+	introspect := func(caller, value string) map[string]interface{} {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/introspect",
+			bytes.NewBufferString(url.Values{"token": {value}}.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(caller, "secret")
+
+		w := httptest.NewRecorder()
+		IntrospectToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		claims := make(map[string]interface{})
+		ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+		return claims
+	}
+
+	claims := introspect("testclient", token.Value)
+	equals(t, map[string]interface{}{
+		"active":    true,
+		"scope":     "read",
+		"client_id": "test_client_id",
+	}, claims)
+
+	claims = introspect("boo", token.Value)
+	equals(t, true, claims["active"])
+	equals(t, "test_user", claims["sub"])
+	equals(t, []interface{}{"premium"}, claims["entitlements"])
+	_, found := claims["exp"]
+	equals(t, false, found)
+
+	claims = introspect("boo", "unknown")
+	equals(t, map[string]interface{}{"active": false}, claims)
+}
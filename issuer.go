@@ -0,0 +1,63 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SetIssuer sets the issuer identifier of the authorization server, the URL
+// browsers and clients reach it at, without path, such as
+// https://auth.example.com. It is advertised in the authorization server
+// metadata, is the iss claim of JWT access tokens and consent receipts, and
+// the only origin authorization forms are accepted from.
+//
+// By default, the issuer is the Host of each request, with the scheme it was
+// received with: https over TLS, the one in the X-Forwarded-Proto header set
+// by a proxy terminating TLS, or http otherwise. Setting the issuer is
+// recommended whenever a proxy rewrites the Host or the scheme.
+// http://tools.ietf.org/html/rfc8414#section-2
+func SetIssuer(issuer string) option {
+	return func(c *config) {
+		u, err := url.Parse(issuer)
+		if err != nil || normalizeOrigin(issuer) == "" || strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			log.Fatalf("Invalid issuer %q, an http or https URL without path is expected", issuer)
+		}
+		c.issuer = normalizeOrigin(issuer)
+	}
+}
+
+// issuerURL returns the issuer identifier of the authorization server, as
+// set by SetIssuer or derived from the request.
+func issuerURL(req *http.Request, cfg config) string {
+	if cfg.issuer != "" {
+		return cfg.issuer
+	}
+	return requestScheme(req) + "://" + req.Host
+}
+
+// requestScheme returns the scheme the request was sent with by the browser
+// or client, before any proxy terminating TLS.
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+
+	// Proxies append to the header, the first value is the client's.
+	proto := strings.Split(req.Header.Get("X-Forwarded-Proto"), ",")[0]
+	switch proto = strings.ToLower(strings.TrimSpace(proto)); proto {
+	case "https", "http":
+		return proto
+	}
+
+	// Requests built by clients carry an absolute URL.
+	if strings.EqualFold(req.URL.Scheme, "https") {
+		return "https"
+	}
+	return "http"
+}
@@ -0,0 +1,190 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// KeyRotator is an optional interface that key providers can implement in
+// order to rotate signing keys through the admin API.
+type KeyRotator interface {
+	// RotateKey introduces a new signing key and returns its public key. The
+	// new key has to be published by PublicKeys before being used to sign
+	// tokens, and previous keys for as long as tokens signed with them
+	// remain valid.
+	RotateKey() (types.PublicKey, error)
+}
+
+// ErrKeyRotatorRequired is returned when rotating keys with a key provider
+// that does not implement KeyRotator.
+var ErrKeyRotatorRequired = errors.New("oauth2: key provider does not implement oauth2.KeyRotator")
+
+// Defaults of RotatingKeys.
+const (
+	DefaultKeyPublishDelay = 24 * time.Hour
+	DefaultKeyRetirement   = 24 * time.Hour
+)
+
+// RotatingKeys is an in-memory KeyProvider rotating keys without downtime.
+// New keys are published right away but only used to sign tokens after a
+// delay, so clients and resource servers caching the key set know them
+// beforehand. Replaced keys are still published, and accepted, until tokens
+// signed with them expire.
+type RotatingKeys struct {
+	// Generates new signing keys, with a unique identifier.
+	Generate func() (types.SigningKey, error)
+	// How long new keys are published before being used. Defaults to
+	// DefaultKeyPublishDelay, it should be longer than clients and resource
+	// servers cache the key set for.
+	PublishDelay time.Duration
+	// How long replaced keys are kept. Defaults to DefaultKeyRetirement, it
+	// should be longer than the lifetime of signed tokens.
+	Retirement time.Duration
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu   sync.Mutex
+	keys []rotatingKey
+}
+
+// rotatingKey is a signing key along with the time it starts being used.
+type rotatingKey struct {
+	key      types.SigningKey
+	activeAt time.Time
+}
+
+// NewRotatingKeys returns a RotatingKeys using the given key right away and
+// generating new ones when rotated.
+func NewRotatingKeys(key types.SigningKey, generate func() (types.SigningKey, error)) *RotatingKeys {
+	return &RotatingKeys{
+		Generate: generate,
+		keys:     []rotatingKey{{key: key}},
+	}
+}
+
+// SigningKey implements KeyProvider, returning the newest active key.
+func (r *RotatingKeys) SigningKey() (types.SigningKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		if !r.keys[i].activeAt.After(now) {
+			return r.keys[i].key, nil
+		}
+	}
+	return types.SigningKey{}, ErrNoSigningKey
+}
+
+// PublicKeys implements KeyProvider, returning upcoming keys, the active key
+// and replaced keys until they expire.
+func (r *RotatingKeys) PublicKeys() ([]types.PublicKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	retirement := r.Retirement
+	if retirement <= 0 {
+		retirement = DefaultKeyRetirement
+	}
+
+	keys := make([]types.PublicKey, 0, len(r.keys))
+	kept := r.keys[:0]
+	for i, k := range r.keys {
+		pub := types.PublicKey{
+			ID:        k.key.ID,
+			Algorithm: k.key.Algorithm,
+			Key:       k.key.Signer.Public(),
+		}
+
+		// Keys expire once the next key has been active long enough.
+		if i+1 < len(r.keys) && !r.keys[i+1].activeAt.After(now) {
+			pub.ExpiresAt = r.keys[i+1].activeAt.Add(retirement)
+			if !now.Before(pub.ExpiresAt) {
+				continue
+			}
+		}
+
+		kept = append(kept, k)
+		keys = append(keys, pub)
+	}
+	r.keys = kept
+	return keys, nil
+}
+
+// RotateKey implements KeyRotator.
+func (r *RotatingKeys) RotateKey() (types.PublicKey, error) {
+	key, err := r.Generate()
+	if err != nil {
+		return types.PublicKey{}, err
+	}
+
+	delay := r.PublishDelay
+	if delay <= 0 {
+		delay = DefaultKeyPublishDelay
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys = append(r.keys, rotatingKey{
+		key:      key,
+		activeAt: r.now().Add(delay),
+	})
+
+	return types.PublicKey{
+		ID:        key.ID,
+		Algorithm: key.Algorithm,
+		Key:       key.Signer.Public(),
+	}, nil
+}
+
+func (r *RotatingKeys) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// rotateKey rotates signing keys through the admin API and returns the
+// identifier of the new key.
+func rotateKey(w http.ResponseWriter, req *http.Request, cfg config, _ string) {
+	rotator, ok := cfg.keyProvider.(KeyRotator)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrKeyRotatorRequired),
+		})
+		return
+	}
+
+	key, err := rotator.RotateKey()
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	kid := keyID(key.ID, key.Key)
+	log.Printf("[INFO] request_id=%s Signing key %s introduced", RequestID(req), kid)
+
+	render.JSON(w, render.Options{
+		Status: http.StatusCreated,
+		Data: map[string]string{
+			"kid": kid,
+			"alg": key.Algorithm,
+		},
+	})
+}
@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestKeyRotation tests that new keys are published before being used, and
+// that tokens signed with replaced keys are accepted until the keys expire.
+func TestKeyRotation(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+
+	var generated int
+	generate := func() (types.SigningKey, error) {
+		generated++
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		return types.SigningKey{ID: "k" + strconv.Itoa(generated), Algorithm: jwt.ES256, Signer: key}, err
+	}
+
+	initial, err := generate()
+	ok(t, err)
+	keys := NewRotatingKeys(initial, generate)
+	keys.PublishDelay = time.Hour
+	keys.Retirement = 2 * time.Hour
+	keys.Now = clock.Now
+
+	cfg := setupTest()
+	cfg.clock = clock
+	SetKeyProvider(keys)(&cfg)
+
+	sign := func() *jwt.Token {
+		signed, err := signJWT(cfg, "", jwt.Claims{Subject: "test"})
+		ok(t, err)
+		token, err := jwt.Parse(signed)
+		ok(t, err)
+		return token
+	}
+
+	published := func() []string {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/jwks", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		JWKS(w, req, cfg)
+
+		keySet := struct {
+			Keys []jwk `json:"keys"`
+		}{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &keySet))
+
+		ids := make([]string, 0)
+		for _, k := range keySet.Keys {
+			ids = append(ids, k.KeyID)
+		}
+		return ids
+	}
+
+	old := sign()
+	equals(t, "k1", old.Header.KeyID)
+
+	req, err := http.NewRequest("POST", "https://example.com/keys", nil)
+	ok(t, err)
+	w := httptest.NewRecorder()
+	AdminHandler(test.NewProvider(true), SetKeyProvider(keys)).ServeHTTP(w, req)
+	equals(t, http.StatusCreated, w.Code)
+
+	// The new key is published, but not used yet.
+	equals(t, []string{"k1", "k2"}, published())
+	equals(t, "k1", sign().Header.KeyID)
+
+	clock.Advance(time.Hour)
+	equals(t, "k2", sign().Header.KeyID)
+	ok(t, verifySignature(cfg, old))
+
+	clock.Advance(2 * time.Hour)
+	equals(t, []string{"k2"}, published())
+	assert(t, verifySignature(cfg, old) != nil, "tokens signed with expired keys should not be accepted")
+}
+
+// TestKeyIDThumbprint tests that tokens signed with keys without identifier
+// are identified by the JWK thumbprint of the key.
+func TestKeyIDThumbprint(t *testing.T) {
+	cfg := setupTest()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	SetSigningKey(types.SigningKey{Algorithm: jwt.ES256, Signer: key})(&cfg)
+
+	signed, err := signJWT(cfg, "", jwt.Claims{Subject: "test"})
+	ok(t, err)
+	token, err := jwt.Parse(signed)
+	ok(t, err)
+
+	equals(t, 43, len(token.Header.KeyID))
+	equals(t, keyID("", key.Public()), token.Header.KeyID)
+	ok(t, verifySignature(cfg, token))
+}
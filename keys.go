@@ -0,0 +1,396 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Errors signing and verifying JWTs.
+var (
+	// ErrNoSigningKey is returned when a JWT needs to be signed but no key provider was configured.
+	ErrNoSigningKey = errors.New("oauth2: no signing key configured")
+	// ErrSigningAlgorithmNotAllowed is returned when a JWT is, or has to be,
+	// signed with an algorithm not allowed by SetSigningAlgorithms.
+	ErrSigningAlgorithmNotAllowed = errors.New("oauth2: signing algorithm not allowed")
+	// ErrNoSigningKeyForAlgorithm is returned when a JWT has to be signed
+	// with an algorithm no signing key is available for.
+	ErrNoSigningKeyForAlgorithm = errors.New("oauth2: no signing key for the requested algorithm")
+)
+
+// KeyProvider supplies the keys used by the authorization server to sign the
+// JWTs it issues. Implementations may keep private keys out of the process
+// by returning signers backed by a HSM or a key management service.
+type KeyProvider interface {
+	// SigningKey returns the key currently used to sign new tokens.
+	SigningKey() (types.SigningKey, error)
+
+	// PublicKeys returns the public keys of every key that tokens still in
+	// circulation may have been signed with, including the current signing
+	// key, and of keys about to be used, so they are known in advance by
+	// whoever caches them. See RotatingKeys.
+	PublicKeys() ([]types.PublicKey, error)
+}
+
+// AlgorithmKeyProvider is an optional interface that key providers can
+// implement in order to sign JWTs with the algorithm registered by each
+// client, besides the one of their current signing key.
+type AlgorithmKeyProvider interface {
+	// AlgorithmSigningKey returns the key currently used to sign new tokens
+	// with the given algorithm.
+	AlgorithmSigningKey(alg string) (types.SigningKey, error)
+}
+
+// SetKeyProvider sets the provider of keys used to sign JWTs.
+func SetKeyProvider(kp KeyProvider) option {
+	return func(c *config) {
+		c.keyProvider = kp
+	}
+}
+
+// SetSigningKey is a shortcut for SetKeyProvider when a single, in-memory,
+// signing key is used.
+func SetSigningKey(key types.SigningKey) option {
+	return SetKeyProvider(staticKeys{key})
+}
+
+// SetSigningKeys is a shortcut for SetKeyProvider when in-memory signing keys
+// with different algorithms are used, so clients can choose theirs. The first
+// key is used for clients without a registered algorithm.
+func SetSigningKeys(keys ...types.SigningKey) option {
+	return SetKeyProvider(staticKeys(keys))
+}
+
+// SetSigningAlgorithms restricts the algorithms JWTs are signed and verified
+// with. Defaults to every supported algorithm: RS256, ES256 and EdDSA.
+func SetSigningAlgorithms(algs ...string) option {
+	return func(c *config) {
+		c.signingAlgorithms = algs
+	}
+}
+
+// staticKeys is a KeyProvider that never rotates keys.
+type staticKeys []types.SigningKey
+
+func (s staticKeys) SigningKey() (types.SigningKey, error) {
+	if len(s) == 0 {
+		return types.SigningKey{}, ErrNoSigningKey
+	}
+	return s[0], nil
+}
+
+func (s staticKeys) AlgorithmSigningKey(alg string) (types.SigningKey, error) {
+	for _, k := range s {
+		if k.Algorithm == alg {
+			return k, nil
+		}
+	}
+	return types.SigningKey{}, ErrNoSigningKeyForAlgorithm
+}
+
+func (s staticKeys) PublicKeys() ([]types.PublicKey, error) {
+	keys := make([]types.PublicKey, 0, len(s))
+	for _, k := range s {
+		keys = append(keys, types.PublicKey{
+			ID:        k.ID,
+			Algorithm: k.Algorithm,
+			Key:       k.Signer.Public(),
+		})
+	}
+	return keys, nil
+}
+
+// signingAlgorithms returns the algorithms JWTs can be signed and verified with.
+func signingAlgorithms(cfg config) []string {
+	if len(cfg.signingAlgorithms) == 0 {
+		return jwt.Algorithms
+	}
+	return cfg.signingAlgorithms
+}
+
+// allowedAlgorithm tells whether JWTs can be signed and verified with the
+// given algorithm.
+func allowedAlgorithm(cfg config, alg string) bool {
+	for _, a := range signingAlgorithms(cfg) {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// signingKey returns the key to sign JWTs with the given algorithm, or with
+// the current signing key if empty.
+func signingKey(cfg config, alg string) (types.SigningKey, error) {
+	if cfg.keyProvider == nil {
+		return types.SigningKey{}, ErrNoSigningKey
+	}
+
+	key, err := cfg.keyProvider.SigningKey()
+	if err != nil || alg == "" || key.Algorithm == alg {
+		return key, err
+	}
+
+	kp, ok := cfg.keyProvider.(AlgorithmKeyProvider)
+	if !ok {
+		return types.SigningKey{}, ErrNoSigningKeyForAlgorithm
+	}
+	return kp.AlgorithmSigningKey(alg)
+}
+
+// signJWT signs the given claims with the given algorithm, or with the
+// current signing key if empty. Signed tokens always identify their key in
+// the "kid" header, so keys can be rotated.
+func signJWT(cfg config, alg string, claims interface{}) (string, error) {
+	key, err := signingKey(cfg, alg)
+	if err != nil {
+		return "", err
+	}
+
+	if !allowedAlgorithm(cfg, key.Algorithm) {
+		return "", ErrSigningAlgorithmNotAllowed
+	}
+
+	header := jwt.Header{
+		Algorithm: key.Algorithm,
+		KeyID:     keyID(key.ID, key.Signer.Public()),
+	}
+	return jwt.Sign(header, claims, key.Signer)
+}
+
+// JWKSHandlers is a map to functions where each function handles a particular HTTP
+// verb or method of the JSON Web Key Set endpoint.
+var JWKSHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET": JWKS,
+}
+
+// jwk is the JSON representation of a public key, as defined in http://tools.ietf.org/html/rfc7517
+type jwk struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Elliptic curve keys
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JWKS publishes the public keys clients and resource servers need in order
+// to verify JWTs signed by this authorization server, and the one clients
+// encrypt request objects to, if any.
+// http://tools.ietf.org/html/rfc7517#section-5
+func JWKS(w http.ResponseWriter, req *http.Request, cfg config) {
+	keySet := struct {
+		Keys []jwk `json:"keys"`
+	}{
+		Keys: []jwk{},
+	}
+
+	if cfg.keyProvider != nil {
+		keys, err := publicKeys(cfg)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+
+		for _, k := range keys {
+			if key, ok := toJWK(k.Key); ok {
+				key.KeyID = k.ID
+				key.Algorithm = k.Algorithm
+				keySet.Keys = append(keySet.Keys, key)
+			}
+		}
+	}
+
+	// Clients encrypt request objects to this key.
+	if k := cfg.requestObjectKey; k.Decrypter != nil {
+		if key, ok := toJWK(k.Decrypter.Public()); ok {
+			key.Use = "enc"
+			key.KeyID = keyID(k.ID, k.Decrypter.Public())
+			key.Algorithm = k.Algorithm
+			keySet.Keys = append(keySet.Keys, key)
+		}
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   keySet,
+		// Public keys are meant to be cached by clients.
+		Cache: true,
+	})
+}
+
+// publicKeys returns the public keys that have not expired yet, identified
+// by their thumbprint if they have no identifier.
+func publicKeys(cfg config) ([]types.PublicKey, error) {
+	keys, err := cfg.keyProvider.PublicKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	valid := make([]types.PublicKey, 0, len(keys))
+	for _, k := range keys {
+		if !k.ExpiresAt.IsZero() && !now(cfg).Before(k.ExpiresAt) {
+			continue
+		}
+
+		k.ID = keyID(k.ID, k.Key)
+		valid = append(valid, k)
+	}
+	return valid, nil
+}
+
+// keyID returns the given key identifier or, if empty, the JWK thumbprint of
+// the public key as defined by http://tools.ietf.org/html/rfc7638
+func keyID(id string, pub crypto.PublicKey) string {
+	if id != "" {
+		return id
+	}
+
+	key, ok := toJWK(pub)
+	if !ok {
+		return ""
+	}
+
+	// Required members only, in lexicographic order.
+	var members string
+	switch key.KeyType {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, key.E, key.KeyType, key.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, key.Curve, key.KeyType, key.X)
+	default:
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, key.Curve, key.KeyType, key.X, key.Y)
+	}
+
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// toJWK returns the JSON Web Key of a RSA, elliptic curve or Ed25519 public
+// key. Ed25519 keys are represented as defined by
+// http://tools.ietf.org/html/rfc8037#section-2
+func toJWK(pub crypto.PublicKey) (jwk, bool) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return jwk{
+			KeyType: "OKP",
+			Use:     "sig",
+			Curve:   "Ed25519",
+			X:       base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	case *rsa.PublicKey:
+		return jwk{
+			KeyType: "RSA",
+			Use:     "sig",
+			N:       base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return jwk{
+			KeyType: "EC",
+			Use:     "sig",
+			Curve:   pub.Curve.Params().Name,
+			X:       base64.RawURLEncoding.EncodeToString(padLeft(pub.X.Bytes(), size)),
+			Y:       base64.RawURLEncoding.EncodeToString(padLeft(pub.Y.Bytes(), size)),
+		}, true
+	}
+	return jwk{}, false
+}
+
+// errJWKInvalid is returned for JSON Web Keys that are malformed, private or
+// of a type and size JWTs can not be verified with.
+var errJWKInvalid = errors.New("oauth2: invalid or unsupported JSON Web Key")
+
+// fromJWK returns the public key of a JSON Web Key, the other way around of
+// toJWK. Only keys that can verify JWTs are supported: RSA keys of at least
+// 2048 bits, P-256 keys and Ed25519 keys. Private keys are rejected.
+func fromJWK(raw []byte) (crypto.PublicKey, error) {
+	var key struct {
+		jwk
+		D string `json:"d"`
+	}
+	if err := json.Unmarshal(raw, &key); err != nil || key.D != "" {
+		return nil, errJWKInvalid
+	}
+
+	decode := base64.RawURLEncoding.DecodeString
+	switch key.KeyType {
+	case "OKP":
+		x, err := decode(key.X)
+		if err != nil || key.Curve != "Ed25519" || len(x) != ed25519.PublicKeySize {
+			return nil, errJWKInvalid
+		}
+		return ed25519.PublicKey(x), nil
+	case "RSA":
+		n, err := decode(key.N)
+		if err != nil {
+			return nil, errJWKInvalid
+		}
+		e, err := decode(key.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errJWKInvalid
+		}
+
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if pub.N.BitLen() < 2048 || pub.E < 3 {
+			return nil, errJWKInvalid
+		}
+		return pub, nil
+	case "EC":
+		x, err := decode(key.X)
+		if err != nil || key.Curve != "P-256" || len(x) != 32 {
+			return nil, errJWKInvalid
+		}
+		y, err := decode(key.Y)
+		if err != nil || len(y) != 32 {
+			return nil, errJWKInvalid
+		}
+
+		// Makes sure the point is on the curve.
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, errJWKInvalid
+		}
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, errJWKInvalid
+}
+
+func padLeft(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
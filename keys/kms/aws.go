@@ -0,0 +1,178 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWSClient implements Client with AWS Key Management Service. Key
+// identifiers can be key IDs, key ARNs or alias ARNs.
+type AWSClient struct {
+	// AWS region where keys are stored. Example: us-east-1
+	Region string
+	// AWS credentials allowed to call kms:Sign and kms:GetPublicKey.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Only needed with temporary credentials.
+	SessionToken string
+	// HTTP client used to talk to AWS KMS. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// AWS KMS endpoint. Defaults to https://kms.<region>.amazonaws.com/
+	Endpoint string
+}
+
+// Sign implements Client.
+func (c *AWSClient) Sign(ctx context.Context, keyID string, alg Algorithm, digest []byte) ([]byte, error) {
+	signingAlg := "ECDSA_SHA_256"
+	if alg == RSAPKCS1SHA256 {
+		signingAlg = "RSASSA_PKCS1_V1_5_SHA_256"
+	}
+
+	body := map[string]string{
+		"KeyId":            keyID,
+		"Message":          base64.StdEncoding.EncodeToString(digest),
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": signingAlg,
+	}
+
+	var res struct {
+		Signature []byte
+	}
+
+	if err := c.do(ctx, "Sign", body, &res); err != nil {
+		return nil, err
+	}
+	return res.Signature, nil
+}
+
+// PublicKey implements Client.
+func (c *AWSClient) PublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	body := map[string]string{
+		"KeyId": keyID,
+	}
+
+	var res struct {
+		PublicKey []byte
+	}
+
+	if err := c.do(ctx, "GetPublicKey", body, &res); err != nil {
+		return nil, err
+	}
+	return res.PublicKey, nil
+}
+
+func (c *AWSClient) do(ctx context.Context, action string, body, v interface{}) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://kms." + c.Region + ".amazonaws.com/"
+	}
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	signV4(req, payload, c.AccessKeyID, c.SecretAccessKey, c.Region, "kms", time.Now())
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms: unexpected response %s from AWS KMS", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// signV4 signs a request in accordance with
+// http://docs.aws.amazon.com/general/latest/gr/signature-version-4.html
+func signV4(req *http.Request, payload []byte, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{
+		"host": req.URL.Host,
+	}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders string
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// GCPClient implements Client with Google Cloud KMS. Key identifiers are
+// key version resource names, such as:
+// projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1
+type GCPClient struct {
+	// Token returns an OAuth2 access token with the cloudkms scope.
+	Token func(ctx context.Context) (string, error)
+	// HTTP client used to talk to Cloud KMS. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// Cloud KMS API endpoint. Defaults to https://cloudkms.googleapis.com/v1/
+	Endpoint string
+}
+
+// Sign implements Client.
+func (c *GCPClient) Sign(ctx context.Context, keyID string, alg Algorithm, digest []byte) ([]byte, error) {
+	body := map[string]interface{}{
+		"digest": map[string]string{
+			"sha256": base64.StdEncoding.EncodeToString(digest),
+		},
+	}
+
+	var res struct {
+		Signature string `json:"signature"`
+	}
+
+	if err := c.do(ctx, "POST", keyID+":asymmetricSign", body, &res); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Signature)
+}
+
+// PublicKey implements Client.
+func (c *GCPClient) PublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	var res struct {
+		PEM string `json:"pem"`
+	}
+
+	if err := c.do(ctx, "GET", keyID+"/publicKey", nil, &res); err != nil {
+		return nil, err
+	}
+	return []byte(res.PEM), nil
+}
+
+func (c *GCPClient) do(ctx context.Context, method, path string, body, v interface{}) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://cloudkms.googleapis.com/v1/"
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(endpoint, "/")+"/"+path, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	if c.Token != nil {
+		token, err := c.Token(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("kms: unexpected response %s from Cloud KMS", res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,179 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package kms adapts keys stored in cloud key management services to
+// crypto.Signer, so they can be used to sign the JWTs issued by the
+// authorization server without private keys ever leaving the service:
+//
+//	signer := kms.NewSigner(&kms.AWSClient{Region: "us-east-1", ...}, keyARN, 2*time.Second)
+//	oauth2.SetSigningKey(types.SigningKey{ID: "aws-1", Algorithm: "ES256", Signer: signer})
+//
+// Only SHA-256 based RSA PKCS #1 v1.5 and ECDSA P-256 keys are supported.
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrUnsupportedKey  = errors.New("kms: unsupported key type")
+	ErrUnsupportedHash = errors.New("kms: only SHA-256 digests are supported")
+)
+
+// Algorithm identifies the signing algorithm of a key.
+type Algorithm string
+
+// Signing algorithms supported.
+const (
+	RSAPKCS1SHA256 Algorithm = "RSA_PKCS1_SHA256"
+	ECDSASHA256    Algorithm = "ECDSA_SHA256"
+)
+
+// Client is the subset of a key management service API needed to sign.
+// AWSClient and GCPClient implement it.
+type Client interface {
+	// Sign signs a SHA-256 digest. RSA signatures are PKCS #1 v1.5 and ECDSA
+	// signatures are ASN.1 encoded.
+	Sign(ctx context.Context, keyID string, alg Algorithm, digest []byte) ([]byte, error)
+
+	// PublicKey returns the public key, either PEM or DER encoded.
+	PublicKey(ctx context.Context, keyID string) ([]byte, error)
+}
+
+// Signer implements crypto.Signer with a key stored in a key management
+// service. Its public key is retrieved asynchronously when the signer is
+// created, so building a JWKS document does not wait on the service more
+// than needed.
+type Signer struct {
+	client  Client
+	keyID   string
+	timeout time.Duration
+
+	ready chan struct{}
+	pub   crypto.PublicKey
+	alg   Algorithm
+	err   error
+
+	mu      sync.Mutex
+	latency time.Duration
+}
+
+// NewSigner returns a signer for the given key. Every call to the key
+// management service is aborted after the given timeout.
+func NewSigner(client Client, keyID string, timeout time.Duration) *Signer {
+	s := &Signer{
+		client:  client,
+		keyID:   keyID,
+		timeout: timeout,
+		ready:   make(chan struct{}),
+	}
+
+	go s.fetchPublicKey()
+	return s
+}
+
+func (s *Signer) fetchPublicKey() {
+	defer close(s.ready)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	b, err := s.client.PublicKey(ctx, s.keyID)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(b)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		s.alg = RSAPKCS1SHA256
+	case *ecdsa.PublicKey:
+		s.alg = ECDSASHA256
+	default:
+		s.err = ErrUnsupportedKey
+		return
+	}
+	s.pub = pub
+}
+
+// PublicKey waits for the public key to be retrieved.
+func (s *Signer) PublicKey(ctx context.Context) (crypto.PublicKey, error) {
+	select {
+	case <-s.ready:
+		return s.pub, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Public implements crypto.Signer. It returns nil if the public key could
+// not be retrieved within the signer's timeout.
+func (s *Signer) Public() crypto.PublicKey {
+	pub, _ := s.PublicKey(context.Background())
+	return pub
+}
+
+// Sign implements crypto.Signer.
+func (s *Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return s.SignContext(context.Background(), digest, opts)
+}
+
+// SignContext signs the given digest, giving up when the context is done or
+// the signer's timeout expires, whatever happens first.
+func (s *Signer) SignContext(ctx context.Context, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, ErrUnsupportedHash
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	if _, err := s.PublicKey(ctx); err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	sig, err := s.client.Sign(ctx, s.keyID, s.alg, digest)
+	s.observe(time.Since(start))
+	return sig, err
+}
+
+// Latency returns the exponentially weighted moving average of the time
+// taken by signing requests, for monitoring and for tuning timeouts.
+func (s *Signer) Latency() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.latency
+}
+
+func (s *Signer) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.latency == 0 {
+		s.latency = d
+		return
+	}
+	s.latency = (s.latency*4 + d) / 5
+}
@@ -0,0 +1,134 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package kms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignV4 tests request signing with the "get-vanilla" case of the AWS
+// Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now, _ := time.Parse("20060102T150405Z", "20150830T123600Z")
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+
+	if auth := req.Header.Get("Authorization"); auth != expected {
+		t.Errorf("unexpected authorization header:\n\texp: %s\n\tgot: %s", expected, auth)
+	}
+}
+
+func TestGCPSigner(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyID := "projects/p/locations/global/keyRings/r/cryptoKeys/k/cryptoKeyVersions/1"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case req.URL.Path == "/"+keyID+"/publicKey":
+			der, _ := x509.MarshalPKIXPublicKey(key.Public())
+			json.NewEncoder(w).Encode(map[string]string{
+				"pem": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+			})
+		case strings.HasSuffix(req.URL.Path, ":asymmetricSign"):
+			var body struct {
+				Digest struct {
+					SHA256 []byte `json:"sha256"`
+				} `json:"digest"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+
+			sig, _ := key.Sign(rand.Reader, body.Digest.SHA256, crypto.SHA256)
+			json.NewEncoder(w).Encode(map[string]string{
+				"signature": base64.StdEncoding.EncodeToString(sig),
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	client := &GCPClient{
+		Endpoint: ts.URL,
+		Token: func(ctx context.Context) (string, error) {
+			return "access-token", nil
+		},
+	}
+
+	signer := NewSigner(client, keyID, time.Duration(2)*time.Second)
+	pub, ok := signer.Public().(*ecdsa.PublicKey)
+	if !ok || !pub.Equal(key.Public()) {
+		t.Fatalf("unexpected public key: %v", signer.Public())
+	}
+
+	digest := sha256.Sum256([]byte("payload"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		t.Error("invalid signature")
+	}
+
+	if signer.Latency() <= 0 {
+		t.Error("expected signing latency to be recorded")
+	}
+}
+
+// slowClient never answers within the signer's timeout.
+type slowClient struct{}
+
+func (slowClient) Sign(ctx context.Context, keyID string, alg Algorithm, digest []byte) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (slowClient) PublicKey(ctx context.Context, keyID string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestSignerTimeout(t *testing.T) {
+	signer := NewSigner(slowClient{}, "key", time.Duration(10)*time.Millisecond)
+
+	digest := sha256.Sum256([]byte("payload"))
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline to be exceeded, got %v", err)
+	}
+
+	if signer.Public() != nil {
+		t.Error("expected no public key")
+	}
+}
@@ -0,0 +1,258 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package vault implements oauth2.KeyProvider on top of the transit secrets
+// engine of HashiCorp Vault (https://www.vaultproject.io/docs/secrets/transit).
+// Private keys never leave Vault, tokens are signed by Vault itself.
+//
+// Only "rsa-2048", "rsa-4096" and "ecdsa-p256" transit keys are supported.
+// Every key version is exposed as a different key, so rotating the transit
+// key in Vault rotates the signing key without downtime: tokens signed with
+// previous versions can still be verified until those versions are trimmed.
+package vault
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Errors
+var (
+	ErrUnsupportedKeyType = errors.New("vault: unsupported transit key type")
+	ErrUnsupportedHash    = errors.New("vault: only SHA-256 digests are supported")
+)
+
+// KeyProvider provides signing keys stored in Vault's transit secrets engine.
+type KeyProvider struct {
+	// Vault's address. Example: https://vault.example.com:8200
+	Address string
+	// Vault token allowed to read the key and to sign with it.
+	Token string
+	// Path where the transit engine is mounted. Defaults to "transit".
+	Mount string
+	// Name of the transit key.
+	KeyName string
+	// HTTP client used to talk to Vault. Defaults to http.DefaultClient.
+	Client *http.Client
+	// How long public keys are cached before asking Vault again. Defaults to 5 minutes.
+	CacheTTL time.Duration
+
+	mu            sync.Mutex
+	keys          []types.PublicKey
+	latest        types.PublicKey
+	latestVersion int
+	fetchedAt     time.Time
+}
+
+// New returns a KeyProvider for the given transit key.
+func New(address, token, keyName string) *KeyProvider {
+	return &KeyProvider{
+		Address: address,
+		Token:   token,
+		KeyName: keyName,
+	}
+}
+
+// SigningKey returns a signer backed by the latest version of the transit key.
+func (k *KeyProvider) SigningKey() (types.SigningKey, error) {
+	if _, err := k.PublicKeys(); err != nil {
+		return types.SigningKey{}, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return types.SigningKey{
+		ID:        k.latest.ID,
+		Algorithm: k.latest.Algorithm,
+		Signer: &signer{
+			provider: k,
+			version:  k.latestVersion,
+			public:   k.latest.Key,
+		},
+	}, nil
+}
+
+// PublicKeys returns the public keys of all the versions of the transit key.
+// They are cached to allow serving JWKS documents without hitting Vault.
+func (k *KeyProvider) PublicKeys() ([]types.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	ttl := k.CacheTTL
+	if ttl <= 0 {
+		ttl = time.Duration(5) * time.Minute
+	}
+
+	if k.keys != nil && time.Since(k.fetchedAt) < ttl {
+		return k.keys, nil
+	}
+
+	var res struct {
+		Data struct {
+			Type          string `json:"type"`
+			LatestVersion int    `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+
+	if err := k.do("GET", "keys/"+k.KeyName, nil, &res); err != nil {
+		return nil, err
+	}
+
+	var alg string
+	switch res.Data.Type {
+	case "rsa-2048", "rsa-4096":
+		alg = "RS256"
+	case "ecdsa-p256":
+		alg = "ES256"
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+
+	keys := make([]types.PublicKey, 0, len(res.Data.Keys))
+	var latest types.PublicKey
+	for v, key := range res.Data.Keys {
+		block, _ := pem.Decode([]byte(key.PublicKey))
+		if block == nil {
+			return nil, fmt.Errorf("vault: invalid public key for version %s", v)
+		}
+
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		pk := types.PublicKey{
+			ID:        k.KeyName + ":" + v,
+			Algorithm: alg,
+			Key:       pub,
+		}
+		keys = append(keys, pk)
+
+		if v == strconv.Itoa(res.Data.LatestVersion) {
+			latest = pk
+		}
+	}
+
+	if latest.Key == nil {
+		return nil, fmt.Errorf("vault: latest version of key %s not found", k.KeyName)
+	}
+
+	sort.Sort(byID(keys))
+	k.keys = keys
+	k.latest = latest
+	k.latestVersion = res.Data.LatestVersion
+	k.fetchedAt = time.Now()
+	return keys, nil
+}
+
+// do sends a request to Vault's transit API.
+func (k *KeyProvider) do(method, path string, body interface{}, v interface{}) error {
+	mount := k.Mount
+	if mount == "" {
+		mount = "transit"
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	u := strings.TrimSuffix(k.Address, "/") + "/v1/" + mount + "/" + path
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", k.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := k.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault: unexpected response %s from %s", res.Status, u)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// signer implements crypto.Signer with a specific version of a transit key.
+type signer struct {
+	provider *KeyProvider
+	version  int
+	public   crypto.PublicKey
+}
+
+func (s *signer) Public() crypto.PublicKey {
+	return s.public
+}
+
+// Sign asks Vault to sign the given SHA-256 digest. RSA signatures use
+// PKCS #1 v1.5 and ECDSA signatures are ASN.1 encoded, as crypto.Signer
+// implementations of the standard library do.
+func (s *signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if opts.HashFunc() != crypto.SHA256 {
+		return nil, ErrUnsupportedHash
+	}
+
+	body := map[string]interface{}{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"key_version":          s.version,
+		"signature_algorithm":  "pkcs1v15",
+		"marshaling_algorithm": "asn1",
+	}
+
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+
+	if err := s.provider.do("POST", "sign/"+s.provider.KeyName+"/sha2-256", body, &res); err != nil {
+		return nil, err
+	}
+
+	// Signatures look like vault:v1:<base64 signature>
+	parts := strings.Split(res.Data.Signature, ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("vault: unexpected signature format: %s", res.Data.Signature)
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+type byID []types.PublicKey
+
+func (b byID) Len() int           { return len(b) }
+func (b byID) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byID) Less(i, j int) bool { return b[i].ID < b[j].ID }
@@ -0,0 +1,122 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package vault
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// fakeVault emulates the subset of the transit engine API used by KeyProvider.
+func fakeVault(t *testing.T, keys map[string]*ecdsa.PrivateKey, latest int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Vault-Token") != "s3cr3t" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch req.URL.Path {
+		case "/v1/transit/keys/tokens":
+			versions := make(map[string]interface{})
+			for v, key := range keys {
+				der, err := x509.MarshalPKIXPublicKey(key.Public())
+				if err != nil {
+					t.Fatal(err)
+				}
+				versions[v] = map[string]string{
+					"public_key": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+				}
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]interface{}{
+					"type":           "ecdsa-p256",
+					"latest_version": latest,
+					"keys":           versions,
+				},
+			})
+		case "/v1/transit/sign/tokens/sha2-256":
+			var body struct {
+				Input      string `json:"input"`
+				KeyVersion int    `json:"key_version"`
+			}
+			json.NewDecoder(req.Body).Decode(&body)
+			digest, _ := base64.StdEncoding.DecodeString(body.Input)
+
+			key := keys[strconv.Itoa(body.KeyVersion)]
+			sig, err := key.Sign(rand.Reader, digest, crypto.SHA256)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"data": map[string]string{
+					"signature": "vault:v1:" + base64.StdEncoding.EncodeToString(sig),
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestKeyProvider(t *testing.T) {
+	keys := make(map[string]*ecdsa.PrivateKey)
+	for _, v := range []string{"1", "2"} {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[v] = key
+	}
+
+	ts := fakeVault(t, keys, 2)
+	defer ts.Close()
+
+	kp := New(ts.URL, "s3cr3t", "tokens")
+
+	pubKeys, err := kp.PublicKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pubKeys) != 2 || pubKeys[0].ID != "tokens:1" || pubKeys[1].ID != "tokens:2" {
+		t.Fatalf("unexpected public keys: %+v", pubKeys)
+	}
+
+	signingKey, err := kp.SigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signingKey.ID != "tokens:2" || signingKey.Algorithm != "ES256" {
+		t.Fatalf("unexpected signing key: %+v", signingKey)
+	}
+
+	digest := sha256.Sum256([]byte("payload"))
+	sig, err := signingKey.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ecdsa.VerifyASN1(&keys["2"].PublicKey, digest[:], sig) {
+		t.Error("signature was not produced by the latest key version")
+	}
+
+	// Public keys are served from cache.
+	ts.Close()
+	if _, err := kp.PublicKeys(); err != nil {
+		t.Errorf("expected public keys to be cached: %v", err)
+	}
+}
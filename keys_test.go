@@ -0,0 +1,99 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestJWKS tests that public keys are published as a JSON Web Key Set.
+func TestJWKS(t *testing.T) {
+	cfg := setupTest()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})(&cfg)
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/jwks", nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	JWKS(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	keySet := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &keySet))
+	equals(t, 1, len(keySet.Keys))
+	equals(t, "EC", keySet.Keys[0].KeyType)
+	equals(t, "P-256", keySet.Keys[0].Curve)
+	equals(t, "1", keySet.Keys[0].KeyID)
+	equals(t, 43, len(keySet.Keys[0].X))
+}
+
+// TestAccessTokenSigningAlgorithm tests that JWT access tokens are signed
+// with the algorithm set for them, if allowed, and not with the one the client
+// registered for ID tokens.
+func TestAccessTokenSigningAlgorithm(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	ok(t, err)
+
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.TokenFormat = types.TokenFormatJWT
+	provider.Client.IDTokenSignedResponseAlg = jwt.ES256
+	cfg.provider = provider
+	SetAccessTokenSigningAlgorithm(jwt.EdDSA)(&cfg)
+	SetSigningKeys(
+		types.SigningKey{ID: "ec", Algorithm: jwt.ES256, Signer: ecKey},
+		types.SigningKey{ID: "ed", Algorithm: jwt.EdDSA, Signer: edKey},
+	)(&cfg)
+
+	issueToken := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	w := issueToken()
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	parsed, err := jwt.Parse(token.Value)
+	ok(t, err)
+	equals(t, jwt.EdDSA, parsed.Header.Algorithm)
+	equals(t, "ed", parsed.Header.KeyID)
+	ok(t, verifySignature(cfg, parsed))
+
+	// Algorithms not allowed are neither used nor accepted.
+	SetSigningAlgorithms(jwt.ES256)(&cfg)
+	equals(t, http.StatusInternalServerError, issueToken().Code)
+	equals(t, ErrSigningAlgorithmNotAllowed, verifySignature(cfg, parsed))
+
+	cfg.accessTokenAlg = ""
+	equals(t, http.StatusOK, issueToken().Code)
+}
@@ -0,0 +1,87 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// SetMessages customizes or translates the descriptions of errors sent back
+// by this package. Messages are keyed by the error's MessageID, or by its
+// error code for errors without one, such as:
+//
+//	SetMessages("es", map[string]string{
+//		"redirect_uri_mismatch": "La redirect_uri no coincide con la registrada para el cliente.",
+//		"invalid_scope":         "El alcance solicitado excede el otorgado.",
+//	})
+//
+// The language is negotiated using the ui_locales request parameter and the
+// Accept-Language header. Messages set for the "" language are used when
+// none of the requested languages is available. Errors missing in the
+// catalog keep their default English description.
+func SetMessages(lang string, messages map[string]string) option {
+	return func(c *config) {
+		if c.messages == nil {
+			c.messages = make(map[string]map[string]string)
+		}
+		c.messages[strings.ToLower(lang)] = messages
+	}
+}
+
+// localize replaces the error description with the message in the language
+// preferred by the client or resource owner, if there is one.
+func localize(req *http.Request, cfg config, err types.AuthzError) types.AuthzError {
+	if cfg.messages == nil {
+		return err
+	}
+
+	messages := cfg.messages[negotiateLang(req, cfg)]
+	id := err.MessageID
+	if id == "" {
+		id = err.Code
+	}
+
+	if msg, ok := messages[id]; ok {
+		err.Description = msg
+	}
+	return err
+}
+
+// negotiateLang returns the first language requested that has messages,
+// falling back to the primary language subtag, e.g. "es" for "es-CO".
+func negotiateLang(req *http.Request, cfg config) string {
+	// http://openid.net/specs/openid-connect-core-1_0.html#AuthRequest
+	requested := strings.Fields(req.URL.Query().Get("ui_locales"))
+
+	// http://tools.ietf.org/html/rfc7231#section-5.3.5, clients usually send
+	// languages sorted by preference, so weights are not taken into account.
+	for _, lang := range strings.Split(req.Header.Get("Accept-Language"), ",") {
+		if i := strings.Index(lang, ";"); i >= 0 {
+			lang = lang[:i]
+		}
+		requested = append(requested, strings.TrimSpace(lang))
+	}
+
+	for _, lang := range requested {
+		lang = strings.ToLower(lang)
+		if lang == "" {
+			continue
+		}
+
+		if _, ok := cfg.messages[lang]; ok {
+			return lang
+		}
+
+		if i := strings.Index(lang, "-"); i > 0 {
+			if _, ok := cfg.messages[lang[:i]]; ok {
+				return lang[:i]
+			}
+		}
+	}
+	return ""
+}
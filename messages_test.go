@@ -0,0 +1,73 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+func TestMessages(t *testing.T) {
+	cfg, authzCode := getTestAuthzCode(t)
+	SetMessages("es", map[string]string{
+		"grant_client_id_mismatch": "El código fue generado para otro cliente.",
+	})(&cfg)
+	SetMessages("", map[string]string{
+		"invalid_grant": "Custom message.",
+	})(&cfg)
+
+	tests := []struct {
+		lang        string
+		description string
+	}{
+		{"es-CO, es;q=0.9", "El código fue generado para otro cliente."},
+		{"fr", "Grant code was generated for a different client ID."},
+	}
+
+	for _, tt := range tests {
+		req := AuthzGrantTokenRequestTest(t, "authorization_code", authzCode)
+		req.SetBasicAuth("boo", "boo")
+		req.Header.Set("Accept-Language", tt.lang)
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+
+		authzErr := types.AuthzError{}
+		err := json.Unmarshal(w.Body.Bytes(), &authzErr)
+		ok(t, err)
+		equals(t, "invalid_grant", authzErr.Code)
+		equals(t, tt.description, authzErr.Description)
+	}
+}
+
+func TestNegotiateLang(t *testing.T) {
+	cfg := setupTest()
+	SetMessages("es", map[string]string{})(&cfg)
+	SetMessages("pt-BR", map[string]string{})(&cfg)
+
+	tests := []struct {
+		uiLocales      string
+		acceptLanguage string
+		expected       string
+	}{
+		{"", "", ""},
+		{"", "fr, es;q=0.8", "es"},
+		{"", "es-MX", "es"},
+		{"", "pt-br", "pt-br"},
+		{"pt-BR", "es", "pt-br"},
+		{"fr", "", ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?ui_locales="+tt.uiLocales, nil)
+		ok(t, err)
+		req.Header.Set("Accept-Language", tt.acceptLanguage)
+		equals(t, tt.expected, negotiateLang(req, cfg))
+	}
+}
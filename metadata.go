@@ -0,0 +1,172 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/hooklift/oauth2/internal/jwe"
+	"github.com/hooklift/oauth2/internal/render"
+)
+
+// ChangePasswordPath is the well-known URL password managers send resource
+// owners to in order to change their password.
+// https://w3c.github.io/webappsec-change-password-url/
+const ChangePasswordPath = "/.well-known/change-password"
+
+// MetadataHandlers is a map to functions where each function handles a particular HTTP
+// verb or method of the authorization server metadata endpoint.
+var MetadataHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET": Metadata,
+}
+
+// ChangePasswordHandlers is a map to functions where each function handles a particular HTTP
+// verb or method of the well-known change password URL.
+var ChangePasswordHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
+	"GET": ChangePassword,
+}
+
+// SetMetadataEndpoint allows setting the endpoint publishing the authorization
+// server metadata. Defaults to "/.well-known/oauth-authorization-server", as
+// defined by http://tools.ietf.org/html/rfc8414#section-3
+func SetMetadataEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.metadataEndpoint = endpoint
+	}
+}
+
+// SetServiceDocumentation sets the URL of the documentation developers need
+// to know about in order to use the authorization server. It is published in
+// the metadata as "service_documentation".
+func SetServiceDocumentation(u string) option {
+	return func(c *config) {
+		c.documents.serviceDocumentation = u
+	}
+}
+
+// SetServerPolicies sets the URLs of the authorization server's policy on how
+// clients can use data about resource owners and of its terms of service.
+// They are published in the metadata as "op_policy_uri" and "op_tos_uri",
+// and linked from the default authorization form footer.
+func SetServerPolicies(policyURL, termsOfServiceURL string) option {
+	return func(c *config) {
+		c.documents.policyURL = policyURL
+		c.documents.termsOfServiceURL = termsOfServiceURL
+	}
+}
+
+// SetChangePasswordURL redirects ChangePasswordPath to the page where resource
+// owners change their password.
+func SetChangePasswordURL(u string) option {
+	return func(c *config) {
+		c.documents.changePasswordURL = u
+	}
+}
+
+// ServerDocuments defines the documents of the authorization server linked
+// from the authorization form.
+type ServerDocuments struct {
+	// Documentation for developers of 3rd-party client apps.
+	ServiceDocumentationURL string
+	// How 3rd-party client apps can use data about resource owners.
+	PolicyURL string
+	// Terms of service of the authorization server.
+	TermsOfServiceURL string
+}
+
+// serverDocuments returns the documents configured for the authorization server.
+func serverDocuments(cfg config) ServerDocuments {
+	return ServerDocuments{
+		ServiceDocumentationURL: cfg.documents.serviceDocumentation,
+		PolicyURL:               cfg.documents.policyURL,
+		TermsOfServiceURL:       cfg.documents.termsOfServiceURL,
+	}
+}
+
+// serverMetadata is the authorization server metadata defined by
+// http://tools.ietf.org/html/rfc8414#section-2
+type serverMetadata struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri,omitempty"`
+	IntrospectionEndpoint         string   `json:"introspection_endpoint"`
+	DeviceAuthorizationEndpoint   string   `json:"device_authorization_endpoint,omitempty"`
+	ResponseTypesSupported        []string `json:"response_types_supported"`
+	ResponseModesSupported        []string `json:"response_modes_supported"`
+	GrantTypesSupported           []string `json:"grant_types_supported"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+	ServiceDocumentation          string   `json:"service_documentation,omitempty"`
+	UILocalesSupported            []string `json:"ui_locales_supported,omitempty"`
+	OPPolicyURI                   string   `json:"op_policy_uri,omitempty"`
+	OPTosURI                      string   `json:"op_tos_uri,omitempty"`
+	// Request objects, as defined by http://tools.ietf.org/html/rfc9101#section-10.1
+	RequestParameterSupported                 bool     `json:"request_parameter_supported"`
+	RequestObjectSigningAlgValuesSupported    []string `json:"request_object_signing_alg_values_supported"`
+	RequestObjectEncryptionAlgValuesSupported []string `json:"request_object_encryption_alg_values_supported,omitempty"`
+	RequestObjectEncryptionEncValuesSupported []string `json:"request_object_encryption_enc_values_supported,omitempty"`
+	// DPoP proofs binding refresh tokens, as defined by http://tools.ietf.org/html/rfc9449#section-5.1
+	DPoPSigningAlgValuesSupported []string `json:"dpop_signing_alg_values_supported"`
+	// Client authentication methods, client_secret_basic if left out, as
+	// defined by http://tools.ietf.org/html/rfc8414#section-2
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Metadata publishes the authorization server metadata, so clients can
+// discover its endpoints and capabilities.
+func Metadata(w http.ResponseWriter, req *http.Request, cfg config) {
+	issuer := issuerURL(req, cfg)
+	caps := capabilities(cfg, now(cfg))
+
+	metadata := serverMetadata{
+		Issuer:                                 issuer,
+		AuthorizationEndpoint:                  issuer + cfg.authzEndpoint,
+		TokenEndpoint:                          issuer + cfg.tokenEndpoint,
+		IntrospectionEndpoint:                  issuer + cfg.introspectionEndpoint,
+		ResponseTypesSupported:                 caps.ResponseTypes,
+		ResponseModesSupported:                 caps.ResponseModes,
+		GrantTypesSupported:                    caps.GrantTypes,
+		TokenEndpointAuthMethodsSupported:      caps.TokenEndpointAuthMethods,
+		CodeChallengeMethodsSupported:          caps.CodeChallengeMethods,
+		ServiceDocumentation:                   cfg.documents.serviceDocumentation,
+		OPPolicyURI:                            cfg.documents.policyURL,
+		OPTosURI:                               cfg.documents.termsOfServiceURL,
+		RequestParameterSupported:              true,
+		RequestObjectSigningAlgValuesSupported: signingAlgorithms(cfg),
+		DPoPSigningAlgValuesSupported:          signingAlgorithms(cfg),
+	}
+
+	if cfg.keyProvider != nil || cfg.requestObjectKey.Decrypter != nil {
+		metadata.JWKSURI = issuer + cfg.jwksEndpoint
+	}
+
+	if grantTypeAllowed(cfg, DeviceCodeGrantType) {
+		if _, ok := unwrap(cfg.provider).(DeviceCodeProvider); ok {
+			metadata.DeviceAuthorizationEndpoint = issuer + cfg.deviceEndpoint
+		}
+	}
+
+	if k := cfg.requestObjectKey; k.Decrypter != nil {
+		metadata.RequestObjectEncryptionAlgValuesSupported = []string{k.Algorithm}
+		metadata.RequestObjectEncryptionEncValuesSupported = jwe.Encryptions
+	}
+
+	for lang := range cfg.messages {
+		metadata.UILocalesSupported = append(metadata.UILocalesSupported, lang)
+	}
+	sort.Strings(metadata.UILocalesSupported)
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   metadata,
+	})
+}
+
+// ChangePassword redirects resource owners to the page where they change
+// their password.
+func ChangePassword(w http.ResponseWriter, req *http.Request, cfg config) {
+	http.Redirect(w, req, cfg.documents.changePasswordURL, http.StatusFound)
+}
@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestMetadata tests that the authorization server metadata publishes its
+// endpoints and documents.
+func TestMetadata(t *testing.T) {
+	provider := test.NewProvider(true)
+	handler := Handler(http.NotFoundHandler(),
+		SetProvider(provider),
+		SetServiceDocumentation("https://example.com/docs"),
+		SetServerPolicies("https://example.com/privacy", "https://example.com/tos"),
+	)
+
+	req, err := http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	metadata := make(map[string]interface{})
+	ok(t, json.Unmarshal(w.Body.Bytes(), &metadata))
+
+	equals(t, "https://example.com", metadata["issuer"])
+	equals(t, "https://example.com/oauth2/tokens", metadata["token_endpoint"])
+	equals(t, "https://example.com/docs", metadata["service_documentation"])
+	equals(t, "https://example.com/privacy", metadata["op_policy_uri"])
+	equals(t, "https://example.com/tos", metadata["op_tos_uri"])
+}
+
+// TestChangePassword tests that the well-known change password URL is only
+// served when configured.
+func TestChangePassword(t *testing.T) {
+	provider := test.NewProvider(true)
+
+	req, err := http.NewRequest("GET", "https://example.com"+ChangePasswordPath, nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	Handler(http.NotFoundHandler(), SetProvider(provider)).ServeHTTP(w, req)
+	equals(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	Handler(http.NotFoundHandler(), SetProvider(provider), SetChangePasswordURL("https://example.com/account/password")).ServeHTTP(w, req)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, "https://example.com/account/password", w.Header().Get("Location"))
+}
+
+// TestAuthzFormServerDocuments tests that the default authorization form links
+// to the authorization server's policy and terms of service.
+func TestAuthzFormServerDocuments(t *testing.T) {
+	provider := test.NewProvider(true)
+	handler := Handler(http.NotFoundHandler(),
+		SetProvider(provider),
+		SetServerPolicies("https://example.com/privacy", "https://example.com/tos"),
+	)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"scope":         {"read"},
+	}
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	for _, s := range []string{
+		`<a href="https://example.com/privacy">Privacy policy</a>`,
+		`<a href="https://example.com/tos">Terms of service</a>`,
+	} {
+		assert(t, strings.Contains(body, s), "'%s' was not found in %v", s, body)
+	}
+}
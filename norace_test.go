@@ -0,0 +1,11 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+//go:build !race
+
+package oauth2
+
+// raceEnabled tells whether tests run with the race detector, which
+// allocates on its own.
+const raceEnabled = false
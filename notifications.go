@@ -0,0 +1,118 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Notifier is told about events host applications may let resource owners
+// know about, such as a client authorized for the first time.
+type Notifier interface {
+	// Notify sends a notification. It is called synchronously, so it should
+	// not block for long.
+	Notify(n types.Notification)
+}
+
+// Locator resolves the approximate location of IP addresses included in
+// notifications, for instance, using a GeoIP database.
+type Locator interface {
+	// Locate returns a human readable location, such as "Lisbon, Portugal",
+	// or an empty string if unknown.
+	Locate(ip string) string
+}
+
+// DeviceProvider is an optional interface that providers can implement in
+// order to remember the devices tokens were issued to, so resource owners
+// are notified of tokens issued to new devices.
+type DeviceProvider interface {
+	// SeenDevice records that a token was issued to the resource owner on the
+	// given device and tells whether it was recorded before.
+	SeenDevice(userID, deviceID string) (bool, error)
+}
+
+// SetNotifier sets the notifier told when resource owners authorize a client
+// for the first time, which requires the provider to implement
+// ConsentProvider, and when tokens are issued to them on a new device, which
+// requires the provider to implement DeviceProvider. Devices are told apart
+// by user agent and IP address.
+func SetNotifier(n Notifier) option {
+	return func(c *config) {
+		c.notifier = n
+	}
+}
+
+// SetLocator sets the locator resolving the approximate location of the IP
+// addresses included in notifications.
+func SetLocator(l Locator) option {
+	return func(c *config) {
+		c.locator = l
+	}
+}
+
+// notify completes a notification with the request details and sends it to
+// the configured notifier, if any.
+func notify(req *http.Request, cfg config, n types.Notification) {
+	if cfg.notifier == nil {
+		return
+	}
+
+	n.IP = clientIP(req)
+	n.UserAgent = req.UserAgent()
+	if cfg.locator != nil {
+		n.Location = cfg.locator.Locate(n.IP)
+	}
+	n.Time = now(cfg)
+	n.RequestID = RequestID(req)
+	cfg.notifier.Notify(n)
+}
+
+// notifyNewDevice notifies the resource owner a token was issued to if the
+// request comes from a device not seen before. Failures are only logged, as
+// the token was issued anyway.
+func notifyNewDevice(req *http.Request, cfg config, client types.Client, token types.Token) {
+	provider, ok := asDeviceProvider(cfg.provider)
+	if cfg.notifier == nil || !ok || token.UserID == "" {
+		return
+	}
+
+	seen, err := provider.SeenDevice(token.UserID, deviceID(req))
+	if err != nil {
+		log.Printf("[WARN] request_id=%s Error looking up device: %+v", RequestID(req), err)
+		return
+	}
+
+	if seen {
+		return
+	}
+
+	notify(req, cfg, types.Notification{
+		Type:       types.NotificationNewDevice,
+		UserID:     token.UserID,
+		ClientID:   client.ID,
+		ClientName: client.Name,
+		Scopes:     token.Scopes,
+	})
+}
+
+// deviceID identifies the device a request comes from by its user agent and
+// IP address, hashed so providers do not store them.
+func deviceID(req *http.Request) string {
+	return tokengen.Hash(req.UserAgent() + "\x00" + clientIP(req))
+}
+
+// clientIP returns the IP address a request comes from.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,133 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// notifications keeps the notifications it is sent.
+type notifications []types.Notification
+
+func (n *notifications) Notify(notification types.Notification) {
+	*n = append(*n, notification)
+}
+
+type fixedLocator string
+
+func (l fixedLocator) Locate(ip string) string {
+	return string(l)
+}
+
+// TestNotifyNewClient tests that resource owners are notified the first time
+// they authorize a client only.
+func TestNotifyNewClient(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	sent := &notifications{}
+	SetNotifier(sent)(&cfg)
+	SetLocator(fixedLocator("Lisbon, Portugal"))(&cfg)
+
+	approve := func(scope string) {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {scope},
+			ConsentParam:    {ConsentApprove},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("User-Agent", "Browser/1.0")
+		req.RemoteAddr = "192.0.2.1:4321"
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusFound, w.Code)
+	}
+
+	approve("read")
+	approve("read write")
+	equals(t, 1, len(*sent))
+
+	n := (*sent)[0]
+	equals(t, types.NotificationNewClient, n.Type)
+	equals(t, "test_user", n.UserID)
+	equals(t, provider.Client.ID, n.ClientID)
+	equals(t, "Test Client", n.ClientName)
+	equals(t, "read", n.Scopes.Encode())
+	equals(t, "192.0.2.1", n.IP)
+	equals(t, "Browser/1.0", n.UserAgent)
+	equals(t, "Lisbon, Portugal", n.Location)
+}
+
+// deviceProvider issues tokens to a resource owner and remembers devices.
+type deviceProvider struct {
+	*test.Provider
+	devices map[string]bool
+}
+
+func (p *deviceProvider) GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	token, err := p.Provider.GenToken(grant, client, refreshToken, expiration)
+	token.UserID = "test_user"
+	return token, err
+}
+
+func (p *deviceProvider) SeenDevice(userID, deviceID string) (bool, error) {
+	key := userID + ":" + deviceID
+	seen := p.devices[key]
+	p.devices[key] = true
+	return seen, nil
+}
+
+// TestNotifyNewDevice tests that resource owners are notified of tokens
+// issued to them on devices not seen before.
+func TestNotifyNewDevice(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = &deviceProvider{Provider: test.NewProvider(true), devices: make(map[string]bool)}
+	sent := &notifications{}
+	SetNotifier(sent)(&cfg)
+
+	issue := func(userAgent string) {
+		values := url.Values{
+			"grant_type": {"password"},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("User-Agent", userAgent)
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+	}
+
+	issue("CLI/1.0")
+	issue("CLI/1.0")
+	equals(t, 1, len(*sent))
+
+	issue("TV/2.0")
+	equals(t, 2, len(*sent))
+	equals(t, types.NotificationNewDevice, (*sent)[1].Type)
+	equals(t, "test_user", (*sent)[1].UserID)
+	equals(t, "TV/2.0", (*sent)[1].UserAgent)
+}
@@ -8,6 +8,7 @@
 package oauth2
 
 import (
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
@@ -16,6 +17,11 @@ import (
 	"time"
 
 	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/redirecturi"
+	"github.com/hooklift/oauth2/replay"
+	"github.com/hooklift/oauth2/revocation"
+	"github.com/hooklift/oauth2/submission"
+	"github.com/hooklift/oauth2/tokengen"
 	"github.com/hooklift/oauth2/types"
 )
 
@@ -56,16 +62,22 @@ type Provider interface {
 	// previously issued based on that authorization code.  The authorization
 	// code is bound to the client identifier and redirection URI.
 	// -- http://tools.ietf.org/html/rfc6749#section-4.1.2
+	//
+	// The returned grant has to satisfy types.Grant.Validate, which
+	// types.NewGrant takes care of.
 	GenGrant(client types.Client, scopes types.Scopes, expiration time.Duration) (code types.Grant, err error)
 
 	// GenToken generates and stores access and refresh tokens with the given
-	// client information and authorization scope.
+	// client information and authorization scope. The returned token has to
+	// satisfy types.Token.Validate, which types.NewToken takes care of.
 	GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (token types.Token, err error)
 
 	// RevokeToken expires a specific token.
 	RevokeToken(token string) error
 
-	// RefreshToken refreshes an access token.
+	// RefreshToken refreshes an access token. The new access token has to
+	// satisfy types.Token.Validate. See ExpiringRefreshProvider for issuing
+	// it with the lifetime set by scope policies.
 	RefreshToken(refreshToken types.Token, scopes types.Scopes) (accessToken types.Token, err error)
 
 	// IsUserAuthenticated checks whether or not the resource owner has a valid session
@@ -73,22 +85,191 @@ type Provider interface {
 	IsUserAuthenticated() bool
 }
 
+// UserProvider is an optional interface that providers can implement to let
+// the oauth2 package know which resource owner is authenticated. It is required
+// by features that keep track of resource owners, such as consent receipts.
+type UserProvider interface {
+	// CurrentUser returns the resource owner authenticated in the given request.
+	CurrentUser(req *http.Request) (types.User, error)
+}
+
+// ErrUserProviderRequired is returned when a feature requires the provider to implement UserProvider.
+var ErrUserProviderRequired = errors.New("oauth2: provider does not implement oauth2.UserProvider")
+
+// currentUser returns the resource owner authenticated in the request.
+func currentUser(req *http.Request, cfg config) (types.User, error) {
+	provider, ok := asUserProvider(cfg.provider)
+	if !ok {
+		return types.User{}, ErrUserProviderRequired
+	}
+	return provider.CurrentUser(req)
+}
+
 // http://commandcenter.blogspot.com/2014/01/self-referential-functions-and-design.html
 type option func(*config)
 
 // Config defines the configuration struct for the oauth2 provider.
 type config struct {
-	authzEndpoint string
-	tokenEndpoint string
-	loginURL      struct {
+	authzEndpoint         string
+	tokenEndpoint         string
+	grantsEndpoint        string
+	jwksEndpoint          string
+	introspectionEndpoint string
+	metadataEndpoint      string
+	brokerEndpoint        string
+	loginURL              struct {
 		url           *url.URL
 		redirectParam string
 	}
-	stsMaxAge       time.Duration
-	authzForm       *template.Template
+	stsMaxAge   time.Duration
+	authzForm   *template.Template
+	displayCode struct {
+		redirectURI string
+		form        *template.Template
+	}
 	provider        Provider
 	authzExpiration time.Duration
 	tokenExpiration time.Duration
+	scopePolicies   map[string]scopePolicy
+	keyProvider     KeyProvider
+	rateLimit       limit
+	replayCache     replay.Cache
+	lockout         limit
+	clock           Clock
+	guard           guardConfig
+	policy          Policy
+	auditor         Auditor
+	// Audience URI of the resource server protected by AuthzHandler.
+	audience string
+	// Claims each caller receives when introspecting tokens.
+	introspectionClaims map[string][]string
+	// Error messages by language.
+	messages map[string]map[string]string
+	// Key signing authorization requests between the form and its approval.
+	authzRequestKey []byte
+	// Remembers approvals of the authorization form, to answer repeated ones.
+	submissionCache submission.Cache
+	// Key decrypting request objects encrypted by clients.
+	requestObjectKey types.DecryptionKey
+	// Version of the consent policy recorded in consent receipts.
+	consentPolicyVersion string
+	// Whether to quarantine grants when a client's redirect URL suspiciously changes.
+	redirectQuarantine bool
+	// How long soft-deleted clients keep their tokens. Zero deletes clients right away.
+	clientDeletionGrace time.Duration
+	// Maximum number of active refresh tokens per client and resource owner.
+	tokenQuota int
+	// Algorithms JWTs can be signed and verified with. Defaults to every supported one.
+	signingAlgorithms []string
+	// Algorithm JWT access tokens are signed with. Defaults to the one of the current signing key.
+	accessTokenAlg string
+	// Whether to skip the authorization form for scopes already approved.
+	rememberConsent bool
+	// How token uses are batched before being recorded.
+	usageTracking struct {
+		batchSize int
+		interval  time.Duration
+	}
+	// Records token uses accepted by AuthzHandler, if tracking is enabled.
+	usage *usageRecorder
+	// Tokens remembered by AuthzHandler, if caching is enabled.
+	tokenCache *TokenCache
+	// Propagates revocations of access tokens to AuthzHandler caches.
+	revocationBus revocation.Bus
+	// Background workers started and stopped along with the handler.
+	workers []Worker
+	// Told about events resource owners may be notified of.
+	notifier Notifier
+	// Resolves approximate locations of IP addresses in notifications.
+	locator Locator
+	// Time after which unused access tokens are rejected.
+	idleTimeout time.Duration
+	// Time after which unused refresh tokens are rejected.
+	refreshIdleTimeout time.Duration
+	// Generates authorization codes on behalf of the provider.
+	codeGenerator tokengen.Generator
+	// Whether codes and refresh tokens are looked up by their hash.
+	secretHashing struct {
+		enabled bool
+		migrate bool
+	}
+	// Prefixes of opaque access tokens and refresh tokens.
+	tokenPrefixes struct {
+		accessToken  string
+		refreshToken string
+	}
+	// Shared secrets of secret scanning partners, by partner.
+	scanningPartners map[string][]byte
+	// Device authorization endpoint, the page resource owners enter user
+	// codes at and its form, how user codes are generated, how long device
+	// codes last and how often devices poll.
+	deviceEndpoint             string
+	deviceVerificationEndpoint string
+	deviceForm                 *template.Template
+	userCode                   *tokengen.UserCode
+	deviceCodeExpiration       time.Duration
+	devicePollInterval         time.Duration
+	// How authorization requests without state are handled.
+	statePolicy StatePolicy
+	// Whether authorization code requests require a PKCE code challenge.
+	pkcePolicy PKCEPolicy
+	// Which clients can use the password grant.
+	passwordGrantPolicy PasswordGrantPolicy
+	// Whether refresh tokens are kept when refreshing access tokens, rather
+	// than rotated.
+	refreshRotationDisabled bool
+	// End of read-only mode, if set.
+	readOnlyUntil time.Time
+	// Grant types enabled, all of them if nil.
+	allowedGrantTypes map[string]bool
+	// How long requests can take and how many are handled at once.
+	overload overloadConfig
+	// Whether the behavior of OAuth 2.1 is enforced.
+	oauth21 bool
+	// Options overriding the configuration of single endpoints, by path.
+	endpointOptions map[string][]option
+	// Flows and endpoints being retired, by grant type or path, and how
+	// often clients still use them.
+	deprecations    map[string]Deprecation
+	deprecationUses *deprecationUses
+	// How often client endpoints are revalidated, and how long they may fail
+	// before clients are suspended.
+	clientRevalidation struct {
+		interval     time.Duration
+		suspendAfter time.Duration
+	}
+	// Scope given to authorization requests without one, if allowed.
+	defaultScope struct {
+		enabled bool
+		scope   string
+	}
+	// Redirect URIs accepted.
+	redirectPolicy redirecturi.Policy
+	// Deviations from the specs tolerated for legacy clients.
+	quirks Quirk
+	// File the authorization form was read from, if any.
+	authzFormFile string
+	// Whether to read the authorization form file again on every request.
+	reloadTemplates bool
+	// Whether to refuse configurations unsafe in production.
+	strict bool
+	// Validators of the extension parameters of authorization requests, by name.
+	extensionParams map[string]ExtensionValidator
+	// Delegates the login of resource owners to upstream providers, if set.
+	broker Broker
+	// How requests not sent to any endpoint are responded to.
+	unmatched Unmatched
+	// Verifies claimed HTTPS redirect URLs of native apps, if enabled.
+	appAssociations *redirecturi.AppAssociations
+	// Documents of the authorization server published in its metadata.
+	documents struct {
+		serviceDocumentation string
+		policyURL            string
+		termsOfServiceURL    string
+		changePasswordURL    string
+	}
+	// Issuer identifier of the authorization server, derived from requests if empty.
+	issuer string
 }
 
 // TokenEndpoint allows setting token endpoint. Defaults to "/oauth2/tokens".
@@ -127,6 +308,24 @@ func SetAuthzEndpoint(endpoint string) option {
 	}
 }
 
+// SetGrantsEndpoint allows setting the endpoint of the self-service grants API. Defaults to "/oauth2/grants".
+//
+// The grants API allows resource owners to review the authorizations they
+// have given to 3rd-party clients.
+func SetGrantsEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.grantsEndpoint = endpoint
+	}
+}
+
+// SetJWKSEndpoint allows setting the endpoint publishing the public keys of
+// the authorization server as a JSON Web Key Set. Defaults to "/oauth2/jwks".
+func SetJWKSEndpoint(endpoint string) option {
+	return func(c *config) {
+		c.jwksEndpoint = endpoint
+	}
+}
+
 // SetSTSMaxAge sets Strict Transport Security maximum age. Defaults to 1yr.
 func SetSTSMaxAge(maxAge time.Duration) option {
 	return func(c *config) {
@@ -135,6 +334,7 @@ func SetSTSMaxAge(maxAge time.Duration) option {
 }
 
 // SetAuthzForm sets authorization form to show to the resource owner.
+// Defaults to DefaultAuthzForm.
 func SetAuthzForm(form string) option {
 	return func(c *config) {
 		t := template.New("authzform")
@@ -144,6 +344,7 @@ func SetAuthzForm(form string) option {
 		}
 
 		c.authzForm = tpl
+		c.authzFormFile = ""
 	}
 }
 
@@ -186,21 +387,63 @@ func SetLoginURL(u, redirectParam string) option {
 // AuthzHandler is intended to be used at the resource server side to protect and validate
 // access to its resources. In accordance with http://tools.ietf.org/html/rfc6749#section-7
 // and http://tools.ietf.org/html/rfc6750
-func AuthzHandler(next http.Handler, provider Provider) http.Handler {
+//
+// Requests let through carry their access token, client and user, which next
+// gets with TokenFromContext, ClientFromContext and UserFromContext.
+//
+// Options other than SetClock, SetProviderTimeout, SetCircuitBreaker,
+// SetMessages, SetKeyProvider, SetAudience, SetUsageTracking, SetIdleTimeout,
+// SetTokenPrefixes, SetTokenCache, SetRevocationBus, SetWorker and SetOAuth21
+// are ignored. A KeyProvider is required to validate self-contained access tokens.
+//
+// Server.AuthzHandler builds one from the options of an authorization server
+// instead, so both enforce the same OAuth 2.1 mode.
+func AuthzHandler(next http.Handler, provider Provider, opts ...option) *ResourceServer {
 	if provider == nil {
 		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
 	}
 
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return newResourceServer(next, provider, cfg)
+}
+
+// newResourceServer returns a resource server validating access tokens with
+// the given provider and configuration.
+func newResourceServer(next http.Handler, provider Provider, cfg config) *ResourceServer {
+	provider = guard(provider, cfg)
+
+	if cfg.usageTracking.batchSize > 0 {
+		p, ok := asUsageProvider(provider)
+		if !ok {
+			log.Fatalln("An implementation of the oauth2.UsageProvider interface is expected")
+		}
+		cfg.usage = newUsageRecorder(p, cfg.usageTracking.batchSize, cfg.usageTracking.interval)
+		cfg.workers = append(cfg.workers, cfg.usage)
+	}
+
+	if cfg.tokenCache != nil && cfg.revocationBus != nil {
+		if err := cfg.revocationBus.Subscribe(cfg.tokenCache.revoked); err != nil {
+			log.Fatalf("Error subscribing to revocations: %v", err)
+		}
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = withRequestID(w, req)
+
 		var token string
 		auth := req.Header.Get("Authorization")
-		if auth == "" {
+		if auth == "" && cfg.oauth21 {
+			token = req.PostFormValue("access_token")
+		} else if auth == "" {
 			token = req.FormValue("access_token")
 		} else {
 			if !strings.HasPrefix(auth, "Bearer ") {
 				render.Unauthorized(w, render.Options{
 					Status: http.StatusUnauthorized,
-					Data:   ErrUnsupportedTokenType,
+					Data:   localize(req, cfg, ErrUnsupportedTokenType),
 				})
 				return
 			}
@@ -219,58 +462,126 @@ func AuthzHandler(next http.Handler, provider Provider) http.Handler {
 			return
 		}
 
+		// Self-contained tokens are validated right away, without looking them up.
+		if isJWT(token) && cfg.keyProvider != nil {
+			tokenInfo, err := verifyAccessToken(cfg, token)
+			if err != nil {
+				render.Unauthorized(w, render.Options{
+					Status: http.StatusUnauthorized,
+					Data:   localize(req, cfg, ErrInvalidToken),
+				})
+				return
+			}
+			checkScopes(w, req, cfg, provider, tokenInfo, next)
+			return
+		}
+
 		// Get token info from Authorizer
-		tokenInfo, err := provider.TokenInfo(token)
+		var tokenInfo types.Token
+		var err error
+		if cfg.tokenCache != nil {
+			tokenInfo, err = cfg.tokenCache.lookup(cfg, provider, unprefix(cfg.tokenPrefixes.accessToken, token))
+		} else {
+			tokenInfo, err = provider.TokenInfo(unprefix(cfg.tokenPrefixes.accessToken, token))
+		}
 		if err != nil {
 			render.Unauthorized(w, render.Options{
 				Status: http.StatusUnauthorized,
-				Data:   ErrServerError("", err),
+				Data:   serverError(req, cfg, "", err),
 			})
 			return
 		}
 
-		if tokenInfo.Status == types.TokenExpired || tokenInfo.Status == types.TokenRevoked {
+		expired := !tokenInfo.ExpiresAt.IsZero() && !now(cfg).Before(tokenInfo.ExpiresAt)
+		if tokenInfo.Value == "" || expired || tokenInfo.Status == types.TokenExpired || tokenInfo.Status == types.TokenRevoked {
 			render.Unauthorized(w, render.Options{
 				Status: http.StatusUnauthorized,
-				Data:   ErrInvalidToken,
+				Data:   localize(req, cfg, ErrInvalidToken),
 			})
 			return
 		}
 
-		// Get scopes information for the given resource
-		scopes, err := provider.ResourceScopes(req.URL)
-		if err != nil {
+		checkScopes(w, req, cfg, provider, tokenInfo, next)
+	})
+
+	return &ResourceServer{lifecycle: lifecycle{workers: cfg.workers}, handler: handler, oauth21: cfg.oauth21}
+}
+
+// checkScopes lets the request through if the token is meant for this
+// resource server, was not left idle and its scope covers the requested resource.
+func checkScopes(w http.ResponseWriter, req *http.Request, cfg config, provider Provider, tokenInfo types.Token, next http.Handler) {
+	if !audienceAllowed(tokenInfo, cfg.audience) {
+		render.Unauthorized(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   localize(req, cfg, ErrInvalidToken),
+		})
+		return
+	}
+
+	isIdle, err := idle(cfg, provider, tokenID(tokenInfo), cfg.idleTimeout)
+	if err != nil {
+		render.Unauthorized(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if isIdle {
+		render.Unauthorized(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   localize(req, cfg, ErrInvalidToken),
+		})
+		return
+	}
+
+	// Get scopes information for the given resource
+	scopes, err := provider.ResourceScopes(req.URL)
+	if err != nil {
+		render.Unauthorized(w, render.Options{
+			Status: http.StatusUnauthorized,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	// Check that token's scope covers the requested resource
+	resourceScopes := scopes.Encode()
+	for _, scope := range tokenInfo.Scopes {
+		if !strings.Contains(resourceScopes, scope.ID) {
 			render.Unauthorized(w, render.Options{
-				Status: http.StatusUnauthorized,
-				Data:   ErrServerError("", err),
+				Status: http.StatusForbidden,
+				Data:   localize(req, cfg, ErrInsufficientScope),
 			})
 			return
 		}
+	}
 
-		// Check that token's scope covers the requested resource
-		resourceScopes := scopes.Encode()
-		for _, scope := range tokenInfo.Scopes {
-			if !strings.Contains(resourceScopes, scope.ID) {
-				render.Unauthorized(w, render.Options{
-					Status: http.StatusForbidden,
-					Data:   ErrInsufficientScope,
-				})
-				return
-			}
-		}
+	if cfg.usage != nil {
+		cfg.usage.record(tokenUse(req, cfg, tokenInfo))
+	}
 
-		next.ServeHTTP(w, req)
-	})
+	next.ServeHTTP(w, withToken(req, provider, tokenInfo))
 }
 
 // Handler handles OAuth2 requests for getting authorization grants as well as
-// access and refresh tokens.
-func Handler(next http.Handler, opts ...option) http.Handler {
+// access and refresh tokens. Its configuration can be changed at runtime with
+// Server.Reload. Requests to other paths are sent to next, if any, unless
+// SetUnmatched says otherwise.
+func Handler(next http.Handler, opts ...option) *Server {
 	// Default configuration options.
 	cfg := config{
-		tokenEndpoint: "/oauth2/tokens",
-		authzEndpoint: "/oauth2/authzs",
-		stsMaxAge:     time.Duration(31536000) * time.Second, // 1yr
+		tokenEndpoint:              "/oauth2/tokens",
+		authzEndpoint:              "/oauth2/authzs",
+		grantsEndpoint:             "/oauth2/grants",
+		jwksEndpoint:               "/oauth2/jwks",
+		introspectionEndpoint:      "/oauth2/introspect",
+		metadataEndpoint:           "/.well-known/oauth-authorization-server",
+		brokerEndpoint:             "/oauth2/broker",
+		deviceEndpoint:             "/oauth2/device_authorizations",
+		deviceVerificationEndpoint: "/oauth2/device",
+		stsMaxAge:                  time.Duration(31536000) * time.Second, // 1yr
+		deprecationUses:            &deprecationUses{},
 	}
 
 	// Applies user's configuration.
@@ -278,33 +589,33 @@ func Handler(next http.Handler, opts ...option) http.Handler {
 		opt(&cfg)
 	}
 
-	if cfg.authzForm == nil {
-		log.Fatalln("Authorization form is required")
-	}
-
-	if cfg.provider == nil {
-		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
+	if cfg.clientRevalidation.interval > 0 {
+		p, ok := asClientCheckProvider(cfg.provider)
+		if !ok {
+			log.Fatalln("An implementation of the oauth2.ClientCheckProvider interface is expected")
+		}
+		if _, ok := unwrap(cfg.provider).(ClientLifecycleProvider); !ok && cfg.clientRevalidation.suspendAfter > 0 {
+			log.Fatalln("An implementation of the oauth2.ClientLifecycleProvider interface is expected")
+		}
+		cfg.workers = append(cfg.workers, newClientRevalidator(cfg, p))
 	}
 
-	// Keeps a registry of path function handlers for OAuth2 requests.
-	registry := map[string]map[string]func(http.ResponseWriter, *http.Request, config){
-		cfg.authzEndpoint: AuthzHandlers,
-		cfg.tokenEndpoint: TokenHandlers,
-	}
+	s := &Server{next: next}
+	s.workers = cfg.workers
+	s.current.Store(newSnapshot(cfg, nil))
+	return s
+}
 
-	// Locates and runs specific OAuth2 handler for request's method
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		for p, handlers := range registry {
-			if strings.HasPrefix(req.URL.Path, p) {
-				if handlerFn, ok := handlers[req.Method]; ok {
-					handlerFn(w, req, cfg)
-					return
-				}
-				w.WriteHeader(http.StatusMethodNotAllowed)
-				w.Write([]byte("Method Not Allowed"))
-				return
-			}
-		}
-		next.ServeHTTP(w, req)
-	})
+// route associates a path prefix with the handlers of its HTTP methods.
+type route struct {
+	path     string
+	handlers map[string]func(http.ResponseWriter, *http.Request, config)
+	// Configuration of the endpoint, if overridden with SetEndpointOptions.
+	cfg *config
 }
+
+type byPathLength []route
+
+func (r byPathLength) Len() int           { return len(r) }
+func (r byPathLength) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byPathLength) Less(i, j int) bool { return len(r[i].path) > len(r[j].path) }
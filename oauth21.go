@@ -0,0 +1,32 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+// SetOAuth21 enforces the behavior of OAuth 2.1, whatever the other options:
+//
+//   - The implicit and password grants are rejected as unsupported, and left
+//     out of the authorization server metadata.
+//   - Authorization code requests have to carry a PKCE code challenge, as with
+//     PKCERequired, unless PKCERequiredS256 is set.
+//   - Redirect URIs have to match the registered ones exactly, but for the
+//     port of loopback ones. QuirkRedirectTrailingSlash and the TrailingSlash
+//     redirect policy are ignored.
+//   - AuthzHandler only accepts access tokens in the Authorization header or
+//     the form-encoded body, never in the query string.
+//
+// Resource servers created with Server.AuthzHandler enforce it along with the
+// authorization server. It has to be given to the package-level AuthzHandler.
+// http://tools.ietf.org/html/draft-ietf-oauth-v2-1-13#section-10
+func SetOAuth21(enabled bool) option {
+	return func(c *config) {
+		c.oauth21 = enabled
+	}
+}
+
+// pkceRequired tells whether authorization code requests have to carry a
+// PKCE code challenge.
+func pkceRequired(cfg config) bool {
+	return cfg.pkcePolicy != PKCEOptional || cfg.oauth21
+}
@@ -0,0 +1,150 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestOAuth21 tests that OAuth 2.1 compliance mode rejects the implicit and
+// password grants, authorization code requests without PKCE and redirect
+// URIs not matching exactly, whatever the other options.
+func TestOAuth21(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetQuirks(QuirkRedirectTrailingSlash)(&cfg)
+	SetOAuth21(true)(&cfg)
+
+	authorize := func(responseType, redirectURI, challenge string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {responseType},
+			"redirect_uri":  {redirectURI},
+			"scope":         {"read"},
+			"state":         {"state-test"},
+		}
+		if challenge != "" {
+			values.Set("code_challenge", challenge)
+			values.Set("code_challenge_method", CodeChallengeS256)
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+	errorOf := func(w *httptest.ResponseRecorder) url.Values {
+		equals(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		if u.Fragment != "" {
+			values, err := url.ParseQuery(u.Fragment)
+			ok(t, err)
+			return values
+		}
+		return u.Query()
+	}
+
+	redirectURI := provider.Client.RedirectURL.String()
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	equals(t, http.StatusOK, authorize("code", redirectURI, challenge).Code)
+	equals(t, types.ErrorUnsupportedResponseType, errorOf(authorize("token", redirectURI, "")).Get("error"))
+	e := errorOf(authorize("code", redirectURI, ""))
+	equals(t, types.ErrorInvalidRequest, e.Get("error"))
+	assert(t, strings.Contains(e.Get("error_description"), "code_challenge"), "unexpected error %v", e)
+
+	w := authorize("code", redirectURI+"/", challenge)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrRedirectURLMismatch.Code), "unexpected response %s", w.Body.String())
+	equals(t, false, sameRedirectURI(cfg, redirectURI, redirectURI+"/"))
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+		bytes.NewBufferString("grant_type=password&username=test&password=test&scope=read"))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusBadRequest, w.Code)
+	var tokenErr types.AuthzError
+	ok(t, json.Unmarshal(w.Body.Bytes(), &tokenErr))
+	equals(t, types.ErrorUnsupportedGrantType, tokenErr.Code)
+}
+
+// TestOAuth21QueryToken tests that access tokens are not accepted in the
+// query string with OAuth 2.1 compliance mode.
+func TestOAuth21QueryToken(t *testing.T) {
+	provider := test.NewProvider(true)
+	token, err := provider.GenToken(types.Grant{Scopes: types.Scopes{{ID: "read"}}}, provider.Client, false, time.Hour)
+	ok(t, err)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	})
+	get := func(opts ...option) int {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource?access_token="+url.QueryEscape(token.Value), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		AuthzHandler(next, provider, opts...).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	equals(t, http.StatusOK, get())
+	equals(t, http.StatusUnauthorized, get(SetOAuth21(true)))
+}
+
+// TestOAuth21ResourceServer tests that resource servers created by an
+// authorization server enforce its OAuth 2.1 mode, and that Capabilities
+// panics once they no longer agree.
+func TestOAuth21ResourceServer(t *testing.T) {
+	provider := test.NewProvider(true)
+	token, err := provider.GenToken(types.Grant{Scopes: types.Scopes{{ID: "read"}}}, provider.Client, false, time.Hour)
+	ok(t, err)
+
+	s := Handler(nil, SetProvider(provider), SetOAuth21(true))
+	rs := s.AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	}), SetOAuth21(false))
+
+	get := func(query bool) int {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		if query {
+			req.URL.RawQuery = "access_token=" + url.QueryEscape(token.Value)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+token.Value)
+		}
+
+		w := httptest.NewRecorder()
+		rs.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	equals(t, http.StatusOK, get(false))
+	equals(t, http.StatusUnauthorized, get(true))
+	equals(t, true, s.Capabilities().Policies.OAuth21)
+
+	s.Reload(SetOAuth21(false))
+	defer func() {
+		r := recover()
+		assert(t, r != nil, "expected Capabilities to panic")
+		assert(t, strings.Contains(r.(string), "disagree"), "unexpected panic %v", r)
+	}()
+	s.Capabilities()
+}
@@ -0,0 +1,70 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// OOBRedirectURI is the redirect URI commonly registered by clients that are
+// unable to receive authorization codes through a redirect, such as CLI tools
+// running where no loopback listener is possible.
+const OOBRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// DisplayCodeData defines properties used to render the page showing the
+// authorization code to the resource owner, for her to copy it into the client.
+type DisplayCodeData struct {
+	// Client information.
+	Client types.Client
+	// Authorization code to copy.
+	Code string
+	// State sent by the client.
+	State string
+}
+
+// SetDisplayCodeForm enables the out-of-band mode for clients registered with
+// the given redirect URI, usually OOBRedirectURI. Instead of redirecting, the
+// authorization endpoint renders the given form with the authorization code
+// for the resource owner to paste it into the client.
+//
+// Only the authorization code flow is supported out-of-band, errors are
+// displayed to the resource owner instead of being sent to the client.
+func SetDisplayCodeForm(redirectURI, form string) option {
+	return func(c *config) {
+		t := template.New("displaycodeform")
+		tpl, err := t.Parse(form)
+		if err != nil {
+			log.Fatalf("Error parsing display code form: %v", err)
+		}
+
+		c.displayCode.redirectURI = redirectURI
+		c.displayCode.form = tpl
+	}
+}
+
+// isOOB returns whether the given redirect URI is the out-of-band one.
+func isOOB(cfg config, u *url.URL) bool {
+	return cfg.displayCode.form != nil && u.String() == cfg.displayCode.redirectURI
+}
+
+// displayCode renders the authorization code for the resource owner to copy it.
+func displayCode(w http.ResponseWriter, cfg config, authzData *AuthzData, code string) {
+	render.HTML(w, render.Options{
+		Status: http.StatusOK,
+		Data: DisplayCodeData{
+			Client: authzData.Client,
+			Code:   code,
+			State:  authzData.State,
+		},
+		Template:  cfg.displayCode.form,
+		STSMaxAge: cfg.stsMaxAge,
+	})
+}
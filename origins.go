@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// TokenPreflight answers CORS preflight requests to the token endpoint.
+// The client is not known until it authenticates, so browsers are allowed
+// to send token requests from any origin, but only the origins registered
+// by the client get to read the responses. See types.Client.AllowedOrigins.
+func TokenPreflight(w http.ResponseWriter, req *http.Request, cfg config) {
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	if origin := req.Header.Get("Origin"); origin != "" {
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Methods", "POST")
+		h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+		h.Set("Access-Control-Max-Age", "600")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allowOrigin lets the client's JavaScript apps read the response to
+// requests they sent from one of its registered origins.
+func allowOrigin(w http.ResponseWriter, req *http.Request, client types.Client) {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+
+	w.Header().Add("Vary", "Origin")
+	if originAllowed(client, normalizeOrigin(origin)) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	}
+}
+
+// formOriginAllowed tells whether the authorization form was submitted from
+// the authorization server itself, the origin of its issuer, as a defense
+// against cross-site request forgery. The Referer is checked if browsers
+// leave the Origin out, and forms without either are rejected. Origins of the
+// client are not allowed, or it could approve its own authorization requests
+// on behalf of resource owners. See SetIssuer.
+func formOriginAllowed(req *http.Request, cfg config) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		origin = req.Header.Get("Referer")
+	}
+
+	// Sandboxed documents send a "null" origin, which is never allowed.
+	origin = normalizeOrigin(origin)
+	return origin != "" && origin == normalizeOrigin(issuerURL(req, cfg))
+}
+
+// originAllowed tells whether the normalized origin was registered by the client.
+func originAllowed(client types.Client, origin string) bool {
+	if origin == "" {
+		return false
+	}
+
+	for _, o := range client.AllowedOrigins {
+		if normalizeOrigin(o) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeOrigin returns the scheme, host and port of a URL, lowercased and
+// without default port, or an empty string if it is not an HTTP(S) URL.
+func normalizeOrigin(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	switch scheme {
+	case "https":
+		host = strings.TrimSuffix(host, ":443")
+	case "http":
+		host = strings.TrimSuffix(host, ":80")
+	default:
+		return ""
+	}
+	return scheme + "://" + host
+}
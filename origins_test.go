@@ -0,0 +1,145 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestTokenCORS tests that only the origins registered by clients can read
+// token responses.
+func TestTokenCORS(t *testing.T) {
+	provider := test.NewProvider(true)
+	provider.Client.AllowedOrigins = []string{"https://App.example.com:443"}
+	cfg := setupTest()
+	cfg.provider = provider
+
+	req, err := http.NewRequest("OPTIONS", "https://example.com/oauth2/tokens", nil)
+	ok(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	w := httptest.NewRecorder()
+	TokenPreflight(w, req, cfg)
+	equals(t, http.StatusNoContent, w.Code)
+	equals(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+	equals(t, "Authorization, Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+
+	issueToken := func(origin string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials&scope=read"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", origin)
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+		equals(t, "Origin", w.Header().Get("Vary"))
+		return w
+	}
+
+	w = issueToken("https://app.example.com")
+	equals(t, "https://app.example.com", w.Header().Get("Access-Control-Allow-Origin"))
+
+	w = issueToken("https://evil.example.com")
+	equals(t, "", w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+// TestFormOrigin tests that authorization forms are only accepted from the
+// authorization server itself, not even from the client's origins, which
+// could otherwise approve their own authorization requests.
+func TestFormOrigin(t *testing.T) {
+	provider := test.NewProvider(true)
+	provider.Client.AllowedOrigins = []string{"https://app.example.com"}
+	cfg := setupTest()
+	cfg.provider = provider
+
+	tests := []struct {
+		origin  string
+		referer string
+		allowed bool
+	}{
+		{"https://example.com", "", true},
+		{"https://app.example.com", "", false},
+		{"", "https://example.com/oauth2/authzs?client_id=test", true},
+		{"", "https://app.example.com/", false},
+		{"", "", false},
+		{"https://evil.example.com", "", false},
+		{"", "https://evil.example.com/", false},
+		{"null", "", false},
+	}
+
+	for _, tt := range tests {
+		body := authzRequest(t, cfg).URL.RawQuery + "&" + ConsentParam + "=approve"
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.referer != "" {
+			req.Header.Set("Referer", tt.referer)
+		}
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		if !tt.allowed {
+			equals(t, http.StatusOK, w.Code)
+			assert(t, bytes.Contains(w.Body.Bytes(), []byte(types.ErrorAccessDenied)), "expected an error for %+v: %s", tt, w.Body)
+			continue
+		}
+
+		equals(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		assert(t, u.Query().Get("code") != "", "expected a code for %+v: %s", tt, u)
+	}
+}
+
+// TestFormOriginScheme tests that authorization forms are expected from the
+// scheme requests are received with, or from the issuer if set.
+func TestFormOriginScheme(t *testing.T) {
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+
+	submit := func(cfg config, host, origin string, header http.Header) bool {
+		body := authzRequest(t, cfg).URL.RawQuery + "&" + ConsentParam + "=approve"
+		req := httptest.NewRequest("POST", "/oauth2/authzs", bytes.NewBufferString(body))
+		req.Host = host
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", origin)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w.Code == http.StatusFound
+	}
+	forwarded := http.Header{"X-Forwarded-Proto": {"https"}}
+
+	equals(t, true, submit(cfg, "localhost:8080", "http://localhost:8080", nil))
+	equals(t, false, submit(cfg, "localhost:8080", "https://localhost:8080", nil))
+	equals(t, true, submit(cfg, "auth.example.com", "https://auth.example.com", forwarded))
+	equals(t, false, submit(cfg, "auth.example.com", "http://auth.example.com", forwarded))
+
+	// The issuer wins over a Host rewritten by a proxy.
+	SetIssuer("https://auth.example.com/")(&cfg)
+	equals(t, true, submit(cfg, "10.0.0.1:8080", "https://auth.example.com", nil))
+	equals(t, false, submit(cfg, "10.0.0.1:8080", "http://10.0.0.1:8080", nil))
+
+	req := httptest.NewRequest("GET", "/.well-known/oauth-authorization-server", nil)
+	w := httptest.NewRecorder()
+	Metadata(w, req, cfg)
+	assert(t, bytes.Contains(w.Body.Bytes(), []byte(`"issuer":"https://auth.example.com"`)), "unexpected metadata %s", w.Body)
+}
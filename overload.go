@@ -0,0 +1,175 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+)
+
+// SetOverloadProtection bounds how long Handler spends on each request and
+// how many requests it handles at once, so requests do not pile up behind a
+// slow storage backend or a traffic spike. Requests taking longer than the
+// deadline, and requests coming while the given number of requests are
+// already being handled, are answered with a temporarily_unavailable error
+// and a Retry-After header of the given duration, one second if zero.
+// Either limit can be zero to leave it out.
+//
+// Handlers still running past the deadline are left to finish in the
+// background, their responses discarded, and count as being handled until
+// then. Their request context is canceled at the deadline. See also
+// SetProviderTimeout, which bounds each Provider call.
+func SetOverloadProtection(deadline time.Duration, maxInFlight int, retryAfter time.Duration) option {
+	return func(c *config) {
+		c.overload.deadline = deadline
+		c.overload.maxInFlight = maxInFlight
+		c.overload.retryAfter = retryAfter
+	}
+}
+
+// overloadConfig defines how requests are shed on overload.
+type overloadConfig struct {
+	deadline    time.Duration
+	maxInFlight int
+	retryAfter  time.Duration
+}
+
+// serveWithinLimits handles the request if fewer than the maximum number of
+// requests are being handled, and answers it if the handler takes longer than
+// the deadline.
+func (s *Server) serveWithinLimits(w http.ResponseWriter, req *http.Request, cfg config, fn func(http.ResponseWriter, *http.Request, config)) {
+	o := cfg.overload
+	if o.maxInFlight > 0 {
+		if n := atomic.AddInt32(&s.inFlight, 1); n > int32(o.maxInFlight) {
+			atomic.AddInt32(&s.inFlight, -1)
+			log.Printf("[WARN] request_id=%s Request shed, %d requests already being handled", RequestID(req), n-1)
+			renderOverloaded(w, req, cfg)
+			return
+		}
+	}
+
+	release := func() {
+		if o.maxInFlight > 0 {
+			atomic.AddInt32(&s.inFlight, -1)
+		}
+	}
+
+	if o.deadline <= 0 {
+		defer release()
+		fn(w, req, cfg)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), o.deadline)
+	defer cancel()
+
+	dw := &deadlineWriter{header: make(http.Header)}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	go func() {
+		defer func() {
+			release()
+			if p := recover(); p != nil {
+				panicked <- p
+				return
+			}
+			close(done)
+		}()
+		fn(dw, req.WithContext(ctx), cfg)
+	}()
+
+	select {
+	case p := <-panicked:
+		panic(p)
+	case <-done:
+		dw.flush(w)
+	case <-ctx.Done():
+		dw.timeOut()
+		log.Printf("[WARN] request_id=%s Request not handled within %s: %v", RequestID(req), o.deadline, ctx.Err())
+		renderOverloaded(w, req, cfg)
+	}
+}
+
+// renderOverloaded answers requests shed on overload.
+func renderOverloaded(w http.ResponseWriter, req *http.Request, cfg config) {
+	retryAfter := cfg.overload.retryAfter
+	if retryAfter <= 0 {
+		retryAfter = time.Second
+	}
+
+	// Rounded up, so clients never retry too early.
+	w.Header().Set("Retry-After", strconv.FormatInt(int64((retryAfter+time.Second-1)/time.Second), 10))
+	render.JSON(w, render.Options{
+		Status: http.StatusServiceUnavailable,
+		Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+	})
+}
+
+// deadlineWriter buffers the response of a handler running with a deadline,
+// to be sent if it finishes in time, and discarded otherwise.
+type deadlineWriter struct {
+	header http.Header
+
+	mu       sync.Mutex
+	code     int
+	body     bytes.Buffer
+	timedOut bool
+}
+
+func (d *deadlineWriter) Header() http.Header {
+	return d.header
+}
+
+func (d *deadlineWriter) WriteHeader(code int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timedOut || d.code != 0 {
+		return
+	}
+	d.code = code
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	return d.body.Write(p)
+}
+
+// timeOut makes the following writes fail.
+func (d *deadlineWriter) timeOut() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.timedOut = true
+}
+
+// flush sends the buffered response, once the handler finished.
+func (d *deadlineWriter) flush(w http.ResponseWriter) {
+	h := w.Header()
+	for k, v := range d.header {
+		h[k] = v
+	}
+
+	if d.code == 0 {
+		d.code = http.StatusOK
+	}
+	w.WriteHeader(d.code)
+	w.Write(d.body.Bytes())
+}
@@ -0,0 +1,64 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestOverloadProtection tests that requests are answered with 503 and a
+// Retry-After header when taking longer than the deadline, or when too many
+// requests are already being handled.
+func TestOverloadProtection(t *testing.T) {
+	provider := &slowProvider{Provider: test.NewProvider(true)}
+	s := Handler(nil,
+		SetProvider(provider),
+		SetTokenExpiration(time.Hour),
+		SetOverloadProtection(50*time.Millisecond, 1, 1500*time.Millisecond),
+	)
+
+	issueToken := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	overloaded := func(w *httptest.ResponseRecorder) {
+		equals(t, http.StatusServiceUnavailable, w.Code)
+		equals(t, "2", w.Header().Get("Retry-After"))
+		var e types.AuthzError
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		equals(t, types.ErrorTemporarilyUnavailable, e.Code)
+	}
+
+	w := issueToken()
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "no-store", w.Header().Get("Cache-Control"))
+
+	atomic.StoreInt64(&provider.delay, int64(200*time.Millisecond))
+	overloaded(issueToken())
+
+	// The request past its deadline is still being handled.
+	overloaded(issueToken())
+	equals(t, int64(2), atomic.LoadInt64(&provider.calls))
+
+	atomic.StoreInt64(&provider.delay, 0)
+	time.Sleep(250 * time.Millisecond)
+	equals(t, http.StatusOK, issueToken().Code)
+}
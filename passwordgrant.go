@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// ResourceOwnerAuthenticator is an optional interface that providers can
+// implement in order to identify the resource owners authenticating with
+// the password grant. Otherwise Provider.AuthenticateUser is called and
+// usernames are taken as user IDs.
+type ResourceOwnerAuthenticator interface {
+	// AuthenticateResourceOwner authenticates a resource owner with their
+	// credentials, returning ErrInvalidCredentials if they do not match. The
+	// returned user is given to the policy and set as the UserID of the
+	// grant tokens are issued with. Its AMR defaults to "pwd".
+	AuthenticateResourceOwner(username, password string) (types.User, error)
+}
+
+// PasswordGrantPolicy tells which clients can use the resource owner
+// password credentials grant. Resource owners give their password to the
+// client itself, so it is meant for highly-trusted first-party apps only.
+// http://tools.ietf.org/html/rfc6749#section-4.3
+type PasswordGrantPolicy int
+
+const (
+	// PasswordGrantAllowed lets any client use the password grant. It is the
+	// default.
+	PasswordGrantAllowed PasswordGrantPolicy = iota
+	// PasswordGrantFirstParty only lets clients registered as first-party
+	// apps, see types.Client.FirstParty, use the password grant.
+	PasswordGrantFirstParty
+	// PasswordGrantDisabled rejects the password grant as unsupported, and
+	// leaves it out of the authorization server metadata.
+	PasswordGrantDisabled
+)
+
+// SetPasswordGrantPolicy sets which clients can use the resource owner
+// password credentials grant. Defaults to PasswordGrantAllowed.
+func SetPasswordGrantPolicy(p PasswordGrantPolicy) option {
+	return func(c *config) {
+		c.passwordGrantPolicy = p
+	}
+}
+
+// passwordGrantAllowed tells whether the client can use the password grant
+// according to the password grant policy.
+func passwordGrantAllowed(cfg config, client types.Client) bool {
+	switch cfg.passwordGrantPolicy {
+	case PasswordGrantFirstParty:
+		return client.FirstParty
+	case PasswordGrantDisabled:
+		return false
+	default:
+		return true
+	}
+}
+
+// authenticateResourceOwner authenticates a resource owner with their
+// credentials, returning whether they match.
+func authenticateResourceOwner(cfg config, username, password string) (types.User, bool, error) {
+	p, ok := asResourceOwnerAuthenticator(cfg.provider)
+	if !ok {
+		valid, err := checkUser(cfg.provider, func() bool {
+			return unwrap(cfg.provider).AuthenticateUser(username, password)
+		})
+		if err != nil || !valid {
+			return types.User{}, false, err
+		}
+		return types.User{ID: username, AMR: []string{"pwd"}}, true, nil
+	}
+
+	user, err := p.AuthenticateResourceOwner(username, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return types.User{}, false, nil
+	}
+	if err != nil {
+		return types.User{}, false, err
+	}
+
+	if len(user.AMR) == 0 {
+		user.AMR = []string{"pwd"}
+	}
+	return user, true, nil
+}
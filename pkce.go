@@ -0,0 +1,94 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"github.com/hooklift/oauth2/types"
+)
+
+// PKCEPolicy tells whether authorization code requests have to carry a PKCE
+// code challenge, as described in http://tools.ietf.org/html/rfc7636. Code
+// challenges are bound to authorization codes, which requires the provider
+// to implement BoundGrantProvider.
+type PKCEPolicy int
+
+const (
+	// PKCEOptional accepts authorization code requests with or without code
+	// challenge. It is the default.
+	PKCEOptional PKCEPolicy = iota
+	// PKCERequired rejects authorization code requests without code
+	// challenge, so codes intercepted by other apps on the device of
+	// native or browser-based apps are useless.
+	PKCERequired
+	// PKCERequiredS256 requires code challenges derived with the S256
+	// method, rejecting the plain one.
+	PKCERequiredS256
+)
+
+// SetPKCEPolicy sets whether authorization code requests have to carry a
+// PKCE code challenge. Defaults to PKCEOptional.
+func SetPKCEPolicy(p PKCEPolicy) option {
+	return func(c *config) {
+		c.pkcePolicy = p
+	}
+}
+
+// codeChallengeMethods returns the code challenge methods accepted by the
+// PKCE policy.
+func codeChallengeMethods(cfg config) []string {
+	if cfg.pkcePolicy == PKCERequiredS256 {
+		return []string{CodeChallengeS256}
+	}
+	return []string{CodeChallengeS256, CodeChallengePlain}
+}
+
+// checkCodeChallenge validates the PKCE code challenge of an authorization
+// code request against the PKCE policy.
+// http://tools.ietf.org/html/rfc7636#section-4.4.1
+func checkCodeChallenge(cfg config, areq AuthorizationRequest) *types.AuthzError {
+	if areq.CodeChallenge == "" {
+		if !pkceRequired(cfg) {
+			return nil
+		}
+		e := ErrCodeChallengeRequired(areq.State)
+		return &e
+	}
+
+	// Defaults to "plain" if not present in the request.
+	method := areq.CodeChallengeMethod
+	if method == "" {
+		method = CodeChallengePlain
+	}
+
+	supported := false
+	for _, m := range codeChallengeMethods(cfg) {
+		supported = supported || m == method
+	}
+
+	if !supported || !validCodeVerifier(areq.CodeChallenge) {
+		e := ErrCodeChallengeInvalid(areq.State)
+		return &e
+	}
+	return nil
+}
+
+// validCodeVerifier tells whether s is made of 43 to 128 unreserved
+// characters, as required for code verifiers and, therefore, for plain and
+// S256 code challenges. http://tools.ietf.org/html/rfc7636#section-4.1
+func validCodeVerifier(s string) bool {
+	if len(s) < 43 || len(s) > 128 {
+		return false
+	}
+
+	for _, c := range s {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
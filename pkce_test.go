@@ -0,0 +1,121 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestPKCEPolicy tests that code challenges are validated, and required,
+// according to the PKCE policy.
+func TestPKCEPolicy(t *testing.T) {
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	tests := []struct {
+		policy       PKCEPolicy
+		responseType string
+		challenge    string
+		method       string
+		messageID    string
+	}{
+		{PKCEOptional, "code", "", "", ""},
+		{PKCEOptional, "code", challenge, CodeChallengeS256, ""},
+		{PKCEOptional, "code", challenge, "", ""},
+		{PKCEOptional, "code", "abc", CodeChallengePlain, "code_challenge_invalid"},
+		{PKCEOptional, "code", challenge + "!", CodeChallengeS256, "code_challenge_invalid"},
+		{PKCEOptional, "code", challenge, "S512", "code_challenge_invalid"},
+		{PKCERequired, "code", "", "", "code_challenge_required"},
+		{PKCERequired, "code", challenge, CodeChallengePlain, ""},
+		{PKCERequired, "token", "", "", ""},
+		{PKCERequiredS256, "code", challenge, CodeChallengePlain, "code_challenge_invalid"},
+		{PKCERequiredS256, "code", challenge, CodeChallengeS256, ""},
+	}
+
+	for _, tt := range tests {
+		cfg := setupTest()
+		provider := test.NewProvider(true)
+		cfg.provider = provider
+		SetPKCEPolicy(tt.policy)(&cfg)
+
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {tt.responseType},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {"read"},
+			"state":         {"state-test"},
+		}
+		if tt.challenge != "" {
+			values.Set("code_challenge", tt.challenge)
+		}
+		if tt.method != "" {
+			values.Set("code_challenge_method", tt.method)
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		if tt.messageID == "" {
+			equals(t, http.StatusOK, w.Code)
+			continue
+		}
+
+		equals(t, http.StatusFound, w.Code)
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		equals(t, types.ErrorInvalidRequest, u.Query().Get("error"))
+		equals(t, "state-test", u.Query().Get("state"))
+		assert(t, strings.Contains(u.Query().Get("error_description"), "code_challenge"), "unexpected error for %+v: %s", tt, u)
+	}
+}
+
+// TestCodeVerifierFormat tests that code verifiers not made of 43 to 128
+// unreserved characters are rejected, even if they match plain challenges.
+func TestCodeVerifierFormat(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	for _, verifier := range []string{strings.Repeat("a", 42), strings.Repeat("a", 129), strings.Repeat("a", 42) + "+"} {
+		grant, err := provider.GenBoundGrant(types.Grant{
+			ClientID:            provider.Client.ID,
+			RedirectURL:         provider.Client.RedirectURL,
+			Scopes:              types.Scopes{types.Scope{ID: "read"}},
+			CodeChallenge:       verifier,
+			CodeChallengeMethod: CodeChallengePlain,
+		}, cfg.authzExpiration)
+		ok(t, err)
+
+		values := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {grant.Code},
+			"code_verifier": {verifier},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusBadRequest, w.Code)
+
+		var e types.AuthzError
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		equals(t, ErrCodeVerifierInvalid.Description, e.Description)
+	}
+
+	SetPKCEPolicy(PKCERequiredS256)(&cfg)
+	equals(t, []string{CodeChallengeS256}, codeChallengeMethods(cfg))
+}
@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// scopePolicy constrains tokens that include a given scope.
+type scopePolicy struct {
+	lifetime    time.Duration
+	refreshable bool
+}
+
+// SetScopePolicy constrains every token whose scope includes the given scope
+// identifier, regardless of the grant type used to obtain it. A lifetime of
+// 0 keeps the configured token expiration. For example, the following
+// makes tokens containing "payments:write" live 5 minutes at most and
+// never come along with a refresh token:
+//
+//	SetScopePolicy("payments:write", time.Duration(5)*time.Minute, false)
+//
+// When several scopes have policies, the shortest lifetime wins and a single
+// non-refreshable scope makes the whole token non-refreshable.
+func SetScopePolicy(scope string, lifetime time.Duration, refreshable bool) option {
+	return func(c *config) {
+		if c.scopePolicies == nil {
+			c.scopePolicies = make(map[string]scopePolicy)
+		}
+		c.scopePolicies[scope] = scopePolicy{
+			lifetime:    lifetime,
+			refreshable: refreshable,
+		}
+	}
+}
+
+// ExpiringRefreshProvider is an optional interface that providers can
+// implement in order to refresh access tokens with the expiration set by
+// SetTokenExpiration and SetScopePolicy. Access tokens refreshed by providers
+// not implementing it are handed to clients with their expiration shortened
+// to the configured one, if longer, but are stored as RefreshToken issued them.
+type ExpiringRefreshProvider interface {
+	// RefreshTokenWithExpiration refreshes an access token, like
+	// RefreshToken does, expiring after the given expiration.
+	RefreshTokenWithExpiration(refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error)
+}
+
+// tokenPolicy returns the effective expiration for a token with the given
+// scopes and whether it may be refreshed.
+func tokenPolicy(cfg config, scopes types.Scopes) (expiration time.Duration, refreshable bool) {
+	expiration = cfg.tokenExpiration
+	refreshable = grantTypeAllowed(cfg, "refresh_token")
+
+	for _, s := range scopes {
+		p, ok := cfg.scopePolicies[s.ID]
+		if !ok {
+			continue
+		}
+
+		if p.lifetime > 0 && (expiration <= 0 || p.lifetime < expiration) {
+			expiration = p.lifetime
+		}
+
+		if !p.refreshable {
+			refreshable = false
+		}
+	}
+	return expiration, refreshable
+}
+
+// Policy centralizes authorization rules outside of provider storage code.
+// It is consulted right before issuing any authorization code or token.
+type Policy interface {
+	// Authorize returns nil to allow the issuance. To deny it, it returns
+	// the OAuth2 error to send back to the client, such as:
+	//
+	//	e := oauth2.ErrInvalidScope
+	//	return &e
+	//
+	// Any other error denies the issuance with ErrPolicyDenied.
+	Authorize(input PolicyInput) error
+}
+
+// PolicyInput is the information available to policies.
+type PolicyInput struct {
+	// Grant being issued: "authorization_code" and "implicit" when the resource
+	// owner approves an authorization request or the grant type used at the
+	// token endpoint otherwise.
+	GrantType string
+	// Client the grant or token is issued to.
+	Client types.Client
+	// Resource owner, if known. Its ID is the username in password grants.
+	User types.User
+	// Scopes being granted.
+	Scopes types.Scopes
+	// Request being processed, to take into account metadata such as the
+	// client's IP address or user agent.
+	Request *http.Request
+	// Authorization request approved by the resource owner, if the grant is
+	// issued by the authorization endpoint.
+	AuthorizationRequest *AuthorizationRequest
+	// Request sent to the token endpoint, if the token is issued by it.
+	TokenRequest *TokenRequest
+}
+
+// SetPolicy sets a policy to consult before issuing grants and tokens.
+func SetPolicy(p Policy) option {
+	return func(c *config) {
+		c.policy = p
+	}
+}
+
+// denied consults the configured policy and returns the error to reply with
+// if the issuance is not allowed.
+func denied(req *http.Request, cfg config, input PolicyInput) (types.AuthzError, bool) {
+	if cfg.policy == nil {
+		return types.AuthzError{}, false
+	}
+
+	input.Request = req
+	err := cfg.policy.Authorize(input)
+	if err == nil {
+		return types.AuthzError{}, false
+	}
+
+	if e, ok := err.(*types.AuthzError); ok {
+		return localize(req, cfg, *e), true
+	}
+
+	log.Printf("[ERROR] request_id=%s Policy denied issuance: %v", RequestID(req), err)
+	return localize(req, cfg, ErrPolicyDenied), true
+}
@@ -0,0 +1,109 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+type policyFunc func(PolicyInput) error
+
+func (f policyFunc) Authorize(input PolicyInput) error {
+	return f(input)
+}
+
+func TestPolicy(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+
+	var inputs []PolicyInput
+	SetPolicy(policyFunc(func(input PolicyInput) error {
+		inputs = append(inputs, input)
+		switch {
+		case input.Scopes.Contains("admin"):
+			e := ErrInvalidScope
+			return &e
+		case input.Scopes.Contains("broken"):
+			return errors.New("policy engine is down")
+		}
+		return nil
+	}))(&cfg)
+
+	tests := []struct {
+		scope  string
+		status int
+		code   string
+	}{
+		{"read", http.StatusOK, ""},
+		{"read admin", http.StatusBadRequest, "invalid_scope"},
+		{"broken", http.StatusBadRequest, "access_denied"},
+	}
+
+	for _, tt := range tests {
+		queryStr := url.Values{
+			"grant_type": {"password"},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+			"scope":      {tt.scope},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(queryStr.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+
+		authzErr := types.AuthzError{}
+		err = json.Unmarshal(w.Body.Bytes(), &authzErr)
+		ok(t, err)
+		equals(t, tt.code, authzErr.Code)
+	}
+
+	equals(t, 3, len(inputs))
+	equals(t, "password", inputs[0].GrantType)
+	equals(t, "test_user", inputs[0].User.ID)
+	equals(t, "test_client_id", inputs[0].Client.ID)
+	assert(t, inputs[0].Request != nil, "expected request metadata")
+	assert(t, inputs[0].TokenRequest != nil, "expected the token request")
+	equals(t, "read", inputs[0].TokenRequest.Scope)
+}
+
+// TestPolicyAuthzDenied tests that resource owners' approvals are also
+// subject to policies, with errors sent back to the client.
+func TestPolicyAuthzDenied(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	SetPolicy(policyFunc(func(input PolicyInput) error {
+		return errors.New("no")
+	}))(&cfg)
+
+	body := authzRequest(t, cfg).URL.RawQuery + "&" + ConsentParam + "=approve"
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "access_denied", u.Query().Get("error"))
+	equals(t, "state-test", u.Query().Get("state"))
+	equals(t, "", u.Query().Get("code"))
+}
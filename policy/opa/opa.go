@@ -0,0 +1,147 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package opa implements oauth2.Policy by querying an Open Policy Agent
+// server through its REST API: http://www.openpolicyagent.org/docs/rest-api.html
+//
+// Decisions can be a boolean or an object such as:
+//
+//	{"allow": false, "error": "invalid_scope", "error_description": "..."}
+//
+// to deny with a specific OAuth2 error. A sample rego policy:
+//
+//	package oauth2
+//
+//	default allow = false
+//
+//	allow {
+//		input.grant_type != "password"
+//	}
+package opa
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hooklift/oauth2"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ErrUndefined is returned when the policy does not produce a decision for the input.
+var ErrUndefined = errors.New("opa: policy decision is undefined")
+
+// Policy queries a policy decision from OPA.
+type Policy struct {
+	// OPA server URL. Example: http://localhost:8181
+	URL string
+	// Path of the decision document. Example: oauth2/allow
+	Path string
+	// HTTP client used to talk to OPA. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Input is the document sent to OPA as input.
+type Input struct {
+	GrantType  string   `json:"grant_type"`
+	ClientID   string   `json:"client_id"`
+	UserID     string   `json:"user_id,omitempty"`
+	Scopes     []string `json:"scopes"`
+	RemoteAddr string   `json:"remote_addr,omitempty"`
+	UserAgent  string   `json:"user_agent,omitempty"`
+	RequestID  string   `json:"request_id,omitempty"`
+}
+
+// decision is the policy decision when denying with a specific error.
+type decision struct {
+	Allow       bool   `json:"allow"`
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Authorize implements oauth2.Policy.
+func (p *Policy) Authorize(in oauth2.PolicyInput) error {
+	input := Input{
+		GrantType: in.GrantType,
+		ClientID:  in.Client.ID,
+		UserID:    in.User.ID,
+		Scopes:    make([]string, 0, len(in.Scopes)),
+	}
+
+	for _, s := range in.Scopes {
+		input.Scopes = append(input.Scopes, s.ID)
+	}
+
+	if req := in.Request; req != nil {
+		input.RemoteAddr = req.RemoteAddr
+		if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+			input.RemoteAddr = host
+		}
+		input.UserAgent = req.UserAgent()
+		input.RequestID = oauth2.RequestID(req)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return err
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	u := strings.TrimSuffix(p.URL, "/") + "/v1/data/" + strings.Trim(p.Path, "/")
+	res, err := client.Post(u, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("opa: unexpected response %s", res.Status)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+
+	if len(result.Result) == 0 {
+		return ErrUndefined
+	}
+
+	var allow bool
+	if err := json.Unmarshal(result.Result, &allow); err == nil {
+		if allow {
+			return nil
+		}
+		e := oauth2.ErrPolicyDenied
+		return &e
+	}
+
+	var d decision
+	if err := json.Unmarshal(result.Result, &d); err != nil {
+		return err
+	}
+
+	if d.Allow {
+		return nil
+	}
+
+	e := oauth2.ErrPolicyDenied
+	if d.Error != "" {
+		e = types.AuthzError{
+			Code:        d.Error,
+			Description: d.Description,
+		}
+	}
+	return &e
+}
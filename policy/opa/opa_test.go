@@ -0,0 +1,69 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package opa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2"
+	"github.com/hooklift/oauth2/types"
+)
+
+func TestPolicy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v1/data/oauth2/allow" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var body struct {
+			Input Input `json:"input"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+
+		switch body.Input.ClientID {
+		case "allowed":
+			w.Write([]byte(`{"result": true}`))
+		case "denied":
+			w.Write([]byte(`{"result": false}`))
+		case "scoped":
+			w.Write([]byte(`{"result": {"allow": false, "error": "invalid_scope", "error_description": "No admin for you."}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer ts.Close()
+
+	p := &Policy{URL: ts.URL, Path: "oauth2/allow"}
+
+	input := func(clientID string) oauth2.PolicyInput {
+		return oauth2.PolicyInput{
+			GrantType: "client_credentials",
+			Client:    types.Client{ID: clientID},
+			Scopes:    types.Scopes{types.Scope{ID: "admin"}},
+		}
+	}
+
+	if err := p.Authorize(input("allowed")); err != nil {
+		t.Errorf("expected issuance to be allowed, got %v", err)
+	}
+
+	err := p.Authorize(input("denied"))
+	if e, ok := err.(*types.AuthzError); !ok || e.Code != "access_denied" {
+		t.Errorf("expected access_denied error, got %v", err)
+	}
+
+	err = p.Authorize(input("scoped"))
+	if e, ok := err.(*types.AuthzError); !ok || e.Code != "invalid_scope" || e.Description != "No admin for you." {
+		t.Errorf("expected invalid_scope error, got %v", err)
+	}
+
+	if err := p.Authorize(input("unknown")); err != ErrUndefined {
+		t.Errorf("expected undefined decision, got %v", err)
+	}
+}
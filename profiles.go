@@ -0,0 +1,134 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"html/template"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Profile is a preset of options suited to an environment.
+type Profile int
+
+const (
+	// ProfileDevelopment accepts plain http redirect URIs to localhost and
+	// loopback IP addresses, and reads the authorization form file set with
+	// SetAuthzFormFile again on every request, so changes show up without
+	// restarting. Strict mode is disabled.
+	ProfileDevelopment Profile = iota + 1
+	// ProfileProduction enables strict mode, see SetStrict, and disables
+	// template reloading.
+	ProfileProduction
+)
+
+// SetProfile applies the options of the given profile. Options given after
+// it take precedence, for instance:
+//
+//	oauth2.Handler(mux,
+//		oauth2.SetProvider(provider),
+//		oauth2.SetProfile(oauth2.ProfileProduction),
+//		oauth2.SetSTSMaxAge(24*time.Hour),
+//	)
+func SetProfile(p Profile) option {
+	return func(c *config) {
+		switch p {
+		case ProfileDevelopment:
+			c.redirectPolicy.Loopback = true
+			c.redirectPolicy.Localhost = true
+			c.reloadTemplates = true
+			c.strict = false
+		case ProfileProduction:
+			c.reloadTemplates = false
+			c.strict = true
+		default:
+			log.Fatalf("Unknown configuration profile: %d", p)
+		}
+	}
+}
+
+// SetStrict refuses to start, or to reload, with options that are unsafe in
+// production: tolerating quirks, accepting plain http redirect URIs other
+// than loopback IP addresses of native apps, disabling Strict Transport
+// Security, redirecting resource owners to a plain http login page, serving
+// the issuer over plain http or reloading templates.
+func SetStrict(enabled bool) option {
+	return func(c *config) {
+		c.strict = enabled
+	}
+}
+
+// SetAuthzFormFile sets the authorization form to show to the resource owner
+// from a template file. See SetAuthzForm and SetTemplateReload.
+func SetAuthzFormFile(path string) option {
+	return func(c *config) {
+		form, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Error reading authorization form: %v", err)
+		}
+
+		SetAuthzForm(string(form))(c)
+		c.authzFormFile = path
+	}
+}
+
+// SetTemplateReload reads the authorization form file set with
+// SetAuthzFormFile again on every request, for development. Errors parsing
+// it are logged and the previous form is shown instead.
+func SetTemplateReload(enabled bool) option {
+	return func(c *config) {
+		c.reloadTemplates = enabled
+	}
+}
+
+// reloadAuthzForm returns the authorization form as currently found in its
+// file, or the one read before if it can't be parsed.
+func reloadAuthzForm(req *http.Request, cfg config) *template.Template {
+	if cfg.authzFormFile == "" {
+		return cfg.authzForm
+	}
+
+	form, err := ioutil.ReadFile(cfg.authzFormFile)
+	if err == nil {
+		var tpl *template.Template
+		if tpl, err = template.New("authzform").Parse(string(form)); err == nil {
+			return tpl
+		}
+	}
+
+	log.Printf("[ERROR] request_id=%s Error reloading authorization form: %v", RequestID(req), err)
+	return cfg.authzForm
+}
+
+// unsafeOptions returns the options set that are unsafe in production.
+func unsafeOptions(cfg config) []string {
+	var unsafe []string
+	if cfg.quirks != 0 {
+		unsafe = append(unsafe, "quirks of legacy clients are tolerated, see SetQuirks")
+	}
+	if cfg.redirectPolicy.Localhost {
+		unsafe = append(unsafe, "plain http redirect URIs to localhost are accepted, see SetRedirectPolicy")
+	}
+	for _, s := range cfg.redirectPolicy.Schemes {
+		if strings.EqualFold(s, "http") {
+			unsafe = append(unsafe, "plain http redirect URIs are accepted, see SetRedirectPolicy")
+		}
+	}
+	if cfg.stsMaxAge <= 0 {
+		unsafe = append(unsafe, "Strict Transport Security is disabled, see SetSTSMaxAge")
+	}
+	if u := cfg.loginURL.url; u != nil && strings.EqualFold(u.Scheme, "http") {
+		unsafe = append(unsafe, "the login page is served over plain http, see SetLoginURL")
+	}
+	if strings.HasPrefix(cfg.issuer, "http:") {
+		unsafe = append(unsafe, "the issuer is served over plain http, see SetIssuer")
+	}
+	if cfg.reloadTemplates {
+		unsafe = append(unsafe, "templates are reloaded on every request, see SetTemplateReload")
+	}
+	return unsafe
+}
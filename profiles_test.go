@@ -0,0 +1,92 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/redirecturi"
+)
+
+// TestDevelopmentProfile tests that the development profile accepts
+// redirect URIs to localhost and picks up changes to the authorization form
+// without restarting.
+func TestDevelopmentProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oauth2")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "authzform.html")
+	ok(t, ioutil.WriteFile(path, []byte("form v1"), 0600))
+
+	provider := test.NewProvider(true)
+	s := Handler(nil, SetProvider(provider), SetAuthzFormFile(path), SetProfile(ProfileDevelopment))
+
+	cfg := s.current.Load().(*snapshot).cfg
+	_, err = redirectPolicy(cfg).Parse("http://localhost:3000/callback")
+	ok(t, err)
+	_, err = redirectPolicy(cfg).Parse("http://example.com/callback")
+	equals(t, redirecturi.ErrScheme, err)
+
+	form := func() string {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"scope":         {"read"},
+		}
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	equals(t, "form v1", form())
+
+	ok(t, ioutil.WriteFile(path, []byte("form v2"), 0600))
+	equals(t, "form v2", form())
+
+	// Broken templates leave the form as first read.
+	ok(t, ioutil.WriteFile(path, []byte("form {{"), 0600))
+	equals(t, "form v1", form())
+}
+
+// TestProductionProfile tests that the production profile disables template
+// reloading and reports the options unsafe in production.
+func TestProductionProfile(t *testing.T) {
+	cfg := setupTest()
+	SetSTSMaxAge(time.Hour)(&cfg)
+	SetProfile(ProfileDevelopment)(&cfg)
+	SetProfile(ProfileProduction)(&cfg)
+	assert(t, cfg.strict, "expected strict mode")
+	assert(t, !cfg.reloadTemplates, "expected templates not to be reloaded")
+
+	unsafe := unsafeOptions(cfg)
+	equals(t, 1, len(unsafe))
+	assert(t, strings.Contains(unsafe[0], "localhost"), "expected localhost redirect URIs to be reported: %v", unsafe)
+
+	cfg = setupTest()
+	SetSTSMaxAge(time.Hour)(&cfg)
+	SetProfile(ProfileProduction)(&cfg)
+	SetRedirectPolicy(redirecturi.Policy{Schemes: []string{"com.example.app"}, Loopback: true})(&cfg)
+	equals(t, 0, len(unsafeOptions(cfg)))
+
+	SetQuirks(QuirkStringExpiresIn)(&cfg)
+	SetSTSMaxAge(0)(&cfg)
+	SetLoginURL("http://example.com/login", "next")(&cfg)
+	equals(t, 3, len(unsafeOptions(cfg)))
+}
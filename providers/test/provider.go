@@ -4,11 +4,12 @@
 package test
 
 import (
+	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
+	"github.com/hooklift/oauth2/tokengen"
 	"github.com/hooklift/oauth2/types"
 	"github.com/satori/go.uuid"
 )
@@ -18,14 +19,49 @@ type Provider struct {
 	Grants              map[string]types.Grant
 	AccessTokens        map[string]types.Token
 	RefreshTokens       map[string]types.Token
+	RotatedTokens       map[string]types.Token
+	ServiceAccounts     map[string]types.ServiceAccount
+	Receipts            []types.ConsentReceipt
+	Consents            map[string]types.Consent
+	Usage               map[string]types.TokenUsage
+	Events              []types.CredentialEvent
+	ResourceServers     map[string]types.ResourceServer
 	isUserAuthenticated bool
+	// How the test user authenticated.
+	AMR              []string
+	IdentityProvider string
+	// Stored refresh tokens, in the order they were issued.
+	refreshOrder []string
+
+	// Whether to store hashes of codes and refresh tokens, as expected by
+	// oauth2.SetSecretHashing.
+	HashSecrets bool
+
+	// Device authorizations, by device code.
+	DeviceAuthorizations map[string]types.DeviceAuthorization
+
+	// Outcomes of client revalidations, by client.
+	ClientChecks map[string]types.ClientCheck
+
+	// Clock used to compute expiration times. Defaults to the system clock.
+	Clock interface {
+		Now() time.Time
+	}
 }
 
 func NewProvider(isUserAuthenticated bool) *Provider {
 	p := &Provider{
-		Grants:        make(map[string]types.Grant),
-		AccessTokens:  make(map[string]types.Token),
-		RefreshTokens: make(map[string]types.Token),
+		Grants:          make(map[string]types.Grant),
+		AccessTokens:    make(map[string]types.Token),
+		RefreshTokens:   make(map[string]types.Token),
+		RotatedTokens:   make(map[string]types.Token),
+		ServiceAccounts: make(map[string]types.ServiceAccount),
+		ResourceServers: make(map[string]types.ResourceServer),
+		Consents:        make(map[string]types.Consent),
+		Usage:           make(map[string]types.TokenUsage),
+
+		DeviceAuthorizations: make(map[string]types.DeviceAuthorization),
+		ClientChecks:         make(map[string]types.ClientCheck),
 	}
 
 	p.isUserAuthenticated = isUserAuthenticated
@@ -40,23 +76,72 @@ func NewProvider(isUserAuthenticated bool) *Provider {
 	return p
 }
 
+func (p *Provider) now() time.Time {
+	if p.Clock == nil {
+		return time.Now()
+	}
+	return p.Clock.Now()
+}
+
+// storedKey returns the key codes and refresh tokens are stored with.
+func (p *Provider) storedKey(secret string) string {
+	if p.HashSecrets {
+		return tokengen.Hash(secret)
+	}
+	return secret
+}
+
 func (p *Provider) ClientInfo(clientID string) (types.Client, error) {
 	return p.Client, nil
 }
 
 func (p *Provider) GenGrant(client types.Client, scopes types.Scopes, expiration time.Duration) (types.Grant, error) {
-	a := types.Grant{
-		Code:        uuid.NewV4().String(),
-		ClientID:    client.ID,
-		RedirectURL: client.RedirectURL,
-		Scopes:      scopes,
+	a, err := types.NewGrant(uuid.NewV4().String(), client, scopes, p.now().Add(expiration))
+	if err != nil {
+		return a, err
 	}
-	a.ExpiresIn = time.Now().Add(expiration)
 
-	p.Grants[a.Code] = a
+	stored := a
+	stored.Code = p.storedKey(a.Code)
+	p.Grants[stored.Code] = stored
 	return a, nil
 }
 
+func (p *Provider) GenBoundGrant(grant types.Grant, expiration time.Duration) (types.Grant, error) {
+	if grant.Code == "" {
+		grant.Code = uuid.NewV4().String()
+	}
+	grant.ExpiresIn = p.now().Add(expiration)
+
+	stored := grant
+	stored.Code = p.storedKey(grant.Code)
+	p.Grants[stored.Code] = stored
+	return grant, nil
+}
+
+func (p *Provider) SaveDeviceAuthorization(authz types.DeviceAuthorization) error {
+	p.DeviceAuthorizations[authz.DeviceCode] = authz
+	return nil
+}
+
+func (p *Provider) DeviceAuthorization(deviceCode string) (types.DeviceAuthorization, error) {
+	return p.DeviceAuthorizations[deviceCode], nil
+}
+
+func (p *Provider) DeviceAuthorizationByUserCode(userCode string) (types.DeviceAuthorization, error) {
+	for _, authz := range p.DeviceAuthorizations {
+		if authz.UserCode == userCode {
+			return authz, nil
+		}
+	}
+	return types.DeviceAuthorization{}, nil
+}
+
+func (p *Provider) DeleteDeviceAuthorization(deviceCode string) error {
+	delete(p.DeviceAuthorizations, deviceCode)
+	return nil
+}
+
 func (p *Provider) ScopesInfo(scopes string) (types.Scopes, error) {
 	s := strings.Split(scopes, " ")
 	scope := make(types.Scopes, 0)
@@ -70,17 +155,33 @@ func (p *Provider) ScopesInfo(scopes string) (types.Scopes, error) {
 }
 
 func (p *Provider) GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
-	t := types.Token{
-		Value:    uuid.NewV4().String(),
-		Type:     "bearer",
-		Scopes:   grant.Scopes,
-		ClientID: client.ID,
+	familyID := grant.FamilyID
+	if familyID == "" {
+		familyID = uuid.NewV4().String()
 	}
+	return p.genToken(grant, client, refreshToken, expiration, familyID, 0)
+}
 
-	t.ExpiresIn = strconv.FormatFloat(expiration.Seconds(), 'f', -1, 64)
+func (p *Provider) genToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration, familyID string, generation int) (types.Token, error) {
+	t, err := types.NewToken(uuid.NewV4().String(), client, grant.Scopes, p.now(), expiration)
+	if err != nil {
+		return t, err
+	}
+	t.Audience = grant.Audience
+	t.Extensions = grant.Extensions
+	t.AMR = grant.AMR
+	t.IdentityProvider = grant.IdentityProvider
+	t.KeyThumbprint = grant.KeyThumbprint
+	t.UserID = grant.UserID
+	t.FamilyID = familyID
+	t.Generation = generation
+
+	stored := t
 	if refreshToken {
 		t.RefreshToken = uuid.NewV4().String()
-		p.RefreshTokens[t.RefreshToken] = t
+		stored.RefreshToken = p.storedKey(t.RefreshToken)
+		p.RefreshTokens[stored.RefreshToken] = stored
+		p.refreshOrder = append(p.refreshOrder, stored.RefreshToken)
 	}
 
 	if v, ok := p.Grants[grant.Code]; ok {
@@ -88,7 +189,7 @@ func (p *Provider) GenToken(grant types.Grant, client types.Client, refreshToken
 		p.Grants[grant.Code] = v
 	}
 
-	p.AccessTokens[t.Value] = t
+	p.AccessTokens[t.Value] = stored
 	return t, nil
 }
 
@@ -99,16 +200,116 @@ func (p *Provider) RevokeToken(token string) error {
 }
 
 func (p *Provider) RefreshToken(refreshToken types.Token, scopes types.Scopes) (types.Token, error) {
-	// Revokes existing refresh token
-	delete(p.RefreshTokens, refreshToken.Value)
+	return p.RefreshTokenWithExpiration(refreshToken, scopes, time.Duration(10)*time.Minute)
+}
+
+func (p *Provider) RefreshTokenWithExpiration(refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	// Revokes existing refresh token, keeping it to detect its reuse.
+	if t, ok := p.RefreshTokens[refreshToken.RefreshToken]; ok {
+		t.Status = types.TokenRotated
+		p.RotatedTokens[refreshToken.RefreshToken] = t
+	}
+	delete(p.RefreshTokens, refreshToken.RefreshToken)
 
 	grant := types.Grant{
-		Scopes: scopes,
+		UserID:           refreshToken.UserID,
+		Scopes:           scopes,
+		Audience:         refreshToken.Audience,
+		Extensions:       refreshToken.Extensions,
+		AMR:              refreshToken.AMR,
+		IdentityProvider: refreshToken.IdentityProvider,
+		KeyThumbprint:    refreshToken.KeyThumbprint,
 	}
 
-	return p.GenToken(grant, types.Client{
+	return p.genToken(grant, types.Client{
 		ID: refreshToken.ClientID,
-	}, true, time.Duration(10)*time.Minute)
+	}, true, expiration, refreshToken.FamilyID, refreshToken.Generation+1)
+}
+
+func (p *Provider) ActiveRefreshTokens(userID, clientID string) ([]types.Token, error) {
+	tokens := make([]types.Token, 0)
+	for _, key := range p.refreshOrder {
+		t, ok := p.RefreshTokens[key]
+		if ok && t.UserID == userID && t.ClientID == clientID {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens, nil
+}
+
+// Stats counts grants by the day they expire, which is close enough to the day
+// they were issued.
+func (p *Provider) Stats(since time.Time) (types.Stats, error) {
+	stats := types.Stats{
+		ActiveTokensByClient: make(map[string]int64),
+		GrantsPerDay:         make([]types.DailyCount, 0),
+		TopScopes:            make([]types.ScopeCount, 0),
+	}
+
+	for _, t := range p.AccessTokens {
+		stats.ActiveTokensByClient[t.ClientID]++
+	}
+	for _, t := range p.RefreshTokens {
+		stats.ActiveTokensByClient[t.ClientID]++
+	}
+
+	days := make(map[string]int64)
+	scopes := make(map[string]int64)
+	for _, g := range p.Grants {
+		if g.ExpiresIn.Before(since) {
+			continue
+		}
+
+		days[g.ExpiresIn.UTC().Format("2006-01-02")]++
+		for _, s := range g.Scopes {
+			scopes[s.ID]++
+		}
+	}
+
+	for day := since.UTC(); !day.After(p.now().UTC()); day = day.AddDate(0, 0, 1) {
+		d := day.Format("2006-01-02")
+		stats.GrantsPerDay = append(stats.GrantsPerDay, types.DailyCount{Day: d, Count: days[d]})
+	}
+
+	for s, n := range scopes {
+		stats.TopScopes = append(stats.TopScopes, types.ScopeCount{Scope: s, Count: n})
+	}
+	return stats, nil
+}
+
+// RevokeTokenFamily revokes the access and refresh tokens of a family.
+func (p *Provider) RevokeTokenFamily(familyID string) error {
+	for _, tokens := range []map[string]types.Token{p.AccessTokens, p.RefreshTokens} {
+		for k, t := range tokens {
+			if t.FamilyID == familyID {
+				t.Status = types.TokenRevoked
+				tokens[k] = t
+			}
+		}
+	}
+	return nil
+}
+
+// TokenFamily counts the access tokens issued to a family still stored.
+func (p *Provider) TokenFamily(familyID string) (types.TokenFamily, error) {
+	family := types.TokenFamily{ID: familyID}
+	for _, t := range p.AccessTokens {
+		if t.FamilyID != familyID {
+			continue
+		}
+
+		family.ClientID = t.ClientID
+		family.UserID = t.UserID
+		family.AccessTokenCount++
+		if t.Generation > family.Generation {
+			family.Generation = t.Generation
+		}
+	}
+
+	if family.AccessTokenCount == 0 {
+		return types.TokenFamily{}, nil
+	}
+	return family, nil
 }
 
 func (p *Provider) IsUserAuthenticated() bool {
@@ -136,11 +337,14 @@ func (p *Provider) TokenInfo(code string) (types.Token, error) {
 		return v, nil
 	}
 
-	return p.RefreshTokens[code], nil
+	if v, ok := p.RefreshTokens[code]; ok {
+		return v, nil
+	}
+	return p.RotatedTokens[code], nil
 }
 
 func (p *Provider) AuthenticateUser(username, password string) bool {
-	return true
+	return password == "test_password"
 }
 
 func (p *Provider) ResourceScopes(url *url.URL) (types.Scopes, error) {
@@ -150,3 +354,184 @@ func (p *Provider) ResourceScopes(url *url.URL) (types.Scopes, error) {
 		types.Scope{ID: "write"},
 	}, nil
 }
+
+func (p *Provider) ServiceAccountInfo(id string) (types.ServiceAccount, error) {
+	return p.ServiceAccounts[id], nil
+}
+
+func (p *Provider) CurrentUser(req *http.Request) (types.User, error) {
+	return types.User{
+		ID:               "test_user",
+		Name:             "Test User",
+		AMR:              p.AMR,
+		IdentityProvider: p.IdentityProvider,
+	}, nil
+}
+
+func (p *Provider) SaveConsentReceipt(receipt types.ConsentReceipt) error {
+	p.Receipts = append(p.Receipts, receipt)
+	return nil
+}
+
+func (p *Provider) ConsentReceipts(userID string) ([]types.ConsentReceipt, error) {
+	receipts := make([]types.ConsentReceipt, 0)
+	for _, r := range p.Receipts {
+		if r.UserID == userID {
+			receipts = append(receipts, r)
+		}
+	}
+	return receipts, nil
+}
+
+func (p *Provider) SaveConsent(consent types.Consent) error {
+	p.Consents[consent.UserID+":"+consent.ClientID] = consent
+	return nil
+}
+
+func (p *Provider) GetConsent(userID, clientID string) (types.Consent, error) {
+	return p.Consents[userID+":"+clientID], nil
+}
+
+func (p *Provider) RevokeConsent(userID, clientID string) error {
+	delete(p.Consents, userID+":"+clientID)
+	return nil
+}
+
+func (p *Provider) RevokeGrants(userID, clientID string) error {
+	for code, g := range p.Grants {
+		if g.ClientID == clientID {
+			g.Status = types.GrantRevoked
+			p.Grants[code] = g
+		}
+	}
+	for _, tokens := range []map[string]types.Token{p.AccessTokens, p.RefreshTokens} {
+		for k, t := range tokens {
+			if t.UserID == userID && t.ClientID == clientID {
+				t.Status = types.TokenRevoked
+				tokens[k] = t
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Provider) RecordUsage(usage []types.TokenUsage) error {
+	for _, u := range usage {
+		u.UseCount += p.Usage[u.TokenID].UseCount
+		p.Usage[u.TokenID] = u
+	}
+	return nil
+}
+
+func (p *Provider) TokenUsage(tokenID string) (types.TokenUsage, error) {
+	return p.Usage[tokenID], nil
+}
+
+func (p *Provider) UserTokenUsage(userID string) ([]types.TokenUsage, error) {
+	usage := make([]types.TokenUsage, 0)
+	for _, u := range p.Usage {
+		if u.UserID == userID {
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+// RevokeByEvent revokes everything, as all grants and tokens belong to the
+// same test user.
+func (p *Provider) RevokeByEvent(event types.CredentialEvent) error {
+	p.Grants = make(map[string]types.Grant)
+	p.AccessTokens = make(map[string]types.Token)
+	p.RefreshTokens = make(map[string]types.Token)
+	p.Consents = make(map[string]types.Consent)
+	p.Events = append(p.Events, event)
+	return nil
+}
+
+func (p *Provider) SetClientStatus(clientID string, status types.ClientStatus) error {
+	if clientID == p.Client.ID {
+		p.Client.Status = status
+	}
+	return nil
+}
+
+func (p *Provider) Clients() ([]types.Client, error) {
+	return []types.Client{p.Client}, nil
+}
+
+func (p *Provider) SaveClientCheck(check types.ClientCheck) error {
+	p.ClientChecks[check.ClientID] = check
+	return nil
+}
+
+func (p *Provider) ClientCheck(clientID string) (types.ClientCheck, error) {
+	return p.ClientChecks[clientID], nil
+}
+
+func (p *Provider) DeleteClient(clientID string) error {
+	if clientID == p.Client.ID {
+		p.Client = types.Client{}
+	}
+	return nil
+}
+
+func (p *Provider) SoftDeleteClient(clientID string, purgeAt time.Time) error {
+	if clientID == p.Client.ID {
+		p.Client.Status = types.ClientDeleted
+		p.Client.PurgeAt = purgeAt
+	}
+	return nil
+}
+
+func (p *Provider) RestoreClient(clientID string) error {
+	if clientID == p.Client.ID {
+		p.Client.Status = types.ClientApproved
+		p.Client.PurgeAt = time.Time{}
+	}
+	return nil
+}
+
+func (p *Provider) SetClientRedirectURL(clientID string, u *url.URL) error {
+	if clientID == p.Client.ID {
+		p.Client.RedirectURL = u
+	}
+	return nil
+}
+
+func (p *Provider) QuarantineGrants(clientID string) error {
+	for code, grant := range p.Grants {
+		if grant.ClientID == clientID && grant.Status == "" {
+			grant.Status = types.GrantQuarantined
+			p.Grants[code] = grant
+		}
+	}
+
+	for value, token := range p.RefreshTokens {
+		if token.ClientID == clientID {
+			delete(p.RefreshTokens, value)
+		}
+	}
+	return nil
+}
+
+func (p *Provider) ResourceServerInfo(audience string) (types.ResourceServer, error) {
+	for _, rs := range p.ResourceServers {
+		if rs.Audience == audience {
+			return rs, nil
+		}
+	}
+	return types.ResourceServer{}, nil
+}
+
+func (p *Provider) AuthenticateResourceServer(id, secret string) (types.ResourceServer, error) {
+	rs, ok := p.ResourceServers[id]
+	if !ok || rs.Secret != secret {
+		return types.ResourceServer{}, nil
+	}
+	return rs, nil
+}
+
+func (p *Provider) SaveResourceServer(rs types.ResourceServer) error {
+	p.ResourceServers[rs.ID] = rs
+	return nil
+}
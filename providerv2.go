@@ -0,0 +1,348 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Errors returned by ProviderV2 implementations, instead of the zero values
+// and booleans returned by Provider ones.
+var (
+	// ErrNotExist is returned when looking up a client, grant, token or
+	// consent that does not exist.
+	ErrNotExist = errors.New("oauth2: does not exist")
+	// ErrInvalidCredentials is returned when authenticating a client or a
+	// resource owner with credentials that do not match.
+	ErrInvalidCredentials = errors.New("oauth2: invalid credentials")
+	// ErrUnauthenticated is returned by CurrentUser when the resource owner
+	// has no valid session.
+	ErrUnauthenticated = errors.New("oauth2: resource owner is not authenticated")
+)
+
+// ErrConsentProviderRequired is returned when a feature requires the provider to implement ConsentProvider.
+var ErrConsentProviderRequired = errors.New("oauth2: provider does not implement oauth2.ConsentProvider")
+
+// ProviderV2 is the next version of the Provider interface. Its methods take
+// a context, identify resource owners and report failures with errors, such
+// as ErrNotExist, rather than zero values, and it includes the methods of
+// ConsentProvider and UserProvider.
+//
+// Provider is still the interface expected by this package, so integrators
+// can move to ProviderV2 at their own pace:
+//   - AdaptProvider wraps Provider implementations as a ProviderV2.
+//   - SetProviderV2, or AdaptProviderV2 for AuthzHandler, takes ProviderV2
+//     implementations. Until the handlers pass their request's context, the
+//     context given to the provider is context.Background().
+type ProviderV2 interface {
+	// AuthenticateClient authenticates a previously registered client.
+	AuthenticateClient(ctx context.Context, clientID, secret string) (types.Client, error)
+
+	// AuthenticateUser authenticates a resource owner, returning
+	// ErrInvalidCredentials if their credentials do not match.
+	AuthenticateUser(ctx context.Context, username, password string) (types.User, error)
+
+	// CurrentUser returns the resource owner authenticated in the given
+	// request, or ErrUnauthenticated.
+	CurrentUser(req *http.Request) (types.User, error)
+
+	// ClientInfo returns 3rd-party client information.
+	ClientInfo(ctx context.Context, clientID string) (types.Client, error)
+
+	// GrantInfo returns information about the authorization grant code.
+	GrantInfo(ctx context.Context, code string) (types.Grant, error)
+
+	// TokenInfo returns information about one specific token.
+	TokenInfo(ctx context.Context, token string) (types.Token, error)
+
+	// ScopesInfo parses and describes the scopes requested by a client. See
+	// Provider.ScopesInfo.
+	ScopesInfo(ctx context.Context, scopes string) (types.Scopes, error)
+
+	// ResourceScopes returns the scopes associated with a given resource.
+	ResourceScopes(ctx context.Context, url *url.URL) (types.Scopes, error)
+
+	// GenGrant issues and stores an authorization grant code. See
+	// Provider.GenGrant.
+	GenGrant(ctx context.Context, client types.Client, scopes types.Scopes, expiration time.Duration) (types.Grant, error)
+
+	// GenToken generates and stores access and refresh tokens. See
+	// Provider.GenToken.
+	GenToken(ctx context.Context, grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error)
+
+	// RevokeToken expires a specific token.
+	RevokeToken(ctx context.Context, token string) error
+
+	// RefreshToken refreshes an access token, expiring after the given
+	// expiration, or one chosen by the provider if zero. See
+	// ExpiringRefreshProvider.
+	RefreshToken(ctx context.Context, refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error)
+
+	// SaveConsent stores the scopes a resource owner approved for a client.
+	SaveConsent(ctx context.Context, consent types.Consent) error
+
+	// GetConsent returns the consent of a resource owner for a client, or
+	// ErrNotExist.
+	GetConsent(ctx context.Context, userID, clientID string) (types.Consent, error)
+
+	// RevokeConsent forgets the consent of a resource owner for a client.
+	RevokeConsent(ctx context.Context, userID, clientID string) error
+}
+
+// SetProviderV2 sets a ProviderV2 implementation as provider.
+func SetProviderV2(p ProviderV2) option {
+	return SetProvider(AdaptProviderV2(p))
+}
+
+// AdaptProvider returns a ProviderV2 calling the given Provider. Contexts
+// are ignored, zero values returned for unknown clients, grants, tokens and
+// consents are reported as ErrNotExist, and consent methods return
+// ErrConsentProviderRequired unless it implements ConsentProvider.
+func AdaptProvider(p Provider) ProviderV2 {
+	if v2, ok := p.(providerV2Adapter); ok {
+		return v2.ProviderV2
+	}
+	return providerAdapter{p}
+}
+
+// AdaptProviderV2 returns a Provider calling the given ProviderV2, as
+// expected by AuthzHandler. It also implements UserProvider and
+// ConsentProvider.
+func AdaptProviderV2(p ProviderV2) Provider {
+	if v1, ok := p.(providerAdapter); ok {
+		return v1.Provider
+	}
+	return providerV2Adapter{p}
+}
+
+// providerAdapter adapts a Provider to ProviderV2.
+type providerAdapter struct {
+	Provider
+}
+
+func (a providerAdapter) AuthenticateClient(ctx context.Context, clientID, secret string) (types.Client, error) {
+	return a.Provider.AuthenticateClient(clientID, secret)
+}
+
+func (a providerAdapter) AuthenticateUser(ctx context.Context, username, password string) (types.User, error) {
+	if !a.Provider.AuthenticateUser(username, password) {
+		return types.User{}, ErrInvalidCredentials
+	}
+	return types.User{ID: username}, nil
+}
+
+func (a providerAdapter) CurrentUser(req *http.Request) (types.User, error) {
+	if !a.Provider.IsUserAuthenticated() {
+		return types.User{}, ErrUnauthenticated
+	}
+	if p, ok := a.Provider.(UserProvider); ok {
+		return p.CurrentUser(req)
+	}
+	return types.User{}, nil
+}
+
+func (a providerAdapter) ClientInfo(ctx context.Context, clientID string) (types.Client, error) {
+	c, err := a.Provider.ClientInfo(clientID)
+	if err == nil && c.ID == "" {
+		err = ErrNotExist
+	}
+	return c, err
+}
+
+func (a providerAdapter) GrantInfo(ctx context.Context, code string) (types.Grant, error) {
+	g, err := a.Provider.GrantInfo(code)
+	if err == nil && g.Code == "" {
+		err = ErrNotExist
+	}
+	return g, err
+}
+
+func (a providerAdapter) TokenInfo(ctx context.Context, token string) (types.Token, error) {
+	t, err := a.Provider.TokenInfo(token)
+	if err == nil && t.Value == "" {
+		err = ErrNotExist
+	}
+	return t, err
+}
+
+func (a providerAdapter) ScopesInfo(ctx context.Context, scopes string) (types.Scopes, error) {
+	return a.Provider.ScopesInfo(scopes)
+}
+
+func (a providerAdapter) ResourceScopes(ctx context.Context, url *url.URL) (types.Scopes, error) {
+	return a.Provider.ResourceScopes(url)
+}
+
+func (a providerAdapter) GenGrant(ctx context.Context, client types.Client, scopes types.Scopes, expiration time.Duration) (types.Grant, error) {
+	return a.Provider.GenGrant(client, scopes, expiration)
+}
+
+func (a providerAdapter) GenToken(ctx context.Context, grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	return a.Provider.GenToken(grant, client, refreshToken, expiration)
+}
+
+func (a providerAdapter) RevokeToken(ctx context.Context, token string) error {
+	return a.Provider.RevokeToken(token)
+}
+
+func (a providerAdapter) RefreshToken(ctx context.Context, refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	if p, ok := a.Provider.(ExpiringRefreshProvider); ok {
+		return p.RefreshTokenWithExpiration(refreshToken, scopes, expiration)
+	}
+	return a.Provider.RefreshToken(refreshToken, scopes)
+}
+
+func (a providerAdapter) SaveConsent(ctx context.Context, consent types.Consent) error {
+	p, ok := a.Provider.(ConsentProvider)
+	if !ok {
+		return ErrConsentProviderRequired
+	}
+	return p.SaveConsent(consent)
+}
+
+func (a providerAdapter) GetConsent(ctx context.Context, userID, clientID string) (types.Consent, error) {
+	p, ok := a.Provider.(ConsentProvider)
+	if !ok {
+		return types.Consent{}, ErrConsentProviderRequired
+	}
+
+	c, err := p.GetConsent(userID, clientID)
+	if err == nil && c.UserID == "" {
+		err = ErrNotExist
+	}
+	return c, err
+}
+
+func (a providerAdapter) RevokeConsent(ctx context.Context, userID, clientID string) error {
+	p, ok := a.Provider.(ConsentProvider)
+	if !ok {
+		return ErrConsentProviderRequired
+	}
+	return p.RevokeConsent(userID, clientID)
+}
+
+// providerV2Adapter adapts a ProviderV2 to Provider, UserProvider and
+// ConsentProvider.
+type providerV2Adapter struct {
+	ProviderV2
+}
+
+// requestAuthenticator is implemented by providers telling whether the
+// resource owner is authenticated from the request.
+type requestAuthenticator interface {
+	isUserAuthenticated(req *http.Request) bool
+}
+
+// userAuthenticated tells whether the resource owner sending the request has
+// a valid session. An error is returned if the provider is unavailable.
+func userAuthenticated(req *http.Request, cfg config) (bool, error) {
+	p := unwrap(cfg.provider)
+	if a, ok := p.(requestAuthenticator); ok {
+		return checkUser(cfg.provider, func() bool {
+			return a.isUserAuthenticated(req)
+		})
+	}
+	return checkUser(cfg.provider, p.IsUserAuthenticated)
+}
+
+func (a providerV2Adapter) isUserAuthenticated(req *http.Request) bool {
+	_, err := a.ProviderV2.CurrentUser(req)
+	return err == nil
+}
+
+// IsUserAuthenticated can't tell without the request, this package calls
+// isUserAuthenticated instead.
+func (a providerV2Adapter) IsUserAuthenticated() bool {
+	return false
+}
+
+func (a providerV2Adapter) AuthenticateClient(username, password string) (types.Client, error) {
+	return a.ProviderV2.AuthenticateClient(context.Background(), username, password)
+}
+
+func (a providerV2Adapter) AuthenticateUser(username, password string) bool {
+	_, err := a.ProviderV2.AuthenticateUser(context.Background(), username, password)
+	return err == nil
+}
+
+func (a providerV2Adapter) AuthenticateResourceOwner(username, password string) (types.User, error) {
+	return a.ProviderV2.AuthenticateUser(context.Background(), username, password)
+}
+
+func (a providerV2Adapter) ClientInfo(clientID string) (types.Client, error) {
+	c, err := a.ProviderV2.ClientInfo(context.Background(), clientID)
+	if err == ErrNotExist {
+		return types.Client{}, nil
+	}
+	return c, err
+}
+
+func (a providerV2Adapter) GrantInfo(code string) (types.Grant, error) {
+	g, err := a.ProviderV2.GrantInfo(context.Background(), code)
+	if err == ErrNotExist {
+		return types.Grant{}, nil
+	}
+	return g, err
+}
+
+func (a providerV2Adapter) TokenInfo(token string) (types.Token, error) {
+	t, err := a.ProviderV2.TokenInfo(context.Background(), token)
+	if err == ErrNotExist {
+		return types.Token{}, nil
+	}
+	return t, err
+}
+
+func (a providerV2Adapter) ScopesInfo(scopes string) (types.Scopes, error) {
+	return a.ProviderV2.ScopesInfo(context.Background(), scopes)
+}
+
+func (a providerV2Adapter) ResourceScopes(url *url.URL) (types.Scopes, error) {
+	return a.ProviderV2.ResourceScopes(context.Background(), url)
+}
+
+func (a providerV2Adapter) GenGrant(client types.Client, scopes types.Scopes, expiration time.Duration) (types.Grant, error) {
+	return a.ProviderV2.GenGrant(context.Background(), client, scopes, expiration)
+}
+
+func (a providerV2Adapter) GenToken(grant types.Grant, client types.Client, refreshToken bool, expiration time.Duration) (types.Token, error) {
+	return a.ProviderV2.GenToken(context.Background(), grant, client, refreshToken, expiration)
+}
+
+func (a providerV2Adapter) RevokeToken(token string) error {
+	return a.ProviderV2.RevokeToken(context.Background(), token)
+}
+
+// RefreshToken leaves the expiration to the ProviderV2, which is given the
+// configured one through RefreshTokenWithExpiration instead.
+func (a providerV2Adapter) RefreshToken(refreshToken types.Token, scopes types.Scopes) (types.Token, error) {
+	return a.ProviderV2.RefreshToken(context.Background(), refreshToken, scopes, 0)
+}
+
+func (a providerV2Adapter) RefreshTokenWithExpiration(refreshToken types.Token, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	return a.ProviderV2.RefreshToken(context.Background(), refreshToken, scopes, expiration)
+}
+
+func (a providerV2Adapter) SaveConsent(consent types.Consent) error {
+	return a.ProviderV2.SaveConsent(context.Background(), consent)
+}
+
+func (a providerV2Adapter) GetConsent(userID, clientID string) (types.Consent, error) {
+	c, err := a.ProviderV2.GetConsent(context.Background(), userID, clientID)
+	if err == ErrNotExist {
+		return types.Consent{}, nil
+	}
+	return c, err
+}
+
+func (a providerV2Adapter) RevokeConsent(userID, clientID string) error {
+	return a.ProviderV2.RevokeConsent(context.Background(), userID, clientID)
+}
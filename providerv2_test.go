@@ -0,0 +1,98 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestAdaptProvider tests that Provider implementations report unknown
+// objects and failures with the errors expected from ProviderV2.
+func TestAdaptProvider(t *testing.T) {
+	ctx := context.Background()
+	provider := test.NewProvider(true)
+	v2 := AdaptProvider(provider)
+
+	_, err := v2.GrantInfo(ctx, "missing")
+	equals(t, ErrNotExist, err)
+	_, err = v2.TokenInfo(ctx, "missing")
+	equals(t, ErrNotExist, err)
+	_, err = v2.GetConsent(ctx, "test_user", provider.Client.ID)
+	equals(t, ErrNotExist, err)
+	_, err = v2.AuthenticateUser(ctx, "test_user", "wrong")
+	equals(t, ErrInvalidCredentials, err)
+
+	user, err := v2.AuthenticateUser(ctx, "test_user", "test_password")
+	ok(t, err)
+	equals(t, "test_user", user.ID)
+
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	ok(t, err)
+	user, err = v2.CurrentUser(req)
+	ok(t, err)
+	equals(t, "test_user", user.ID)
+	_, err = AdaptProvider(test.NewProvider(false)).CurrentUser(req)
+	equals(t, ErrUnauthenticated, err)
+
+	ok(t, v2.SaveConsent(ctx, types.Consent{UserID: "test_user", ClientID: provider.Client.ID}))
+	consent, err := v2.GetConsent(ctx, "test_user", provider.Client.ID)
+	ok(t, err)
+	equals(t, "test_user", consent.UserID)
+
+	// Adapting back returns the original provider.
+	equals(t, Provider(provider), AdaptProviderV2(v2))
+}
+
+// providerV2 is a ProviderV2 implementation, built on the test provider,
+// with a session only known from requests.
+type providerV2 struct {
+	ProviderV2
+	session string
+}
+
+func (p providerV2) CurrentUser(req *http.Request) (types.User, error) {
+	if c, err := req.Cookie("session"); err != nil || c.Value != p.session {
+		return types.User{}, ErrUnauthenticated
+	}
+	return types.User{ID: "test_user"}, nil
+}
+
+// TestSetProviderV2 tests that ProviderV2 implementations authorize clients,
+// telling whether the resource owner is authenticated from the request.
+func TestSetProviderV2(t *testing.T) {
+	provider := test.NewProvider(false)
+	cfg := setupTest()
+	SetProviderV2(providerV2{AdaptProvider(provider), "s3cr3t"})(&cfg)
+
+	authorize := func(session string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"scope":         {"read"},
+		}
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	equals(t, http.StatusFound, authorize("wrong").Code)
+	equals(t, http.StatusOK, authorize("s3cr3t").Code)
+
+	_, isUserProvider := cfg.provider.(UserProvider)
+	_, isConsentProvider := cfg.provider.(ConsentProvider)
+	assert(t, isUserProvider && isConsentProvider, "adapted provider should implement UserProvider and ConsentProvider")
+}
@@ -0,0 +1,163 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Errors provisioners return to refuse creating an account, sent back to
+// the client as OAuth2 errors.
+var (
+	// The resource owner is not allowed an account, for instance, because
+	// of the domain of their email address. Sent as access_denied.
+	ErrProvisioningDenied = errors.New("oauth2: provisioning denied")
+	// An account not linked to the upstream identity already exists, for
+	// instance, with the same email address. Sent as access_denied.
+	ErrProvisioningConflict = errors.New("oauth2: account already exists")
+	// The user directory is temporarily unavailable. Sent as
+	// temporarily_unavailable.
+	ErrProvisioningUnavailable = errors.New("oauth2: provisioning unavailable")
+)
+
+// Provisioner is an optional interface brokers can implement in order to
+// create local accounts for resource owners logging in through an upstream
+// provider for the first time. The user linked or provisioned is then given
+// to Broker.SignIn along with the identity.
+type Provisioner interface {
+	// LinkedUser returns the local user linked to the upstream identity, or
+	// a zero User if the resource owner never logged in with it before.
+	LinkedUser(identity UpstreamIdentity) (types.User, error)
+	// Provision creates a local account out of the upstream claims, linked
+	// to the upstream identity, or refuses to with ErrProvisioningDenied,
+	// ErrProvisioningConflict or ErrProvisioningUnavailable. Other errors
+	// are sent back to the client as server_error.
+	Provision(user ProvisionedUser) (types.User, error)
+}
+
+// ProvisionedUser is an account to create for an upstream identity, with the
+// SCIM core attributes its claims map to. See
+// http://tools.ietf.org/html/rfc7643#section-4.1
+type ProvisionedUser struct {
+	// Upstream identity, along with every claim.
+	Identity UpstreamIdentity
+	// Identifier of the resource owner at the upstream, its sub claim.
+	ExternalID string
+	// Its preferred_username claim, or its email address, if verified.
+	UserName string
+	// Its name, given_name and family_name claims.
+	DisplayName string
+	GivenName   string
+	FamilyName  string
+	// Its email claim, only if the upstream verified it, so accounts can not
+	// be claimed with someone else's address.
+	Email string
+	// Its locale claim.
+	Locale string
+}
+
+// newProvisionedUser maps the claims of an upstream identity to the SCIM
+// attributes of the account to create.
+func newProvisionedUser(identity UpstreamIdentity) ProvisionedUser {
+	claim := func(name string) string {
+		v, _ := identity.Claims[name].(string)
+		return v
+	}
+
+	user := ProvisionedUser{
+		Identity:    identity,
+		ExternalID:  identity.Subject,
+		UserName:    claim("preferred_username"),
+		DisplayName: identity.Name,
+		GivenName:   claim("given_name"),
+		FamilyName:  claim("family_name"),
+		Locale:      claim("locale"),
+	}
+
+	if identity.EmailVerified {
+		user.Email = identity.Email
+	}
+
+	if user.UserName == "" {
+		user.UserName = user.Email
+	}
+	return user
+}
+
+// linkedUser returns the local user linked to the upstream identity,
+// provisioning it if the resource owner logs in for the first time.
+func linkedUser(req *http.Request, cfg config, p Provisioner, identity UpstreamIdentity) (types.User, error) {
+	user, err := p.LinkedUser(identity)
+	if err != nil || user.ID != "" {
+		return user, err
+	}
+
+	user, err = p.Provision(newProvisionedUser(identity))
+	if err != nil {
+		return user, err
+	}
+
+	log.Printf("[INFO] request_id=%s Provisioned user %s for %s of upstream %s", RequestID(req), user.ID, identity.Subject, identity.Upstream)
+	audit(req, cfg, types.AuditEvent{
+		Type:   types.AuditUserProvisioned,
+		UserID: user.ID,
+		Details: map[string]string{
+			"upstream": identity.Upstream,
+			"issuer":   identity.Issuer,
+			"subject":  identity.Subject,
+		},
+	})
+	return user, nil
+}
+
+// provisioningError returns the OAuth2 error a provisioning failure is sent
+// back to the client with.
+func provisioningError(req *http.Request, cfg config, err error) types.AuthzError {
+	switch err {
+	case ErrProvisioningDenied:
+		return ErrUserProvisioningDenied
+	case ErrProvisioningConflict:
+		return ErrUserAccountConflict
+	case ErrProvisioningUnavailable:
+		return ErrTemporarilyUnavailable
+	}
+	return serverError(req, cfg, "", err)
+}
+
+// resumeWithError ends the authorization request the login was started for
+// with the given error, sent to the client as long as the request is valid.
+// Otherwise, the resource owner is told about the request being invalid.
+func resumeWithError(w http.ResponseWriter, req *http.Request, cfg config, returnURL string, e types.AuthzError) {
+	u, err := url.Parse(returnURL)
+	if err != nil {
+		renderBrokerError(w, req, cfg, e)
+		return
+	}
+
+	// The authorization request, as if the resource owner was sent back to it.
+	areq := *req
+	areq.Method = "GET"
+	areq.URL = u
+	areq.Form, areq.PostForm = nil, nil
+
+	params, err := authzRequestParams(&areq, cfg, false)
+	if err != nil {
+		renderBrokerError(w, req, cfg, e)
+		return
+	}
+
+	authzData := authCodeGrant1(w, &areq, cfg, newAuthorizationRequest(params))
+	if authzData == nil {
+		return
+	}
+
+	e.State = authzData.State
+	redirectErr(w, req, cfg, authzData.Client.RedirectURL, authzData.ResponseMode, e)
+}
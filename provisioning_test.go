@@ -0,0 +1,156 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+type testProvisioner struct {
+	testBroker
+	linked      map[string]types.User
+	provisioned []ProvisionedUser
+	err         error
+}
+
+func (p *testProvisioner) LinkedUser(identity UpstreamIdentity) (types.User, error) {
+	return p.linked[identity.Subject], nil
+}
+
+func (p *testProvisioner) Provision(user ProvisionedUser) (types.User, error) {
+	if p.err != nil {
+		return types.User{}, p.err
+	}
+
+	p.provisioned = append(p.provisioned, user)
+	u := types.User{ID: "user-" + user.ExternalID, Name: user.DisplayName}
+	p.linked[user.ExternalID] = u
+	return u, nil
+}
+
+// TestBrokerProvisioning tests that accounts are provisioned for resource
+// owners logging in through an upstream provider for the first time, and
+// that provisioning failures are sent back to the client.
+func TestBrokerProvisioning(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	var nonce string
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		idToken, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256}, map[string]interface{}{
+			"iss":            "https://idp.acme.com",
+			"sub":            "jdoe",
+			"aud":            "broker",
+			"exp":            time.Now().Add(time.Minute).Unix(),
+			"nonce":          nonce,
+			"email":          "jdoe@acme.com",
+			"email_verified": true,
+			"name":           "John Doe",
+			"given_name":     "John",
+		}, key)
+		ok(t, err)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	}))
+	defer upstream.Close()
+
+	client := brokerClient
+	brokerClient = upstream.Client()
+	defer func() { brokerClient = client }()
+
+	broker := &testProvisioner{
+		testBroker: testBroker{upstream: Upstream{
+			ID:                    "acme",
+			Issuer:                "https://idp.acme.com",
+			AuthorizationEndpoint: "https://idp.acme.com/authorize",
+			TokenEndpoint:         upstream.URL + "/token",
+			ClientID:              "broker",
+		}},
+		linked: make(map[string]types.User),
+	}
+
+	provider := test.NewProvider(false)
+	cfg := setupTest()
+	cfg.provider = provider
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+	SetBroker(broker)(&cfg)
+	SetBrokerEndpoint("/oauth2/broker")(&cfg)
+
+	login := func() *httptest.ResponseRecorder {
+		areq := authzRequest(t, cfg)
+		areq.URL.RawQuery += "&tenant=acme"
+		w := httptest.NewRecorder()
+		CreateGrant(w, areq, cfg)
+		equals(t, http.StatusFound, w.Code)
+
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		nonce = u.Query().Get("nonce")
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/broker?code=upstream-code&state="+url.QueryEscape(u.Query().Get("state")), nil)
+		ok(t, err)
+		req.AddCookie(w.Result().Cookies()[0])
+
+		w = httptest.NewRecorder()
+		BrokerCallback(w, req, cfg)
+		return w
+	}
+
+	w := login()
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(broker.provisioned))
+	user := broker.provisioned[0]
+	equals(t, "jdoe", user.ExternalID)
+	equals(t, "jdoe@acme.com", user.UserName)
+	equals(t, "jdoe@acme.com", user.Email)
+	equals(t, "John Doe", user.DisplayName)
+	equals(t, "John", user.GivenName)
+	equals(t, "user-jdoe", broker.identities[0].User.ID)
+
+	equals(t, 2, len(*events))
+	equals(t, types.AuditUserProvisioned, (*events)[0].Type)
+	equals(t, "user-jdoe", (*events)[0].UserID)
+
+	// Resource owners are provisioned only once.
+	w = login()
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(broker.provisioned))
+	equals(t, "user-jdoe", broker.identities[1].User.ID)
+
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{ErrProvisioningDenied, types.ErrorAccessDenied},
+		{ErrProvisioningConflict, types.ErrorAccessDenied},
+		{ErrProvisioningUnavailable, types.ErrorTemporarilyUnavailable},
+	}
+
+	broker.linked = make(map[string]types.User)
+	for _, tt := range tests {
+		broker.err = tt.err
+		w := login()
+		equals(t, http.StatusFound, w.Code)
+
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		equals(t, provider.Client.RedirectURL.Host, u.Host)
+		equals(t, tt.code, u.Query().Get("error"))
+		equals(t, "state-test", u.Query().Get("state"))
+	}
+	equals(t, 2, len(broker.identities))
+}
@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/hooklift/oauth2/redirecturi"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Quirk is a deviation from the specs tolerated for the sake of real-world
+// clients relying on it. None is tolerated by default.
+type Quirk int
+
+const (
+	// QuirkGrantTypeInQuery accepts grant_type in the query string of token
+	// requests, rather than only in their body.
+	// http://tools.ietf.org/html/rfc6749#section-4.1.3
+	QuirkGrantTypeInQuery Quirk = 1 << iota
+	// QuirkRedirectTrailingSlash accepts redirect URIs differing from the
+	// registered ones by a trailing slash in their path, both in
+	// authorization requests and when exchanging the codes issued to them.
+	// Authorization responses are still sent to the registered URI.
+	QuirkRedirectTrailingSlash
+	// QuirkStringExpiresIn sends expires_in as a string in token responses,
+	// as this package did in the past, rather than as a number.
+	// http://tools.ietf.org/html/rfc6749#section-5.1
+	QuirkStringExpiresIn
+)
+
+// SetQuirks tolerates the given quirks of legacy clients. Each of them is
+// enabled on its own, for instance:
+//
+//	oauth2.SetQuirks(oauth2.QuirkGrantTypeInQuery, oauth2.QuirkStringExpiresIn)
+func SetQuirks(quirks ...Quirk) option {
+	return func(c *config) {
+		for _, q := range quirks {
+			c.quirks |= q
+		}
+	}
+}
+
+// tolerates tells whether the given quirk is enabled. Redirect URIs have to
+// match exactly with OAuth 2.1.
+func (c config) tolerates(q Quirk) bool {
+	if c.oauth21 && q == QuirkRedirectTrailingSlash {
+		return false
+	}
+	return c.quirks&q != 0
+}
+
+// grantType returns the grant_type parameter of a token request.
+func grantType(req *http.Request, cfg config) string {
+	if cfg.tolerates(QuirkGrantTypeInQuery) {
+		return req.FormValue("grant_type")
+	}
+	return req.PostFormValue("grant_type")
+}
+
+// tokenResponse returns the token as sent to clients, with expires_in as a
+// number unless QuirkStringExpiresIn is enabled.
+func tokenResponse(cfg config, token types.Token) interface{} {
+	if cfg.tolerates(QuirkStringExpiresIn) {
+		return token
+	}
+
+	return struct {
+		types.Token
+		ExpiresIn json.Number `json:"expires_in"`
+	}{token, json.Number(token.ExpiresIn)}
+}
+
+// sameRedirectURI tells whether the redirect URI sent when exchanging a code
+// is the one sent when requesting it.
+func sameRedirectURI(cfg config, sent, requested string) bool {
+	if sent == requested {
+		return true
+	}
+	return cfg.tolerates(QuirkRedirectTrailingSlash) && redirecturi.SameIgnoringTrailingSlash(sent, requested)
+}
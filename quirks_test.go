@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestQuirks tests that deviations from the specs are only tolerated when
+// enabled, each on its own.
+func TestQuirks(t *testing.T) {
+	issueToken := func(cfg config, query, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens"+query, bytes.NewBufferString(body))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	expiresIn := func(w *httptest.ResponseRecorder) interface{} {
+		equals(t, http.StatusOK, w.Code)
+		var body map[string]interface{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body["expires_in"]
+	}
+
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	equals(t, float64(600), expiresIn(issueToken(cfg, "", "grant_type=client_credentials&scope=read")))
+
+	w := issueToken(cfg, "?grant_type=client_credentials", "scope=read")
+	equals(t, http.StatusBadRequest, w.Code)
+	assert(t, strings.Contains(w.Body.String(), "unsupported_grant_type"), "unexpected response %s", w.Body.String())
+
+	SetQuirks(QuirkGrantTypeInQuery)(&cfg)
+	equals(t, float64(600), expiresIn(issueToken(cfg, "?grant_type=client_credentials", "scope=read")))
+
+	SetQuirks(QuirkStringExpiresIn)(&cfg)
+	equals(t, "600", expiresIn(issueToken(cfg, "", "grant_type=client_credentials&scope=read")))
+
+	// Either way, responses decode into types.Token.
+	token := types.Token{}
+	ok(t, json.Unmarshal([]byte(`{"access_token":"a","token_type":"bearer","expires_in":600}`), &token))
+	equals(t, "600", token.ExpiresIn)
+}
+
+// TestQuirkRedirectTrailingSlash tests that redirect URIs with a trailing
+// slash are accepted when enabled, sending the authorization response to the
+// registered redirect URI and exchanging the code with either one.
+func TestQuirkRedirectTrailingSlash(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	authorize := func() *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String() + "/"},
+			"scope":         {"read"},
+			ConsentParam:    {"approve"},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	w := authorize()
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrRedirectURLMismatch.Code), "unexpected response %s", w.Body.String())
+
+	SetQuirks(QuirkRedirectTrailingSlash)(&cfg)
+	w = authorize()
+	equals(t, http.StatusFound, w.Code)
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, provider.Client.RedirectURL.String(), u.Scheme+"://"+u.Host+u.Path)
+
+	equals(t, true, sameRedirectURI(cfg, provider.Client.RedirectURL.String(), provider.Client.RedirectURL.String()+"/"))
+	cfg.quirks = 0
+	equals(t, false, sameRedirectURI(cfg, provider.Client.RedirectURL.String(), provider.Client.RedirectURL.String()+"/"))
+}
@@ -0,0 +1,83 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// TokenQuotaProvider is an optional interface that providers can implement
+// in order to enforce a quota of active refresh tokens. It is required by
+// SetTokenQuota.
+type TokenQuotaProvider interface {
+	// ActiveRefreshTokens returns the refresh tokens issued to a client on
+	// behalf of a resource owner that are still active, oldest first.
+	ActiveRefreshTokens(userID, clientID string) ([]types.Token, error)
+}
+
+// ErrTokenQuotaProviderRequired is returned when issuing refresh tokens with
+// a quota and a provider that does not implement TokenQuotaProvider.
+var ErrTokenQuotaProviderRequired = errors.New("oauth2: provider does not implement oauth2.TokenQuotaProvider")
+
+// SetTokenQuota limits the number of active refresh tokens, and therefore
+// sessions, a client can hold on behalf of a resource owner. Whenever a new
+// refresh token goes over the quota, the oldest ones are revoked and the
+// auditor is sent a types.AuditTokenQuotaExceeded event. Rotating a refresh
+// token does not count towards the quota. It requires the provider to
+// implement TokenQuotaProvider. Unlimited by default.
+func SetTokenQuota(max int) option {
+	return func(c *config) {
+		c.tokenQuota = max
+	}
+}
+
+// enforceTokenQuota revokes the oldest refresh tokens of the client and
+// resource owner the given token was issued to, if over the quota.
+func enforceTokenQuota(req *http.Request, cfg config, token types.Token) error {
+	if cfg.tokenQuota <= 0 || token.RefreshToken == "" {
+		return nil
+	}
+
+	p, ok := asTokenQuotaProvider(cfg.provider)
+	if !ok {
+		return ErrTokenQuotaProviderRequired
+	}
+
+	tokens, err := p.ActiveRefreshTokens(token.UserID, token.ClientID)
+	if err != nil {
+		return err
+	}
+
+	excess := len(tokens) - cfg.tokenQuota
+	if excess <= 0 {
+		return nil
+	}
+
+	for _, t := range tokens[:excess] {
+		if err := cfg.provider.RevokeToken(t.RefreshToken); err != nil {
+			return err
+		}
+		publishRevocation(cfg, t.RefreshToken)
+	}
+
+	log.Printf("[INFO] request_id=%s Revoked %d refresh tokens of client %s over the quota of %d",
+		RequestID(req), excess, token.ClientID, cfg.tokenQuota)
+
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditTokenQuotaExceeded,
+		ClientID: token.ClientID,
+		UserID:   token.UserID,
+		Details: map[string]string{
+			"quota":   strconv.Itoa(cfg.tokenQuota),
+			"revoked": strconv.Itoa(excess),
+		},
+	})
+	return nil
+}
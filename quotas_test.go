@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestTokenQuota tests that the oldest refresh tokens are revoked when a
+// client goes over its quota, and that the auditor is told about it.
+func TestTokenQuota(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+	SetTokenQuota(2)(&cfg)
+
+	issue := func() types.Token {
+		values := url.Values{
+			"grant_type": {"password"},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+			"scope":      {"read"},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		token := types.Token{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		return token
+	}
+
+	first := issue()
+	issue()
+	equals(t, 0, len(*events))
+
+	third := issue()
+	equals(t, 2, len(provider.RefreshTokens))
+	_, found := provider.RefreshTokens[first.RefreshToken]
+	assert(t, !found, "oldest refresh token should have been revoked")
+	_, found = provider.RefreshTokens[third.RefreshToken]
+	assert(t, found, "newest refresh token should be active")
+
+	equals(t, 1, len(*events))
+	event := (*events)[0]
+	equals(t, types.AuditTokenQuotaExceeded, event.Type)
+	equals(t, provider.Client.ID, event.ClientID)
+	equals(t, "2", event.Details["quota"])
+	equals(t, "1", event.Details["revoked"])
+}
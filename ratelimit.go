@@ -0,0 +1,136 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/ratelimit"
+)
+
+// limit is a maximum number of events allowed within a time window.
+type limit struct {
+	store  ratelimit.Store
+	max    int64
+	window time.Duration
+}
+
+// SetRateLimit allows up to the given number of requests to the token endpoint
+// per client within the given time window. Requests beyond the limit are
+// rejected with 429 Too Many Requests until the window expires.
+//
+// Use ratelimit.RedisStore to share counters among several instances of
+// the handler.
+func SetRateLimit(store ratelimit.Store, requests int, window time.Duration) option {
+	return func(c *config) {
+		c.rateLimit = limit{
+			store:  store,
+			max:    int64(requests),
+			window: window,
+		}
+	}
+}
+
+// SetLockout locks clients and resource owners out of the token endpoint
+// for the given duration after the given number of consecutive
+// authentication failures. A successful authentication clears the count.
+func SetLockout(store ratelimit.Store, attempts int, duration time.Duration) option {
+	return func(c *config) {
+		c.lockout = limit{
+			store:  store,
+			max:    int64(attempts),
+			window: duration,
+		}
+	}
+}
+
+// throttle counts a request for the given key and renders an error if the
+// rate limit was exceeded. Store errors let requests through.
+func throttle(w http.ResponseWriter, req *http.Request, cfg config, key string) bool {
+	l := cfg.rateLimit
+	if l.store == nil {
+		return false
+	}
+
+	count, err := l.store.Incr("rate:"+key, l.window)
+	if err != nil {
+		log.Printf("[ERROR] Error counting requests: %+v", err)
+		return false
+	}
+
+	if count <= l.max {
+		return false
+	}
+
+	renderTooManyRequests(w, req, cfg, l.window)
+	return true
+}
+
+// lockedOut renders an error if the given key is locked out.
+func lockedOut(w http.ResponseWriter, req *http.Request, cfg config, key string) bool {
+	l := cfg.lockout
+	if l.store == nil {
+		return false
+	}
+
+	count, err := l.store.Count("lockout:" + key)
+	if err != nil {
+		log.Printf("[ERROR] Error getting failed attempts: %+v", err)
+		return false
+	}
+
+	if count < l.max {
+		return false
+	}
+
+	renderTooManyRequests(w, req, cfg, l.window)
+	return true
+}
+
+// authFailed records a failed authentication attempt for the given key.
+func authFailed(cfg config, key string) {
+	l := cfg.lockout
+	if l.store == nil {
+		return
+	}
+
+	if _, err := l.store.Incr("lockout:"+key, l.window); err != nil {
+		log.Printf("[ERROR] Error recording failed attempt: %+v", err)
+	}
+}
+
+// authSucceeded clears failed authentication attempts for the given key.
+func authSucceeded(cfg config, key string) {
+	l := cfg.lockout
+	if l.store == nil {
+		return
+	}
+
+	if err := l.store.Reset("lockout:" + key); err != nil {
+		log.Printf("[ERROR] Error clearing failed attempts: %+v", err)
+	}
+}
+
+// clientKey identifies the client making the request, falling back to its
+// IP address when it did not send credentials.
+func clientKey(req *http.Request) string {
+	if username, _, ok := req.BasicAuth(); ok && username != "" {
+		return "client:" + username
+	}
+
+	return "ip:" + clientIP(req)
+}
+
+func renderTooManyRequests(w http.ResponseWriter, req *http.Request, cfg config, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter/time.Second)))
+	render.JSON(w, render.Options{
+		Status: http.StatusTooManyRequests,
+		Data:   localize(req, cfg, ErrTooManyRequests),
+	})
+}
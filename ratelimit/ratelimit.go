@@ -0,0 +1,100 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package ratelimit defines the storage of counters used by the oauth2
+// package to throttle requests and to lock out clients and resource owners
+// after repeated authentication failures. Sharing a Store between
+// instances of the oauth2 handler keeps throttling decisions consistent
+// when scaling horizontally.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps counters that expire after a time window.
+type Store interface {
+	// Incr increments the counter of the given key and returns its new value.
+	// A counter that does not exist is created with the given expiration window.
+	Incr(key string, window time.Duration) (int64, error)
+
+	// Count returns the current value of the counter, or 0 if it does not
+	// exist or expired.
+	Count(key string) (int64, error)
+
+	// Reset removes the counter.
+	Reset(key string) error
+}
+
+// MemoryStore is a Store for single instance deployments.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	value     int64
+	expiresAt time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters: make(map[string]*counter),
+	}
+}
+
+// Incr implements Store.
+func (s *MemoryStore) Incr(key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.expiresAt) {
+		// Takes the chance to remove expired counters, once in a while, so
+		// memory does not grow unbounded.
+		if now.Sub(s.lastSweep) > time.Minute {
+			s.sweep(now)
+			s.lastSweep = now
+		}
+
+		c = &counter{expiresAt: now.Add(window)}
+		s.counters[key] = c
+	}
+
+	c.value++
+	return c.value, nil
+}
+
+// Count implements Store.
+func (s *MemoryStore) Count(key string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok || !time.Now().Before(c.expiresAt) {
+		return 0, nil
+	}
+	return c.value, nil
+}
+
+// Reset implements Store.
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.counters, key)
+	return nil
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for k, c := range s.counters {
+		if !now.Before(c.expiresAt) {
+			delete(s.counters, k)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func testStore(t *testing.T, s Store) {
+	for i := int64(1); i <= 3; i++ {
+		count, err := s.Incr("key", time.Duration(1)*time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != i {
+			t.Errorf("expected count %d, got %d", i, count)
+		}
+	}
+
+	count, err := s.Count("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("expected count 3, got %d", count)
+	}
+
+	if err := s.Reset("key"); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err = s.Count("key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("expected count 0 after reset, got %d", count)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMemoryStoreExpiration(t *testing.T) {
+	s := NewMemoryStore()
+	s.Incr("key", time.Duration(10)*time.Millisecond)
+	time.Sleep(time.Duration(20) * time.Millisecond)
+
+	count, _ := s.Count("key")
+	if count != 0 {
+		t.Errorf("expected counter to expire, got %d", count)
+	}
+
+	count, _ = s.Incr("key", time.Duration(1)*time.Minute)
+	if count != 1 {
+		t.Errorf("expected a new counter, got %d", count)
+	}
+}
+
+// fakeRedis emulates the subset of Redis commands used by RedisStore.
+type fakeRedis struct {
+	data    map[string]int64
+	expires map[string]int64
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "EVAL":
+		key := args[2].(string)
+		f.data[key]++
+		if f.data[key] == 1 {
+			f.expires[key] = args[3].(int64)
+		}
+		return f.data[key], nil
+	case "GET":
+		v, ok := f.data[args[0].(string)]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case "DEL":
+		delete(f.data, args[0].(string))
+		return int64(1), nil
+	}
+	return nil, nil
+}
+
+func (f *fakeRedis) Close() error {
+	return nil
+}
+
+func TestRedisStore(t *testing.T) {
+	conn := &fakeRedis{
+		data:    make(map[string]int64),
+		expires: make(map[string]int64),
+	}
+
+	s := &RedisStore{
+		Get: func() RedisConn { return conn },
+	}
+	testStore(t, s)
+
+	s.Incr("key", time.Duration(2)*time.Second)
+	if ms := conn.expires["oauth2:ratelimit:key"]; ms != 2000 {
+		t.Errorf("expected expiration of 2000ms, got %d", ms)
+	}
+}
@@ -0,0 +1,98 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// RedisConn is a connection to a Redis server. It is satisfied by
+// github.com/garyburd/redigo/redis.Conn.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Close() error
+}
+
+// ErrUnexpectedReply is returned when Redis replies with an unexpected type.
+var ErrUnexpectedReply = errors.New("ratelimit: unexpected reply from redis")
+
+// Increments a counter and sets its expiration atomically, only when created.
+const incrScript = `
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count`
+
+// RedisStore is a Store shared by all instances of the oauth2 handler.
+type RedisStore struct {
+	// Get returns a connection from a pool. For instance, when using redigo:
+	//	func() ratelimit.RedisConn { return pool.Get() }
+	Get func() RedisConn
+	// Prefix added to all keys. Defaults to "oauth2:ratelimit:".
+	Prefix string
+}
+
+// Incr implements Store.
+func (s *RedisStore) Incr(key string, window time.Duration) (int64, error) {
+	conn := s.Get()
+	defer conn.Close()
+
+	ms := int64(window / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+
+	reply, err := conn.Do("EVAL", incrScript, 1, s.key(key), ms)
+	if err != nil {
+		return 0, err
+	}
+
+	count, ok := reply.(int64)
+	if !ok {
+		return 0, ErrUnexpectedReply
+	}
+	return count, nil
+}
+
+// Count implements Store.
+func (s *RedisStore) Count(key string) (int64, error) {
+	conn := s.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("GET", s.key(key))
+	if err != nil || reply == nil {
+		return 0, err
+	}
+
+	switch v := reply.(type) {
+	case []byte:
+		return strconv.ParseInt(string(v), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case int64:
+		return v, nil
+	}
+	return 0, ErrUnexpectedReply
+}
+
+// Reset implements Store.
+func (s *RedisStore) Reset(key string) error {
+	conn := s.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", s.key(key))
+	return err
+}
+
+func (s *RedisStore) key(key string) string {
+	prefix := s.Prefix
+	if prefix == "" {
+		prefix = "oauth2:ratelimit:"
+	}
+	return prefix + key
+}
@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/ratelimit"
+)
+
+func passwordGrantRequest(t *testing.T, password string) *http.Request {
+	queryStr := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test_user"},
+		"password":   {password},
+	}
+
+	buffer := bytes.NewBufferString(queryStr.Encode())
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+	return req
+}
+
+func TestRateLimit(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	SetRateLimit(ratelimit.NewMemoryStore(), 2, time.Duration(1)*time.Minute)(&cfg)
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+		equals(t, http.StatusOK, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusTooManyRequests, w.Code)
+	equals(t, "60", w.Header().Get("Retry-After"))
+}
+
+func TestLockout(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+	store := ratelimit.NewMemoryStore()
+	SetLockout(store, 3, time.Duration(15)*time.Minute)(&cfg)
+
+	// A successful login clears previous failures.
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		IssueToken(w, passwordGrantRequest(t, "wrong"), cfg)
+		equals(t, http.StatusBadRequest, w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		IssueToken(w, passwordGrantRequest(t, "wrong"), cfg)
+		equals(t, http.StatusBadRequest, w.Code)
+	}
+
+	// Even the right password is rejected while locked out.
+	w = httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusTooManyRequests, w.Code)
+	equals(t, "900", w.Header().Get("Retry-After"))
+
+	ok(t, store.Reset("lockout:user:test_user"))
+	w = httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusOK, w.Code)
+}
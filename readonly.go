@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+)
+
+// SetReadOnlyUntil puts the authorization server in read-only mode until the
+// given time, for instance during a storage failover or an incident. New
+// grants, tokens, refreshes and device authorizations are refused with a
+// temporarily_unavailable error and a Retry-After header, while tokens
+// already issued keep being validated and introspected. Revocations are
+// still accepted, so compromised tokens can be dealt with. Meant to be set
+// with Server.Reload, read-only mode ends by itself at the given time, or
+// right away by reloading with a zero time:
+//
+//	s.Reload(oauth2.SetReadOnlyUntil(time.Now().Add(30 * time.Minute)))
+func SetReadOnlyUntil(t time.Time) option {
+	return func(c *config) {
+		c.readOnlyUntil = t
+	}
+}
+
+// unlessReadOnly refuses requests to handlers writing to the provider while
+// in read-only mode.
+func unlessReadOnly(fn func(http.ResponseWriter, *http.Request, config)) func(http.ResponseWriter, *http.Request, config) {
+	return func(w http.ResponseWriter, req *http.Request, cfg config) {
+		left := cfg.readOnlyUntil.Sub(now(cfg))
+		if cfg.readOnlyUntil.IsZero() || left <= 0 {
+			fn(w, req, cfg)
+			return
+		}
+
+		// Rounded up, so clients never retry too early.
+		retryAfter := (left + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(retryAfter), 10))
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrReadOnly),
+		})
+	}
+}
@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestReadOnly tests that new tokens are refused in read-only mode, while
+// issued tokens can still be introspected, until read-only mode ends.
+func TestReadOnly(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+	s := Handler(nil,
+		SetProvider(provider),
+		SetClock(clock),
+		SetTokenExpiration(time.Hour),
+		SetReadOnlyUntil(clock.now.Add(90*time.Second+500*time.Millisecond)),
+	)
+
+	issued, err := provider.GenToken(types.Grant{Scopes: types.Scopes{{ID: "read"}}}, provider.Client, false, time.Hour)
+	ok(t, err)
+
+	post := func(path string, values url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com"+path, bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		return w
+	}
+	clientCredentials := url.Values{"grant_type": {"client_credentials"}}
+
+	w := post("/oauth2/tokens", clientCredentials)
+	equals(t, http.StatusServiceUnavailable, w.Code)
+	equals(t, "91", w.Header().Get("Retry-After"))
+	var e types.AuthzError
+	ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+	equals(t, types.ErrorTemporarilyUnavailable, e.Code)
+
+	w = post("/oauth2/introspect", url.Values{"token": {issued.Value}})
+	equals(t, http.StatusOK, w.Code)
+	var claims map[string]interface{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+	equals(t, true, claims["active"])
+
+	clock.Advance(91 * time.Second)
+	equals(t, http.StatusOK, post("/oauth2/tokens", clientCredentials).Code)
+
+	s.Reload(SetReadOnlyUntil(clock.now.Add(time.Minute)))
+	equals(t, http.StatusServiceUnavailable, post("/oauth2/tokens", clientCredentials).Code)
+	s.Reload(SetReadOnlyUntil(time.Time{}))
+	equals(t, http.StatusOK, post("/oauth2/tokens", clientCredentials).Code)
+}
@@ -0,0 +1,97 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ConsentReceiptProvider is an optional interface that providers can implement
+// in order to keep signed receipts of every authorization approved, or
+// denied, by resource owners, for auditing purposes. Receipts are only generated if a
+// KeyProvider is also configured.
+type ConsentReceiptProvider interface {
+	// SaveConsentReceipt stores a consent receipt.
+	SaveConsentReceipt(receipt types.ConsentReceipt) error
+
+	// ConsentReceipts returns all the consent receipts of a resource owner.
+	ConsentReceipts(userID string) ([]types.ConsentReceipt, error)
+}
+
+// SetConsentPolicyVersion sets the version of the consent policy recorded in
+// consent receipts.
+func SetConsentPolicyVersion(version string) option {
+	return func(c *config) {
+		c.consentPolicyVersion = version
+	}
+}
+
+// receiptClaims defines the JWT claims of a signed consent receipt.
+type receiptClaims struct {
+	jwt.Claims
+	ClientID      string `json:"client_id"`
+	Scope         string `json:"scope"`
+	Decision      string `json:"decision"`
+	PolicyVersion string `json:"policy_version,omitempty"`
+}
+
+// saveConsentReceipt signs and stores a receipt for the authorization just
+// approved, or denied, by the resource owner. It does nothing if the provider
+// does not keep receipts or there is no key to sign them with.
+func saveConsentReceipt(req *http.Request, cfg config, authzData *AuthzData, decision types.ConsentDecision) error {
+	provider, ok := asConsentReceiptProvider(cfg.provider)
+	if !ok || cfg.keyProvider == nil {
+		return nil
+	}
+
+	user, err := currentUser(req, cfg)
+	if err != nil {
+		return err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	receipt := types.ConsentReceipt{
+		ID:            id,
+		UserID:        user.ID,
+		ClientID:      authzData.Client.ID,
+		Scopes:        authzData.Scopes,
+		Decision:      decision,
+		IssuedAt:      now(cfg),
+		PolicyVersion: cfg.consentPolicyVersion,
+	}
+
+	claims := receiptClaims{
+		Claims: jwt.Claims{
+			Issuer:   issuerURL(req, cfg),
+			Subject:  receipt.UserID,
+			IssuedAt: receipt.IssuedAt.Unix(),
+			ID:       receipt.ID,
+		},
+		ClientID:      receipt.ClientID,
+		Scope:         receipt.Scopes.Encode(),
+		Decision:      string(receipt.Decision),
+		PolicyVersion: receipt.PolicyVersion,
+	}
+
+	receipt.Receipt, err = signJWT(cfg, "", claims)
+	if err != nil {
+		return err
+	}
+
+	return provider.SaveConsentReceipt(receipt)
+}
+
+// newID returns a random identifier.
+func newID() (string, error) {
+	return tokengen.Random{Bytes: 16, Encoding: tokengen.Hex}.Generate()
+}
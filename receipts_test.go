@@ -0,0 +1,90 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestConsentReceipts tests that a signed receipt is kept when the resource
+// owner approves an authorization request, and that it can be retrieved
+// through the grants API.
+func TestConsentReceipts(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})(&cfg)
+	SetConsentPolicyVersion("2015-08")(&cfg)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read identity"},
+		ConsentParam:    {"approve"},
+	}
+
+	buffer := bytes.NewBufferString(values.Encode())
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+	equals(t, 1, len(provider.Receipts))
+
+	receipt := provider.Receipts[0]
+	equals(t, "test_user", receipt.UserID)
+	equals(t, provider.Client.ID, receipt.ClientID)
+	equals(t, "2015-08", receipt.PolicyVersion)
+	equals(t, types.ConsentApproved, receipt.Decision)
+
+	signed, err := jwt.Parse(receipt.Receipt)
+	ok(t, err)
+	ok(t, signed.Verify(key.Public()))
+	equals(t, "1", signed.Header.KeyID)
+
+	claims := receiptClaims{}
+	ok(t, signed.Decode(&claims))
+	equals(t, "test_user", claims.Subject)
+	equals(t, "read identity", claims.Scope)
+	equals(t, "2015-08", claims.PolicyVersion)
+
+	req, err = http.NewRequest("GET", "https://example.com/oauth2/grants/"+receipt.ID, nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	found := types.ConsentReceipt{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &found))
+	equals(t, receipt.Receipt, found.Receipt)
+
+	req, err = http.NewRequest("GET", "https://example.com/oauth2/grants/unknown", nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusNotFound, w.Code)
+}
@@ -0,0 +1,97 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Parameters carrying credentials, which are never logged nor included in
+// audit events.
+var redactedParams = []string{"code", "access_token", "refresh_token", "client_secret", "code_verifier", "device_code"}
+
+// RedactURL returns rawURL without the code, access_token, refresh_token,
+// client_secret, code_verifier and device_code parameters, whether they are
+// in its query or its fragment, as is the case of implicit authorization
+// responses.
+// Strings that are not URLs are returned unchanged.
+//
+// Host applications and providers can use it before logging URLs of their
+// own, such as redirects of authorization responses.
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	redacted := false
+	if u.RawQuery != "" {
+		if query, ok := redactQuery(u.RawQuery); ok {
+			u.RawQuery = query
+			redacted = true
+		}
+	}
+
+	if u.Fragment != "" {
+		if fragment, ok := redactQuery(u.Fragment); ok {
+			u.Fragment = fragment
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return rawURL
+	}
+	return u.String()
+}
+
+// RedactForm returns a copy of form without the parameters stripped by
+// RedactURL.
+func RedactForm(form url.Values) url.Values {
+	redacted := make(url.Values, len(form))
+	for k, v := range form {
+		if !isRedacted(k) {
+			redacted[k] = v
+		}
+	}
+	return redacted
+}
+
+// redactQuery strips redacted parameters from a URL encoded query, telling
+// whether there were any.
+func redactQuery(query string) (string, bool) {
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return query, false
+	}
+
+	for k := range values {
+		if isRedacted(k) {
+			return RedactForm(values).Encode(), true
+		}
+	}
+	return query, false
+}
+
+// redactError strips redacted parameters from URLs reported by HTTP clients
+// in errors, such as failed requests to clients' redirect URLs.
+func redactError(err error) error {
+	if e, ok := err.(*url.Error); ok {
+		redacted := *e
+		redacted.URL = RedactURL(e.URL)
+		return &redacted
+	}
+	return err
+}
+
+func isRedacted(param string) bool {
+	for _, p := range redactedParams {
+		if strings.EqualFold(param, p) {
+			return true
+		}
+	}
+	return false
+}
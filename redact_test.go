@@ -0,0 +1,71 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRedactURL tests that credentials are stripped from queries and
+// fragments, leaving everything else untouched.
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"https://client.example.com/cb?code=abc&state=xyz", "https://client.example.com/cb?state=xyz"},
+		{"https://client.example.com/cb#access_token=abc&state=xyz&token_type=bearer", "https://client.example.com/cb#state=xyz&token_type=bearer"},
+		{"/oauth2/tokens?grant_type=refresh_token&refresh_token=abc", "/oauth2/tokens?grant_type=refresh_token"},
+		{"https://example.com/?client_secret=s3cr3t&code_verifier=v&Code=c", "https://example.com/"},
+		{"https://client.example.com/cb?state=xyz&b=1", "https://client.example.com/cb?state=xyz&b=1"},
+		{"read write", "read write"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		equals(t, tt.expected, RedactURL(tt.url))
+	}
+}
+
+// TestRedactForm tests that credentials are stripped from a copy of form
+// data.
+func TestRedactForm(t *testing.T) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"abc"},
+		"client_secret": {"s3cr3t"},
+		"code_verifier": {"v"},
+	}
+
+	equals(t, "grant_type=authorization_code", RedactForm(form).Encode())
+	equals(t, "abc", form.Get("code"))
+}
+
+// TestRedactedAuditEvents tests that URLs in audit events and in logged
+// errors are redacted.
+func TestRedactedAuditEvents(t *testing.T) {
+	cfg := setupTest()
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+
+	details := map[string]string{"url": "https://client.example.com/cb?code=abc", "scope": "read"}
+	req, err := http.NewRequest("GET", "https://example.com/", nil)
+	ok(t, err)
+	audit(req, cfg, types.AuditEvent{Type: types.AuditTokenLeaked, Details: details})
+
+	equals(t, 1, len(*events))
+	equals(t, "https://client.example.com/cb", (*events)[0].Details["url"])
+	equals(t, "read", (*events)[0].Details["scope"])
+	equals(t, "https://client.example.com/cb?code=abc", details["url"])
+
+	e := redactError(&url.Error{Op: "Get", URL: "https://client.example.com/cb?code=abc", Err: errors.New("timeout")})
+	assert(t, !strings.Contains(e.Error(), "abc"), "code should be redacted from %q", e)
+}
@@ -0,0 +1,230 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package redirecturi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// Documents through which hosts associate their HTTPS URLs with native apps.
+const (
+	// Android Digital Asset Links. https://developer.android.com/training/app-links/verify-android-applinks
+	AssetLinksPath = "/.well-known/assetlinks.json"
+	// Apple App Site Association. https://developer.apple.com/documentation/xcode/supporting-associated-domains
+	AppleAppSiteAssociationPath = "/.well-known/apple-app-site-association"
+)
+
+// Maximum size of association documents.
+const maxAssociationSize = 128 << 10
+
+// Errors verifying claimed HTTPS redirect URIs.
+var (
+	ErrNotAssociated    = errors.New("redirecturi: redirect URI host does not associate the redirect URI with the app")
+	ErrUnknownPlatform  = errors.New("redirecturi: unknown app platform")
+	ErrNoFingerprints   = errors.New("redirecturi: android apps require certificate fingerprints")
+	ErrAssociationFetch = errors.New("redirecturi: association document could not be fetched")
+)
+
+// AppAssociations verifies that native apps can claim HTTPS redirect URIs,
+// as Android App Links or iOS Universal Links, by checking that the host of
+// the redirect URI lists them in its association document.
+// http://tools.ietf.org/html/rfc8252#section-7.2
+type AppAssociations struct {
+	// HTTP client fetching association documents. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Verify checks that the host of the HTTPS redirect URI associates it with
+// the given app.
+func (a AppAssociations) Verify(u *url.URL, app types.AppAssociation) error {
+	if u.Scheme != "https" {
+		return ErrScheme
+	}
+
+	switch app.Platform {
+	case types.PlatformAndroid:
+		if len(app.CertFingerprints) == 0 {
+			return ErrNoFingerprints
+		}
+
+		var statements []assetLink
+		if err := a.fetch(u, AssetLinksPath, &statements); err != nil {
+			return err
+		}
+
+		for _, s := range statements {
+			if s.handlesURLs() && s.Target.Namespace == "android_app" &&
+				s.Target.PackageName == app.AppID && s.signedBy(app.CertFingerprints) {
+				return nil
+			}
+		}
+		return ErrNotAssociated
+	case types.PlatformIOS:
+		var doc appSiteAssociation
+		if err := a.fetch(u, AppleAppSiteAssociationPath, &doc); err != nil {
+			return err
+		}
+
+		for _, d := range doc.AppLinks.Details {
+			if d.includes(app.AppID) && d.matches(u.EscapedPath()) {
+				return nil
+			}
+		}
+		return ErrNotAssociated
+	}
+	return ErrUnknownPlatform
+}
+
+// fetch decodes the association document at the given path of the redirect
+// URI host. Redirects are not followed, as required by both platforms.
+func (a AppAssociations) fetch(u *url.URL, path string, v interface{}) error {
+	client := http.DefaultClient
+	if a.Client != nil {
+		client = a.Client
+	}
+
+	noRedirects := *client
+	noRedirects.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := noRedirects.Get("https://" + u.Host + path)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAssociationFetch, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned %s", ErrAssociationFetch, path, resp.Status)
+	}
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAssociationSize)).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrAssociationFetch, err)
+	}
+	return nil
+}
+
+// assetLink is a statement of a Digital Asset Links document.
+type assetLink struct {
+	Relation []string `json:"relation"`
+	Target   struct {
+		Namespace    string   `json:"namespace"`
+		PackageName  string   `json:"package_name"`
+		Fingerprints []string `json:"sha256_cert_fingerprints"`
+	} `json:"target"`
+}
+
+func (s assetLink) handlesURLs() bool {
+	for _, r := range s.Relation {
+		if r == "delegate_permission/common.handle_all_urls" {
+			return true
+		}
+	}
+	return false
+}
+
+func (s assetLink) signedBy(fingerprints []string) bool {
+	for _, f := range fingerprints {
+		for _, t := range s.Target.Fingerprints {
+			if strings.EqualFold(f, t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// appSiteAssociation is an Apple App Site Association document, in either
+// its legacy form, with "appID" and "paths", or its current one, with
+// "appIDs" and "components".
+type appSiteAssociation struct {
+	AppLinks struct {
+		Details []appSiteAssociationDetail `json:"details"`
+	} `json:"applinks"`
+}
+
+// appSiteAssociationDetail lists the paths handled by some apps.
+type appSiteAssociationDetail struct {
+	AppID      string   `json:"appID"`
+	AppIDs     []string `json:"appIDs"`
+	Paths      []string `json:"paths"`
+	Components []struct {
+		Path    string `json:"/"`
+		Exclude bool   `json:"exclude"`
+	} `json:"components"`
+}
+
+func (d appSiteAssociationDetail) includes(appID string) bool {
+	if d.AppID == appID {
+		return true
+	}
+
+	for _, id := range d.AppIDs {
+		if id == appID {
+			return true
+		}
+	}
+	return false
+}
+
+// matches tells whether the path is handled by the app. Patterns are
+// evaluated in order, and the first one matching decides.
+func (d appSiteAssociationDetail) matches(path string) bool {
+	for _, c := range d.Components {
+		if c.Path != "" && wildcardMatch(c.Path, path) {
+			return !c.Exclude
+		}
+	}
+
+	for _, p := range d.Paths {
+		if strings.HasPrefix(p, "NOT ") {
+			if wildcardMatch(strings.TrimPrefix(p, "NOT "), path) {
+				return false
+			}
+			continue
+		}
+
+		if wildcardMatch(p, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches patterns where "*" matches any sequence of
+// characters, including "/", and "?" any single character. It backtracks to
+// the last "*" only, so hostile patterns can't make it slow.
+func wildcardMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, mark := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, i
+			p++
+		case star >= 0:
+			mark++
+			p, i = star+1, mark
+		default:
+			return false
+		}
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
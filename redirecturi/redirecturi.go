@@ -0,0 +1,192 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package redirecturi validates the redirect URIs clients register and the
+// ones they send in authorization requests, in accordance with
+// http://tools.ietf.org/html/rfc6749#section-3.1.2 and, for native apps,
+// http://tools.ietf.org/html/rfc8252#section-7. It is used by the oauth2
+// package wherever redirect URIs are received, so they are checked the same
+// way when authorizing clients and when registering them.
+package redirecturi
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// Errors validating redirect URIs.
+var (
+	ErrNotAbsolute = errors.New("redirecturi: redirect URI has to be absolute")
+	ErrFragment    = errors.New("redirecturi: redirect URI must not include a fragment")
+	ErrScheme      = errors.New("redirecturi: redirect URI scheme is not allowed")
+	ErrHost        = errors.New("redirecturi: redirect URI host is missing or not allowed")
+	ErrUserInfo    = errors.New("redirecturi: redirect URI must not include credentials")
+	ErrMismatch    = errors.New("redirecturi: redirect URI does not match any registered one")
+)
+
+// Policy tells which redirect URIs are acceptable. HTTPS redirect URIs are
+// always acceptable. The zero value only accepts them.
+type Policy struct {
+	// Schemes accepted besides https, such as the private-use URI schemes
+	// of native apps like "com.example.app".
+	// http://tools.ietf.org/html/rfc8252#section-7.1
+	Schemes []string
+	// Whether plain http redirect URIs to loopback IP addresses are accepted,
+	// for native apps listening on an ephemeral port. Their port is ignored
+	// when matching them against registered ones.
+	// http://tools.ietf.org/html/rfc8252#section-7.3
+	Loopback bool
+	// Whether plain http redirect URIs to "localhost" are accepted, for
+	// clients under development. Not meant for production, as the name may
+	// resolve to something else. See IsLoopback.
+	Localhost bool
+	// Redirect URIs accepted as they are, such as the out-of-band one.
+	Exact []string
+	// Whether requested redirect URIs differing from the registered ones by
+	// a trailing slash in their path match them, for clients adding or
+	// dropping it.
+	TrailingSlash bool
+}
+
+// Parse parses a redirect URI and validates it against the policy.
+func (p Policy) Parse(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty fragments are lost once parsed.
+	if strings.Contains(rawURL, "#") {
+		return nil, ErrFragment
+	}
+
+	if err := p.Validate(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Validate checks a redirect URI against the policy. Besides their scheme,
+// redirect URIs can't include a fragment nor credentials and their host
+// can't include wildcards, as they can be abused to redirect elsewhere.
+func (p Policy) Validate(u *url.URL) error {
+	for _, exact := range p.Exact {
+		if u.String() == exact {
+			return nil
+		}
+	}
+
+	if !u.IsAbs() {
+		return ErrNotAbsolute
+	}
+
+	if u.Fragment != "" {
+		return ErrFragment
+	}
+
+	if u.User != nil {
+		return ErrUserInfo
+	}
+
+	switch {
+	case u.Scheme == "https":
+	case u.Scheme == "http" && p.Loopback && IsLoopback(u):
+	case u.Scheme == "http" && p.Localhost && IsLocalhost(u):
+	case p.allowedScheme(u.Scheme):
+		// Private-use URI schemes have no authority component.
+		return nil
+	default:
+		return ErrScheme
+	}
+
+	if u.Hostname() == "" || strings.ContainsAny(u.Host, "*%\\") {
+		return ErrHost
+	}
+	return nil
+}
+
+func (p Policy) allowedScheme(scheme string) bool {
+	for _, s := range p.Schemes {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the redirect URI to send the authorization response to, out
+// of the requested one and the registered ones. URIs are compared as strings
+// without normalization, as required by
+// http://tools.ietf.org/html/rfc6749#section-3.1.2.3, except for the port of
+// loopback redirect URIs, if accepted by the policy.
+func (p Policy) Match(registered []*url.URL, requested string) (*url.URL, error) {
+	for _, u := range registered {
+		if u != nil && u.String() == requested {
+			return u, nil
+		}
+	}
+
+	if p.TrailingSlash {
+		for _, u := range registered {
+			if u != nil && SameIgnoringTrailingSlash(u.String(), requested) {
+				return u, nil
+			}
+		}
+	}
+
+	if !p.Loopback {
+		return nil, ErrMismatch
+	}
+
+	r, err := url.Parse(requested)
+	if err != nil || r.Scheme != "http" || !IsLoopback(r) {
+		return nil, ErrMismatch
+	}
+
+	for _, u := range registered {
+		if u == nil || u.Scheme != "http" || !IsLoopback(u) {
+			continue
+		}
+
+		if u.Hostname() == r.Hostname() && u.EscapedPath() == r.EscapedPath() &&
+			u.RawQuery == r.RawQuery && r.User == nil && r.Fragment == "" {
+			return r, nil
+		}
+	}
+	return nil, ErrMismatch
+}
+
+// IsLoopback tells whether the redirect URI points to a loopback IP address.
+// The "localhost" name is not considered a loopback address, as it may
+// resolve to something else. http://tools.ietf.org/html/rfc8252#section-8.3
+func IsLoopback(u *url.URL) bool {
+	ip := net.ParseIP(u.Hostname())
+	return ip != nil && ip.IsLoopback()
+}
+
+// IsLocalhost tells whether the redirect URI points to the "localhost" name.
+func IsLocalhost(u *url.URL) bool {
+	return strings.EqualFold(u.Hostname(), "localhost")
+}
+
+// SameIgnoringTrailingSlash tells whether two redirect URIs are the same,
+// but for a trailing slash in their path.
+func SameIgnoringTrailingSlash(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	ua.Path = strings.TrimSuffix(ua.Path, "/")
+	ua.RawPath = ""
+	ub.Path = strings.TrimSuffix(ub.Path, "/")
+	ub.RawPath = ""
+	return ua.String() == ub.String()
+}
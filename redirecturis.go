@@ -0,0 +1,69 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/url"
+
+	"github.com/hooklift/oauth2/redirecturi"
+	"github.com/hooklift/oauth2/types"
+)
+
+// SetRedirectPolicy sets which redirect URIs are accepted, both in
+// authorization requests and when changing clients' redirect URL. Defaults
+// to HTTPS redirect URIs only. For instance, for native apps:
+//
+//	SetRedirectPolicy(redirecturi.Policy{
+//		Schemes:  []string{"com.example.app"},
+//		Loopback: true,
+//	})
+//
+// The out-of-band redirect URI set with SetDisplayCodeForm is always accepted.
+func SetRedirectPolicy(p redirecturi.Policy) option {
+	return func(c *config) {
+		c.redirectPolicy = p
+	}
+}
+
+// redirectPolicy returns the redirect URI policy in effect.
+func redirectPolicy(cfg config) redirecturi.Policy {
+	p := cfg.redirectPolicy
+	if cfg.tolerates(QuirkRedirectTrailingSlash) {
+		p.TrailingSlash = true
+	}
+	if cfg.oauth21 {
+		p.TrailingSlash = false
+	}
+	if cfg.displayCode.form != nil {
+		p.Exact = append(p.Exact[:len(p.Exact):len(p.Exact)], cfg.displayCode.redirectURI)
+	}
+	return p
+}
+
+// SetAppAssociationVerification verifies HTTPS redirect URLs of clients with
+// native apps, see types.AppAssociation, when changed through the admin API.
+// Their host has to associate them with every app of the client, in its
+// Digital Asset Links or Apple App Site Association document, for the apps
+// to be opened by the authorization response instead of the browser.
+func SetAppAssociationVerification(v redirecturi.AppAssociations) option {
+	return func(c *config) {
+		c.appAssociations = &v
+	}
+}
+
+// verifyAppAssociations checks that a HTTPS redirect URL is claimed by the
+// native apps of the client, if verification is enabled.
+func verifyAppAssociations(cfg config, client types.Client, u *url.URL) error {
+	if cfg.appAssociations == nil || u.Scheme != "https" {
+		return nil
+	}
+
+	for _, app := range client.AppAssociations {
+		if err := cfg.appAssociations.Verify(u, app); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/redirecturi"
+)
+
+// TestLoopbackRedirect tests that native apps can receive authorization codes
+// on the ephemeral port they listen to, if allowed by the redirect policy.
+func TestLoopbackRedirect(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.RedirectURL, _ = url.Parse("http://127.0.0.1/callback")
+	cfg.provider = provider
+
+	authorize := func() *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {"http://127.0.0.1:51234/callback"},
+			"scope":         {"read"},
+			ConsentParam:    {"approve"},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+
+	w := authorize()
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), ErrRedirectURLInvalid.Description), "expected an error: %s", w.Body.String())
+
+	SetRedirectPolicy(redirecturi.Policy{Loopback: true})(&cfg)
+	w = authorize()
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "127.0.0.1:51234", u.Host)
+
+	values := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {u.Query().Get("code")},
+		"redirect_uri": {"http://127.0.0.1:51234/callback"},
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+}
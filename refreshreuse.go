@@ -0,0 +1,65 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// TokenFamilyRevoker is an optional interface that providers can implement
+// in order to revoke a whole token family when one of its refresh tokens is
+// used again after being rotated. Reuses are only detected if the provider
+// keeps rotated refresh tokens, with the types.TokenRotated status.
+type TokenFamilyRevoker interface {
+	// RevokeTokenFamily revokes every access and refresh token of the family.
+	RevokeTokenFamily(familyID string) error
+}
+
+// refreshReused responds to the reuse of a rotated refresh token by
+// revoking its family, if possible, and raising a high severity audit event
+// for incident response tooling to react to.
+func refreshReused(req *http.Request, cfg config, client types.Client, token types.Token) {
+	details := map[string]string{
+		"family_id":      token.FamilyID,
+		"generation":     strconv.Itoa(token.Generation),
+		"scope":          token.Scopes.Encode(),
+		"presented_by":   client.ID,
+		"family_revoked": "false",
+	}
+
+	if p, ok := asTokenFamilyProvider(cfg.provider); ok && token.FamilyID != "" {
+		family, err := p.TokenFamily(token.FamilyID)
+		if err != nil {
+			log.Printf("[ERROR] request_id=%s Error looking up token family %s: %v", RequestID(req), token.FamilyID, err)
+		} else if family.ID != "" {
+			details["current_generation"] = strconv.Itoa(family.Generation)
+			details["access_token_count"] = strconv.Itoa(family.AccessTokenCount)
+		}
+	}
+
+	if p, ok := asTokenFamilyRevoker(cfg.provider); ok && token.FamilyID != "" {
+		if err := p.RevokeTokenFamily(token.FamilyID); err != nil {
+			log.Printf("[ERROR] request_id=%s Error revoking token family %s: %v", RequestID(req), token.FamilyID, err)
+		} else {
+			details["family_revoked"] = "true"
+			publishRevocation(cfg, "")
+		}
+	}
+
+	log.Printf("[WARN] request_id=%s Rotated refresh token of family %s reused by client %s, family revoked: %s",
+		RequestID(req), token.FamilyID, client.ID, details["family_revoked"])
+
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditRefreshTokenReused,
+		Severity: types.AuditSeverityHigh,
+		ClientID: token.ClientID,
+		UserID:   token.UserID,
+		Details:  details,
+	})
+}
@@ -0,0 +1,111 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRefreshTokenReuse tests that presenting a rotated refresh token again
+// revokes its family and raises a high severity audit event.
+func TestRefreshTokenReuse(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	events := &auditLog{}
+	SetAuditor(events)(&cfg)
+
+	first, err := provider.GenToken(types.Grant{
+		Scopes: types.Scopes{{ID: "identity"}},
+	}, types.Client{ID: "test_client_id"}, true, cfg.tokenExpiration)
+	ok(t, err)
+
+	refresh := func(refreshToken string) (*httptest.ResponseRecorder, types.Token) {
+		body := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {refreshToken},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(body.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+
+		var token types.Token
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		return w, token
+	}
+
+	w, second := refresh(first.RefreshToken)
+	equals(t, http.StatusOK, w.Code)
+	equals(t, 0, len(*events))
+
+	w, _ = refresh(first.RefreshToken)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	equals(t, 1, len(*events))
+	event := (*events)[0]
+	equals(t, types.AuditRefreshTokenReused, event.Type)
+	equals(t, types.AuditSeverityHigh, event.Severity)
+	equals(t, first.FamilyID, event.Details["family_id"])
+	equals(t, "0", event.Details["generation"])
+	equals(t, "1", event.Details["current_generation"])
+	equals(t, "true", event.Details["family_revoked"])
+
+	// The token issued by the rotation belongs to the revoked family.
+	equals(t, types.TokenRevoked, provider.AccessTokens[second.Value].Status)
+	w, _ = refresh(second.RefreshToken)
+	equals(t, http.StatusBadRequest, w.Code)
+}
+
+// TestRefreshTokenRotationDisabled tests that refresh tokens can be used
+// repeatedly if rotation is disabled, issuing access tokens in their family.
+func TestRefreshTokenRotationDisabled(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetRefreshTokenRotation(false)(&cfg)
+
+	first, err := provider.GenToken(types.Grant{
+		UserID: "test_user",
+		Scopes: types.Scopes{{ID: "identity"}},
+	}, types.Client{ID: "test_client_id"}, true, cfg.tokenExpiration)
+	ok(t, err)
+
+	for i := 0; i < 2; i++ {
+		body := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {first.RefreshToken},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(body.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		var token types.Token
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		equals(t, first.RefreshToken, token.RefreshToken)
+		assert(t, token.Value != first.Value, "expected a new access token")
+
+		issued := provider.AccessTokens[token.Value]
+		equals(t, provider.AccessTokens[first.Value].FamilyID, issued.FamilyID)
+		equals(t, "test_user", issued.UserID)
+	}
+	equals(t, 0, len(provider.RotatedTokens))
+}
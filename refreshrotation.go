@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// SetRefreshTokenRotation sets whether refresh tokens are rotated when
+// refreshing access tokens. Rotated refresh tokens are replaced by the new
+// one issued along with the access token, so stolen ones are detected when
+// presented again, see TokenFamilyRevoker. Otherwise, refresh tokens can be
+// used until they expire or are revoked, and access tokens are issued with
+// Provider.GenToken, in the family of the refresh token, rather than with
+// Provider.RefreshToken. Defaults to true.
+// http://tools.ietf.org/html/rfc9700#section-4.14.2
+func SetRefreshTokenRotation(enabled bool) option {
+	return func(c *config) {
+		c.refreshRotationDisabled = !enabled
+	}
+}
+
+// reuseRefreshToken issues an access token with a refresh token, which is
+// kept rather than rotated, and sent back as is.
+func reuseRefreshToken(req *http.Request, cfg config, client types.Client, refreshToken types.Token, raw string, scopes types.Scopes, expiration time.Duration) (types.Token, error) {
+	grant := types.Grant{
+		UserID:           refreshToken.UserID,
+		Scopes:           scopes,
+		Audience:         refreshToken.Audience,
+		Extensions:       refreshToken.Extensions,
+		AMR:              refreshToken.AMR,
+		IdentityProvider: refreshToken.IdentityProvider,
+		KeyThumbprint:    refreshToken.KeyThumbprint,
+		FamilyID:         refreshToken.FamilyID,
+	}
+
+	token, err := genToken(req, cfg, grant, client, false, expiration)
+	if err != nil {
+		return token, err
+	}
+
+	token.RefreshToken = raw
+	return token, nil
+}
@@ -0,0 +1,49 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package replay
+
+import "time"
+
+// RedisConn is a connection to a Redis server. It is satisfied by
+// github.com/garyburd/redigo/redis.Conn.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Close() error
+}
+
+// RedisCache is a Cache shared by all instances of the oauth2 handler.
+type RedisCache struct {
+	// Get returns a connection from a pool. For instance, when using redigo:
+	//	func() replay.RedisConn { return pool.Get() }
+	Get func() RedisConn
+	// Prefix added to all keys. Defaults to "oauth2:replay:".
+	Prefix string
+}
+
+// Seen implements Cache.
+func (c *RedisCache) Seen(id string, expiresAt time.Time) (bool, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	ms := int64(expiresAt.Sub(time.Now()) / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+
+	// SET NX only succeeds if the key does not exist, replying nil otherwise.
+	reply, err := conn.Do("SET", c.key(id), 1, "PX", ms, "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply == nil, nil
+}
+
+func (c *RedisCache) key(id string) string {
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = "oauth2:replay:"
+	}
+	return prefix + id
+}
@@ -0,0 +1,62 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package replay defines the storage of JWT identifiers ("jti") used by the
+// oauth2 package to reject assertions presented more than once. Sharing
+// a Cache between instances of the oauth2 handler is required to detect
+// replays sent to different instances.
+package replay
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache remembers identifiers until they expire.
+type Cache interface {
+	// Seen records the given identifier until the given expiration time and
+	// tells whether it was already recorded and did not expire yet. It has
+	// to be atomic, so only one of several concurrent calls for the same
+	// identifier returns false.
+	Seen(id string, expiresAt time.Time) (bool, error)
+}
+
+// MemoryCache is a Cache for single instance deployments.
+type MemoryCache struct {
+	mu        sync.Mutex
+	ids       map[string]time.Time
+	lastSweep time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		ids: make(map[string]time.Time),
+	}
+}
+
+// Seen implements Cache.
+func (c *MemoryCache) Seen(id string, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if exp, ok := c.ids[id]; ok && now.Before(exp) {
+		return true, nil
+	}
+
+	// Takes the chance to remove expired identifiers, once in a while, so
+	// memory does not grow unbounded.
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, exp := range c.ids {
+			if !now.Before(exp) {
+				delete(c.ids, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.ids[id] = expiresAt
+	return false, nil
+}
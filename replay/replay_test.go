@@ -0,0 +1,86 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package replay
+
+import (
+	"testing"
+	"time"
+)
+
+func testCache(t *testing.T, c Cache) {
+	exp := time.Now().Add(time.Duration(1) * time.Minute)
+	for i, expected := range []bool{false, true, true} {
+		seen, err := c.Seen("jti", exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != expected {
+			t.Errorf("call %d: expected seen to be %t", i, expected)
+		}
+	}
+
+	seen, err := c.Seen("other", exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seen {
+		t.Error("expected other identifier not to be seen")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, NewMemoryCache())
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	c := NewMemoryCache()
+	c.Seen("jti", time.Now().Add(time.Duration(10)*time.Millisecond))
+	time.Sleep(time.Duration(20) * time.Millisecond)
+
+	seen, _ := c.Seen("jti", time.Now().Add(time.Duration(1)*time.Minute))
+	if seen {
+		t.Error("expected identifier to expire")
+	}
+}
+
+// fakeRedis emulates the subset of Redis commands used by RedisCache.
+type fakeRedis struct {
+	data    map[string]bool
+	expires map[string]int64
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "SET" {
+		return nil, nil
+	}
+
+	key := args[0].(string)
+	if f.data[key] {
+		return nil, nil
+	}
+	f.data[key] = true
+	f.expires[key] = args[3].(int64)
+	return "OK", nil
+}
+
+func (f *fakeRedis) Close() error {
+	return nil
+}
+
+func TestRedisCache(t *testing.T) {
+	conn := &fakeRedis{
+		data:    make(map[string]bool),
+		expires: make(map[string]int64),
+	}
+
+	c := &RedisCache{
+		Get: func() RedisConn { return conn },
+	}
+	testCache(t, c)
+
+	if ms := conn.expires["oauth2:replay:jti"]; ms <= 59000 || ms > 60000 {
+		t.Errorf("expected expiration of about 60000ms, got %d", ms)
+	}
+}
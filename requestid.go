@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// RequestIDHeader is the HTTP header used to propagate correlation IDs. If
+// a request comes with one, such as those set by load balancers, it is
+// reused. Otherwise a new one is generated. Either way, it is sent back in
+// the response and included in error responses and logs, so support teams
+// can match errors reported by users with server logs.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// withRequestID assigns a correlation ID to the request.
+func withRequestID(w http.ResponseWriter, req *http.Request) *http.Request {
+	id := req.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		var err error
+		if id, err = newID(); err != nil {
+			log.Printf("[ERROR] Error generating request ID: %v", err)
+			return req
+		}
+	}
+
+	w.Header().Set(RequestIDHeader, id)
+	return req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id))
+}
+
+// RequestID returns the correlation ID of a request handled by this package,
+// providers can include it in their own logs.
+func RequestID(req *http.Request) string {
+	// Events of background jobs are not caused by any request.
+	if req == nil {
+		return ""
+	}
+
+	id, _ := req.Context().Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID makes sure IDs coming from clients are safe to log and echo back.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// serverError logs an internal error along with the request's correlation ID
+// and returns the error to send back to the client.
+func serverError(req *http.Request, cfg config, state string, err error) types.AuthzError {
+	id := RequestID(req)
+	log.Printf("[ERROR] request_id=%s Internal server error: %v", id, redactError(err))
+
+	e := localize(req, cfg, errServerError(state, err))
+	e.RequestID = id
+	return e
+}
@@ -0,0 +1,68 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+func TestRequestID(t *testing.T) {
+	handler := benchHandler()
+
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"abc-123", "abc-123"},
+		// Unsafe IDs are replaced.
+		{"abc\n123", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=foo"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set(RequestIDHeader, tt.header)
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, http.StatusBadRequest, w.Code)
+
+		authzErr := types.AuthzError{}
+		err = json.Unmarshal(w.Body.Bytes(), &authzErr)
+		ok(t, err)
+		equals(t, "unsupported_grant_type", authzErr.Code)
+
+		id := w.Header().Get(RequestIDHeader)
+		if tt.expected != "" {
+			equals(t, tt.expected, id)
+		} else {
+			equals(t, 32, len(id))
+		}
+		equals(t, id, authzErr.RequestID)
+	}
+}
+
+func TestServerErrorRequestID(t *testing.T) {
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs", nil)
+	ok(t, err)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	req = withRequestID(httptest.NewRecorder(), req)
+
+	authzErr := serverError(req, setupTest(), "state", errors.New("boom"))
+	equals(t, "server_error", authzErr.Code)
+	equals(t, "state", authzErr.State)
+	equals(t, "abc-123", authzErr.RequestID)
+}
@@ -0,0 +1,175 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/jwe"
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/types"
+)
+
+// RequestObjectParam is the authorization request parameter carrying the
+// request object. http://tools.ietf.org/html/rfc9101#section-5.1
+const RequestObjectParam = "request"
+
+// errRequestObjectInvalid is returned for request objects that can not be
+// decrypted or verified. The reason is logged, but not disclosed.
+var errRequestObjectInvalid = errors.New("oauth2: request object is invalid")
+
+// SetRequestObjectDecryptionKey sets the key clients encrypt request objects
+// to. Its public key is published in the JSON Web Key Set, along with the
+// signing keys, and its algorithm in the authorization server metadata.
+// Without it, only signed request objects are accepted.
+func SetRequestObjectDecryptionKey(key types.DecryptionKey) option {
+	return func(c *config) {
+		c.requestObjectKey = key
+	}
+}
+
+// requestObjectParams returns the parameters of an authorization request
+// sent as a request object, as defined by http://tools.ietf.org/html/rfc9101
+//
+// Implementation notes:
+//   - Request objects have to be signed with one of the client's public keys,
+//     and may be encrypted to the authorization server's decryption key.
+//   - "iss" and "client_id" claims have to be the client_id query parameter,
+//     and "aud" the issuer of the authorization server.
+//   - Request objects have to expire.
+//   - Request objects can only be used once if a replay cache is set, and
+//     then have to carry a "jti" claim. Approving or denying the form sends
+//     the request object again, which is not a replay.
+//   - Authorization request parameters outside the request object are ignored.
+func requestObjectParams(req *http.Request, cfg config) (map[string]string, error) {
+	raw := req.FormValue(RequestObjectParam)
+	if jwe.IsEncrypted(raw) {
+		plaintext, err := decryptRequestObject(cfg, raw)
+		if err != nil {
+			return nil, invalidRequestObject(req, err)
+		}
+		raw = string(plaintext)
+	}
+
+	token, err := jwt.Parse(raw)
+	if err != nil {
+		return nil, invalidRequestObject(req, err)
+	}
+
+	clientID := req.FormValue("client_id")
+	client, err := cfg.provider.ClientInfo(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyRequestObject(cfg, client, token); err != nil {
+		return nil, invalidRequestObject(req, err)
+	}
+
+	claims := make(map[string]interface{})
+	if err := token.Decode(&claims); err != nil {
+		return nil, invalidRequestObject(req, err)
+	}
+
+	if token.Claims.Issuer != clientID || claims["client_id"] != clientID {
+		return nil, invalidRequestObject(req, errors.New("issuer or client_id claims do not identify the client"))
+	}
+
+	if !token.Claims.Audience.Contains("https://" + req.Host) {
+		return nil, invalidRequestObject(req, errors.New("audience does not identify the authorization server"))
+	}
+
+	if err := token.Claims.Validate(now(cfg), assertionLeeway); err != nil {
+		return nil, invalidRequestObject(req, err)
+	}
+
+	if cfg.replayCache != nil && !consentApproval(req) && !consentDenial(req) {
+		if token.Claims.ID == "" {
+			return nil, invalidRequestObject(req, errors.New("jti claim is missing"))
+		}
+
+		seen, err := replayed(cfg, token.Claims)
+		if err != nil {
+			return nil, err
+		}
+		if seen {
+			return nil, invalidRequestObject(req, errors.New("jti was already used"))
+		}
+	}
+
+	params := make(map[string]string)
+	for _, v := range authzRequestVars {
+		switch c := claims[v].(type) {
+		case string:
+			params[v] = c
+		case bool:
+			// Such as include_granted_scopes, which may be sent as a JSON
+			// boolean rather than a string.
+			if c {
+				params[v] = "true"
+			}
+		}
+	}
+
+	for name := range cfg.extensionParams {
+		if c, ok := claims[name].(string); ok {
+			params[name] = c
+		}
+	}
+	return params, nil
+}
+
+// decryptRequestObject decrypts a request object encrypted to the
+// authorization server's decryption key.
+func decryptRequestObject(cfg config, raw string) ([]byte, error) {
+	key := cfg.requestObjectKey
+	if key.Decrypter == nil {
+		return nil, errors.New("no decryption key configured")
+	}
+
+	header, err := jwe.ParseHeader(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Algorithm != key.Algorithm {
+		return nil, jwe.ErrUnsupportedAlgorithm
+	}
+
+	if header.KeyID != "" && header.KeyID != keyID(key.ID, key.Decrypter.Public()) {
+		return nil, errors.New("encrypted to an unknown key")
+	}
+	return jwe.Decrypt(raw, key.Decrypter)
+}
+
+// verifyRequestObject verifies the signature of a request object with the
+// public keys of the client.
+func verifyRequestObject(cfg config, client types.Client, token *jwt.Token) error {
+	if !allowedAlgorithm(cfg, token.Header.Algorithm) {
+		return ErrSigningAlgorithmNotAllowed
+	}
+
+	for _, k := range client.PublicKeys {
+		if token.Header.KeyID != "" && token.Header.KeyID != keyID(k.ID, k.Key) {
+			continue
+		}
+
+		if k.Algorithm != "" && k.Algorithm != token.Header.Algorithm {
+			continue
+		}
+
+		if token.Verify(k.Key) == nil {
+			return nil
+		}
+	}
+	return jwt.ErrInvalidSignature
+}
+
+func invalidRequestObject(req *http.Request, err error) error {
+	log.Printf("[WARN] request_id=%s Invalid request object: %v", RequestID(req), err)
+	return errRequestObjectInvalid
+}
@@ -0,0 +1,254 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwe"
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/replay"
+	"github.com/hooklift/oauth2/types"
+)
+
+// requestObjectClaims are the claims of request objects sent in tests.
+type requestObjectClaims struct {
+	jwt.Claims
+	ClientID     string `json:"client_id"`
+	ResponseType string `json:"response_type"`
+	RedirectURI  string `json:"redirect_uri"`
+	Scope        string `json:"scope"`
+	State        string `json:"state"`
+
+	IncludeGrantedScopes bool `json:"include_granted_scopes,omitempty"`
+}
+
+// TestRequestObject tests that authorization requests are taken from signed,
+// and optionally encrypted, request objects.
+func TestRequestObject(t *testing.T) {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, err)
+
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.PublicKeys = []types.PublicKey{
+		{ID: "client-key", Algorithm: jwt.ES256, Key: clientKey.Public()},
+	}
+	cfg.provider = provider
+	SetRequestObjectDecryptionKey(types.DecryptionKey{
+		ID:        "server-key",
+		Algorithm: jwe.RSAOAEP256,
+		Decrypter: serverKey,
+	})(&cfg)
+
+	claims := requestObjectClaims{
+		Claims: jwt.Claims{
+			Issuer:    provider.Client.ID,
+			Audience:  jwt.Audience{"https://example.com"},
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		},
+		ClientID:     provider.Client.ID,
+		ResponseType: "code",
+		RedirectURI:  provider.Client.RedirectURL.String(),
+		Scope:        "read",
+		State:        "state-from-object",
+	}
+
+	sign := func(claims requestObjectClaims) string {
+		signed, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256, KeyID: "client-key"}, claims, clientKey)
+		ok(t, err)
+		return signed
+	}
+
+	encrypt := func(signed string) string {
+		encrypted, err := jwe.Encrypt([]byte(signed), jwe.Header{
+			Algorithm:   jwe.RSAOAEP256,
+			Encryption:  jwe.A256GCM,
+			KeyID:       "server-key",
+			ContentType: "JWT",
+		}, &serverKey.PublicKey)
+		ok(t, err)
+		return encrypted
+	}
+
+	authorize := func(requestObject string) string {
+		values := url.Values{
+			"client_id":        {provider.Client.ID},
+			"response_type":    {"code"},
+			"state":            {"state-from-query"},
+			RequestObjectParam: {requestObject},
+		}
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	for _, requestObject := range []string{sign(claims), encrypt(sign(claims))} {
+		body := authorize(requestObject)
+		assert(t, strings.Contains(body, `value="state-from-object"`), "state was not taken from the request object: %s", body)
+		assert(t, !strings.Contains(body, "state-from-query"), "parameters outside the request object should be ignored: %s", body)
+	}
+
+	// Boolean claims are taken as the "true" parameter value.
+	include := claims
+	include.IncludeGrantedScopes = true
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+url.Values{
+		"client_id":        {provider.Client.ID},
+		RequestObjectParam: {sign(include)},
+	}.Encode(), nil)
+	ok(t, err)
+	params, err := requestObjectParams(req, cfg)
+	ok(t, err)
+	equals(t, "true", params["include_granted_scopes"])
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	forged, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256, KeyID: "client-key"}, claims, otherKey)
+	ok(t, err)
+
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Hour).Unix()
+
+	audience := claims
+	audience.Audience = jwt.Audience{"https://attacker.example.com"}
+
+	issuer := claims
+	issuer.Issuer = "other-client"
+
+	// Tampering with the protected header fails authenticated decryption.
+	tampered := encrypt(sign(claims))
+	tampered = tampered[:40] + "A" + tampered[41:]
+
+	for desc, requestObject := range map[string]string{
+		"forged":          forged,
+		"expired":         sign(expired),
+		"wrong audience":  sign(audience),
+		"wrong issuer":    encrypt(sign(issuer)),
+		"not a JWT":       "garbage",
+		"tampered header": tampered,
+	} {
+		body := authorize(requestObject)
+		assert(t, strings.Contains(body, types.ErrorInvalidRequestObject), "%s request object should be rejected: %s", desc, body)
+	}
+}
+
+// TestRequestObjectReplay tests that request objects can only be used once
+// when a replay cache is set, but for approving the form they were sent to.
+func TestRequestObjectReplay(t *testing.T) {
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.PublicKeys = []types.PublicKey{
+		{ID: "client-key", Algorithm: jwt.ES256, Key: clientKey.Public()},
+	}
+	cfg.provider = provider
+	SetReplayCache(replay.NewMemoryCache())(&cfg)
+
+	sign := func(jti string) string {
+		signed, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256, KeyID: "client-key"}, requestObjectClaims{
+			Claims: jwt.Claims{
+				ID:        jti,
+				Issuer:    provider.Client.ID,
+				Audience:  jwt.Audience{"https://example.com"},
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			},
+			ClientID:     provider.Client.ID,
+			ResponseType: "code",
+			RedirectURI:  provider.Client.RedirectURL.String(),
+			Scope:        "read",
+			State:        "state-from-object",
+		}, clientKey)
+		ok(t, err)
+		return signed
+	}
+
+	authorize := func(method, requestObject string) *httptest.ResponseRecorder {
+		values := url.Values{
+			"client_id":        {provider.Client.ID},
+			RequestObjectParam: {requestObject},
+		}
+		// The default form is submitted to the URL it was displayed at.
+		req, err := http.NewRequest(method, "https://example.com/oauth2/authzs?"+values.Encode(),
+			strings.NewReader(ConsentParam+"=approve"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		return w
+	}
+	rejected := func(w *httptest.ResponseRecorder) bool {
+		return strings.Contains(w.Body.String(), types.ErrorInvalidRequestObject)
+	}
+
+	requestObject := sign("jti-1")
+	equals(t, false, rejected(authorize("GET", requestObject)))
+	equals(t, http.StatusFound, authorize("POST", requestObject).Code)
+	equals(t, true, rejected(authorize("GET", requestObject)))
+	equals(t, true, rejected(authorize("GET", sign(""))))
+	equals(t, false, rejected(authorize("GET", sign("jti-2"))))
+}
+
+// TestRequestObjectDiscovery tests that the decryption key and supported
+// algorithms are published.
+func TestRequestObjectDiscovery(t *testing.T) {
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	ok(t, err)
+
+	handler := Handler(http.NotFoundHandler(),
+		SetProvider(test.NewProvider(true)),
+		SetRequestObjectDecryptionKey(types.DecryptionKey{
+			ID:        "server-key",
+			Algorithm: jwe.RSAOAEP,
+			Decrypter: serverKey,
+		}),
+	)
+
+	get := func(path string, v interface{}) {
+		req, err := http.NewRequest("GET", "https://example.com"+path, nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+		ok(t, json.Unmarshal(w.Body.Bytes(), v))
+	}
+
+	metadata := make(map[string]interface{})
+	get("/.well-known/oauth-authorization-server", &metadata)
+	equals(t, true, metadata["request_parameter_supported"])
+	equals(t, []interface{}{"RSA-OAEP"}, metadata["request_object_encryption_alg_values_supported"])
+	equals(t, []interface{}{"A128GCM", "A256GCM"}, metadata["request_object_encryption_enc_values_supported"])
+	equals(t, "https://example.com/oauth2/jwks", metadata["jwks_uri"])
+
+	keySet := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	get("/oauth2/jwks", &keySet)
+	equals(t, 1, len(keySet.Keys))
+	equals(t, "enc", keySet.Keys[0].Use)
+	equals(t, "server-key", keySet.Keys[0].KeyID)
+	equals(t, "RSA-OAEP", keySet.Keys[0].Algorithm)
+}
@@ -0,0 +1,167 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+)
+
+// AuthorizationRequest is an authorization request sent to the
+// authorization endpoint, whether in the query string, the form of the
+// resource owner's approval, a signed request or a request object.
+// http://tools.ietf.org/html/rfc6749#section-4.1.1
+type AuthorizationRequest struct {
+	ClientID string
+	// "code" or "token".
+	ResponseType string
+	// Empty if the client relies on the default for its response type.
+	ResponseMode string
+	// As sent by the client, before any default scope is applied.
+	Scope       string
+	RedirectURI string
+	State       string
+	// PKCE parameters. http://tools.ietf.org/html/rfc7636#section-4.3
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Whether the scopes approved before are to be added to the grant.
+	IncludeGrantedScopes bool
+	// Parameters unknown to this package, as sent in the query string or
+	// form of plain authorization requests. Nil if there is none.
+	Extra map[string]string
+}
+
+// TokenRequest is a request sent to the token endpoint, once the client is
+// authenticated. Fields not used by its grant type are empty.
+// http://tools.ietf.org/html/rfc6749#section-4.1.3
+type TokenRequest struct {
+	GrantType string
+	// Authorization code, along with the redirect URI and PKCE verifier it
+	// was requested with.
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	// Device code of device authorizations approved by the resource owner.
+	DeviceCode   string
+	RefreshToken string
+	// Resource owner credentials of password grants.
+	Username string
+	Password string
+	// JWT assertion of service accounts.
+	Assertion string
+	Scope     string
+	// JWK thumbprint of the key the client proved possession of with the
+	// DPoP header, if any. http://tools.ietf.org/html/rfc9449#section-4
+	KeyThumbprint string
+	// Resource servers the token is requested for.
+	// http://tools.ietf.org/html/rfc8707#section-2
+	Resources []string
+	// Token presented for exchange, the type of token requested in return
+	// and the audience it is meant for, with the token exchange grant.
+	// http://tools.ietf.org/html/rfc8693#section-2.1
+	SubjectToken       string
+	SubjectTokenType   string
+	RequestedTokenType string
+	Audiences          []string
+	// Parameters unknown to this package, as sent in the request body. Nil
+	// if there is none.
+	Extra map[string]string
+}
+
+// Parameters of token requests read into TokenRequest fields.
+var tokenRequestVars = map[string]bool{
+	"grant_type":    true,
+	"code":          true,
+	"redirect_uri":  true,
+	"code_verifier": true,
+	"device_code":   true,
+	"refresh_token": true,
+	"username":      true,
+	"password":      true,
+	"assertion":     true,
+	"scope":         true,
+	"resource":      true,
+	"client_id":     true,
+	"client_secret": true,
+
+	"subject_token":        true,
+	"subject_token_type":   true,
+	"requested_token_type": true,
+	"audience":             true,
+}
+
+// Parameters of the authorization form that are not part of the request.
+var authzFormVars = map[string]bool{
+	ConsentParam:       true,
+	AuthzRequestParam:  true,
+	RequestObjectParam: true,
+}
+
+// newAuthorizationRequest returns the authorization request made of the
+// given parameters.
+func newAuthorizationRequest(params map[string]string) AuthorizationRequest {
+	r := AuthorizationRequest{
+		ClientID:             params["client_id"],
+		ResponseType:         params["response_type"],
+		ResponseMode:         params["response_mode"],
+		Scope:                params["scope"],
+		RedirectURI:          params["redirect_uri"],
+		State:                params["state"],
+		CodeChallenge:        params["code_challenge"],
+		CodeChallengeMethod:  params["code_challenge_method"],
+		IncludeGrantedScopes: params["include_granted_scopes"] == "true",
+	}
+
+	for k, v := range params {
+		if !isAuthzRequestVar(k) {
+			if r.Extra == nil {
+				r.Extra = make(map[string]string)
+			}
+			r.Extra[k] = v
+		}
+	}
+	return r
+}
+
+func isAuthzRequestVar(name string) bool {
+	for _, v := range authzRequestVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newTokenRequest parses the request sent to the token endpoint.
+func newTokenRequest(req *http.Request, cfg config) TokenRequest {
+	req.ParseForm()
+	r := TokenRequest{
+		GrantType:    grantType(req, cfg),
+		Code:         req.FormValue("code"),
+		RedirectURI:  req.FormValue("redirect_uri"),
+		CodeVerifier: req.FormValue("code_verifier"),
+		DeviceCode:   req.FormValue("device_code"),
+		RefreshToken: req.FormValue("refresh_token"),
+		Username:     req.FormValue("username"),
+		Password:     req.FormValue("password"),
+		Assertion:    req.FormValue("assertion"),
+		Scope:        req.FormValue("scope"),
+		Resources:    req.Form["resource"],
+
+		SubjectToken:       req.FormValue("subject_token"),
+		SubjectTokenType:   req.FormValue("subject_token_type"),
+		RequestedTokenType: req.FormValue("requested_token_type"),
+		Audiences:          req.Form["audience"],
+	}
+
+	for k, v := range req.PostForm {
+		if !tokenRequestVars[k] && len(v) > 0 {
+			if r.Extra == nil {
+				r.Extra = make(map[string]string)
+			}
+			r.Extra[k] = v[0]
+		}
+	}
+	return r
+}
@@ -0,0 +1,74 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestAuthorizationRequestPolicy tests that policies get the authorization
+// request approved by the resource owner, along with the parameters unknown
+// to this package.
+func TestAuthorizationRequestPolicy(t *testing.T) {
+	cfg := setupTest()
+	cfg.provider = test.NewProvider(true)
+
+	var inputs []PolicyInput
+	SetPolicy(policyFunc(func(input PolicyInput) error {
+		inputs = append(inputs, input)
+		return nil
+	}))(&cfg)
+
+	body := authzRequest(t, cfg).URL.RawQuery + "&tenant=acme&" + ConsentParam + "=approve"
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(body))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	equals(t, 1, len(inputs))
+	areq := inputs[0].AuthorizationRequest
+	assert(t, areq != nil, "expected the authorization request")
+	equals(t, "test_client_id", areq.ClientID)
+	equals(t, "code", areq.ResponseType)
+	equals(t, "state-test", areq.State)
+	equals(t, map[string]string{"tenant": "acme"}, areq.Extra)
+	assert(t, inputs[0].TokenRequest == nil, "expected no token request")
+}
+
+// TestTokenRequest tests that token requests are parsed into their fields,
+// with unknown parameters as extras.
+func TestTokenRequest(t *testing.T) {
+	cfg := setupTest()
+	body := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"code"},
+		"redirect_uri":  {"https://example.com/callback"},
+		"code_verifier": {"verifier"},
+		"resource":      {"https://api.example.com", "https://files.example.com"},
+		"client_id":     {"client"},
+		"device":        {"laptop"},
+	}
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(body.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+
+	treq := newTokenRequest(req, cfg)
+	equals(t, "authorization_code", treq.GrantType)
+	equals(t, "code", treq.Code)
+	equals(t, "https://example.com/callback", treq.RedirectURI)
+	equals(t, "verifier", treq.CodeVerifier)
+	equals(t, []string{"https://api.example.com", "https://files.example.com"}, treq.Resources)
+	equals(t, map[string]string{"device": "laptop"}, treq.Extra)
+}
@@ -0,0 +1,203 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// ResourceServerProvider is an optional interface that providers can
+// implement in order to register resource servers. Clients can then restrict
+// tokens to specific resource servers and resource servers can introspect
+// tokens with their own credentials.
+type ResourceServerProvider interface {
+	// ResourceServerInfo returns the resource server registered with the
+	// given audience URI. An empty types.ResourceServer is expected if there
+	// is none.
+	ResourceServerInfo(audience string) (types.ResourceServer, error)
+
+	// AuthenticateResourceServer authenticates a resource server. An empty
+	// types.ResourceServer is expected if the credentials are invalid.
+	AuthenticateResourceServer(id, secret string) (types.ResourceServer, error)
+
+	// SaveResourceServer registers a resource server or updates it, if
+	// already registered.
+	SaveResourceServer(rs types.ResourceServer) error
+}
+
+// ErrResourceServerProviderRequired is returned when registering resource
+// servers with a provider that does not implement ResourceServerProvider.
+var ErrResourceServerProviderRequired = errors.New("oauth2: provider does not implement oauth2.ResourceServerProvider")
+
+// SetAudience sets the audience URI of the resource server protected by
+// AuthzHandler. Tokens restricted to other resource servers are rejected.
+func SetAudience(uri string) option {
+	return func(c *config) {
+		c.audience = uri
+	}
+}
+
+// audienceAllowed tells whether a token can be used with the given audience.
+func audienceAllowed(token types.Token, audience string) bool {
+	if len(token.Audience) == 0 || audience == "" {
+		return true
+	}
+
+	for _, aud := range token.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// requestedAudience validates the resource servers a token is requested for,
+// in accordance with http://tools.ietf.org/html/rfc8707#section-2.2. The
+// requested scopes must be allowed by each of them.
+func requestedAudience(w http.ResponseWriter, req *http.Request, cfg config, resources []string, scopes types.Scopes) ([]string, bool) {
+	if len(resources) == 0 {
+		return nil, true
+	}
+
+	provider, ok := asResourceServerProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrInvalidTarget),
+		})
+		return nil, false
+	}
+
+	for _, resource := range resources {
+		// The value of the resource parameter MUST be an absolute URI and it
+		// MUST NOT include a fragment component.
+		u, err := url.Parse(resource)
+		if err != nil || !u.IsAbs() || u.Fragment != "" {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrInvalidTarget),
+			})
+			return nil, false
+		}
+
+		rs, err := provider.ResourceServerInfo(resource)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return nil, false
+		}
+
+		if rs.ID == "" {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrInvalidTarget),
+			})
+			return nil, false
+		}
+
+		if len(rs.Scopes) == 0 {
+			continue
+		}
+
+		for _, s := range scopes {
+			if !rs.Scopes.Contains(s.ID) {
+				render.JSON(w, render.Options{
+					Status: http.StatusBadRequest,
+					Data:   localize(req, cfg, ErrInvalidScope),
+				})
+				return nil, false
+			}
+		}
+	}
+	return resources, true
+}
+
+// authenticateResourceServer authenticates the resource server calling the
+// introspection endpoint, if the provider registers them.
+func authenticateResourceServer(cfg config, id, secret string) (types.ResourceServer, error) {
+	provider, ok := asResourceServerProvider(cfg.provider)
+	if !ok {
+		return types.ResourceServer{}, nil
+	}
+	return provider.AuthenticateResourceServer(id, secret)
+}
+
+// saveResourceServer registers or updates a resource server through the admin API.
+func saveResourceServer(w http.ResponseWriter, req *http.Request, cfg config, id string) {
+	provider, ok := asResourceServerProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrResourceServerProviderRequired),
+		})
+		return
+	}
+
+	var body struct {
+		Name                string   `json:"name"`
+		Audience            string   `json:"audience"`
+		Secret              string   `json:"secret"`
+		Scope               string   `json:"scope"`
+		IntrospectionClaims []string `json:"introspection_claims"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrInvalidTarget),
+		})
+		return
+	}
+
+	u, err := url.Parse(body.Audience)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrInvalidTarget),
+		})
+		return
+	}
+
+	rs := types.ResourceServer{
+		ID:                  id,
+		Name:                body.Name,
+		Audience:            body.Audience,
+		Secret:              body.Secret,
+		IntrospectionClaims: body.IntrospectionClaims,
+	}
+
+	if body.Scope != "" {
+		rs.Scopes, err = cfg.provider.ScopesInfo(body.Scope)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+	}
+
+	if err := provider.SaveResourceServer(rs); err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	// Secrets are never sent back.
+	rs.Secret = ""
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   rs,
+	})
+}
@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestResourceServers makes sure tokens are restricted to the resource servers
+// they were requested for, in accordance with http://tools.ietf.org/html/rfc8707
+func TestResourceServers(t *testing.T) {
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+
+	admin := AdminHandler(provider)
+	register := func(id, body string) {
+		req, err := http.NewRequest("PUT", "https://example.com/resource_servers/"+id, bytes.NewBufferString(body))
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+
+		rs := types.ResourceServer{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &rs))
+		equals(t, "", rs.Secret)
+	}
+
+	register("photos", `{"audience": "https://photos.example.com", "secret": "photos-secret", "scope": "read write", "introspection_claims": ["scope", "aud"]}`)
+	register("mail", `{"audience": "https://mail.example.com", "secret": "mail-secret"}`)
+
+	issueToken := func(values url.Values) *httptest.ResponseRecorder {
+		values.Set("grant_type", "client_credentials")
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	tests := []struct {
+		resource string
+		scope    string
+		code     string
+	}{
+		{"https://unknown.example.com", "read", "invalid_target"},
+		{"photos", "read", "invalid_target"},
+		{"https://photos.example.com", "admin", "invalid_scope"},
+	}
+
+	for _, tt := range tests {
+		w := issueToken(url.Values{"resource": {tt.resource}, "scope": {tt.scope}})
+		equals(t, http.StatusBadRequest, w.Code)
+
+		e := types.AuthzError{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		equals(t, tt.code, e.Code)
+	}
+
+	w := issueToken(url.Values{"resource": {"https://photos.example.com"}, "scope": {"read"}})
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, []string{"https://photos.example.com"}, provider.AccessTokens[token.Value].Audience)
+
+	introspect := func(id, secret string) map[string]interface{} {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/introspect",
+			bytes.NewBufferString(url.Values{"token": {token.Value}}.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth(id, secret)
+
+		w := httptest.NewRecorder()
+		IntrospectToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		claims := make(map[string]interface{})
+		ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+		return claims
+	}
+
+	equals(t, map[string]interface{}{
+		"active": true,
+		"scope":  "read",
+		"aud":    []interface{}{"https://photos.example.com"},
+	}, introspect("photos", "photos-secret"))
+	equals(t, map[string]interface{}{"active": false}, introspect("mail", "mail-secret"))
+
+	protected := func(audience string) int {
+		handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}), provider, SetAudience(audience))
+
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer "+token.Value)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	equals(t, http.StatusOK, protected("https://photos.example.com"))
+	equals(t, http.StatusUnauthorized, protected("https://mail.example.com"))
+}
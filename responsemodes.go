@@ -0,0 +1,127 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// Response modes, telling how authorization responses are sent back to the
+// client, requested with the response_mode parameter. Authorization codes are
+// sent in the query string and access tokens in the fragment by default.
+// http://openid.net/specs/oauth-v2-multiple-response-types-1_0.html#ResponseModes
+// http://openid.net/specs/oauth-v2-form-post-response-mode-1_0.html
+const (
+	ResponseModeQuery    = "query"
+	ResponseModeFragment = "fragment"
+	ResponseModeFormPost = "form_post"
+)
+
+// formPostForm auto-submits authorization responses to the client's redirect
+// URL, for the form_post response mode.
+var formPostForm = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Submit this form</title>
+</head>
+<body onload="document.forms[0].submit()">
+	<form method="post" action="{{.RedirectURL}}">
+	{{range $name, $values := .Params}}{{range $values}}
+		<input type="hidden" name="{{$name}}" value="{{.}}"/>
+	{{end}}{{end}}
+		<noscript><button type="submit">Continue</button></noscript>
+	</form>
+</body>
+</html>
+`))
+
+// formPostData defines the properties used to render formPostForm.
+type formPostData struct {
+	RedirectURL string
+	Params      url.Values
+}
+
+// responseMode returns the response mode of an authorization request, or the
+// default one for its response type. It also tells whether the requested
+// mode is supported, access tokens are never sent in the query string.
+func responseMode(areq AuthorizationRequest) (string, bool) {
+	mode := ResponseModeQuery
+	if areq.ResponseType == "token" {
+		mode = ResponseModeFragment
+	}
+
+	switch areq.ResponseMode {
+	case "":
+		return mode, true
+	case ResponseModeQuery:
+		return mode, mode == ResponseModeQuery
+	case ResponseModeFragment, ResponseModeFormPost:
+		return areq.ResponseMode, true
+	default:
+		return mode, false
+	}
+}
+
+// redirect sends the parameters of an authorization response back to the
+// client using the given response mode. The client's redirect URL is not
+// modified.
+func redirect(w http.ResponseWriter, req *http.Request, cfg config, redirectURL *url.URL, mode string, params url.Values) {
+	u := *redirectURL
+	// Redirect URLs must not include a fragment.
+	// http://tools.ietf.org/html/rfc6749#section-3.1.2
+	u.Fragment, u.RawFragment = "", ""
+
+	switch mode {
+	case ResponseModeFormPost:
+		render.HTML(w, render.Options{
+			Status:    http.StatusOK,
+			Data:      formPostData{RedirectURL: u.String(), Params: params},
+			Template:  formPostForm,
+			STSMaxAge: cfg.stsMaxAge,
+		})
+	case ResponseModeFragment:
+		http.Redirect(w, req, u.String()+"#"+params.Encode(), http.StatusFound)
+	default:
+		// Query components of redirect URLs are retained.
+		// http://tools.ietf.org/html/rfc6749#section-3.1.2
+		query := u.Query()
+		for name, values := range params {
+			query[name] = values
+		}
+		u.RawQuery = query.Encode()
+		http.Redirect(w, req, u.String(), http.StatusFound)
+	}
+}
+
+// redirectErr sends an error back to the client through its redirect URI using
+// the given response mode, or displays it to the resource owner if the client
+// is out-of-band. Every error sent back by the authorization endpoint goes
+// through it, so it is localized and correlated with server logs.
+func redirectErr(w http.ResponseWriter, req *http.Request, cfg config, u *url.URL, mode string, err types.AuthzError) {
+	err = localize(req, cfg, err)
+	if err.RequestID == "" {
+		err.RequestID = RequestID(req)
+	}
+
+	if isOOB(cfg, u) {
+		render.HTML(w, render.Options{
+			Status: http.StatusOK,
+			Data: AuthzData{
+				Errors: []types.AuthzError{err},
+			},
+			Template:  cfg.authzForm,
+			STSMaxAge: cfg.stsMaxAge,
+		})
+		return
+	}
+
+	redirect(w, req, cfg, u, mode, errParams(err))
+}
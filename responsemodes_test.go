@@ -0,0 +1,168 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+func TestResponseMode(t *testing.T) {
+	tests := []struct {
+		responseType string
+		responseMode string
+		mode         string
+		supported    bool
+	}{
+		{"code", "", ResponseModeQuery, true},
+		{"token", "", ResponseModeFragment, true},
+		{"code", ResponseModeFragment, ResponseModeFragment, true},
+		{"code", ResponseModeFormPost, ResponseModeFormPost, true},
+		{"token", ResponseModeFormPost, ResponseModeFormPost, true},
+		{"token", ResponseModeQuery, ResponseModeFragment, false},
+		{"code", "web_message", ResponseModeQuery, false},
+		{"token", "web_message", ResponseModeFragment, false},
+	}
+
+	for _, tt := range tests {
+		mode, supported := responseMode(AuthorizationRequest{
+			ResponseType: tt.responseType,
+			ResponseMode: tt.responseMode,
+		})
+		equals(t, tt.mode, mode)
+		equals(t, tt.supported, supported)
+	}
+}
+
+// TestRedirect tests that responses are encoded according to the response
+// mode, keeping the query string of the redirect URL and leaving it untouched.
+func TestRedirect(t *testing.T) {
+	redirectURL, err := url.Parse("https://example.com/callback?tenant=a%26b#ignored")
+	ok(t, err)
+	params := url.Values{
+		"code":  {"a code"},
+		"state": {"s&t=#"},
+	}
+
+	tests := []struct {
+		mode     string
+		status   int
+		location string
+	}{
+		{ResponseModeQuery, http.StatusFound, "https://example.com/callback?code=a+code&state=s%26t%3D%23&tenant=a%26b"},
+		{ResponseModeFragment, http.StatusFound, "https://example.com/callback?tenant=a%26b#code=a+code&state=s%26t%3D%23"},
+		{ResponseModeFormPost, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		redirect(w, req, setupTest(), redirectURL, tt.mode, params)
+		equals(t, tt.status, w.Code)
+		equals(t, tt.location, w.Header().Get("Location"))
+		equals(t, "https://example.com/callback?tenant=a%26b#ignored", redirectURL.String())
+
+		if tt.mode != ResponseModeFormPost {
+			continue
+		}
+
+		body := w.Body.String()
+		for _, s := range []string{
+			`action="https://example.com/callback?tenant=a%26b"`,
+			`name="code" value="a code"`,
+			`name="state" value="s&amp;t=#"`,
+		} {
+			assert(t, strings.Contains(body, s), "%s not found in form: %s", s, body)
+		}
+	}
+}
+
+// TestFormPostResponseMode tests that errors and access tokens are sent back
+// using the requested response mode.
+func TestFormPostResponseMode(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"token"},
+		"response_mode": {ResponseModeFormPost},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read"},
+		ConsentParam:    {"approve"},
+	}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", strings.NewReader(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+	assert(t, strings.Contains(w.Body.String(), `name="access_token"`), "access token not found in form: %s", w.Body.String())
+	assert(t, strings.Contains(w.Body.String(), `name="state" value="state-test"`), "state not found in form: %s", w.Body.String())
+
+	values.Set("response_mode", ResponseModeQuery)
+	req, err = http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+	ok(t, err)
+
+	w = httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	equals(t, "", u.RawQuery)
+	fragment, err := url.ParseQuery(u.Fragment)
+	ok(t, err)
+	equals(t, "invalid_request", fragment.Get("error"))
+	equals(t, "state-test", fragment.Get("state"))
+}
+
+// TestRedirectErrQuery tests that errors are added to the query string of
+// redirect URLs, keeping their parameters, without modifying clients.
+func TestRedirectErrQuery(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.RedirectURL, _ = url.Parse("https://example.com/callback?tenant=acme")
+	cfg.provider = provider
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"state":         {"state-test"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+	}
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+		req.Header.Set(RequestIDHeader, "req-1")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, withRequestID(w, req), cfg)
+		equals(t, http.StatusFound, w.Code)
+
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		query := u.Query()
+		equals(t, "acme", query.Get("tenant"))
+		equals(t, "invalid_request", query.Get("error"))
+		equals(t, ErrScopeRequired("").Description, query.Get("error_description"))
+		equals(t, "state-test", query.Get("state"))
+		equals(t, "req-1", query.Get("request_id"))
+		equals(t, "https://example.com/callback?tenant=acme", provider.Client.RedirectURL.String())
+	}
+}
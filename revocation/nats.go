@@ -0,0 +1,45 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package revocation
+
+// NATSConn publishes NATS messages. It is satisfied by
+// github.com/nats-io/nats.go.Conn.
+type NATSConn interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSBus is a Bus on top of a NATS subject. Reconnections are left to the
+// NATS client.
+type NATSBus struct {
+	// Conn revocations are published with.
+	Conn NATSConn
+	// Listen subscribes to a subject. For instance, when using nats.go:
+	//	func(subject string, fn func([]byte)) error {
+	//		_, err := nc.Subscribe(subject, func(m *nats.Msg) { fn(m.Data) })
+	//		return err
+	//	}
+	Listen func(subject string, fn func(data []byte)) error
+	// Subject revocations are published to. Defaults to "oauth2.revocations".
+	Subject string
+}
+
+// Publish implements Bus.
+func (b *NATSBus) Publish(id string) error {
+	return b.Conn.Publish(b.subject(), []byte(id))
+}
+
+// Subscribe implements Bus.
+func (b *NATSBus) Subscribe(fn func(id string)) error {
+	return b.Listen(b.subject(), func(data []byte) {
+		fn(string(data))
+	})
+}
+
+func (b *NATSBus) subject() string {
+	if b.Subject == "" {
+		return "oauth2.revocations"
+	}
+	return b.Subject
+}
@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package revocation
+
+import (
+	"log"
+	"time"
+)
+
+// RedisConn is a connection to a Redis server. It is satisfied by
+// github.com/garyburd/redigo/redis.Conn.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Send(commandName string, args ...interface{}) error
+	Flush() error
+	Receive() (reply interface{}, err error)
+	Close() error
+}
+
+// RedisBus is a Bus on top of a Redis channel.
+type RedisBus struct {
+	// Get returns a connection from a pool. For instance, when using redigo:
+	//	func() revocation.RedisConn { return pool.Get() }
+	// Subscriptions keep their connection blocked waiting for messages, so
+	// it must not have a read timeout.
+	Get func() RedisConn
+	// Channel revocations are published to. Defaults to "oauth2:revocations".
+	Channel string
+	// Time to wait before subscribing again after losing the connection.
+	// Defaults to one second.
+	RetryInterval time.Duration
+}
+
+// Publish implements Bus.
+func (b *RedisBus) Publish(id string) error {
+	conn := b.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("PUBLISH", b.channel(), id)
+	return err
+}
+
+// Subscribe implements Bus. It returns once subscribed and keeps receiving
+// revocations in the background, subscribing again whenever the connection
+// is lost.
+func (b *RedisBus) Subscribe(fn func(id string)) error {
+	conn, err := b.subscribe()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for {
+			b.receive(conn, fn)
+
+			// Revocations published while disconnected are lost.
+			fn("")
+			for {
+				time.Sleep(b.retryInterval())
+				if conn, err = b.subscribe(); err == nil {
+					break
+				}
+				log.Printf("[WARN] Error subscribing to revocations: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// subscribe returns a connection subscribed to the channel.
+func (b *RedisBus) subscribe() (RedisConn, error) {
+	conn := b.Get()
+	if err := conn.Send("SUBSCRIBE", b.channel()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// receive calls fn with the revocations received until the connection fails.
+func (b *RedisBus) receive(conn RedisConn, fn func(id string)) {
+	defer conn.Close()
+
+	for {
+		reply, err := conn.Receive()
+		if err != nil {
+			log.Printf("[WARN] Error receiving revocations: %v", err)
+			return
+		}
+
+		// Messages are replied as ["message", channel, data]. Subscription
+		// confirmations are ignored.
+		msg, ok := reply.([]interface{})
+		if !ok || len(msg) != 3 {
+			continue
+		}
+		if kind, _ := msg[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		data, _ := msg[2].([]byte)
+		fn(string(data))
+	}
+}
+
+func (b *RedisBus) channel() string {
+	if b.Channel == "" {
+		return "oauth2:revocations"
+	}
+	return b.Channel
+}
+
+func (b *RedisBus) retryInterval() time.Duration {
+	if b.RetryInterval <= 0 {
+		return time.Second
+	}
+	return b.RetryInterval
+}
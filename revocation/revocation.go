@@ -0,0 +1,59 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package revocation defines how the oauth2 package announces revoked
+// access tokens to resource servers, so tokens cached by AuthzHandler stop
+// being accepted within seconds instead of when their cache entry expires.
+// Tokens are identified by the tokengen.Hash of the value providers look
+// them up by, never by the token itself.
+package revocation
+
+import "sync"
+
+// Bus carries revocations from the authorization server to resource servers.
+type Bus interface {
+	// Publish announces the revocation of the token with the given
+	// identifier. An empty identifier announces that an unknown set of
+	// tokens was revoked.
+	Publish(id string) error
+
+	// Subscribe calls fn with the identifier of every revocation published
+	// from then on. fn is called with an empty identifier when the set of
+	// revoked tokens is unknown, for instance, because revocations may have
+	// been missed while reconnecting, so subscribers have to forget every
+	// token.
+	Subscribe(fn func(id string)) error
+}
+
+// MemoryBus is a Bus for deployments running the authorization server and
+// the resource servers in the same process.
+type MemoryBus struct {
+	mu          sync.RWMutex
+	subscribers []func(id string)
+}
+
+// NewMemoryBus returns a MemoryBus without subscribers.
+func NewMemoryBus() *MemoryBus {
+	return &MemoryBus{}
+}
+
+// Publish implements Bus.
+func (b *MemoryBus) Publish(id string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, fn := range b.subscribers {
+		fn(id)
+	}
+	return nil
+}
+
+// Subscribe implements Bus.
+func (b *MemoryBus) Subscribe(fn func(id string)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subscribers = append(b.subscribers, fn)
+	return nil
+}
@@ -0,0 +1,123 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package revocation
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMemoryBus(t *testing.T) {
+	b := NewMemoryBus()
+
+	var first, second []string
+	b.Subscribe(func(id string) { first = append(first, id) })
+	b.Subscribe(func(id string) { second = append(second, id) })
+
+	if err := b.Publish("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, []string{"abc"}) || !reflect.DeepEqual(second, []string{"abc"}) {
+		t.Errorf("expected every subscriber to receive the revocation, got %q and %q", first, second)
+	}
+}
+
+// fakeRedis replies to PUBLISH and replays messages to subscribers.
+type fakeRedis struct {
+	published []interface{}
+	replies   []interface{}
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "PUBLISH" {
+		f.published = append(f.published, args...)
+	}
+	return int64(1), nil
+}
+
+func (f *fakeRedis) Send(cmd string, args ...interface{}) error { return nil }
+
+func (f *fakeRedis) Flush() error { return nil }
+
+func (f *fakeRedis) Receive() (interface{}, error) {
+	if len(f.replies) == 0 {
+		return nil, errors.New("connection closed")
+	}
+	reply := f.replies[0]
+	f.replies = f.replies[1:]
+	return reply, nil
+}
+
+func (f *fakeRedis) Close() error { return nil }
+
+func TestRedisBus(t *testing.T) {
+	conn := &fakeRedis{
+		replies: []interface{}{
+			[]interface{}{[]byte("subscribe"), []byte("oauth2:revocations"), int64(1)},
+			[]interface{}{[]byte("message"), []byte("oauth2:revocations"), []byte("abc")},
+		},
+	}
+	b := &RedisBus{
+		Get:           func() RedisConn { return conn },
+		RetryInterval: time.Hour,
+	}
+
+	if err := b.Publish("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(conn.published, []interface{}{"oauth2:revocations", "abc"}) {
+		t.Errorf("unexpected PUBLISH arguments: %q", conn.published)
+	}
+
+	ids := make(chan string, 2)
+	if err := b.Subscribe(func(id string) { ids <- id }); err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocations may be missed once the connection is lost.
+	for _, expected := range []string{"abc", ""} {
+		select {
+		case id := <-ids:
+			if id != expected {
+				t.Errorf("expected %q, got %q", expected, id)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %q to be received", expected)
+		}
+	}
+}
+
+type fakeNATS struct {
+	handlers map[string]func([]byte)
+}
+
+func (f *fakeNATS) Publish(subject string, data []byte) error {
+	f.handlers[subject](data)
+	return nil
+}
+
+func TestNATSBus(t *testing.T) {
+	conn := &fakeNATS{handlers: make(map[string]func([]byte))}
+	b := &NATSBus{
+		Conn: conn,
+		Listen: func(subject string, fn func([]byte)) error {
+			conn.handlers[subject] = fn
+			return nil
+		},
+	}
+
+	var ids []string
+	if err := b.Subscribe(func(id string) { ids = append(ids, id) }); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Publish("abc"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"abc"}) {
+		t.Errorf("expected revocation to be received, got %q", ids)
+	}
+}
@@ -0,0 +1,42 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+
+	"github.com/hooklift/oauth2/revocation"
+	"github.com/hooklift/oauth2/tokengen"
+)
+
+// SetRevocationBus propagates revocations of access tokens from Handler to
+// AuthzHandler caches, see SetTokenCache. Handler publishes the tokens it
+// revokes and AuthzHandler forgets them as soon as they are received. Tokens
+// revoked in bulk, such as by RevokeByEvent or when deleting a client, make
+// AuthzHandler forget every token. Host applications revoking tokens through
+// their provider directly are expected to publish the tokengen.Hash of their
+// identifier themselves.
+func SetRevocationBus(bus revocation.Bus) option {
+	return func(c *config) {
+		c.revocationBus = bus
+	}
+}
+
+// publishRevocation announces the revocation of the token with the given
+// identifier, or of unknown tokens if empty. Failures are only logged, as
+// the token is revoked anyway and cached copies expire eventually.
+func publishRevocation(cfg config, id string) {
+	if cfg.revocationBus == nil {
+		return
+	}
+
+	if id != "" {
+		id = tokengen.Hash(id)
+	}
+
+	if err := cfg.revocationBus.Publish(id); err != nil {
+		log.Printf("[WARN] Error publishing revocation: %v", err)
+	}
+}
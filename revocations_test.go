@@ -0,0 +1,54 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/revocation"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestRevocationPropagation tests that tokens revoked by the authorization
+// server stop being accepted by resource servers caching them right away.
+func TestRevocationPropagation(t *testing.T) {
+	cfg, authzCode := getTestAuthzCode(t)
+	bus := revocation.NewMemoryBus()
+	SetRevocationBus(bus)(&cfg)
+
+	req := AuthzGrantTokenRequestTest(t, "authorization_code", authzCode)
+	req.SetBasicAuth("testclient", "testclient")
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+
+	var token types.Token
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})
+	handler := AuthzHandler(next, cfg.provider, SetTokenCache(NewTokenCache(0, time.Hour, 0)), SetRevocationBus(bus))
+	access := func() int {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer "+token.Value)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+	equals(t, http.StatusOK, access())
+
+	req, err := http.NewRequest("DELETE", "https://example.com/oauth2/tokens/"+token.Value, nil)
+	ok(t, err)
+	req.SetBasicAuth("testclient", "testclient")
+	w = httptest.NewRecorder()
+	RevokeToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	equals(t, http.StatusUnauthorized, access())
+}
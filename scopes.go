@@ -0,0 +1,35 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import "github.com/hooklift/oauth2/types"
+
+// SetDefaultScope makes the scope parameter optional in authorization
+// requests, as allowed by http://tools.ietf.org/html/rfc6749#section-3.3.
+// Requests without it are given the client's types.Client.DefaultScope or,
+// if the client has none, the given space-delimited scope. Requests without
+// scope are still rejected if both are empty.
+//
+// Without this option, the scope parameter is required and clients' default
+// scopes are ignored.
+func SetDefaultScope(scope string) option {
+	return func(c *config) {
+		c.defaultScope.enabled = true
+		c.defaultScope.scope = scope
+	}
+}
+
+// requestedScope returns the scope of an authorization request, falling back
+// to the default scope for the client if allowed.
+func requestedScope(cfg config, client types.Client, scope string) string {
+	if scope != "" || !cfg.defaultScope.enabled {
+		return scope
+	}
+
+	if client.DefaultScope != "" {
+		return client.DefaultScope
+	}
+	return cfg.defaultScope.scope
+}
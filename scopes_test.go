@@ -0,0 +1,67 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestDefaultScope tests that authorization requests without scope are
+// rejected unless default scopes are enabled, in which case the client's
+// default scope wins over the authorization server's.
+func TestDefaultScope(t *testing.T) {
+	tests := []struct {
+		options      []option
+		clientScope  string
+		errorCode    string
+		expectedForm string
+	}{
+		{nil, "read", "invalid_request", ""},
+		{[]option{SetDefaultScope("")}, "", "invalid_request", ""},
+		{[]option{SetDefaultScope("read")}, "", "", `value="read"`},
+		{[]option{SetDefaultScope("read")}, "identity write", "", `value="identity write"`},
+		{[]option{SetDefaultScope("")}, "identity", "", `value="identity"`},
+	}
+
+	for _, tt := range tests {
+		cfg := setupTest()
+		provider := test.NewProvider(true)
+		provider.Client.DefaultScope = tt.clientScope
+		cfg.provider = provider
+		for _, opt := range tt.options {
+			opt(&cfg)
+		}
+
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+
+		if tt.errorCode != "" {
+			equals(t, http.StatusFound, w.Code)
+			u, err := url.Parse(w.Header().Get("Location"))
+			ok(t, err)
+			equals(t, tt.errorCode, u.Query().Get("error"))
+			continue
+		}
+
+		equals(t, http.StatusOK, w.Code)
+		assert(t, strings.Contains(w.Body.String(), tt.expectedForm), "expected %s in authorization form: %s", tt.expectedForm, w.Body.String())
+	}
+}
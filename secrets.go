@@ -0,0 +1,103 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/subtle"
+
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// SetSecretHashing makes authorization codes and refresh tokens be looked up
+// by their hash, so a dump of the provider's storage does not yield usable
+// credentials. Providers are then expected to store the tokengen.Hash of the
+// codes and refresh tokens they issue, in types.Grant.Code and
+// types.Token.RefreshToken, while returning the secrets themselves.
+//
+// Codes and refresh tokens issued before hashing was turned on are still
+// looked up as they are if migrate is true, until they expire or are used.
+func SetSecretHashing(migrate bool) option {
+	return func(c *config) {
+		c.secretHashing.enabled = true
+		c.secretHashing.migrate = migrate
+	}
+}
+
+// secretID returns the identifier of a code or refresh token as stored by
+// the provider.
+func secretID(cfg config, secret string) string {
+	if !cfg.secretHashing.enabled {
+		return secret
+	}
+	return tokengen.Hash(secret)
+}
+
+// equalSecrets compares secrets in constant time.
+func equalSecrets(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// grantInfo looks up an authorization code. An empty types.Grant is
+// returned if there is none.
+func grantInfo(cfg config, code string) (types.Grant, error) {
+	if !cfg.secretHashing.enabled {
+		return cfg.provider.GrantInfo(code)
+	}
+
+	id := tokengen.Hash(code)
+	grant, err := cfg.provider.GrantInfo(id)
+	if err != nil || equalSecrets(grant.Code, id) {
+		return grant, err
+	}
+
+	if cfg.secretHashing.migrate {
+		grant, err = cfg.provider.GrantInfo(code)
+		if err != nil || equalSecrets(grant.Code, code) {
+			return grant, err
+		}
+	}
+	return types.Grant{}, nil
+}
+
+// refreshTokenInfo looks up a refresh token and returns it along with its
+// identifier as stored by the provider. An empty types.Token is returned if
+// there is none, or if what was presented is not the refresh token of the
+// token found, such as an access token.
+func refreshTokenInfo(cfg config, refreshToken string) (types.Token, string, error) {
+	refreshToken = unprefix(cfg.tokenPrefixes.refreshToken, refreshToken)
+	if !cfg.secretHashing.enabled {
+		token, err := cfg.provider.TokenInfo(refreshToken)
+		if err != nil || equalSecrets(token.RefreshToken, refreshToken) {
+			return token, refreshToken, err
+		}
+		return types.Token{}, refreshToken, nil
+	}
+
+	id := tokengen.Hash(refreshToken)
+	token, err := cfg.provider.TokenInfo(id)
+	if err != nil || equalSecrets(token.RefreshToken, id) {
+		return token, id, err
+	}
+
+	if cfg.secretHashing.migrate {
+		token, err = cfg.provider.TokenInfo(refreshToken)
+		if err != nil || equalSecrets(token.RefreshToken, refreshToken) {
+			return token, refreshToken, err
+		}
+	}
+	return types.Token{}, id, nil
+}
+
+// lookupToken looks up an access or refresh token and returns it along with
+// its identifier as stored by the provider.
+func lookupToken(cfg config, raw string) (types.Token, string, error) {
+	id := accessTokenID(cfg, raw)
+	token, err := cfg.provider.TokenInfo(id)
+	if err != nil || !cfg.secretHashing.enabled || token.Value != "" {
+		return token, id, err
+	}
+	return refreshTokenInfo(cfg, raw)
+}
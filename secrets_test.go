@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestSecretHashing tests that codes and refresh tokens are looked up by
+// their hash, so the stored values can not be used as credentials, and that
+// the ones issued before hashing was turned on can be migrated.
+func TestSecretHashing(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	// Issued before hashing was turned on.
+	legacy, err := provider.GenGrant(provider.Client, types.Scopes{types.Scope{ID: "read"}}, cfg.authzExpiration)
+	ok(t, err)
+
+	provider.HashSecrets = true
+	SetSecretHashing(false)(&cfg)
+
+	tokenRequest := func(cfg config, values url.Values) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	grant, err := provider.GenGrant(provider.Client, types.Scopes{types.Scope{ID: "read"}}, cfg.authzExpiration)
+	ok(t, err)
+
+	_, found := provider.Grants[grant.Code]
+	assert(t, !found, "codes should not be stored as they are")
+
+	// Stored values are not credentials.
+	w := tokenRequest(cfg, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {tokengen.Hash(grant.Code)},
+	})
+	equals(t, http.StatusBadRequest, w.Code)
+
+	w = tokenRequest(cfg, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {grant.Code},
+	})
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	_, found = provider.RefreshTokens[token.RefreshToken]
+	assert(t, !found, "refresh tokens should not be stored as they are")
+
+	w = tokenRequest(cfg, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokengen.Hash(token.RefreshToken)},
+	})
+	equals(t, http.StatusBadRequest, w.Code)
+
+	w = tokenRequest(cfg, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	})
+	equals(t, http.StatusOK, w.Code)
+
+	// Legacy codes only work while migrating.
+	w = tokenRequest(cfg, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {legacy.Code},
+	})
+	equals(t, http.StatusBadRequest, w.Code)
+
+	SetSecretHashing(true)(&cfg)
+	w = tokenRequest(cfg, url.Values{
+		"grant_type": {"authorization_code"},
+		"code":       {legacy.Code},
+	})
+	equals(t, http.StatusOK, w.Code)
+}
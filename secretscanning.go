@@ -0,0 +1,212 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+	"github.com/hooklift/oauth2/webhook"
+)
+
+// Headers authenticating secret scanning partners.
+const (
+	ScanningPartnerHeader   = "X-Scanning-Partner"
+	ScanningSignatureHeader = "X-Scanning-Signature"
+)
+
+// maxLeakReportSize is the size of the largest report read from secret
+// scanning partners.
+const maxLeakReportSize = 1 << 20
+
+// SetScanningPartner allows a secret scanning partner to report leaked tokens
+// to SecretScanningHandler, signing its reports with the given secret.
+func SetScanningPartner(id, secret string) option {
+	return func(c *config) {
+		if c.scanningPartners == nil {
+			c.scanningPartners = make(map[string][]byte)
+		}
+		c.scanningPartners[id] = []byte(secret)
+	}
+}
+
+// leakedToken is a token reported by a secret scanning partner.
+type leakedToken struct {
+	Token  string `json:"token"`
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Source string `json:"source"`
+}
+
+// leakLabel tells a secret scanning partner whether a token was genuine.
+type leakLabel struct {
+	Token string `json:"token_raw"`
+	Type  string `json:"token_type"`
+	Label string `json:"label"`
+}
+
+// SecretScanningHandler returns the intake endpoint of secret scanning
+// partners, such as GitHub's, which report tokens found in public places.
+// Genuine tokens are revoked and reported to the auditor as
+// types.AuditTokenLeaked events. For example:
+//
+//	POST /oauth2/leaked_tokens
+//	Content-Type: application/json
+//	X-Scanning-Partner: github
+//	X-Scanning-Signature: sha256=<hex encoded HMAC-SHA256 of the body>
+//
+//	[{"token": "hlat_...", "type": "access_token", "url": "https://...", "source": "content"}]
+//
+// It replies with a label for each token, "true_positive" if it was genuine
+// or "false_positive" otherwise. Only tokens with the prefixes set by
+// SetTokenPrefixes and a valid checksum are looked up. Partners are
+// registered with SetScanningPartner, reports of unknown partners are not
+// read, and reports larger than 1 MiB are rejected. Options other than
+// SetTokenPrefixes, SetScanningPartner, SetSecretHashing, SetKeyProvider,
+// SetAuditor, SetClock and SetMessages are ignored.
+func SecretScanningHandler(provider Provider, opts ...option) http.Handler {
+	if provider == nil {
+		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
+	}
+
+	cfg := config{provider: provider}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req = withRequestID(w, req)
+
+		if req.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Reports of unknown partners are not even read.
+		partner := req.Header.Get(ScanningPartnerHeader)
+		secret, ok := cfg.scanningPartners[partner]
+		if !ok {
+			render.JSON(w, render.Options{
+				Status: http.StatusUnauthorized,
+				Data:   localize(req, cfg, ErrUnauthorizedClient),
+			})
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxLeakReportSize))
+		if err != nil {
+			status := http.StatusBadRequest
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			render.JSON(w, render.Options{
+				Status: status,
+				Data:   localize(req, cfg, ErrLeakReportMalformed),
+			})
+			return
+		}
+
+		signature := strings.TrimPrefix(req.Header.Get(ScanningSignatureHeader), "sha256=")
+		if !equalSecrets(signature, webhook.Sign(secret, body)) {
+			render.JSON(w, render.Options{
+				Status: http.StatusUnauthorized,
+				Data:   localize(req, cfg, ErrUnauthorizedClient),
+			})
+			return
+		}
+
+		var leaked []leakedToken
+		if err := json.Unmarshal(body, &leaked); err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrLeakReportMalformed),
+			})
+			return
+		}
+
+		labels := make([]leakLabel, 0, len(leaked))
+		for _, l := range leaked {
+			genuine, err := revokeLeakedToken(req, cfg, partner, l)
+			if err != nil {
+				render.JSON(w, render.Options{
+					Status: http.StatusInternalServerError,
+					Data:   serverError(req, cfg, "", err),
+				})
+				return
+			}
+
+			label := leakLabel{Token: l.Token, Type: l.Type, Label: "false_positive"}
+			if genuine {
+				label.Label = "true_positive"
+			}
+			labels = append(labels, label)
+		}
+
+		render.JSON(w, render.Options{
+			Status: http.StatusOK,
+			Data:   labels,
+		})
+	})
+}
+
+// revokeLeakedToken revokes a reported token and tells whether it was genuine.
+func revokeLeakedToken(req *http.Request, cfg config, partner string, l leakedToken) (bool, error) {
+	var token types.Token
+	var id, tokenType string
+	var err error
+
+	access, refresh := cfg.tokenPrefixes.accessToken, cfg.tokenPrefixes.refreshToken
+	switch {
+	case access != "" && strings.HasPrefix(l.Token, access):
+		if _, ok := tokengen.Unprefix(access, l.Token); !ok {
+			return false, nil
+		}
+		tokenType = "access_token"
+		id = accessTokenID(cfg, l.Token)
+		token, err = cfg.provider.TokenInfo(id)
+	case refresh != "" && strings.HasPrefix(l.Token, refresh):
+		if _, ok := tokengen.Unprefix(refresh, l.Token); !ok {
+			return false, nil
+		}
+		tokenType = "refresh_token"
+		token, id, err = refreshTokenInfo(cfg, l.Token)
+	default:
+		return false, nil
+	}
+
+	if err != nil || token.Value == "" {
+		return false, err
+	}
+
+	if err := cfg.provider.RevokeToken(id); err != nil {
+		return false, err
+	}
+	publishRevocation(cfg, id)
+
+	log.Printf("[INFO] request_id=%s Revoked leaked %s of client %s reported by %s",
+		RequestID(req), tokenType, token.ClientID, partner)
+
+	audit(req, cfg, types.AuditEvent{
+		Type:     types.AuditTokenLeaked,
+		ClientID: token.ClientID,
+		UserID:   token.UserID,
+		Details: map[string]string{
+			"partner":    partner,
+			"token_type": tokenType,
+			"source":     l.Source,
+			"url":        l.URL,
+		},
+	})
+	return true, nil
+}
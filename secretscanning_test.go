@@ -0,0 +1,120 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+	"github.com/hooklift/oauth2/webhook"
+)
+
+// TestSecretScanning tests that genuine leaked tokens reported by secret
+// scanning partners are revoked and audited, and that reports have to be
+// signed.
+func TestSecretScanning(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	prefixes := SetTokenPrefixes("hlat_", "hlrt_")
+	prefixes(&cfg)
+
+	w := httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	events := &auditLog{}
+	handler := SecretScanningHandler(provider, prefixes, SetAuditor(events), SetScanningPartner("github", "s3cr3t"))
+
+	report := func(secret string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/leaked_tokens", bytes.NewReader(body))
+		ok(t, err)
+		req.Header.Set(ScanningPartnerHeader, "github")
+		req.Header.Set(ScanningSignatureHeader, "sha256="+webhook.Sign([]byte(secret), body))
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	tampered := token.Value[:len(token.Value)-1] + "x"
+	if tampered == token.Value {
+		tampered = token.Value[:len(token.Value)-1] + "y"
+	}
+
+	body, err := json.Marshal([]leakedToken{
+		{Token: token.Value, Type: "access_token", URL: "https://example.com/leak", Source: "content"},
+		{Token: token.RefreshToken, Type: "refresh_token"},
+		{Token: tampered, Type: "access_token"},
+		{Token: "ghp_not_ours", Type: "access_token"},
+	})
+	ok(t, err)
+
+	w = report("wrong", body)
+	equals(t, http.StatusUnauthorized, w.Code)
+	equals(t, 1, len(provider.AccessTokens))
+
+	w = report("s3cr3t", body)
+	equals(t, http.StatusOK, w.Code)
+
+	labels := []leakLabel{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &labels))
+	equals(t, 4, len(labels))
+	equals(t, "true_positive", labels[0].Label)
+	equals(t, "true_positive", labels[1].Label)
+	equals(t, "false_positive", labels[2].Label)
+	equals(t, "false_positive", labels[3].Label)
+
+	equals(t, 0, len(provider.AccessTokens))
+	equals(t, 0, len(provider.RefreshTokens))
+
+	equals(t, 2, len(*events))
+	leak := (*events)[0]
+	equals(t, types.AuditTokenLeaked, leak.Type)
+	equals(t, provider.Client.ID, leak.ClientID)
+	equals(t, "https://example.com/leak", leak.Details["url"])
+	equals(t, "github", leak.Details["partner"])
+}
+
+// unreadBody fails the test reading it.
+type unreadBody struct {
+	t *testing.T
+}
+
+func (b unreadBody) Read(p []byte) (int, error) {
+	b.t.Fatal("the report of an unknown partner was read")
+	return 0, io.EOF
+}
+
+// TestSecretScanningLimits tests that reports of unknown partners are not
+// read, and that large reports are rejected.
+func TestSecretScanningLimits(t *testing.T) {
+	handler := SecretScanningHandler(test.NewProvider(true), SetScanningPartner("github", "s3cr3t"))
+
+	report := func(partner string, body io.Reader) int {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/leaked_tokens", body)
+		ok(t, err)
+		if partner != "" {
+			req.Header.Set(ScanningPartnerHeader, partner)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	equals(t, http.StatusUnauthorized, report("", unreadBody{t}))
+	equals(t, http.StatusUnauthorized, report("unknown", unreadBody{t}))
+	equals(t, http.StatusRequestEntityTooLarge, report("github", bytes.NewReader(make([]byte, maxLeakReportSize+1))))
+}
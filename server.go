@@ -0,0 +1,417 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+)
+
+// Unmatched tells how Handler responds to requests not sent to any of its
+// endpoints.
+type Unmatched int
+
+const (
+	// UnmatchedNext delegates them to the next handler. It is the default.
+	UnmatchedNext Unmatched = iota
+	// UnmatchedNotFound responds with a not_found JSON error, for servers
+	// only serving the OAuth2 endpoints. It is also used if there is no next
+	// handler.
+	UnmatchedNotFound
+)
+
+// SetUnmatched sets how requests not sent to any of the endpoints of Handler
+// are responded to. Defaults to UnmatchedNext.
+func SetUnmatched(u Unmatched) option {
+	return func(c *config) {
+		c.unmatched = u
+	}
+}
+
+// Worker is a background subsystem, such as a webhook.Dispatcher, stopped
+// along with the handler that runs it. Workers also implementing a Start
+// method are started by it too.
+type Worker interface {
+	// Shutdown stops the worker once its pending work is done, or when the
+	// context is done, whichever comes first.
+	Shutdown(ctx context.Context) error
+}
+
+// SetWorker has the given worker started and stopped along with the handler.
+// Workers set by Server.Reload are ignored.
+func SetWorker(w Worker) option {
+	return func(c *config) {
+		c.workers = append(c.workers, w)
+	}
+}
+
+// lifecycle starts and stops the workers of a handler.
+type lifecycle struct {
+	started  sync.Once
+	stopping sync.Once
+	workers  []Worker
+}
+
+// start starts the workers, if not done yet and not stopped.
+func (l *lifecycle) start() {
+	l.started.Do(func() {
+		for _, w := range l.workers {
+			if s, ok := w.(interface {
+				Start()
+			}); ok {
+				s.Start()
+			}
+		}
+	})
+}
+
+// shutdown stops the workers, returning the first error.
+func (l *lifecycle) shutdown(ctx context.Context) error {
+	// Workers are not started afterwards.
+	l.started.Do(func() {})
+
+	var err error
+	l.stopping.Do(func() {
+		for _, w := range l.workers {
+			if e := w.Shutdown(ctx); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+// Server is the http.Handler returned by Handler. Its configuration can be
+// changed while it serves requests, see Reload.
+type Server struct {
+	lifecycle
+	next http.Handler
+	// Serializes reloads.
+	mu sync.Mutex
+	// Current *snapshot, swapped atomically by Reload.
+	current atomic.Value
+	// Number of requests being handled, if limited with SetOverloadProtection.
+	inFlight int32
+	// Resource servers created by AuthzHandler, guarded by mu.
+	resourceServers []*ResourceServer
+}
+
+// snapshot is a configuration as given by options, along with what is
+// derived from it. It is never modified once stored in a Server.
+type snapshot struct {
+	// Configuration as given by options, to apply reloaded options on.
+	options config
+	// Configuration requests are handled with.
+	cfg    config
+	routes []route
+}
+
+// newSnapshot derives the configuration to handle requests with from the one
+// given by options. The provider guard of the previous snapshot, if any, is
+// kept if the provider and its guard options did not change, so reloading
+// does not reset the circuit breaker.
+func newSnapshot(options config, prev *snapshot) *snapshot {
+	if options.provider == nil {
+		log.Fatalln("An implementation of the oauth2.Provider interface is expected")
+	}
+
+	if options.strict {
+		if unsafe := unsafeOptions(options); len(unsafe) > 0 {
+			log.Fatalf("Options unsafe in production while in strict mode: %s", strings.Join(unsafe, "; "))
+		}
+	}
+
+	cfg := options
+	if cfg.authzForm == nil {
+		SetAuthzForm(DefaultAuthzForm)(&cfg)
+	}
+
+	if prev != nil && prev.options.provider == options.provider &&
+		prev.options.guard == options.guard && prev.options.clock == options.clock {
+		cfg.provider = prev.cfg.provider
+	} else {
+		cfg.provider = guard(options.provider, cfg)
+	}
+
+	// Keeps a registry of path function handlers for OAuth2 requests.
+	registry := map[string]map[string]func(http.ResponseWriter, *http.Request, config){
+		cfg.authzEndpoint:         AuthzHandlers,
+		cfg.tokenEndpoint:         TokenHandlers,
+		cfg.grantsEndpoint:        GrantsHandlers,
+		cfg.jwksEndpoint:          JWKSHandlers,
+		cfg.introspectionEndpoint: IntrospectionHandlers,
+		cfg.metadataEndpoint:      MetadataHandlers,
+	}
+
+	if cfg.documents.changePasswordURL != "" {
+		registry[ChangePasswordPath] = ChangePasswordHandlers
+	}
+
+	if cfg.broker != nil {
+		registry[cfg.brokerEndpoint] = BrokerHandlers
+	}
+
+	if _, ok := options.provider.(DeviceCodeProvider); ok && grantTypeAllowed(cfg, DeviceCodeGrantType) {
+		registry[cfg.deviceEndpoint] = DeviceAuthorizationHandlers
+		registry[cfg.deviceVerificationEndpoint] = DeviceVerificationHandlers
+	}
+
+	// Iterating over a map on every request is slow and its order random,
+	// so routes are matched against a slice, from the longest to the shortest path.
+	routes := make([]route, 0, len(registry))
+	for p, handlers := range registry {
+		routes = append(routes, route{path: p, handlers: handlers})
+	}
+	sort.Sort(byPathLength(routes))
+
+	validateDeprecations(cfg, registry)
+
+	if t := cfg.readOnlyUntil; now(cfg).Before(t) {
+		log.Printf("[WARN] Read-only mode until %s", t.Format(time.RFC3339))
+	}
+
+	for p := range cfg.endpointOptions {
+		if _, ok := registry[p]; !ok {
+			log.Fatalf("Options given for unknown endpoint %s", p)
+		}
+	}
+
+	for i, r := range routes {
+		opts, ok := cfg.endpointOptions[r.path]
+		if !ok {
+			continue
+		}
+
+		ecfg := cfg.clone()
+		for _, opt := range opts {
+			opt(&ecfg)
+		}
+
+		// The provider, its guard and background workers are shared by every endpoint.
+		ecfg.provider = cfg.provider
+		ecfg.workers = cfg.workers
+
+		if ecfg.strict {
+			if unsafe := unsafeOptions(ecfg); len(unsafe) > 0 {
+				log.Fatalf("Options of endpoint %s unsafe in production while in strict mode: %s", r.path, strings.Join(unsafe, "; "))
+			}
+		}
+		routes[i].cfg = &ecfg
+	}
+
+	return &snapshot{options: options, cfg: cfg, routes: routes}
+}
+
+// SetEndpointOptions overrides options for a single endpoint, given by its
+// path, such as the authorization endpoint, which does not face the same
+// threats as the token endpoint. For instance, to rate limit device
+// authorization requests more tightly than token requests and send a
+// shorter Strict-Transport-Security max age from the authorization endpoint:
+//
+//	oauth2.Handler(mux,
+//		oauth2.SetProvider(provider),
+//		oauth2.SetRateLimit(store, 100, time.Minute),
+//		oauth2.SetEndpointOptions("/oauth2/device_authorizations", oauth2.SetRateLimit(deviceStore, 10, time.Minute)),
+//		oauth2.SetEndpointOptions("/oauth2/authzs", oauth2.SetSTSMaxAge(24*time.Hour)),
+//	)
+//
+// Endpoints rate limited with the same store share their counters. Endpoint
+// options are applied on top of the other options, whatever their order,
+// and are kept when reloading. Options setting the provider, the paths of
+// endpoints or background workers have no effect. Unknown paths are fatal.
+func SetEndpointOptions(endpoint string, opts ...option) option {
+	return func(c *config) {
+		options := make(map[string][]option, len(c.endpointOptions)+1)
+		for k, v := range c.endpointOptions {
+			options[k] = v
+		}
+		options[endpoint] = append(append([]option(nil), options[endpoint]...), opts...)
+		c.endpointOptions = options
+	}
+}
+
+// Reload applies the given options on top of the current configuration, for
+// instance, to rotate signing keys or change policies, templates or rate
+// limits without restarting. Requests being handled keep the configuration
+// they started with, and the following ones get the new configuration as a
+// whole, never a mix of both. Options left out keep their current value.
+func (s *Server) Reload(opts ...option) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev := s.current.Load().(*snapshot)
+	options := prev.options.clone()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	s.current.Store(newSnapshot(options, prev))
+	log.Printf("[INFO] Configuration reloaded")
+}
+
+// Start starts the background workers of the server. Calling it is optional,
+// they are started by the first request otherwise.
+func (s *Server) Start() {
+	s.start()
+}
+
+// Shutdown stops the background workers of the server, letting them finish
+// their pending work until the context is done. It is meant to be called
+// after http.Server.Shutdown, once requests were drained.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.shutdown(ctx)
+}
+
+// ServeHTTP locates and runs the specific OAuth2 handler for the request's
+// method, or passes the request on to the next handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.start()
+
+	snap := s.current.Load().(*snapshot)
+	for _, r := range snap.routes {
+		if strings.HasPrefix(req.URL.Path, r.path) {
+			if handlerFn, ok := r.handlers[req.Method]; ok {
+				req, cfg := withRequestID(w, req), snap.cfg
+				if r.cfg != nil {
+					cfg = *r.cfg
+				}
+				if checkDeprecation(w, req, cfg, r.path, "") {
+					retireEndpoint(w, req, cfg)
+					return
+				}
+				if cfg.reloadTemplates {
+					cfg.authzForm = reloadAuthzForm(req, cfg)
+				}
+				if cfg.overload.deadline > 0 || cfg.overload.maxInFlight > 0 {
+					s.serveWithinLimits(w, req, cfg, handlerFn)
+					return
+				}
+				handlerFn(w, req, cfg)
+				return
+			}
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("Method Not Allowed"))
+			return
+		}
+	}
+
+	if s.next == nil || snap.cfg.unmatched == UnmatchedNotFound {
+		req = withRequestID(w, req)
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, snap.cfg, ErrNotFound),
+		})
+		return
+	}
+	s.next.ServeHTTP(w, req)
+}
+
+// ResourceServer is the http.Handler returned by AuthzHandler.
+type ResourceServer struct {
+	lifecycle
+	handler http.Handler
+	// Whether access tokens are rejected in the query string, as with SetOAuth21.
+	oauth21 bool
+}
+
+// AuthzHandler returns a resource server protecting next, configured with the
+// options of the authorization server, so that SetOAuth21 and the provider,
+// clock, messages and key provider given to Handler apply to both. The given
+// options are applied on top, but for SetOAuth21 which is always the one of the
+// authorization server. Background workers set with SetWorker are not shared.
+//
+// Reloading the authorization server does not reconfigure resource servers
+// already created, and Capabilities panics if their OAuth 2.1 mode no longer
+// agrees with the one of the authorization server.
+func (s *Server) AuthzHandler(next http.Handler, opts ...option) *ResourceServer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	options := s.current.Load().(*snapshot).options
+	cfg := options.clone()
+	cfg.workers = nil
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.oauth21 = options.oauth21
+
+	rs := newResourceServer(next, cfg.provider, cfg)
+	s.resourceServers = append(s.resourceServers, rs)
+	return rs
+}
+
+// Start starts the background workers of the resource server, such as the
+// one recording token uses. Calling it is optional, they are started by the
+// first request otherwise.
+func (s *ResourceServer) Start() {
+	s.start()
+}
+
+// Shutdown stops the background workers of the resource server, letting
+// them finish their pending work until the context is done. It is meant to
+// be called after http.Server.Shutdown, once requests were drained.
+func (s *ResourceServer) Shutdown(ctx context.Context) error {
+	return s.shutdown(ctx)
+}
+
+// ServeHTTP implements http.Handler.
+func (s *ResourceServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.start()
+	s.handler.ServeHTTP(w, req)
+}
+
+// clone returns a copy of the configuration that options can modify without
+// affecting the original, which may be in use by other goroutines.
+func (c config) clone() config {
+	if c.scopePolicies != nil {
+		policies := make(map[string]scopePolicy, len(c.scopePolicies))
+		for k, v := range c.scopePolicies {
+			policies[k] = v
+		}
+		c.scopePolicies = policies
+	}
+
+	if c.extensionParams != nil {
+		params := make(map[string]ExtensionValidator, len(c.extensionParams))
+		for k, v := range c.extensionParams {
+			params[k] = v
+		}
+		c.extensionParams = params
+	}
+
+	if c.introspectionClaims != nil {
+		claims := make(map[string][]string, len(c.introspectionClaims))
+		for k, v := range c.introspectionClaims {
+			claims[k] = v
+		}
+		c.introspectionClaims = claims
+	}
+
+	if c.messages != nil {
+		messages := make(map[string]map[string]string, len(c.messages))
+		for k, v := range c.messages {
+			messages[k] = v
+		}
+		c.messages = messages
+	}
+
+	if c.scanningPartners != nil {
+		partners := make(map[string][]byte, len(c.scanningPartners))
+		for k, v := range c.scanningPartners {
+			partners[k] = v
+		}
+		c.scanningPartners = partners
+	}
+	c.workers = append([]Worker(nil), c.workers...)
+	return c
+}
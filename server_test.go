@@ -0,0 +1,213 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestReload tests that reloaded options apply to the following requests,
+// while other options keep their value.
+func TestReload(t *testing.T) {
+	s := Handler(http.NotFoundHandler(),
+		SetProvider(test.NewProvider(true)),
+		SetServiceDocumentation("https://example.com/docs/v1"),
+		SetServerPolicies("https://example.com/policy", ""),
+	)
+
+	metadata := func() string {
+		req, err := http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+		return w.Body.String()
+	}
+
+	body := metadata()
+	assert(t, strings.Contains(body, "https://example.com/docs/v1"), "expected documentation URL: %s", body)
+
+	s.Reload(SetServiceDocumentation("https://example.com/docs/v2"))
+	body = metadata()
+	assert(t, strings.Contains(body, "https://example.com/docs/v2"), "expected reloaded documentation URL: %s", body)
+	assert(t, strings.Contains(body, "https://example.com/policy"), "expected policy URL to be kept: %s", body)
+}
+
+// TestReloadSnapshot tests that reloading does not modify the configuration
+// in use by requests already being handled, and that the provider guard is
+// only replaced if its options change.
+func TestReloadSnapshot(t *testing.T) {
+	s := Handler(http.NotFoundHandler(),
+		SetProvider(test.NewProvider(true)),
+		SetProviderTimeout(time.Second),
+		SetScopePolicy("read", time.Hour, true),
+	)
+
+	before := s.current.Load().(*snapshot)
+	s.Reload(SetScopePolicy("write", time.Minute, false))
+	after := s.current.Load().(*snapshot)
+
+	equals(t, 1, len(before.cfg.scopePolicies))
+	equals(t, 2, len(after.cfg.scopePolicies))
+	assert(t, before.cfg.provider == after.cfg.provider, "expected provider guard to be kept")
+
+	s.Reload(SetProviderTimeout(2 * time.Second))
+	assert(t, after.cfg.provider != s.current.Load().(*snapshot).cfg.provider, "expected provider guard to be replaced")
+}
+
+// TestReloadConcurrency tests that requests can be handled while reloading.
+// It is meant to be run with the race detector.
+func TestReloadConcurrency(t *testing.T) {
+	s := Handler(http.NotFoundHandler(), SetProvider(test.NewProvider(true)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				req, _ := http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+				s.ServeHTTP(httptest.NewRecorder(), req)
+			}
+		}()
+	}
+
+	for i := 0; i < 50; i++ {
+		s.Reload(SetMessages("es", map[string]string{"invalid_request": "Solicitud inválida"}))
+	}
+	wg.Wait()
+}
+
+// stubWorker records whether it was started and stopped.
+type stubWorker struct {
+	started, stopped int
+}
+
+func (w *stubWorker) Start() { w.started++ }
+
+func (w *stubWorker) Shutdown(ctx context.Context) error {
+	w.stopped++
+	return nil
+}
+
+// TestServerLifecycle tests that workers are started once, by Start or by
+// the first request, and stopped by Shutdown.
+func TestServerLifecycle(t *testing.T) {
+	worker := &stubWorker{}
+	s := Handler(http.NotFoundHandler(), SetProvider(test.NewProvider(true)), SetWorker(worker))
+	equals(t, 0, worker.started)
+
+	req, err := http.NewRequest("GET", "https://example.com/.well-known/oauth-authorization-server", nil)
+	ok(t, err)
+	s.ServeHTTP(httptest.NewRecorder(), req)
+	s.Start()
+	equals(t, 1, worker.started)
+
+	ok(t, s.Shutdown(context.Background()))
+	ok(t, s.Shutdown(context.Background()))
+	equals(t, 1, worker.stopped)
+
+	// Workers of a server stopped before starting are never started.
+	worker = &stubWorker{}
+	s = Handler(http.NotFoundHandler(), SetProvider(test.NewProvider(true)), SetWorker(worker))
+	ok(t, s.Shutdown(context.Background()))
+	s.Start()
+	equals(t, 0, worker.started)
+	equals(t, 1, worker.stopped)
+}
+
+// TestResourceServerShutdown tests that token uses still queued are recorded
+// when shutting down.
+func TestResourceServerShutdown(t *testing.T) {
+	provider := test.NewProvider(true)
+	provider.AccessTokens["token"] = types.Token{
+		ClientID: provider.Client.ID,
+		Value:    "token",
+		Type:     "bearer",
+		Scopes:   types.Scopes{types.Scope{ID: "read"}},
+	}
+
+	s := AuthzHandler(http.NotFoundHandler(), provider, SetUsageTracking(100, time.Hour))
+	s.Start()
+
+	req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+	ok(t, err)
+	req.Header.Set("Authorization", "Bearer token")
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	ok(t, s.Shutdown(context.Background()))
+	equals(t, int64(1), provider.Usage["token"].UseCount)
+}
+
+// TestUnmatched tests that requests to other paths are sent to the next
+// handler, or answered with a not_found error if configured so or if there
+// is no next handler.
+func TestUnmatched(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	get := func(h http.Handler) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "https://example.com/hello", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	provider := SetProvider(test.NewProvider(true))
+	equals(t, http.StatusTeapot, get(Handler(next, provider)).Code)
+
+	for _, h := range []*Server{Handler(next, provider, SetUnmatched(UnmatchedNotFound)), Handler(nil, provider)} {
+		w := get(h)
+		equals(t, http.StatusNotFound, w.Code)
+		assert(t, strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"), "expected a JSON response")
+		assert(t, strings.Contains(w.Body.String(), types.ErrorNotFound), "unexpected response %s", w.Body.String())
+		assert(t, w.Header().Get(RequestIDHeader) != "", "expected a request ID")
+	}
+}
+
+// TestEndpointOptions tests that options overridden for an endpoint only
+// apply to it, and are kept when reloading.
+func TestEndpointOptions(t *testing.T) {
+	s := Handler(http.NotFoundHandler(),
+		SetProvider(test.NewProvider(true)),
+		SetSTSMaxAge(time.Hour),
+		SetEndpointOptions("/oauth2/authzs", SetSTSMaxAge(24*time.Hour)),
+	)
+
+	head := func() string {
+		req, err := http.NewRequest("HEAD", "https://example.com/oauth2/authzs", nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		equals(t, http.StatusOK, w.Code)
+		return w.Header().Get("Strict-Transport-Security")
+	}
+
+	equals(t, "max-age=86400", head())
+	equals(t, time.Hour, s.current.Load().(*snapshot).cfg.stsMaxAge)
+
+	s.Reload(SetSTSMaxAge(2 * time.Hour))
+	equals(t, "max-age=86400", head())
+
+	for _, r := range s.current.Load().(*snapshot).routes {
+		if r.path != "/oauth2/authzs" {
+			assert(t, r.cfg == nil, "unexpected options for endpoint %s", r.path)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/replay"
+	"github.com/hooklift/oauth2/types"
+)
+
+// JWTBearerGrantType is the grant type used by service accounts to exchange
+// signed assertions for access tokens. http://tools.ietf.org/html/rfc7523#section-2.1
+const JWTBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+
+// Clock skew tolerated when validating time based claims of assertions.
+const assertionLeeway = time.Duration(1) * time.Minute
+
+// ServiceAccountProvider is an optional interface that providers can implement
+// in order to allow service accounts to obtain access tokens without any
+// interactive flow.
+type ServiceAccountProvider interface {
+	// ServiceAccountInfo returns the service account registered with the given
+	// identifier, along with its public key and scope ceiling. An empty
+	// types.ServiceAccount is expected if it does not exist.
+	ServiceAccountInfo(id string) (types.ServiceAccount, error)
+}
+
+// SetReplayCache rejects JWT assertions, request objects and DPoP proofs
+// presented more than once within their validity window. Assertions and
+// request objects are then required to have a "jti" claim. If the cache
+// fails, they are rejected.
+//
+// Use replay.RedisCache to detect replays among several instances of the
+// handler.
+func SetReplayCache(c replay.Cache) option {
+	return func(cfg *config) {
+		cfg.replayCache = c
+	}
+}
+
+// replayed tells whether the assertion was already presented. Assertions are
+// remembered until they expire, along with the tolerated clock skew.
+func replayed(cfg config, claims jwt.Claims) (bool, error) {
+	if cfg.replayCache == nil {
+		return false, nil
+	}
+
+	expiresAt := time.Unix(claims.ExpiresAt, 0).Add(assertionLeeway)
+	return cfg.replayCache.Seen(claims.Issuer+":"+claims.ID, expiresAt)
+}
+
+// Implements http://tools.ietf.org/html/rfc7523#section-2.1 and
+// http://tools.ietf.org/html/rfc7523#section-3
+//
+// Implementation notes:
+//  * Service accounts are their own clients, "iss" and "sub" must both be the
+//    service account identifier.
+//  * Scopes requested beyond the service account ceiling are rejected. If no
+//    scope is requested, the whole ceiling is granted.
+//  * Refresh tokens are never issued, service accounts can always sign a new assertion.
+//  * Assertions can only be used once if a replay cache is set.
+func serviceAccountGrant(w http.ResponseWriter, req *http.Request, cfg config, treq TokenRequest) {
+	provider, ok := asServiceAccountProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
+		})
+		return
+	}
+
+	assertion, err := jwt.Parse(treq.Assertion)
+	if err != nil {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_malformed", "Assertion is missing or malformed.")
+		return
+	}
+
+	claims := assertion.Claims
+	if claims.Issuer == "" || claims.Subject != claims.Issuer {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_issuer", "Assertion issuer and subject must identify the service account.")
+		return
+	}
+
+	account, err := provider.ServiceAccountInfo(claims.Issuer)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if account.ID == "" || account.PublicKey == nil {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_not_found", "Service account not found.")
+		return
+	}
+
+	if account.KeyID != "" && account.KeyID != assertion.Header.KeyID {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_unknown_key", "Assertion was signed with an unknown key.")
+		return
+	}
+
+	if err := assertion.Verify(account.PublicKey); err != nil {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_signature", "Assertion signature is invalid.")
+		return
+	}
+
+	// The JWT MUST contain an "aud" (audience) claim containing a value that
+	// identifies the authorization server as an intended audience.
+	if !claims.Audience.Contains("https://" + req.Host + req.URL.Path) {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_audience", "Assertion audience does not identify this token endpoint.")
+		return
+	}
+
+	if err := claims.Validate(now(cfg), assertionLeeway); err != nil {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_expired", "Assertion is expired or not valid yet.")
+		return
+	}
+
+	if cfg.replayCache != nil && claims.ID == "" {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_jti_missing", "Assertion must have a jti claim.")
+		return
+	}
+
+	seen, err := replayed(cfg, claims)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if seen {
+		renderInvalidAssertion(w, req, cfg, "invalid_assertion_replayed", "Assertion was already used.")
+		return
+	}
+
+	scopes := account.Scopes
+	if scope := treq.Scope; scope != "" {
+		scopes, err = cfg.provider.ScopesInfo(scope)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+
+		for _, s := range scopes {
+			if !account.Scopes.Contains(s.ID) {
+				render.JSON(w, render.Options{
+					Status: http.StatusBadRequest,
+					Data:   localize(req, cfg, ErrServiceAccountScope),
+				})
+				return
+			}
+		}
+	}
+
+	client := types.Client{
+		ID:   account.ID,
+		Name: account.Name,
+	}
+
+	grant := types.Grant{
+		ClientID: account.ID,
+		Scopes:   scopes,
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    JWTBearerGrantType,
+		Client:       client,
+		Scopes:       scopes,
+		TokenRequest: &treq,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	expiration, _ := tokenPolicy(cfg, scopes)
+	token, err := genToken(req, cfg, grant, client, false, expiration)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	renderToken(w, req, cfg, client, token)
+}
+
+func renderInvalidAssertion(w http.ResponseWriter, req *http.Request, cfg config, messageID, desc string) {
+	e := ErrInvalidGrant
+	e.Description = desc
+	e.MessageID = messageID
+	render.JSON(w, render.Options{
+		Status: http.StatusBadRequest,
+		Data:   localize(req, cfg, e),
+	})
+}
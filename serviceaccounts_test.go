@@ -0,0 +1,166 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/replay"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestServiceAccountGrant tests that service accounts are able to exchange signed
+// assertions for access tokens, in accordance with http://tools.ietf.org/html/rfc7523#section-2.1
+func TestServiceAccountGrant(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	provider.ServiceAccounts["builder"] = types.ServiceAccount{
+		ID:        "builder",
+		Name:      "CI builder",
+		PublicKey: key.Public(),
+		Scopes: types.Scopes{
+			types.Scope{ID: "read"},
+			types.Scope{ID: "identity"},
+		},
+	}
+
+	claims := jwt.Claims{
+		Issuer:    "builder",
+		Subject:   "builder",
+		Audience:  jwt.Audience{"https://example.com/oauth2/tokens"},
+		ExpiresAt: time.Now().Add(time.Duration(5) * time.Minute).Unix(),
+	}
+
+	expired := claims
+	expired.ExpiresAt = time.Now().Add(-time.Duration(1) * time.Hour).Unix()
+
+	otherAudience := claims
+	otherAudience.Audience = jwt.Audience{"https://attacker.com/oauth2/tokens"}
+
+	tests := []struct {
+		claims jwt.Claims
+		key    *ecdsa.PrivateKey
+		scope  string
+		status int
+		err    string
+	}{
+		{claims, key, "", http.StatusOK, ""},
+		{claims, key, "read", http.StatusOK, ""},
+		{claims, key, "read write", http.StatusBadRequest, "invalid_scope"},
+		{claims, otherKey, "read", http.StatusBadRequest, "invalid_grant"},
+		{expired, key, "read", http.StatusBadRequest, "invalid_grant"},
+		{otherAudience, key, "read", http.StatusBadRequest, "invalid_grant"},
+	}
+
+	for _, tt := range tests {
+		assertion, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256}, tt.claims, tt.key)
+		ok(t, err)
+
+		queryStr := url.Values{
+			"grant_type": {JWTBearerGrantType},
+			"assertion":  {assertion},
+			"scope":      {tt.scope},
+		}
+
+		buffer := bytes.NewBufferString(queryStr.Encode())
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+
+		if tt.err != "" {
+			authzErr := types.AuthzError{}
+			err = json.Unmarshal(w.Body.Bytes(), &authzErr)
+			ok(t, err)
+			equals(t, tt.err, authzErr.Code)
+			continue
+		}
+
+		token := types.Token{}
+		err = json.Unmarshal(w.Body.Bytes(), &token)
+		ok(t, err)
+		equals(t, "bearer", token.Type)
+
+		// Service accounts can always sign a new assertion.
+		equals(t, "", token.RefreshToken)
+		equals(t, "builder", provider.AccessTokens[token.Value].ClientID)
+	}
+}
+
+// TestAssertionReplay tests that assertions can only be used once when a replay
+// cache is set.
+func TestAssertionReplay(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetReplayCache(replay.NewMemoryCache())(&cfg)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	provider.ServiceAccounts["builder"] = types.ServiceAccount{
+		ID:        "builder",
+		PublicKey: key.Public(),
+		Scopes:    types.Scopes{types.Scope{ID: "read"}},
+	}
+
+	claims := jwt.Claims{
+		Issuer:    "builder",
+		Subject:   "builder",
+		Audience:  jwt.Audience{"https://example.com/oauth2/tokens"},
+		ExpiresAt: time.Now().Add(time.Duration(5) * time.Minute).Unix(),
+	}
+
+	withID := claims
+	withID.ID = "assertion-1"
+
+	tests := []struct {
+		claims jwt.Claims
+		status int
+	}{
+		{claims, http.StatusBadRequest},
+		{withID, http.StatusOK},
+		{withID, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		assertion, err := jwt.Sign(jwt.Header{Algorithm: jwt.ES256}, tt.claims, key)
+		ok(t, err)
+
+		queryStr := url.Values{
+			"grant_type": {JWTBearerGrantType},
+			"assertion":  {assertion},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(queryStr.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+	}
+}
@@ -0,0 +1,219 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package session keeps the sessions of resource owners with the
+// authorization server in encrypted cookies, so providers can implement
+// CurrentUser, or IsUserAuthenticated, and the login page they send
+// resource owners to with SetLoginURL, without storing sessions.
+//
+// Sessions are encrypted and authenticated with the envelope package, so
+// resource owners can neither read nor forge them, and rotating the keyring
+// does not log everyone out. Sessions carry the resource owner's
+// identifier, when and how they authenticated, and expire a fixed time
+// after authenticating, no matter how active they are.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hooklift/oauth2/envelope"
+	"github.com/hooklift/oauth2/types"
+)
+
+// DefaultCookieName is the name of the session cookie if no CookieName is given.
+const DefaultCookieName = "oauth2_session"
+
+// DefaultMaxAge is how long sessions last if no MaxAge is given.
+const DefaultMaxAge = 12 * time.Hour
+
+// Authentication methods, as registered by
+// http://tools.ietf.org/html/rfc8176#section-2
+const (
+	AMRPassword = "pwd"
+	AMROTP      = "otp"
+	AMRMFA      = "mfa"
+	AMRHardware = "hwk"
+	AMRFed      = "fed"
+)
+
+// Errors
+var (
+	ErrNoSession = errors.New("session: no session")
+	ErrInvalid   = errors.New("session: invalid session")
+	ErrExpired   = errors.New("session: session expired")
+	ErrNoUser    = errors.New("session: sessions have to belong to a user")
+)
+
+// Session is the information carried by a session cookie.
+type Session struct {
+	// Identifier of the authenticated resource owner.
+	UserID string `json:"sub"`
+	// Resource owner's name.
+	Name string `json:"name,omitempty"`
+	// When the resource owner authenticated.
+	AuthTime time.Time `json:"auth_time"`
+	// How the resource owner authenticated, such as AMRPassword.
+	AMR []string `json:"amr,omitempty"`
+	// Upstream identity provider the resource owner logged in with, if any.
+	IdentityProvider string `json:"idp,omitempty"`
+}
+
+// User returns the resource owner the session belongs to, along with how
+// they authenticated, for the authorization server to record it in grants.
+func (s Session) User() types.User {
+	return types.User{ID: s.UserID, Name: s.Name, AMR: s.AMR, IdentityProvider: s.IdentityProvider}
+}
+
+// Manager starts, reads and ends sessions.
+type Manager struct {
+	// Keys encrypting sessions.
+	Keyring envelope.Keyring
+	// Name of the session cookie. Defaults to DefaultCookieName.
+	CookieName string
+	// Domain and path of the session cookie. The path defaults to "/".
+	Domain string
+	Path   string
+	// How long sessions last after authenticating. Defaults to DefaultMaxAge.
+	MaxAge time.Duration
+	// Whether to send the session cookie over plain HTTP too, for
+	// development only.
+	Insecure bool
+	// Returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Start starts a session for the resource owner who just authenticated
+// with the given methods, replacing any previous session. The methods
+// default to the user's, along with their identity provider.
+func (m Manager) Start(w http.ResponseWriter, user types.User, amr ...string) (Session, error) {
+	if user.ID == "" {
+		return Session{}, ErrNoUser
+	}
+
+	if len(amr) == 0 {
+		amr = user.AMR
+	}
+
+	s := Session{
+		UserID:           user.ID,
+		Name:             user.Name,
+		AuthTime:         m.now().UTC(),
+		AMR:              amr,
+		IdentityProvider: user.IdentityProvider,
+	}
+
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return Session{}, err
+	}
+
+	value, err := envelope.Seal(m.Keyring, payload, []byte(m.cookieName()))
+	if err != nil {
+		return Session{}, err
+	}
+
+	http.SetCookie(w, m.cookie(value, int(m.maxAge().Seconds())))
+	return s, nil
+}
+
+// Get returns the session of the resource owner sending the request.
+func (m Manager) Get(req *http.Request) (Session, error) {
+	var s Session
+	cookie, err := req.Cookie(m.cookieName())
+	if err != nil || cookie.Value == "" {
+		return s, ErrNoSession
+	}
+
+	payload, err := envelope.Open(m.Keyring, cookie.Value, []byte(m.cookieName()))
+	if err != nil {
+		return s, ErrInvalid
+	}
+
+	if err := json.Unmarshal(payload, &s); err != nil || s.UserID == "" {
+		return Session{}, ErrInvalid
+	}
+
+	if m.now().After(s.AuthTime.Add(m.maxAge())) {
+		return s, ErrExpired
+	}
+	return s, nil
+}
+
+// CurrentUser returns the resource owner authenticated in the request,
+// as expected from providers. Any error means there is no valid session.
+func (m Manager) CurrentUser(req *http.Request) (types.User, error) {
+	s, err := m.Get(req)
+	if err != nil {
+		return types.User{}, err
+	}
+	return s.User(), nil
+}
+
+// End ends the session of the resource owner, logging them out.
+func (m Manager) End(w http.ResponseWriter) {
+	http.SetCookie(w, m.cookie("", -1))
+}
+
+// ReturnURL returns where the login page has to send the resource owner
+// back to once authenticated, as given in the redirect parameter of
+// oauth2.SetLoginURL. It is "/" unless it is a local path, so the login
+// page can not be used as an open redirector.
+func ReturnURL(req *http.Request, redirectParam string) string {
+	u := req.FormValue(redirectParam)
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") ||
+		strings.ContainsAny(u, "\r\n") {
+		return "/"
+	}
+
+	if _, err := url.ParseRequestURI(u); err != nil {
+		return "/"
+	}
+	return u
+}
+
+func (m Manager) cookie(value string, maxAge int) *http.Cookie {
+	path := m.Path
+	if path == "" {
+		path = "/"
+	}
+
+	// Lax, so resource owners sent to the authorization endpoint by clients
+	// are still logged in.
+	return &http.Cookie{
+		Name:     m.cookieName(),
+		Value:    value,
+		Domain:   m.Domain,
+		Path:     path,
+		MaxAge:   maxAge,
+		Secure:   !m.Insecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (m Manager) cookieName() string {
+	if m.CookieName != "" {
+		return m.CookieName
+	}
+	return DefaultCookieName
+}
+
+func (m Manager) maxAge() time.Duration {
+	if m.MaxAge > 0 {
+		return m.MaxAge
+	}
+	return DefaultMaxAge
+}
+
+func (m Manager) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
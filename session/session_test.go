@@ -0,0 +1,154 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/envelope"
+	"github.com/hooklift/oauth2/types"
+)
+
+func testManager(now *time.Time) Manager {
+	return Manager{
+		Keyring: envelope.StaticKeyring{
+			Current: "k1",
+			Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+		},
+		Now: func() time.Time { return *now },
+	}
+}
+
+// request returns a request sending the cookies set on w.
+func request(w *httptest.ResponseRecorder) *http.Request {
+	req := httptest.NewRequest("GET", "https://example.com/oauth2/authzs", nil)
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+func TestStartGet(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := testManager(&now)
+
+	w := httptest.NewRecorder()
+	if _, err := m.Start(w, types.User{ID: "jdoe", Name: "John Doe"}, AMRPassword, AMROTP); err != nil {
+		t.Fatal(err)
+	}
+
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != DefaultCookieName || !cookie.Secure || !cookie.HttpOnly || cookie.Path != "/" {
+		t.Errorf("unexpected cookie attributes: %+v", cookie)
+	}
+
+	if strings.Contains(cookie.Value, "jdoe") {
+		t.Errorf("session leaks the user: %s", cookie.Value)
+	}
+
+	s, err := m.Get(request(w))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.UserID != "jdoe" || s.Name != "John Doe" || !s.AuthTime.Equal(now) {
+		t.Errorf("unexpected session: %+v", s)
+	}
+
+	if len(s.AMR) != 2 || s.AMR[0] != AMRPassword || s.AMR[1] != AMROTP {
+		t.Errorf("unexpected authentication methods: %v", s.AMR)
+	}
+
+	user, err := m.CurrentUser(request(w))
+	if err != nil || user.ID != "jdoe" || len(user.AMR) != 2 {
+		t.Errorf("unexpected user %+v: %v", user, err)
+	}
+
+	// Resource owners logging in through an upstream identity provider.
+	w = httptest.NewRecorder()
+	if _, err := m.Start(w, types.User{ID: "jdoe", AMR: []string{AMRFed}, IdentityProvider: "https://idp.acme.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	user, err = m.CurrentUser(request(w))
+	if err != nil || user.IdentityProvider != "https://idp.acme.com" || len(user.AMR) != 1 || user.AMR[0] != AMRFed {
+		t.Errorf("unexpected user %+v: %v", user, err)
+	}
+
+	now = now.Add(DefaultMaxAge + time.Second)
+	if _, err := m.Get(request(w)); err != ErrExpired {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
+
+func TestGetInvalid(t *testing.T) {
+	now := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := testManager(&now)
+
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	if _, err := m.Get(req); err != ErrNoSession {
+		t.Errorf("expected ErrNoSession, got %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	if _, err := m.Start(w, types.User{ID: "jdoe"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions can not be moved to other cookies.
+	other := m
+	other.CookieName = "other"
+	req = httptest.NewRequest("GET", "https://example.com/", nil)
+	req.AddCookie(&http.Cookie{Name: "other", Value: w.Result().Cookies()[0].Value})
+	if _, err := other.Get(req); err != ErrInvalid {
+		t.Errorf("expected ErrInvalid, got %v", err)
+	}
+
+	if _, err := m.Start(w, types.User{}); err != ErrNoUser {
+		t.Errorf("expected ErrNoUser, got %v", err)
+	}
+}
+
+func TestEnd(t *testing.T) {
+	now := time.Now()
+	m := testManager(&now)
+
+	w := httptest.NewRecorder()
+	m.End(w)
+	cookie := w.Result().Cookies()[0]
+	if cookie.Name != DefaultCookieName || cookie.MaxAge >= 0 || cookie.Value != "" {
+		t.Errorf("expected the session cookie to be deleted: %+v", cookie)
+	}
+}
+
+func TestReturnURL(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"/oauth2/authzs?client_id=test", "/oauth2/authzs?client_id=test"},
+		{"", "/"},
+		{"https://evil.example.com/", "/"},
+		{"//evil.example.com/", "/"},
+		{"/\\evil.example.com/", "/"},
+		{"/a\r\nSet-Cookie: x=y", "/"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "https://example.com/login", nil)
+		q := req.URL.Query()
+		q.Set("redirect_to", tt.value)
+		req.URL.RawQuery = q.Encode()
+
+		if got := ReturnURL(req, "redirect_to"); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.value, tt.expected, got)
+		}
+	}
+}
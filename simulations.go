@@ -0,0 +1,160 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// simulationRequest is the hypothetical token request to evaluate.
+type simulationRequest struct {
+	ClientID  string `json:"client_id"`
+	UserID    string `json:"user_id"`
+	Scope     string `json:"scope"`
+	GrantType string `json:"grant_type"`
+}
+
+// Grant types that can be simulated, and whether their tokens may come along
+// with a refresh token.
+var simulatedGrantTypes = map[string]bool{
+	"authorization_code": true,
+	"implicit":           false,
+	"password":           true,
+	"client_credentials": false,
+	"refresh_token":      true,
+}
+
+// simulate evaluates whether a hypothetical token request would succeed and
+// returns the checks evaluated. See AdminHandler.
+func simulate(w http.ResponseWriter, req *http.Request, cfg config, _ string) {
+	var r simulationRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil || r.ClientID == "" || r.GrantType == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrSimulationMalformed),
+		})
+		return
+	}
+
+	sim, err := simulation(req, cfg, r)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   sim,
+	})
+}
+
+// simulation runs the checks token requests go through, in the same order,
+// stopping at the first one failing. Errors are only returned when the
+// provider fails.
+func simulation(req *http.Request, cfg config, r simulationRequest) (types.Simulation, error) {
+	sim := types.Simulation{Trace: []types.SimulationStep{}}
+	pass := func(check, detail string) {
+		sim.Trace = append(sim.Trace, types.SimulationStep{Check: check, Passed: true, Detail: detail})
+	}
+	fail := func(check, detail string, e types.AuthzError) (types.Simulation, error) {
+		sim.Trace = append(sim.Trace, types.SimulationStep{Check: check, Detail: detail})
+		sim.Error = &e
+		return sim, nil
+	}
+
+	refreshable, ok := simulatedGrantTypes[r.GrantType]
+	if !ok {
+		return fail("grant_type", r.GrantType+" can not be simulated", localize(req, cfg, ErrUnsupportedGrantType))
+	}
+	if !grantTypeAllowed(cfg, r.GrantType) {
+		return fail("grant_type", r.GrantType+" is disabled", localize(req, cfg, ErrUnsupportedGrantType))
+	}
+	if retiredAt(cfg, r.GrantType, now(cfg)) {
+		return fail("grant_type", r.GrantType+" is past its sunset", localize(req, cfg, ErrUnsupportedGrantType))
+	}
+	pass("grant_type", r.GrantType)
+
+	client, err := cfg.provider.ClientInfo(r.ClientID)
+	if err != nil {
+		return sim, err
+	}
+
+	// Unknown clients are reported by the client check.
+	if r.GrantType == "password" && client.ID != "" {
+		switch {
+		case cfg.passwordGrantPolicy == PasswordGrantDisabled:
+			return fail("password_policy", "the password grant is disabled", localize(req, cfg, ErrUnsupportedGrantType))
+		case !passwordGrantAllowed(cfg, client):
+			return fail("password_policy", client.ID+" is not a first-party client", localize(req, cfg, ErrPasswordGrantNotAllowed))
+		}
+		pass("password_policy", "allowed")
+	}
+
+	if client.ID == "" {
+		return fail("client", r.ClientID+" does not exist", localize(req, cfg, ErrClientIDNotFound))
+	}
+	pass("client", client.ID)
+
+	if e, inactive := inactiveClient(req, cfg, client); inactive {
+		return fail("client_status", string(clientStatus(client)), e)
+	}
+	pass("client_status", string(clientStatus(client)))
+
+	scope := requestedScope(cfg, client, r.Scope)
+	if r.GrantType == "client_credentials" && r.Scope == "" {
+		scope = client.ClientCredentialsScope
+	}
+
+	var scopes types.Scopes
+	if scope != "" {
+		if scopes, err = cfg.provider.ScopesInfo(scope); err != nil {
+			return sim, err
+		}
+	}
+	if len(scopes) == 0 && r.GrantType != "client_credentials" {
+		return fail("scope", "no scope requested nor default scope set", localize(req, cfg, ErrScopeRequired("")))
+	}
+	if r.GrantType == "client_credentials" && !clientCredentialsScopeAllowed(client, scopes) {
+		return fail("scope", "exceeds "+client.ClientCredentialsScope, localize(req, cfg, ErrClientCredentialsScope))
+	}
+	sim.Scope = scopes.Encode()
+	pass("scope", sim.Scope)
+
+	if cfg.policy == nil {
+		pass("policy", "no policy set")
+	} else {
+		if e, ok := denied(req, cfg, PolicyInput{
+			GrantType: r.GrantType,
+			Client:    client,
+			User:      types.User{ID: r.UserID},
+			Scopes:    scopes,
+		}); ok {
+			return fail("policy", e.Description, e)
+		}
+		pass("policy", "allowed")
+	}
+
+	expiration, allowed := tokenPolicy(cfg, scopes)
+	if expiration <= 0 {
+		return fail("token_policy", "no token expiration set", errServerError("", types.ErrExpirationRequired))
+	}
+	if r.GrantType == "refresh_token" && !allowed {
+		return fail("token_policy", "a scope policy forbids refreshing", localize(req, cfg, ErrRefreshNotAllowed))
+	}
+
+	sim.Allowed = true
+	sim.ExpiresIn = int64(expiration.Seconds())
+	sim.RefreshToken = refreshable && allowed
+	pass("token_policy", fmt.Sprintf("expires in %s, refresh token: %t", expiration, sim.RefreshToken))
+	return sim, nil
+}
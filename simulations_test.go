@@ -0,0 +1,134 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestSimulate tests that simulations report whether token requests would
+// succeed, along with the check failing, without issuing anything.
+func TestSimulate(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetTokenExpiration(time.Hour)(&cfg)
+	SetScopePolicy("admin", time.Minute, false)(&cfg)
+	SetPolicy(policyFunc(func(input PolicyInput) error {
+		if input.Scopes.Contains("delete") {
+			e := ErrInvalidScope
+			return &e
+		}
+		return nil
+	}))(&cfg)
+
+	tests := []struct {
+		body         string
+		status       int
+		allowed      bool
+		failed       string
+		code         string
+		expiresIn    int64
+		refreshToken bool
+	}{
+		{`{"client_id": "c", "user_id": "u", "scope": "read", "grant_type": "authorization_code"}`, http.StatusOK, true, "", "", 3600, true},
+		{`{"client_id": "c", "scope": "admin", "grant_type": "password"}`, http.StatusOK, true, "", "", 60, false},
+		{`{"client_id": "c", "scope": "read", "grant_type": "implicit"}`, http.StatusOK, true, "", "", 3600, false},
+		{`{"client_id": "c", "scope": "admin", "grant_type": "refresh_token"}`, http.StatusOK, false, "token_policy", "invalid_grant", 0, false},
+		{`{"client_id": "c", "scope": "read delete", "grant_type": "password"}`, http.StatusOK, false, "policy", "invalid_scope", 0, false},
+		{`{"client_id": "c", "grant_type": "password"}`, http.StatusOK, false, "scope", "invalid_request", 0, false},
+		{`{"client_id": "c", "scope": "read", "grant_type": "urn:custom"}`, http.StatusOK, false, "grant_type", "unsupported_grant_type", 0, false},
+		{`{"scope": "read", "grant_type": "password"}`, http.StatusBadRequest, false, "", "invalid_request", 0, false},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "https://example.com/admin/simulate", strings.NewReader(tt.body))
+		ok(t, err)
+		w := httptest.NewRecorder()
+		simulate(w, req, cfg, "")
+		equals(t, tt.status, w.Code)
+
+		if tt.status != http.StatusOK {
+			var e types.AuthzError
+			ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+			equals(t, tt.code, e.Code)
+			continue
+		}
+
+		var sim types.Simulation
+		ok(t, json.Unmarshal(w.Body.Bytes(), &sim))
+		equals(t, tt.allowed, sim.Allowed)
+		equals(t, tt.expiresIn, sim.ExpiresIn)
+		equals(t, tt.refreshToken, sim.RefreshToken)
+
+		last := sim.Trace[len(sim.Trace)-1]
+		if tt.allowed {
+			assert(t, sim.Error == nil, "expected no error, got %v", sim.Error)
+			equals(t, "token_policy", last.Check)
+			assert(t, last.Passed, "expected the last check to pass")
+			continue
+		}
+		equals(t, tt.failed, last.Check)
+		assert(t, !last.Passed, "expected the last check to fail")
+		assert(t, sim.Error != nil, "expected an error")
+		equals(t, tt.code, sim.Error.Code)
+	}
+
+	equals(t, 0, len(provider.AccessTokens))
+
+	provider.Client.Status = types.ClientSuspended
+	req, err := http.NewRequest("POST", "https://example.com/admin/simulate", strings.NewReader(`{"client_id": "c", "scope": "read", "grant_type": "password"}`))
+	ok(t, err)
+	w := httptest.NewRecorder()
+	simulate(w, req, cfg, "")
+	var sim types.Simulation
+	ok(t, json.Unmarshal(w.Body.Bytes(), &sim))
+	equals(t, false, sim.Allowed)
+	equals(t, "client_status", sim.Trace[len(sim.Trace)-1].Check)
+}
+
+// TestSimulatePasswordGrantPolicy tests that simulations of the password
+// grant follow the password grant policy.
+func TestSimulatePasswordGrantPolicy(t *testing.T) {
+	tests := []struct {
+		policy     PasswordGrantPolicy
+		firstParty bool
+		allowed    bool
+		code       string
+	}{
+		{PasswordGrantAllowed, false, true, ""},
+		{PasswordGrantFirstParty, true, true, ""},
+		{PasswordGrantFirstParty, false, false, types.ErrorUnauthorizedClient},
+		{PasswordGrantDisabled, true, false, types.ErrorUnsupportedGrantType},
+	}
+
+	for _, tt := range tests {
+		cfg := setupTest()
+		provider := test.NewProvider(true)
+		provider.Client.FirstParty = tt.firstParty
+		cfg.provider = provider
+		SetTokenExpiration(time.Hour)(&cfg)
+		SetPasswordGrantPolicy(tt.policy)(&cfg)
+
+		sim, err := simulation(&http.Request{}, cfg, simulationRequest{ClientID: "c", Scope: "read", GrantType: "password"})
+		ok(t, err)
+		equals(t, tt.allowed, sim.Allowed)
+
+		passwordPolicy := sim.Trace[1]
+		equals(t, "password_policy", passwordPolicy.Check)
+		equals(t, tt.allowed, passwordPolicy.Passed)
+		if !tt.allowed {
+			equals(t, tt.code, sim.Error.Code)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+)
+
+// StatePolicy tells how authorization requests without a state parameter are
+// handled. RFC 6749 only recommends clients to send it, in order to prevent
+// cross-site request forgery: http://tools.ietf.org/html/rfc6749#section-10.12
+type StatePolicy int
+
+const (
+	// StateRequired rejects authorization requests without state. It is the
+	// default.
+	StateRequired StatePolicy = iota
+	// StateRecommended accepts authorization requests without state, logging
+	// a warning about the client sending them.
+	StateRecommended
+	// StateOptionalWithPKCE accepts authorization code requests without state
+	// as long as they carry a PKCE code challenge, which also prevents
+	// cross-site request forgery. The code challenge is bound to the
+	// authorization code, which requires the provider to implement
+	// BoundGrantProvider.
+	StateOptionalWithPKCE
+)
+
+// SetStatePolicy sets how authorization requests without a state parameter
+// are handled. Defaults to StateRequired.
+func SetStatePolicy(p StatePolicy) option {
+	return func(c *config) {
+		c.statePolicy = p
+	}
+}
+
+// stateAccepted tells whether the state of an authorization request, or its
+// absence, is acceptable according to the state policy.
+func stateAccepted(req *http.Request, cfg config, areq AuthorizationRequest) bool {
+	if areq.State != "" {
+		return true
+	}
+
+	switch cfg.statePolicy {
+	case StateRecommended:
+		log.Printf("[WARN] request_id=%s Client %s sent an authorization request without state", RequestID(req), areq.ClientID)
+		return true
+	case StateOptionalWithPKCE:
+		return areq.ResponseType == "code" && areq.CodeChallenge != ""
+	default:
+		return false
+	}
+}
@@ -0,0 +1,147 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/clientstate"
+	"github.com/hooklift/oauth2/envelope"
+	"github.com/hooklift/oauth2/providers/test"
+)
+
+// TestStatePolicy tests that authorization requests without state are handled
+// according to the state policy.
+func TestStatePolicy(t *testing.T) {
+	tests := []struct {
+		policy       StatePolicy
+		responseType string
+		challenge    string
+		accepted     bool
+	}{
+		{StateRequired, "code", "", false},
+		{StateRequired, "code", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", false},
+		{StateRecommended, "code", "", true},
+		{StateRecommended, "token", "", true},
+		{StateOptionalWithPKCE, "code", "", false},
+		{StateOptionalWithPKCE, "code", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", true},
+		{StateOptionalWithPKCE, "token", "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", false},
+	}
+
+	for _, tt := range tests {
+		cfg := setupTest()
+		provider := test.NewProvider(true)
+		cfg.provider = provider
+		SetStatePolicy(tt.policy)(&cfg)
+
+		values := url.Values{
+			"client_id":             {provider.Client.ID},
+			"response_type":         {tt.responseType},
+			"redirect_uri":          {provider.Client.RedirectURL.String()},
+			"scope":                 {"read"},
+			"code_challenge":        {tt.challenge},
+			"code_challenge_method": {CodeChallengeS256},
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		if tt.accepted {
+			equals(t, http.StatusOK, w.Code)
+			continue
+		}
+
+		equals(t, http.StatusFound, w.Code)
+		assert(t, strings.Contains(w.Header().Get("Location"), "error="), "expected error redirect for policy %d: %s", tt.policy, w.Header().Get("Location"))
+	}
+}
+
+// TestStateOptionalWithPKCE tests that codes issued without state are bound to
+// the PKCE code challenge and sent back without state.
+func TestStateOptionalWithPKCE(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetStatePolicy(StateOptionalWithPKCE)(&cfg)
+
+	values := url.Values{
+		"client_id":             {provider.Client.ID},
+		"response_type":         {"code"},
+		"redirect_uri":          {provider.Client.RedirectURL.String()},
+		"scope":                 {"read"},
+		"code_challenge":        {"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"},
+		"code_challenge_method": {CodeChallengeS256},
+		ConsentParam:            {"approve"},
+	}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+	_, hasState := u.Query()["state"]
+	assert(t, !hasState, "state was not expected: %s", u)
+
+	grant, ok2 := provider.Grants[u.Query().Get("code")]
+	assert(t, ok2, "grant not found for code %q", u.Query().Get("code"))
+	equals(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM", grant.CodeChallenge)
+	equals(t, CodeChallengeS256, grant.CodeChallengeMethod)
+}
+
+// TestClientState tests that states minted by client apps with the clientstate
+// package are sent back unmodified and can be verified by them.
+func TestClientState(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	codec := clientstate.Codec{
+		Keyring: envelope.StaticKeyring{
+			Current: "k1",
+			Keys:    map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+		},
+	}
+
+	state, err := codec.Encode("client-session", "/settings")
+	ok(t, err)
+
+	values := url.Values{
+		"client_id":     {provider.Client.ID},
+		"response_type": {"code"},
+		"redirect_uri":  {provider.Client.RedirectURL.String()},
+		"scope":         {"read"},
+		"state":         {state},
+		ConsentParam:    {"approve"},
+	}
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.Header.Set("Origin", "https://example.com")
+
+	w := httptest.NewRecorder()
+	CreateGrant(w, req, cfg)
+	equals(t, http.StatusFound, w.Code)
+
+	u, err := url.Parse(w.Header().Get("Location"))
+	ok(t, err)
+
+	s, err := codec.Decode("client-session", u.Query().Get("state"))
+	ok(t, err)
+	equals(t, "/settings", s.ReturnURL)
+}
@@ -0,0 +1,86 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// StatsProvider is an optional interface that providers can implement in
+// order to publish statistics through the admin API. Figures are expected to
+// be approximate, so providers can compute them cheaply, for instance out of
+// counters or table estimates, rather than scanning every grant and token.
+type StatsProvider interface {
+	// Stats returns statistics about grants issued since the given time and
+	// about the tokens currently active.
+	Stats(since time.Time) (types.Stats, error)
+}
+
+// ErrStatsProviderRequired is returned when requesting statistics with a
+// provider that does not implement StatsProvider.
+var ErrStatsProviderRequired = errors.New("oauth2: provider does not implement oauth2.StatsProvider")
+
+// Defaults of the stats admin API.
+const (
+	statsDays      = 30
+	statsMaxDays   = 366
+	statsTopScopes = 10
+)
+
+// getStats returns statistics through the admin API, with scopes sorted by
+// count and limited to the top ones.
+func getStats(w http.ResponseWriter, req *http.Request, cfg config, _ string) {
+	provider, ok := asStatsProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrStatsProviderRequired),
+		})
+		return
+	}
+
+	days := statsDays
+	if v := req.FormValue("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > statsMaxDays {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrStatsDaysInvalid),
+			})
+			return
+		}
+		days = n
+	}
+
+	// Days are counted in UTC, including today.
+	today := now(cfg).UTC().Truncate(24 * time.Hour)
+	stats, err := provider.Stats(today.AddDate(0, 0, 1-days))
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	sort.SliceStable(stats.TopScopes, func(i, j int) bool {
+		return stats.TopScopes[i].Count > stats.TopScopes[j].Count
+	})
+	if len(stats.TopScopes) > statsTopScopes {
+		stats.TopScopes = stats.TopScopes[:statsTopScopes]
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   stats,
+	})
+}
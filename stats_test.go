@@ -0,0 +1,61 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestStats tests the stats admin API.
+func TestStats(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+
+	read := types.Scope{ID: "read"}
+	write := types.Scope{ID: "write"}
+	_, err := provider.GenGrant(provider.Client, types.Scopes{read}, 10*time.Minute)
+	ok(t, err)
+	_, err = provider.GenGrant(provider.Client, types.Scopes{read, write}, 10*time.Minute)
+	ok(t, err)
+	_, err = provider.GenToken(types.Grant{Scopes: types.Scopes{read}}, provider.Client, false, 10*time.Minute)
+	ok(t, err)
+
+	admin := AdminHandler(provider, SetClock(clock))
+	adminRequest := func(method, path string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "https://example.com"+path, nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		admin.ServeHTTP(w, req)
+		return w
+	}
+
+	w := adminRequest("GET", "/stats?days=2")
+	equals(t, http.StatusOK, w.Code)
+
+	var stats types.Stats
+	ok(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	equals(t, map[string]int64{provider.Client.ID: 1}, stats.ActiveTokensByClient)
+	equals(t, []types.DailyCount{
+		{Day: "2015-05-31", Count: 0},
+		{Day: "2015-06-01", Count: 2},
+	}, stats.GrantsPerDay)
+	equals(t, []types.ScopeCount{
+		{Scope: "read", Count: 2},
+		{Scope: "write", Count: 1},
+	}, stats.TopScopes)
+
+	equals(t, http.StatusBadRequest, adminRequest("GET", "/stats?days=0").Code)
+	equals(t, http.StatusMethodNotAllowed, adminRequest("DELETE", "/stats").Code)
+	equals(t, http.StatusNotFound, adminRequest("GET", "/clients").Code)
+}
@@ -0,0 +1,79 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package submission
+
+import "time"
+
+// RedisConn is a connection to a Redis server. It is satisfied by
+// github.com/garyburd/redigo/redis.Conn.
+type RedisConn interface {
+	Do(commandName string, args ...interface{}) (reply interface{}, err error)
+	Close() error
+}
+
+// RedisCache is a Cache shared by all instances of the oauth2 handler.
+type RedisCache struct {
+	// Get returns a connection from a pool. For instance, when using redigo:
+	//	func() submission.RedisConn { return pool.Get() }
+	Get func() RedisConn
+	// Prefix added to all keys. Defaults to "oauth2:submission:".
+	Prefix string
+}
+
+// Start implements Cache.
+func (c *RedisCache) Start(key string, now, expiresAt time.Time) (bool, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	// SET NX only succeeds if the key does not exist, replying nil otherwise.
+	reply, err := conn.Do("SET", c.key(key), "", "PX", ttl(now, expiresAt), "NX")
+	if err != nil {
+		return false, err
+	}
+	return reply == nil, nil
+}
+
+// Finish implements Cache.
+func (c *RedisCache) Finish(key string, response []byte, now, expiresAt time.Time) error {
+	conn := c.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("SET", c.key(key), response, "PX", ttl(now, expiresAt))
+	return err
+}
+
+// Response implements Cache.
+func (c *RedisCache) Response(key string, now time.Time) ([]byte, error) {
+	conn := c.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("GET", c.key(key))
+	if err != nil {
+		return nil, err
+	}
+
+	response, _ := reply.([]byte)
+	if len(response) == 0 {
+		return nil, nil
+	}
+	return response, nil
+}
+
+func (c *RedisCache) key(key string) string {
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = "oauth2:submission:"
+	}
+	return prefix + key
+}
+
+// ttl returns the milliseconds left from now until the given time, at least one.
+func ttl(now, expiresAt time.Time) int64 {
+	ms := int64(expiresAt.Sub(now) / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	return ms
+}
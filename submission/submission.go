@@ -0,0 +1,98 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package submission defines the storage of authorization form submissions
+// used by the oauth2 package to answer forms submitted more than once, after
+// a double click or a browser retry, with the response to the first
+// submission. Sharing a Cache between instances of the oauth2 handler is
+// required to detect submissions sent to different instances.
+package submission
+
+import (
+	"sync"
+	"time"
+)
+
+// Cache remembers submissions, and their responses, until they expire.
+// Times are relative to now, the current time as told by the clock of the
+// oauth2 handler, so expirations follow oauth2.SetClock.
+type Cache interface {
+	// Start records that the submission with the given key is being
+	// processed, until the given expiration time, and tells whether it was
+	// already recorded and did not expire yet. It has to be atomic, so only
+	// one of several concurrent calls for the same key returns false.
+	Start(key string, now, expiresAt time.Time) (bool, error)
+
+	// Finish records the response to a submission being processed.
+	Finish(key string, response []byte, now, expiresAt time.Time) error
+
+	// Response returns the response to a submission, or nil if it is still
+	// being processed, was never recorded or expired.
+	Response(key string, now time.Time) ([]byte, error)
+}
+
+// entry is a submission remembered by MemoryCache.
+type entry struct {
+	response  []byte
+	expiresAt time.Time
+}
+
+// MemoryCache is a Cache for single instance deployments.
+type MemoryCache struct {
+	mu        sync.Mutex
+	entries   map[string]entry
+	lastSweep time.Time
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries: make(map[string]entry),
+	}
+}
+
+// Start implements Cache.
+func (c *MemoryCache) Start(key string, now, expiresAt time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok && now.Before(e.expiresAt) {
+		return true, nil
+	}
+
+	// Takes the chance to remove expired submissions, once in a while, so
+	// memory does not grow unbounded.
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, e := range c.entries {
+			if !now.Before(e.expiresAt) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastSweep = now
+	}
+
+	c.entries[key] = entry{expiresAt: expiresAt}
+	return false, nil
+}
+
+// Finish implements Cache.
+func (c *MemoryCache) Finish(key string, response []byte, now, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = entry{response: response, expiresAt: expiresAt}
+	return nil
+}
+
+// Response implements Cache.
+func (c *MemoryCache) Response(key string, now time.Time) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		return nil, nil
+	}
+	return e.response, nil
+}
@@ -0,0 +1,132 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package submission
+
+import (
+	"testing"
+	"time"
+)
+
+func testCache(t *testing.T, c Cache) {
+	now := time.Now()
+	exp := now.Add(time.Duration(1) * time.Minute)
+	for i, expected := range []bool{false, true} {
+		started, err := c.Start("form", now, exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if started != expected {
+			t.Errorf("call %d: expected started to be %t", i, expected)
+		}
+	}
+
+	response, err := c.Response("form", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response != nil {
+		t.Errorf("expected no response while processing, got %q", response)
+	}
+
+	if err := c.Finish("form", []byte("code"), now, exp); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err = c.Response("form", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(response) != "code" {
+		t.Errorf("expected response %q, got %q", "code", response)
+	}
+
+	started, err := c.Start("form", now, exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !started {
+		t.Error("expected finished submission to be started")
+	}
+
+	started, err = c.Start("other", now, exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started {
+		t.Error("expected other submission not to be started")
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, NewMemoryCache())
+}
+
+func TestMemoryCacheExpiration(t *testing.T) {
+	c := NewMemoryCache()
+	now := time.Now()
+	c.Start("form", now, now.Add(time.Duration(10)*time.Millisecond))
+	c.Finish("form", []byte("code"), now, now.Add(time.Duration(10)*time.Millisecond))
+
+	later := now.Add(time.Duration(10) * time.Millisecond)
+	if response, _ := c.Response("form", later); response != nil {
+		t.Errorf("expected response to expire, got %q", response)
+	}
+	started, _ := c.Start("form", later, later.Add(time.Duration(1)*time.Minute))
+	if started {
+		t.Error("expected submission to expire")
+	}
+}
+
+// fakeRedis emulates the subset of Redis commands used by RedisCache.
+type fakeRedis struct {
+	data    map[string][]byte
+	expires map[string]int64
+}
+
+func (f *fakeRedis) Do(cmd string, args ...interface{}) (interface{}, error) {
+	key := args[0].(string)
+	switch cmd {
+	case "GET":
+		v, ok := f.data[key]
+		if !ok {
+			return nil, nil
+		}
+		return v, nil
+	case "SET":
+		if _, ok := f.data[key]; ok && len(args) > 4 && args[4] == "NX" {
+			return nil, nil
+		}
+
+		switch v := args[1].(type) {
+		case string:
+			f.data[key] = []byte(v)
+		case []byte:
+			f.data[key] = v
+		}
+		f.expires[key] = args[3].(int64)
+		return "OK", nil
+	}
+	return nil, nil
+}
+
+func (f *fakeRedis) Close() error {
+	return nil
+}
+
+func TestRedisCache(t *testing.T) {
+	conn := &fakeRedis{
+		data:    make(map[string][]byte),
+		expires: make(map[string]int64),
+	}
+
+	c := &RedisCache{
+		Get: func() RedisConn { return conn },
+	}
+	testCache(t, c)
+
+	if ms := conn.expires["oauth2:submission:form"]; ms <= 59000 || ms > 60000 {
+		t.Errorf("expected expiration of about 60000ms, got %d", ms)
+	}
+}
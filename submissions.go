@@ -0,0 +1,125 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/hooklift/oauth2/envelope"
+	"github.com/hooklift/oauth2/submission"
+	"github.com/hooklift/oauth2/types"
+)
+
+// How long a repeated submission waits for the first one to be processed,
+// and how often it checks.
+const (
+	submissionWait         = time.Duration(2) * time.Second
+	submissionPollInterval = time.Duration(50) * time.Millisecond
+)
+
+// FormNonceParam is the form field carrying the nonce of the displayed
+// authorization form, see AuthzData.Nonce.
+const FormNonceParam = "form_nonce"
+
+// SetSubmissionCache makes approvals of the authorization form idempotent.
+// A form submitted more than once, after a double click or a browser retry,
+// redirects back to the client with the code issued to the first submission,
+// instead of issuing another one. Only submissions of the same displayed
+// form are deduplicated: each display carries a nonce, see AuthzData.Nonce,
+// so approving the same authorization request again issues a fresh code.
+// Forms sent without the nonce are never deduplicated. Implicit grants are
+// not affected.
+//
+// The authorization form has to send back the nonce in a field named after
+// FormNonceParam:
+//
+//	{{with .Nonce}}<input type="hidden" name="form_nonce" value="{{.}}"/>{{end}}
+//
+// Codes are cached encrypted with a key derived from the submission, so the
+// cache alone does not disclose them. Use submission.RedisCache to detect
+// submissions sent to different instances of the handler.
+func SetSubmissionCache(c submission.Cache) option {
+	return func(cfg *config) {
+		cfg.submissionCache = c
+	}
+}
+
+// formSubmission identifies an approval of the authorization form.
+type formSubmission struct {
+	// Key the submission is cached under.
+	id string
+	// Key its response is encrypted with.
+	keyring envelope.Keyring
+}
+
+// newFormSubmission derives the identifier and encryption key of an
+// approval of the given authorization request by the resource owner, from
+// the form displayed with the given nonce.
+func newFormSubmission(req *http.Request, cfg config, user types.User, nonce string, params map[string]string) formSubmission {
+	material := user.ID + "\x00" + nonce + "\x00"
+	if cfg.authzRequestKey != nil {
+		material += req.FormValue(AuthzRequestParam)
+	} else {
+		names := make([]string, 0, len(params))
+		for name := range params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		values := url.Values{}
+		for _, name := range names {
+			values.Set(name, params[name])
+		}
+		material += values.Encode()
+	}
+
+	id := sha256.Sum256([]byte("id\x00" + material))
+	key := sha256.Sum256([]byte("key\x00" + material))
+	return formSubmission{
+		id:      base64.RawURLEncoding.EncodeToString(id[:]),
+		keyring: envelope.StaticKeyring{Current: "s", Keys: map[string][]byte{"s": key[:]}},
+	}
+}
+
+// start records the submission and returns the code issued to a previous
+// one, if any, waiting for it if still being processed. An empty code means
+// the submission has to be processed, either because it is the first one or
+// because the previous one did not complete in time.
+func (s formSubmission) start(cfg config) (string, error) {
+	t := now(cfg)
+	started, err := cfg.submissionCache.Start(s.id, t, t.Add(cfg.authzExpiration))
+	if err != nil || !started {
+		return "", err
+	}
+
+	for waited := time.Duration(0); waited < submissionWait; waited += submissionPollInterval {
+		response, err := cfg.submissionCache.Response(s.id, now(cfg))
+		if err != nil {
+			return "", err
+		}
+
+		if response != nil {
+			code, err := envelope.Open(s.keyring, string(response), []byte(s.id))
+			return string(code), err
+		}
+		time.Sleep(submissionPollInterval)
+	}
+	return "", nil
+}
+
+// finish records the code issued to the submission.
+func (s formSubmission) finish(cfg config, code string) error {
+	sealed, err := envelope.Seal(s.keyring, []byte(code), []byte(s.id))
+	if err != nil {
+		return err
+	}
+	t := now(cfg)
+	return cfg.submissionCache.Finish(s.id, []byte(sealed), t, t.Add(cfg.authzExpiration))
+}
@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/submission"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestDoubleSubmission tests that submitting the same authorization form
+// twice redirects with the same code, without issuing a second grant, while
+// approving the same request from another form issues a fresh code.
+func TestDoubleSubmission(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	SetClock(clock)(&cfg)
+	SetSubmissionCache(submission.NewMemoryCache())(&cfg)
+
+	approve := func(state, nonce string) string {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {state},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {"read"},
+			ConsentParam:    {"approve"},
+			FormNonceParam:  {nonce},
+		}
+
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/authzs", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set("Origin", "https://example.com")
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusFound, w.Code)
+
+		u, err := url.Parse(w.Header().Get("Location"))
+		ok(t, err)
+		equals(t, state, u.Query().Get("state"))
+		return u.Query().Get("code")
+	}
+
+	code := approve("state-test", "form-1")
+	assert(t, code != "", "expected an authorization code")
+	equals(t, code, approve("state-test", "form-1"))
+	equals(t, 1, len(provider.Grants))
+
+	// Other authorization requests are not affected.
+	assert(t, approve("other-state", "form-2") != code, "expected a different code for another request")
+	equals(t, 2, len(provider.Grants))
+
+	// Approving the same request from another display of the form, or
+	// without a nonce, issues a fresh code.
+	assert(t, approve("state-test", "form-3") != code, "expected a fresh code for another form")
+	equals(t, 3, len(provider.Grants))
+	assert(t, approve("state-test", "") != approve("state-test", ""), "expected submissions without nonce to issue fresh codes")
+	equals(t, 5, len(provider.Grants))
+
+	// Submissions expire along with the codes, following the handler's clock.
+	clock.Advance(cfg.authzExpiration)
+	assert(t, approve("state-test", "form-1") != code, "expected a fresh code once the submission expired")
+	equals(t, 6, len(provider.Grants))
+}
+
+// TestSubmissionNonce tests that each display of the authorization form
+// carries a different nonce.
+func TestSubmissionNonce(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetSubmissionCache(submission.NewMemoryCache())(&cfg)
+
+	display := func() string {
+		values := url.Values{
+			"client_id":     {provider.Client.ID},
+			"response_type": {"code"},
+			"state":         {"state-test"},
+			"redirect_uri":  {provider.Client.RedirectURL.String()},
+			"scope":         {"read"},
+		}
+
+		req, err := http.NewRequest("GET", "https://example.com/oauth2/authzs?"+values.Encode(), nil)
+		ok(t, err)
+
+		w := httptest.NewRecorder()
+		CreateGrant(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		match := regexp.MustCompile(`name="form_nonce" value="([0-9a-f]+)"`).FindStringSubmatch(w.Body.String())
+		assert(t, match != nil, "expected a form nonce in %s", w.Body.String())
+		return match[1]
+	}
+
+	assert(t, display() != display(), "expected a different nonce for each display")
+}
+
+// TestSubmissionWait tests that a repeated submission waits for the first
+// one to get its code.
+func TestSubmissionWait(t *testing.T) {
+	cfg := setupTest()
+	SetSubmissionCache(submission.NewMemoryCache())(&cfg)
+
+	user := types.User{ID: "user"}
+	params := map[string]string{"client_id": "client", "state": "state"}
+	first := newFormSubmission(nil, cfg, user, "form", params)
+	code, err := first.start(cfg)
+	ok(t, err)
+	equals(t, "", code)
+
+	go func() {
+		time.Sleep(submissionPollInterval * 2)
+		first.finish(cfg, "code")
+	}()
+
+	code, err = newFormSubmission(nil, cfg, user, "form", params).start(cfg)
+	ok(t, err)
+	equals(t, "code", code)
+
+	// Submissions by other resource owners are not mixed up.
+	code, err = newFormSubmission(nil, cfg, types.User{ID: "other"}, "form", params).start(cfg)
+	ok(t, err)
+	equals(t, "", code)
+}
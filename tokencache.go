@@ -0,0 +1,157 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// DefaultTokenCacheSize is the number of tokens a TokenCache holds if none is given.
+const DefaultTokenCacheSize = 10000
+
+// SetTokenCache makes AuthzHandler look up opaque access tokens in the given
+// cache before asking the provider, so resource servers with many requests
+// per second do not overload a remote provider. Revoked tokens keep being
+// accepted until they leave the cache, unless revocations are propagated
+// with SetRevocationBus.
+func SetTokenCache(c *TokenCache) option {
+	return func(cfg *config) {
+		cfg.tokenCache = c
+	}
+}
+
+// TokenCache remembers the tokens AuthzHandler looked up, up to a maximum
+// number, forgetting the least recently used ones first. Tokens the provider
+// does not know about are remembered too, for a separate time, so clients
+// retrying invalid tokens do not reach the provider either. Provider errors
+// are not cached.
+type TokenCache struct {
+	mu         sync.Mutex
+	size       int
+	ttl        time.Duration
+	invalidTTL time.Duration
+	tokens     map[string]*list.Element
+	// Most recently used tokens first.
+	lru   *list.List
+	stats types.TokenCacheStats
+}
+
+// cachedToken is a token remembered by TokenCache.
+type cachedToken struct {
+	// Hash of the token identifier.
+	key       string
+	token     types.Token
+	expiresAt time.Time
+}
+
+// NewTokenCache returns a cache of up to size tokens, remembering valid
+// tokens for ttl and unknown ones for invalidTTL. Unknown tokens are not
+// cached if invalidTTL is zero. Size defaults to DefaultTokenCacheSize.
+func NewTokenCache(size int, ttl, invalidTTL time.Duration) *TokenCache {
+	if size <= 0 {
+		size = DefaultTokenCacheSize
+	}
+
+	return &TokenCache{
+		size:       size,
+		ttl:        ttl,
+		invalidTTL: invalidTTL,
+		tokens:     make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+}
+
+// Stats returns the counters of the cache since it was created.
+func (c *TokenCache) Stats() types.TokenCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+	return stats
+}
+
+// lookup returns the token with the given identifier, from the cache if
+// possible, or from the provider otherwise.
+func (c *TokenCache) lookup(cfg config, provider Provider, id string) (types.Token, error) {
+	key := tokengen.Hash(id)
+	t := now(cfg)
+
+	c.mu.Lock()
+	if e, ok := c.tokens[key]; ok {
+		cached := e.Value.(*cachedToken)
+		if t.Before(cached.expiresAt) {
+			c.lru.MoveToFront(e)
+			if cached.token.Value == "" {
+				c.stats.NegativeHits++
+			} else {
+				c.stats.Hits++
+			}
+			c.mu.Unlock()
+			return cached.token, nil
+		}
+		c.remove(e)
+	}
+	c.stats.Misses++
+	c.mu.Unlock()
+
+	token, err := provider.TokenInfo(id)
+	if err != nil {
+		return token, err
+	}
+
+	ttl := c.ttl
+	if token.Value == "" {
+		ttl = c.invalidTTL
+	}
+	if ttl <= 0 {
+		return token, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached := &cachedToken{key: key, token: token, expiresAt: t.Add(ttl)}
+	if e, ok := c.tokens[key]; ok {
+		e.Value = cached
+		c.lru.MoveToFront(e)
+		return token, nil
+	}
+
+	c.tokens[key] = c.lru.PushFront(cached)
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+	return token, nil
+}
+
+// revoked forgets the token with the given hashed identifier, or every
+// token if empty.
+func (c *TokenCache) revoked(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if id == "" {
+		c.tokens = make(map[string]*list.Element)
+		c.lru.Init()
+		return
+	}
+
+	if e, ok := c.tokens[id]; ok {
+		c.remove(e)
+	}
+}
+
+// remove forgets a cached token. c.mu must be held.
+func (c *TokenCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.tokens, e.Value.(*cachedToken).key)
+}
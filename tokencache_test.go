@@ -0,0 +1,117 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// countingProvider counts token lookups, optionally failing them.
+type countingProvider struct {
+	*test.Provider
+	lookups int
+	err     error
+}
+
+func (p *countingProvider) TokenInfo(token string) (types.Token, error) {
+	p.lookups++
+	if p.err != nil {
+		return types.Token{}, p.err
+	}
+	return p.Provider.TokenInfo(token)
+}
+
+// TestTokenCache tests that valid and unknown tokens are looked up once
+// until they expire from the cache, each with its own TTL.
+func TestTokenCache(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	cfg := config{clock: clock}
+	provider := &countingProvider{Provider: test.NewProvider(true)}
+	provider.AccessTokens["valid"] = types.Token{Value: "valid"}
+
+	cache := NewTokenCache(10, time.Minute, time.Second)
+	lookup := func(id string) string {
+		token, err := cache.lookup(cfg, provider, id)
+		ok(t, err)
+		return token.Value
+	}
+
+	equals(t, "valid", lookup("valid"))
+	equals(t, "", lookup("unknown"))
+	equals(t, 2, provider.lookups)
+
+	equals(t, "valid", lookup("valid"))
+	equals(t, "", lookup("unknown"))
+	equals(t, 2, provider.lookups)
+
+	clock.Advance(time.Second)
+	equals(t, "", lookup("unknown"))
+	equals(t, "valid", lookup("valid"))
+	equals(t, 3, provider.lookups)
+
+	clock.Advance(time.Minute)
+	equals(t, "valid", lookup("valid"))
+	equals(t, 4, provider.lookups)
+
+	equals(t, types.TokenCacheStats{Hits: 2, NegativeHits: 1, Misses: 4, Size: 2}, cache.Stats())
+	equals(t, 3.0/7, cache.Stats().HitRate())
+
+	// Provider errors are not cached.
+	provider.err = errors.New("boom")
+	_, err := cache.lookup(cfg, provider, "other")
+	assert(t, err != nil, "expected provider error")
+	_, err = cache.lookup(cfg, provider, "other")
+	assert(t, err != nil, "expected provider error")
+	equals(t, 6, provider.lookups)
+}
+
+// TestTokenCacheEviction tests that the least recently used tokens are
+// evicted once the cache is full, and that revocations remove tokens.
+func TestTokenCacheEviction(t *testing.T) {
+	cfg := config{}
+	provider := &countingProvider{Provider: test.NewProvider(true)}
+	for _, id := range []string{"a", "b", "c"} {
+		provider.AccessTokens[id] = types.Token{Value: id}
+	}
+
+	cache := NewTokenCache(2, time.Hour, 0)
+	lookup := func(id string) {
+		_, err := cache.lookup(cfg, provider, id)
+		ok(t, err)
+	}
+
+	lookup("a")
+	lookup("b")
+	lookup("a")
+	lookup("c")
+	equals(t, 3, provider.lookups)
+	equals(t, int64(1), cache.Stats().Evictions)
+	equals(t, 2, cache.Stats().Size)
+
+	// b was the least recently used.
+	lookup("a")
+	lookup("c")
+	equals(t, 3, provider.lookups)
+	lookup("b")
+	equals(t, 4, provider.lookups)
+
+	// Unknown tokens are not cached without a TTL for them.
+	lookup("unknown")
+	lookup("unknown")
+	equals(t, 6, provider.lookups)
+
+	cache.revoked(tokengen.Hash("b"))
+	lookup("b")
+	equals(t, 7, provider.lookups)
+
+	cache.revoked("")
+	equals(t, 0, cache.Stats().Size)
+}
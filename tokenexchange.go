@@ -0,0 +1,206 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TokenExchangeGrantType is the grant type used by services holding an
+// access token to exchange it for another one, with a narrower scope or
+// meant for another resource server. http://tools.ietf.org/html/rfc8693#section-2.1
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// AccessTokenType identifies access tokens as subject and issued tokens of
+// token exchanges, the only type supported.
+// http://tools.ietf.org/html/rfc8693#section-3
+const AccessTokenType = "urn:ietf:params:oauth:token-type:access_token"
+
+// TokenExchangeProvider is an optional interface that providers implement in
+// order to support the token exchange grant, deciding which clients can
+// exchange which tokens.
+type TokenExchangeProvider interface {
+	// AllowTokenExchange tells whether the client can exchange the subject
+	// token, issued to it or to another client, for an access token with the
+	// given scopes, within the scope of the subject token, and restricted to
+	// the given audience. An empty audience means the one of the subject
+	// token is kept.
+	AllowTokenExchange(client types.Client, subject types.Token, scopes types.Scopes, audience []string) (bool, error)
+}
+
+// Implements http://tools.ietf.org/html/rfc8693#section-2. Exchanged tokens
+// are issued for the same resource owner as the subject token, never with a
+// refresh token, and do not outlive it. Delegation, with actor tokens, is
+// not supported.
+func tokenExchangeGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
+	provider, ok := asTokenExchangeProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
+		})
+		return
+	}
+
+	if treq.SubjectToken == "" || treq.SubjectTokenType == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrSubjectTokenRequired),
+		})
+		return
+	}
+
+	if treq.SubjectTokenType != AccessTokenType ||
+		(treq.RequestedTokenType != "" && treq.RequestedTokenType != AccessTokenType) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrTokenTypeUnsupported),
+		})
+		return
+	}
+
+	subject, err := subjectToken(cfg, treq.SubjectToken)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if subject.Value == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrSubjectTokenInvalid),
+		})
+		return
+	}
+
+	// Tokens can only be downscoped. The scope of the subject token is kept
+	// if none is requested.
+	scopes := subject.Scopes
+	if treq.Scope != "" {
+		scopes, err = cfg.provider.ScopesInfo(treq.Scope)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+
+		for _, s := range scopes {
+			if !subject.Scopes.Contains(s.ID) {
+				render.JSON(w, render.Options{
+					Status: http.StatusBadRequest,
+					Data:   localize(req, cfg, ErrInvalidScope),
+				})
+				return
+			}
+		}
+	}
+
+	// Audiences are identified by the audience URI of resource servers, the
+	// same as resources.
+	targets := append(append([]string(nil), treq.Resources...), treq.Audiences...)
+	audience, ok := requestedAudience(w, req, cfg, targets, scopes)
+	if !ok {
+		return
+	}
+
+	allowed, err := provider.AllowTokenExchange(cinfo, subject, scopes, audience)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if !allowed {
+		log.Printf("[INFO] request_id=%s Client %s not allowed to exchange a token of client %s", RequestID(req), cinfo.ID, subject.ClientID)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrTokenExchangeDenied),
+		})
+		return
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    TokenExchangeGrantType,
+		Client:       cinfo,
+		Scopes:       scopes,
+		TokenRequest: &treq,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	if len(audience) == 0 {
+		audience = subject.Audience
+	}
+
+	grant := types.Grant{
+		UserID:           subject.UserID,
+		Scopes:           scopes,
+		Audience:         audience,
+		AMR:              subject.AMR,
+		IdentityProvider: subject.IdentityProvider,
+	}
+
+	expiration, _ := tokenPolicy(cfg, scopes)
+	if !subject.ExpiresAt.IsZero() {
+		if left := subject.ExpiresAt.Sub(now(cfg)); left < expiration {
+			expiration = left
+		}
+	}
+
+	token, err := genToken(req, cfg, grant, cinfo, false, expiration)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	token.IssuedTokenType = AccessTokenType
+	renderToken(w, req, cfg, cinfo, token)
+}
+
+// subjectToken looks up the access token presented for exchange, returning
+// an empty token if it is not genuine, not active or a refresh token.
+func subjectToken(cfg config, raw string) (types.Token, error) {
+	// Self-contained tokens have to be genuine before looking them up.
+	if isJWT(raw) && cfg.keyProvider != nil {
+		if _, err := verifyAccessToken(cfg, raw); err != nil {
+			return types.Token{}, nil
+		}
+	}
+
+	token, id, err := lookupToken(cfg, raw)
+	if err != nil {
+		return types.Token{}, err
+	}
+
+	isIdle, err := idle(cfg, cfg.provider, id, cfg.idleTimeout)
+	if err != nil {
+		return types.Token{}, err
+	}
+
+	expired := !token.ExpiresAt.IsZero() && !now(cfg).Before(token.ExpiresAt)
+	if token.Value == "" || expired || isIdle || token.Status == types.TokenExpired || token.Status == types.TokenRevoked ||
+		token.Status == types.TokenRotated || (token.RefreshToken != "" && token.RefreshToken == id) {
+		return types.Token{}, nil
+	}
+	return token, nil
+}
@@ -0,0 +1,101 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+type tokenExchangeProvider struct {
+	*test.Provider
+	allowed bool
+}
+
+func (p tokenExchangeProvider) AllowTokenExchange(client types.Client, subject types.Token, scopes types.Scopes, audience []string) (bool, error) {
+	return p.allowed, nil
+}
+
+// TestTokenExchange tests that access tokens can be exchanged for tokens
+// with a narrower scope, issued for the same resource owner, if the provider
+// allows it.
+func TestTokenExchange(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+	cfg.provider = provider
+	SetClock(clock)(&cfg)
+
+	subject, err := provider.GenToken(types.Grant{
+		UserID: "test_user",
+		Scopes: types.Scopes{types.Scope{ID: "read"}, types.Scope{ID: "write"}},
+		AMR:    []string{"pwd"},
+	}, provider.Client, true, 5*time.Minute)
+	ok(t, err)
+
+	exchange := func(values url.Values) *httptest.ResponseRecorder {
+		values.Set("grant_type", TokenExchangeGrantType)
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+	errorOf := func(w *httptest.ResponseRecorder) types.AuthzError {
+		equals(t, http.StatusBadRequest, w.Code)
+		var e types.AuthzError
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		return e
+	}
+	request := func(token, scope string) url.Values {
+		return url.Values{
+			"subject_token":      {token},
+			"subject_token_type": {AccessTokenType},
+			"scope":              {scope},
+		}
+	}
+
+	// Providers have to opt in.
+	equals(t, types.ErrorUnsupportedGrantType, errorOf(exchange(request(subject.Value, "read"))).Code)
+
+	cfg.provider = tokenExchangeProvider{Provider: provider, allowed: true}
+	equals(t, ErrSubjectTokenRequired.Description, errorOf(exchange(url.Values{"subject_token": {subject.Value}})).Description)
+	values := request(subject.Value, "read")
+	values.Set("subject_token_type", "urn:ietf:params:oauth:token-type:id_token")
+	equals(t, ErrTokenTypeUnsupported.Description, errorOf(exchange(values)).Description)
+	equals(t, ErrSubjectTokenInvalid.Description, errorOf(exchange(request(subject.RefreshToken, "read"))).Description)
+	equals(t, ErrSubjectTokenInvalid.Description, errorOf(exchange(request("unknown", "read"))).Description)
+	equals(t, types.ErrorInvalidScope, errorOf(exchange(request(subject.Value, "read admin"))).Code)
+
+	clock.Advance(4 * time.Minute)
+	w := exchange(request(subject.Value, "read"))
+	equals(t, http.StatusOK, w.Code)
+	var token types.Token
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, AccessTokenType, token.IssuedTokenType)
+	equals(t, "", token.RefreshToken)
+
+	// Exchanged tokens do not outlive the subject token.
+	exchanged := provider.AccessTokens[token.Value]
+	equals(t, types.Scopes{types.Scope{ID: "read", Description: "test scope"}}, exchanged.Scopes)
+	equals(t, "test_user", exchanged.UserID)
+	equals(t, []string{"pwd"}, exchanged.AMR)
+	equals(t, provider.AccessTokens[subject.Value].ExpiresAt, exchanged.ExpiresAt)
+
+	cfg.provider = tokenExchangeProvider{Provider: provider}
+	equals(t, ErrTokenExchangeDenied.Description, errorOf(exchange(request(subject.Value, "read"))).Description)
+}
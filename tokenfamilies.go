@@ -0,0 +1,87 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TokenFamilyProvider is an optional interface that providers can implement
+// in order to describe the families of tokens issued by rotating refresh
+// tokens. Families are inspected through the admin API and their access
+// token count is included when introspecting refresh tokens.
+type TokenFamilyProvider interface {
+	// TokenFamily returns the token family with the given identifier, or an
+	// empty family if not found.
+	TokenFamily(familyID string) (types.TokenFamily, error)
+}
+
+// ErrTokenFamilyProviderRequired is returned when inspecting token families
+// with a provider that does not implement TokenFamilyProvider.
+var ErrTokenFamilyProviderRequired = errors.New("oauth2: provider does not implement oauth2.TokenFamilyProvider")
+
+// familyClaims adds the rotation generation, the family and its access token
+// count to the introspection claims of a refresh token.
+func familyClaims(cfg config, token types.Token, claims map[string]interface{}) error {
+	if token.FamilyID == "" {
+		return nil
+	}
+
+	claims["family_id"] = token.FamilyID
+	claims["generation"] = token.Generation
+
+	p, ok := asTokenFamilyProvider(cfg.provider)
+	if !ok {
+		return nil
+	}
+
+	family, err := p.TokenFamily(token.FamilyID)
+	if err != nil {
+		return err
+	}
+
+	if family.ID != "" {
+		claims["access_token_count"] = family.AccessTokenCount
+	}
+	return nil
+}
+
+// getTokenFamily returns a token family through the admin API.
+func getTokenFamily(w http.ResponseWriter, req *http.Request, cfg config, familyID string) {
+	provider, ok := asTokenFamilyProvider(cfg.provider)
+	if !ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", ErrTokenFamilyProviderRequired),
+		})
+		return
+	}
+
+	family, err := provider.TokenFamily(familyID)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if family.ID == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusNotFound,
+			Data:   localize(req, cfg, ErrNotFound),
+		})
+		return
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   family,
+	})
+}
@@ -0,0 +1,95 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestTokenFamilies tests that refresh tokens are introspected along with
+// their family, and that families can be inspected through the admin API.
+func TestTokenFamilies(t *testing.T) {
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+
+	scopes := types.Scopes{types.Scope{ID: "read"}}
+	token, err := provider.GenToken(types.Grant{Scopes: scopes}, provider.Client, true, 10*time.Minute)
+	ok(t, err)
+
+	refreshed, err := provider.RefreshToken(provider.RefreshTokens[token.RefreshToken], scopes)
+	ok(t, err)
+	equals(t, token.FamilyID, refreshed.FamilyID)
+
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/introspect",
+		bytes.NewBufferString(url.Values{"token": {refreshed.RefreshToken}}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "secret")
+
+	w := httptest.NewRecorder()
+	IntrospectToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	claims := make(map[string]interface{})
+	ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+	equals(t, true, claims["active"])
+	equals(t, token.FamilyID, claims["family_id"])
+	equals(t, float64(1), claims["generation"])
+	equals(t, float64(2), claims["access_token_count"])
+
+	// Access tokens are not described by their family.
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/introspect",
+		bytes.NewBufferString(url.Values{"token": {refreshed.Value}}.Encode()))
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "secret")
+
+	w = httptest.NewRecorder()
+	IntrospectToken(w, req, cfg)
+	claims = make(map[string]interface{})
+	ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+	_, found := claims["family_id"]
+	equals(t, false, found)
+
+	admin := AdminHandler(provider)
+
+	req, err = http.NewRequest("GET", "/token_families/"+token.FamilyID, nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	equals(t, http.StatusOK, w.Code)
+
+	var family types.TokenFamily
+	ok(t, json.Unmarshal(w.Body.Bytes(), &family))
+	equals(t, types.TokenFamily{
+		ID:               token.FamilyID,
+		ClientID:         provider.Client.ID,
+		Generation:       1,
+		AccessTokenCount: 2,
+	}, family)
+
+	req, err = http.NewRequest("GET", "/token_families/unknown", nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	equals(t, http.StatusNotFound, w.Code)
+
+	req, err = http.NewRequest("PUT", "/token_families/"+token.FamilyID, nil)
+	ok(t, err)
+	w = httptest.NewRecorder()
+	admin.ServeHTTP(w, req)
+	equals(t, http.StatusMethodNotAllowed, w.Code)
+	equals(t, "GET", w.Header().Get("Allow"))
+}
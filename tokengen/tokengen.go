@@ -0,0 +1,244 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package tokengen generates the random values of authorization codes,
+// opaque tokens, device codes and user codes. Providers can use it when
+// issuing codes and tokens, and the oauth2 package uses it for the values
+// it generates on their behalf, so deployments can choose the entropy and
+// encoding that meet their requirements.
+package tokengen
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+// Generator generates random values.
+type Generator interface {
+	// Generate returns a new random value.
+	Generate() (string, error)
+}
+
+// Encoding defines how random bytes are turned into a string.
+type Encoding int
+
+const (
+	// Base64URL encodes bytes with the URL safe base64 alphabet, without padding.
+	Base64URL Encoding = iota
+	// Hex encodes bytes as lowercase hexadecimal digits.
+	Hex
+	// Base32 encodes bytes with the standard base32 alphabet, without
+	// padding, for systems that are case insensitive.
+	Base32
+)
+
+// DefaultBytes is the number of random bytes generated by Random if none is
+// given, 256 bits of entropy.
+const DefaultBytes = 32
+
+// Default generates values with 256 bits of entropy, URL safe base64 encoded.
+var Default Generator = Random{}
+
+// Random generates values out of bytes read from crypto/rand.
+type Random struct {
+	// Number of random bytes. Defaults to DefaultBytes.
+	Bytes int
+	// Encoding of the random bytes. Defaults to Base64URL.
+	Encoding Encoding
+}
+
+// Generate implements Generator.
+func (r Random) Generate() (string, error) {
+	n := r.Bytes
+	if n <= 0 {
+		n = DefaultBytes
+	}
+
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	switch r.Encoding {
+	case Hex:
+		return hex.EncodeToString(b), nil
+	case Base32:
+		return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b), nil
+	default:
+		return base64.RawURLEncoding.EncodeToString(b), nil
+	}
+}
+
+// UserCodeCharset is the default set of characters of user codes. It has no
+// vowels, so no words are spelled by accident, and no easily confused
+// characters. http://tools.ietf.org/html/rfc8628#section-6.1
+const UserCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// UserCodeDigits is the set of characters of numeric user codes, easier to
+// type on devices without a full keyboard. Numeric codes need more
+// characters than UserCodeCharset ones for the same entropy.
+const UserCodeDigits = "0123456789"
+
+// UserCode generates short codes resource owners can type on another device,
+// such as "WDJB-MJHT". Their entropy is low, so they must expire shortly and
+// attempts to enter them must be rate limited.
+type UserCode struct {
+	// Number of characters, separators excluded. Defaults to 8.
+	Length int
+	// Characters codes are made of. Defaults to UserCodeCharset.
+	Charset string
+	// Number of characters between dashes. Codes are not grouped if zero.
+	GroupSize int
+}
+
+// Generate implements Generator.
+func (u UserCode) Generate() (string, error) {
+	length := u.Length
+	if length <= 0 {
+		length = 8
+	}
+
+	charset := u.Charset
+	if charset == "" {
+		charset = UserCodeCharset
+	}
+
+	max := big.NewInt(int64(len(charset)))
+	var code bytes.Buffer
+	for i := 0; i < length; i++ {
+		if u.GroupSize > 0 && i > 0 && i%u.GroupSize == 0 {
+			code.WriteByte('-')
+		}
+
+		// rand.Int picks characters uniformly, with no modulo bias.
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(charset[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// Normalize turns a user code as typed by a resource owner into the form
+// generated, so "wdjb mjht" or "WDJBMJHT" are looked up as "WDJB-MJHT".
+// Letters are upper-cased if the charset has no lowercase letters, and
+// characters not in the charset, such as separators, are dropped.
+func (u UserCode) Normalize(input string) string {
+	charset := u.Charset
+	if charset == "" {
+		charset = UserCodeCharset
+	}
+	if strings.ToUpper(charset) == charset {
+		input = strings.ToUpper(input)
+	}
+
+	var code bytes.Buffer
+	n := 0
+	for i := 0; i < len(input); i++ {
+		if strings.IndexByte(charset, input[i]) < 0 {
+			continue
+		}
+		if u.GroupSize > 0 && n > 0 && n%u.GroupSize == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(input[i])
+		n++
+	}
+	return code.String()
+}
+
+// ErrCollision is returned by Unique when it does not find an unused value.
+var ErrCollision = errors.New("tokengen: too many collisions generating a unique value")
+
+// Unique generates values that are not in use yet, which matters for values
+// with little entropy, such as user codes, that are looked up by value.
+type Unique struct {
+	// Generator of candidate values.
+	Generator Generator
+	// Exists tells whether a value is already in use.
+	Exists func(value string) (bool, error)
+	// Number of candidates generated before giving up. Defaults to 5.
+	Attempts int
+}
+
+// Generate implements Generator.
+func (u Unique) Generate() (string, error) {
+	attempts := u.Attempts
+	if attempts <= 0 {
+		attempts = 5
+	}
+
+	for i := 0; i < attempts; i++ {
+		value, err := u.Generator.Generate()
+		if err != nil {
+			return "", err
+		}
+
+		exists, err := u.Exists(value)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return value, nil
+		}
+	}
+	return "", ErrCollision
+}
+
+// Hash returns the hex encoded SHA-256 hash of a secret, such as an
+// authorization code or a refresh token, for providers to store instead of
+// the secret itself. Secrets are random and long enough for a plain hash to
+// be irreversible, no salt is needed and hashes can be looked up directly.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// checksumLength is the length of the checksum appended to prefixed tokens.
+// Six base62 digits hold any CRC32 value.
+const checksumLength = 6
+
+const base62 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// Prefix turns a token into one that identifies itself, such as
+// "hlat_3f1c...M2x9Tq", for secret scanning tools to find it and for people to
+// tell what it is when it shows up in logs. A CRC32 checksum is appended so
+// scanners can tell real tokens from random strings without looking them up.
+func Prefix(prefix, token string) string {
+	return prefix + token + checksum(prefix+token)
+}
+
+// Unprefix returns the token Prefix was given, if the prefix and checksum
+// are valid.
+func Unprefix(prefix, token string) (string, bool) {
+	if !strings.HasPrefix(token, prefix) || len(token) < len(prefix)+checksumLength {
+		return "", false
+	}
+
+	body := token[:len(token)-checksumLength]
+	if checksum(body) != token[len(body):] {
+		return "", false
+	}
+	return strings.TrimPrefix(body, prefix), true
+}
+
+// checksum returns the CRC32 of s as six base62 digits.
+func checksum(s string) string {
+	n := crc32.ChecksumIEEE([]byte(s))
+	b := make([]byte, checksumLength)
+	for i := checksumLength - 1; i >= 0; i-- {
+		b[i] = base62[n%62]
+		n /= 62
+	}
+	return string(b)
+}
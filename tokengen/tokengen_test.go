@@ -0,0 +1,151 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package tokengen
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestRandom(t *testing.T) {
+	tests := []struct {
+		gen     Random
+		pattern string
+	}{
+		{Random{}, `^[A-Za-z0-9_-]{43}$`},
+		{Random{Bytes: 16, Encoding: Hex}, `^[0-9a-f]{32}$`},
+		{Random{Bytes: 20, Encoding: Base32}, `^[A-Z2-7]{32}$`},
+	}
+
+	for _, tt := range tests {
+		seen := make(map[string]bool)
+		for i := 0; i < 10; i++ {
+			v, err := tt.gen.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !regexp.MustCompile(tt.pattern).MatchString(v) {
+				t.Errorf("%q does not match %s", v, tt.pattern)
+			}
+			if seen[v] {
+				t.Errorf("%q was generated twice", v)
+			}
+			seen[v] = true
+		}
+	}
+}
+
+func TestUserCode(t *testing.T) {
+	tests := []struct {
+		gen     UserCode
+		pattern string
+	}{
+		{UserCode{}, `^[BCDFGHJKLMNPQRSTVWXZ]{8}$`},
+		{UserCode{GroupSize: 4}, `^[BCDFGHJKLMNPQRSTVWXZ]{4}-[BCDFGHJKLMNPQRSTVWXZ]{4}$`},
+		{UserCode{Length: 9, Charset: "0123456789", GroupSize: 3}, `^[0-9]{3}-[0-9]{3}-[0-9]{3}$`},
+	}
+
+	for _, tt := range tests {
+		v, err := tt.gen.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(tt.pattern).MatchString(v) {
+			t.Errorf("%q does not match %s", v, tt.pattern)
+		}
+	}
+}
+
+func TestUserCodeNormalize(t *testing.T) {
+	tests := []struct {
+		gen      UserCode
+		input    string
+		expected string
+	}{
+		{UserCode{GroupSize: 4}, "wdjb mjht", "WDJB-MJHT"},
+		{UserCode{GroupSize: 4}, "WDJBMJHT", "WDJB-MJHT"},
+		{UserCode{}, "WDJB-MJHT", "WDJBMJHT"},
+		{UserCode{Charset: UserCodeDigits, GroupSize: 3}, "123 456-789", "123-456-789"},
+		{UserCode{Charset: "abcdef"}, "AB-cd", "cd"},
+	}
+
+	for _, tt := range tests {
+		if v := tt.gen.Normalize(tt.input); v != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.expected, v)
+		}
+	}
+
+	gen := UserCode{GroupSize: 4}
+	code, err := gen.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := gen.Normalize(code); v != code {
+		t.Errorf("expected generated code %q to be normalized, got %q", code, v)
+	}
+}
+
+type sequence []string
+
+func (s *sequence) Generate() (string, error) {
+	v := (*s)[0]
+	*s = (*s)[1:]
+	return v, nil
+}
+
+func TestUnique(t *testing.T) {
+	used := map[string]bool{"A": true, "B": true}
+	exists := func(v string) (bool, error) { return used[v], nil }
+
+	v, err := Unique{Generator: &sequence{"A", "B", "C"}, Exists: exists}.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "C" {
+		t.Errorf("expected first unused value, got %q", v)
+	}
+
+	_, err = Unique{Generator: &sequence{"A", "B", "C"}, Exists: exists, Attempts: 2}.Generate()
+	if err != ErrCollision {
+		t.Errorf("expected ErrCollision, got %v", err)
+	}
+
+	boom := errors.New("boom")
+	_, err = Unique{
+		Generator: &sequence{"C"},
+		Exists:    func(string) (bool, error) { return false, boom },
+	}.Generate()
+	if err != boom {
+		t.Errorf("expected lookup error, got %v", err)
+	}
+}
+
+func TestHash(t *testing.T) {
+	// echo -n abc | sha256sum
+	expected := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if h := Hash("abc"); h != expected {
+		t.Errorf("expected %s, got %s", expected, h)
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	token := Prefix("hlat_", "3f1c9a")
+	if token[:11] != "hlat_3f1c9a" || len(token) != 17 {
+		t.Fatalf("unexpected prefixed token %q", token)
+	}
+
+	v, ok := Unprefix("hlat_", token)
+	if !ok || v != "3f1c9a" {
+		t.Errorf("expected 3f1c9a, got %q", v)
+	}
+
+	tampered := token[:6] + "0" + token[7:]
+	for _, bad := range []string{tampered, "hlrt_" + token[5:], "hlat_", "3f1c9a"} {
+		if _, ok := Unprefix("hlat_", bad); ok {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"strings"
+
+	"github.com/hooklift/oauth2/tokengen"
+	"github.com/hooklift/oauth2/types"
+)
+
+// SetTokenPrefixes makes opaque access tokens and refresh tokens identify
+// themselves with the given prefixes, followed by the token issued by the
+// provider and a checksum. See tokengen.Prefix. For example:
+//
+//	SetTokenPrefixes("hlat_", "hlrt_")
+//
+// Providers keep storing tokens as they issue them. Tokens with no prefix,
+// issued before prefixes were set, are still accepted. AuthzHandler has to be
+// given the same prefixes.
+func SetTokenPrefixes(accessToken, refreshToken string) option {
+	return func(c *config) {
+		c.tokenPrefixes.accessToken = accessToken
+		c.tokenPrefixes.refreshToken = refreshToken
+	}
+}
+
+// prefixToken adds the configured prefixes to the tokens issued by the provider.
+func prefixToken(cfg config, token types.Token) types.Token {
+	if p := cfg.tokenPrefixes.accessToken; p != "" && !isJWT(token.Value) {
+		token.Value = tokengen.Prefix(p, token.Value)
+	}
+
+	if p := cfg.tokenPrefixes.refreshToken; p != "" && token.RefreshToken != "" {
+		token.RefreshToken = tokengen.Prefix(p, token.RefreshToken)
+	}
+	return token
+}
+
+// unprefix returns the token issued by the provider. Tokens with a wrong
+// checksum are turned into an empty string, which matches no token.
+func unprefix(prefix, token string) string {
+	if prefix == "" || !strings.HasPrefix(token, prefix) {
+		return token
+	}
+
+	v, _ := tokengen.Unprefix(prefix, token)
+	return v
+}
@@ -0,0 +1,77 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestTokenPrefixes tests that prefixed tokens are issued and accepted, and
+// that tokens with a wrong checksum are rejected.
+func TestTokenPrefixes(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	prefixes := SetTokenPrefixes("hlat_", "hlrt_")
+	prefixes(&cfg)
+
+	w := httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	assert(t, strings.HasPrefix(token.Value, "hlat_"), "unexpected access token %s", token.Value)
+	assert(t, strings.HasPrefix(token.RefreshToken, "hlrt_"), "unexpected refresh token %s", token.RefreshToken)
+
+	handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	}), provider, prefixes)
+
+	tampered := token.Value[:len(token.Value)-1] + "x"
+	if strings.HasSuffix(token.Value, "x") {
+		tampered = token.Value[:len(token.Value)-1] + "y"
+	}
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{token.Value, http.StatusOK},
+		{tampered, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, tt.status, w.Code)
+	}
+
+	buffer := bytes.NewBufferString(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+	}.Encode())
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+}
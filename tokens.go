@@ -17,37 +17,122 @@ import (
 // TokenHandlers is a map to functions where each function handles a particular HTTP
 // verb or method.
 var TokenHandlers map[string]func(http.ResponseWriter, *http.Request, config) = map[string]func(http.ResponseWriter, *http.Request, config){
-	"POST":   IssueToken,
-	"DELETE": RevokeToken,
+	"POST":    noMethodOverride(unlessReadOnly(IssueToken)),
+	"DELETE":  noMethodOverride(RevokeToken),
+	"OPTIONS": TokenPreflight,
+}
+
+// Headers some frameworks and proxies use to override the request method.
+var methodOverrideHeaders = []string{"X-HTTP-Method-Override", "X-HTTP-Method", "X-Method-Override"}
+
+// noMethodOverride rejects requests trying to override their method. They
+// are routed by their actual method, but anything in front of the handler
+// honoring the override, such as a proxy turning a cross-site POST into a
+// DELETE, would make them act differently than routed.
+func noMethodOverride(fn func(http.ResponseWriter, *http.Request, config)) func(http.ResponseWriter, *http.Request, config) {
+	return func(w http.ResponseWriter, req *http.Request, cfg config) {
+		for _, h := range methodOverrideHeaders {
+			if req.Header.Get(h) != "" {
+				render.JSON(w, render.Options{
+					Status: http.StatusBadRequest,
+					Data:   localize(req, cfg, ErrMethodOverride),
+				})
+				return
+			}
+		}
+		fn(w, req, cfg)
+	}
 }
 
 // IssueToken handles all requests going to tokens endpoint.
 func IssueToken(w http.ResponseWriter, req *http.Request, cfg config) {
 	provider := cfg.provider
+
+	key := clientKey(req)
+	if throttle(w, req, cfg, key) || lockedOut(w, req, cfg, key) {
+		return
+	}
+
+	treq := newTokenRequest(req, cfg)
+	if !grantTypeAllowed(cfg, treq.GrantType) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
+		})
+		return
+	}
+
+	// Service accounts authenticate by signing the assertion itself.
+	if treq.GrantType == JWTBearerGrantType {
+		if checkDeprecation(w, req, cfg, treq.GrantType, "") {
+			render.JSON(w, render.Options{
+				Status: http.StatusBadRequest,
+				Data:   localize(req, cfg, ErrUnsupportedGrantType),
+			})
+			return
+		}
+		serviceAccountGrant(w, req, cfg, treq)
+		return
+	}
+
 	username, password, ok := req.BasicAuth()
 	cinfo, err := provider.AuthenticateClient(username, password)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
 	if !ok || err != nil {
+		authFailed(cfg, key)
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrUnauthorizedClient,
+			Data:   localize(req, cfg, ErrUnauthorizedClient),
 		})
 		return
 	}
+	authSucceeded(cfg, key)
+	allowOrigin(w, req, cinfo)
 
-	grantType := req.FormValue("grant_type")
-	switch grantType {
+	if e, inactive := inactiveClient(req, cfg, cinfo); inactive && !gracefulRefresh(req, cfg, cinfo) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	if !proveKey(w, req, cfg, cinfo, &treq) {
+		return
+	}
+
+	if checkDeprecation(w, req, cfg, treq.GrantType, cinfo.ID) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
+		})
+		return
+	}
+
+	switch treq.GrantType {
 	case "authorization_code":
-		authCodeGrant2(w, req, cfg, cinfo)
+		authCodeGrant2(w, req, cfg, cinfo, treq)
 	case "client_credentials":
-		clientCredentialsGrant(w, req, cfg, cinfo)
+		clientCredentialsGrant(w, req, cfg, cinfo, treq)
 	case "password":
-		resourceOwnerCredentialsGrant(w, req, cfg, cinfo)
+		resourceOwnerCredentialsGrant(w, req, cfg, cinfo, treq)
 	case "refresh_token":
-		refreshToken(w, req, cfg, cinfo)
+		refreshToken(w, req, cfg, cinfo, treq)
+	case DeviceCodeGrantType:
+		deviceCodeGrant(w, req, cfg, cinfo, treq)
+	case TokenExchangeGrantType:
+		tokenExchangeGrant(w, req, cfg, cinfo, treq)
 	default:
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrUnsupportedGrantType,
+			Data:   localize(req, cfg, ErrUnsupportedGrantType),
 		})
 		return
 	}
@@ -59,49 +144,44 @@ func IssueToken(w http.ResponseWriter, req *http.Request, cfg config) {
 //
 // Implementation notes:
 //  * Ignores client_id as we are always requiring the client to authenticate
-//  * Ignores redirect_uri as we force a static and pre-registered redirect URI for the client
-func authCodeGrant2(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client) {
-	provider := cfg.provider
-	code := req.FormValue("code")
+//  * redirect_uri has to be sent if it was sent in the authorization request,
+//    which requires the provider to implement BoundGrantProvider
+func authCodeGrant2(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
+	code := treq.Code
 	if code == "" {
-		err := ErrUnauthorizedClient
-		err.Description = "Authorization code can't be empty."
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrUnauthorizedClient,
+			Data:   localize(req, cfg, ErrAuthzCodeRequired),
 		})
 		return
 	}
 
-	grant, err := provider.GrantInfo(code)
+	grant, err := grantInfo(cfg, code)
 	if err != nil {
 		e := ErrInvalidGrant
 		e.Description = err.Error()
 
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   e,
+			Data:   localize(req, cfg, e),
 		})
 		return
 	}
 
-	if grant.Status == types.GrantRevoked ||
+	expired := !grant.ExpiresIn.IsZero() && !now(cfg).Before(grant.ExpiresIn)
+	if expired ||
+		grant.Status == types.GrantRevoked ||
+		grant.Status == types.GrantQuarantined ||
 		grant.Status == types.GrantExpired ||
 		grant.Status == types.GrantUsed {
-		e := ErrInvalidGrant
-		e.Description = "Grant code was revoked, expired or already used."
-
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   e,
+			Data:   localize(req, cfg, ErrGrantCodeUsed),
 		})
 		return
 	}
 
-	if cinfo.RedirectURL.String() != grant.RedirectURL.String() {
-		e := ErrInvalidGrant
-		e.Description = "Grant code was generated for a different redirect URI."
-
+	if e, ok := verifyGrant(req, cfg, grant, cinfo, treq); !ok {
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
 			Data:   e,
@@ -109,12 +189,18 @@ func authCodeGrant2(w http.ResponseWriter, req *http.Request, cfg config, cinfo
 		return
 	}
 
-	// This should not happen if the provider is doing its work properly but we are
-	// checking anyways.
-	if grant.ClientID != cinfo.ID {
-		e := ErrInvalidGrant
-		e.Description = "Grant code was generated for a different client ID."
-
+	audience, ok := requestedAudience(w, req, cfg, treq.Resources, grant.Scopes)
+	if !ok {
+		return
+	}
+	grant.Audience = audience
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    "authorization_code",
+		Client:       cinfo,
+		Scopes:       grant.Scopes,
+		TokenRequest: &treq,
+	}); ok {
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
 			Data:   e,
@@ -122,33 +208,69 @@ func authCodeGrant2(w http.ResponseWriter, req *http.Request, cfg config, cinfo
 		return
 	}
 
-	token, err := provider.GenToken(grant, cinfo, true, cfg.tokenExpiration)
+	grant.KeyThumbprint = treq.KeyThumbprint
+	expiration, refreshable := tokenPolicy(cfg, grant.Scopes)
+	token, err := genToken(req, cfg, grant, cinfo, refreshable, expiration)
 	if err != nil {
 		render.JSON(w, render.Options{
 			Status: http.StatusInternalServerError,
-			Data:   ErrServerError("", err),
+			Data:   serverError(req, cfg, "", err),
 		})
 		return
 	}
 
-	render.JSON(w, render.Options{
-		Status: http.StatusOK,
-		Data:   token,
-	})
+	renderToken(w, req, cfg, cinfo, token)
 }
 
 // Implements http://tools.ietf.org/html/rfc6749#section-4.3
-func resourceOwnerCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client) {
+func resourceOwnerCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
 	provider := cfg.provider
-	if ok := provider.AuthenticateUser(req.FormValue("username"), req.FormValue("password")); !ok {
+	username := treq.Username
+	key := "user:" + username
+	if !passwordGrantAllowed(cfg, cinfo) {
+		e := ErrPasswordGrantNotAllowed
+		if cfg.passwordGrantPolicy == PasswordGrantDisabled {
+			e = ErrUnsupportedGrantType
+		}
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrUnathorizedUser,
+			Data:   localize(req, cfg, e),
+		})
+		return
+	}
+
+	if lockedOut(w, req, cfg, key) {
+		return
+	}
+
+	user, ok, err := authenticateResourceOwner(cfg, username, treq.Password)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
 		})
 		return
 	}
 
-	scope := req.FormValue("scope")
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if !ok {
+		authFailed(cfg, key)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrUnathorizedUser),
+		})
+		return
+	}
+	authSucceeded(cfg, key)
+
+	scope := treq.Scope
 	var scopes types.Scopes
 	if scope != "" {
 		var err error
@@ -156,34 +278,64 @@ func resourceOwnerCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg
 		if err != nil {
 			render.JSON(w, render.Options{
 				Status: http.StatusBadRequest,
-				Data:   ErrServerError("", err),
+				Data:   serverError(req, cfg, "", err),
 			})
 			return
 		}
 	}
 
+	audience, ok := requestedAudience(w, req, cfg, treq.Resources, scopes)
+	if !ok {
+		return
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    "password",
+		Client:       cinfo,
+		User:         user,
+		Scopes:       scopes,
+		TokenRequest: &treq,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
+	// The resource owner authenticated with their password, in this request.
 	noAuthzGrant := types.Grant{
-		Scopes: scopes,
+		Scopes:           scopes,
+		Audience:         audience,
+		UserID:           user.ID,
+		AMR:              user.AMR,
+		IdentityProvider: user.IdentityProvider,
+		KeyThumbprint:    treq.KeyThumbprint,
 	}
-	token, err := provider.GenToken(noAuthzGrant, cinfo, true, cfg.tokenExpiration)
+	expiration, refreshable := tokenPolicy(cfg, scopes)
+	token, err := genToken(req, cfg, noAuthzGrant, cinfo, refreshable, expiration)
 	if err != nil {
 		render.JSON(w, render.Options{
 			Status: http.StatusInternalServerError,
-			Data:   ErrServerError("", err),
+			Data:   serverError(req, cfg, "", err),
 		})
 		return
 	}
 
-	render.JSON(w, render.Options{
-		Status: http.StatusOK,
-		Data:   token,
-	})
+	renderToken(w, req, cfg, cinfo, token)
 }
 
 // Implements http://tools.ietf.org/html/rfc6749#section-4.4
-func clientCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client) {
+//
+// Clients are granted the scope registered for them, if they do not request
+// one, and never a refresh token.
+func clientCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
 	provider := cfg.provider
-	scope := req.FormValue("scope")
+	scope := treq.Scope
+	if scope == "" {
+		scope = cinfo.ClientCredentialsScope
+	}
+
 	var scopes types.Scopes
 	if scope != "" {
 		var err error
@@ -191,97 +343,217 @@ func clientCredentialsGrant(w http.ResponseWriter, req *http.Request, cfg config
 		if err != nil {
 			render.JSON(w, render.Options{
 				Status: http.StatusBadRequest,
-				Data:   ErrServerError("", err),
+				Data:   serverError(req, cfg, "", err),
 			})
 			return
 		}
 	}
 
+	if !clientCredentialsScopeAllowed(cinfo, scopes) {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrClientCredentialsScope),
+		})
+		return
+	}
+
+	audience, ok := requestedAudience(w, req, cfg, treq.Resources, scopes)
+	if !ok {
+		return
+	}
+
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    "client_credentials",
+		Client:       cinfo,
+		Scopes:       scopes,
+		TokenRequest: &treq,
+	}); ok {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   e,
+		})
+		return
+	}
+
 	noAuthzGrant := types.Grant{
-		Scopes: scopes,
+		Scopes:   scopes,
+		Audience: audience,
 	}
-	token, err := provider.GenToken(noAuthzGrant, cinfo, false, cfg.tokenExpiration)
+	expiration, _ := tokenPolicy(cfg, scopes)
+	token, err := genToken(req, cfg, noAuthzGrant, cinfo, false, expiration)
 	if err != nil {
 		render.JSON(w, render.Options{
 			Status: http.StatusInternalServerError,
-			Data:   ErrServerError("", err),
+			Data:   serverError(req, cfg, "", err),
 		})
 		return
 	}
 
-	render.JSON(w, render.Options{
-		Status: http.StatusOK,
-		Data:   token,
-	})
+	renderToken(w, req, cfg, cinfo, token)
+}
+
+// clientCredentialsScopeAllowed tells whether the scopes do not exceed the
+// scope the client can be granted on its own behalf.
+func clientCredentialsScopeAllowed(cinfo types.Client, scopes types.Scopes) bool {
+	if cinfo.ClientCredentialsScope == "" {
+		return true
+	}
+
+	allowed := strings.Fields(cinfo.ClientCredentialsScope)
+	for _, s := range scopes {
+		found := false
+		for _, id := range allowed {
+			found = found || id == s.ID
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
 }
 
 // Implements http://tools.ietf.org/html/rfc6749#section-6
-func refreshToken(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client) {
+func refreshToken(w http.ResponseWriter, req *http.Request, cfg config, cinfo types.Client, treq TokenRequest) {
 	provider := cfg.provider
-	code := req.FormValue("refresh_token")
-	token, err := provider.TokenInfo(code)
+	code := treq.RefreshToken
+	if code == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshTokenRequired),
+		})
+		return
+	}
+
+	token, id, err := refreshTokenInfo(cfg, code)
 	if err != nil {
 		render.JSON(w, render.Options{
 			Status: http.StatusInternalServerError,
-			Data:   ErrServerError("", err),
+			Data:   serverError(req, cfg, "", err),
 		})
 		return
 	}
 
-	scope := req.FormValue("scope")
-	var scopes types.Scopes
-	if scope != "" {
-		var err error
+	// Rotated refresh tokens are only presented again if they were stolen.
+	if token.Status == types.TokenRotated {
+		refreshReused(req, cfg, cinfo, token)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshTokenInvalid),
+		})
+		return
+	}
+
+	if token.Value == "" || token.Status == types.TokenExpired || token.Status == types.TokenRevoked {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshTokenInvalid),
+		})
+		return
+	}
+
+	if token.ClientID != cinfo.ID {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshClientIDMismatch),
+		})
+		return
+	}
+
+	// Bound refresh tokens are useless to whoever stole them without the key.
+	if token.KeyThumbprint != "" && token.KeyThumbprint != treq.KeyThumbprint {
+		log.Printf("[WARN] request_id=%s Refresh token of client %s presented without the key it is bound to", RequestID(req), cinfo.ID)
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshTokenKeyMismatch),
+		})
+		return
+	}
+
+	isIdle, err := idle(cfg, provider, id, cfg.refreshIdleTimeout)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	if isIdle {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshTokenInvalid),
+		})
+		return
+	}
+
+	// The requested scope MUST NOT include any scope not originally granted
+	// by the resource owner, and if omitted is treated as equal to the scope
+	// originally granted by the resource owner.
+	scopes := token.Scopes
+	if scope := treq.Scope; scope != "" {
 		scopes, err = provider.ScopesInfo(scope)
 		if err != nil {
 			render.JSON(w, render.Options{
 				Status: http.StatusInternalServerError,
-				Data:   ErrServerError("", err),
+				Data:   serverError(req, cfg, "", err),
 			})
 			return
 		}
 
-		// The requested scope MUST NOT include any scope not originally granted
-		// by the resource owner, and if omitted is treated as equal to the scope
-		// originally granted by the resource owner.
-		tscopes := token.Scopes.Encode()
 		for _, s := range scopes {
-			// TODO(c4milo): make more robust
-			if !strings.Contains(tscopes, s.ID) {
+			if !token.Scopes.Contains(s.ID) {
 				render.JSON(w, render.Options{
 					Status: http.StatusBadRequest,
-					Data:   ErrInvalidScope,
+					Data:   localize(req, cfg, ErrInvalidScope),
 				})
 				return
 			}
 		}
+
+		if len(scopes) == 0 {
+			scopes = token.Scopes
+		}
 	}
 
-	if len(scopes) == 0 {
-		scopes = token.Scopes
+	// Scope policies are evaluated again in case they changed since the
+	// refresh token was issued.
+	expiration, refreshable := tokenPolicy(cfg, scopes)
+	if !refreshable {
+		render.JSON(w, render.Options{
+			Status: http.StatusBadRequest,
+			Data:   localize(req, cfg, ErrRefreshNotAllowed),
+		})
+		return
 	}
 
-	if token.ClientID != cinfo.ID {
+	if e, ok := denied(req, cfg, PolicyInput{
+		GrantType:    "refresh_token",
+		Client:       cinfo,
+		Scopes:       scopes,
+		TokenRequest: &treq,
+	}); ok {
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrClientIDMismatch,
+			Data:   e,
 		})
 		return
 	}
 
-	newToken, err := provider.RefreshToken(token, scopes)
+	var newToken types.Token
+	if cfg.refreshRotationDisabled {
+		newToken, err = reuseRefreshToken(req, cfg, cinfo, token, code, scopes, expiration)
+	} else {
+		newToken, err = refreshAccessToken(req, cfg, cinfo, token, scopes, expiration)
+	}
 	if err != nil {
 		render.JSON(w, render.Options{
 			Status: http.StatusInternalServerError,
-			Data:   ErrServerError("", err),
+			Data:   serverError(req, cfg, "", err),
 		})
 		return
 	}
 
-	render.JSON(w, render.Options{
-		Status: http.StatusOK,
-		Data:   newToken,
-	})
+	renderToken(w, req, cfg, cinfo, newToken)
 }
 
 // Implements https://tools.ietf.org/html/rfc7009
@@ -290,22 +562,37 @@ func refreshToken(w http.ResponseWriter, req *http.Request, cfg config, cinfo ty
 // unsupported_token_type error responses are not produced by this implementation either.
 func RevokeToken(w http.ResponseWriter, req *http.Request, cfg config) {
 	provider := cfg.provider
+
+	key := clientKey(req)
+	if lockedOut(w, req, cfg, key) {
+		return
+	}
+
 	username, password, ok := req.BasicAuth()
 	cinfo, err := provider.AuthenticateClient(username, password)
+	if isUnavailable(err) {
+		render.JSON(w, render.Options{
+			Status: http.StatusServiceUnavailable,
+			Data:   localize(req, cfg, ErrTemporarilyUnavailable),
+		})
+		return
+	}
+
 	if !ok || err != nil {
+		authFailed(cfg, key)
 		// TODO(c4milo): verify other implementations to see if they reply
 		// with 401 instead of 400. Spec is sort of contradictory in this regard.
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrUnauthorizedClient,
+			Data:   localize(req, cfg, ErrUnauthorizedClient),
 		})
 		return
 	}
+	authSucceeded(cfg, key)
 
-	token := path.Base(req.URL.Path)
-	tokenInfo, err := provider.TokenInfo(token)
+	tokenInfo, token, err := lookupToken(cfg, path.Base(req.URL.Path))
 	if err != nil {
-		log.Printf("[ERROR] Error getting token info: %+v", err)
+		log.Printf("[ERROR] request_id=%s Error getting token info: %+v", RequestID(req), err)
 		render.JSON(w, render.Options{
 			Status: http.StatusServiceUnavailable,
 		})
@@ -315,19 +602,20 @@ func RevokeToken(w http.ResponseWriter, req *http.Request, cfg config) {
 	if tokenInfo.ClientID != cinfo.ID {
 		render.JSON(w, render.Options{
 			Status: http.StatusBadRequest,
-			Data:   ErrClientIDMismatch,
+			Data:   localize(req, cfg, ErrClientIDMismatch),
 		})
 		return
 	}
 
 	err = provider.RevokeToken(token)
 	if err != nil {
-		log.Printf("[ERROR] Error revoking token: %+v", err)
+		log.Printf("[ERROR] request_id=%s Error revoking token: %+v", RequestID(req), err)
 		render.JSON(w, render.Options{
 			Status: http.StatusServiceUnavailable,
 		})
 		return
 	}
+	publishRevocation(cfg, token)
 
 	render.JSON(w, render.Options{
 		Status: http.StatusOK,
@@ -11,6 +11,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/hooklift/oauth2/providers/test"
 	"github.com/hooklift/oauth2/types"
@@ -117,6 +118,70 @@ func TestResourceOwnerCredentialsGrant(t *testing.T) {
 	equals(t, "0", w.Header().Get("Expires"))
 }
 
+// resourceOwnerProvider identifies resource owners authenticating with the
+// password grant.
+type resourceOwnerProvider struct {
+	*test.Provider
+}
+
+func (p resourceOwnerProvider) AuthenticateResourceOwner(username, password string) (types.User, error) {
+	if password != "test_password" {
+		return types.User{}, ErrInvalidCredentials
+	}
+	return types.User{ID: "id-" + username, AMR: []string{"pwd", "otp"}}, nil
+}
+
+// TestPasswordGrantPolicy tests that only the clients allowed by the password
+// grant policy get tokens with resource owners' credentials, issued to the
+// resource owner identified by the provider.
+func TestPasswordGrantPolicy(t *testing.T) {
+	tests := []struct {
+		policy     PasswordGrantPolicy
+		firstParty bool
+		code       string
+	}{
+		{PasswordGrantAllowed, false, ""},
+		{PasswordGrantFirstParty, false, types.ErrorUnauthorizedClient},
+		{PasswordGrantFirstParty, true, ""},
+		{PasswordGrantDisabled, true, types.ErrorUnsupportedGrantType},
+	}
+
+	for _, tt := range tests {
+		cfg := setupTest()
+		provider := test.NewProvider(true)
+		provider.Client.FirstParty = tt.firstParty
+		cfg.provider = resourceOwnerProvider{provider}
+		SetPasswordGrantPolicy(tt.policy)(&cfg)
+
+		values := url.Values{
+			"grant_type": {"password"},
+			"username":   {"test_user"},
+			"password":   {"test_password"},
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+
+		if tt.code != "" {
+			equals(t, http.StatusBadRequest, w.Code)
+			var e types.AuthzError
+			ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+			equals(t, tt.code, e.Code)
+			continue
+		}
+
+		equals(t, http.StatusOK, w.Code)
+		var token types.Token
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		equals(t, "id-test_user", provider.AccessTokens[token.Value].UserID)
+		equals(t, []string{"pwd", "otp"}, provider.AccessTokens[token.Value].AMR)
+	}
+}
+
 // TestClientCredentialsGrant tests happy path for http://tools.ietf.org/html/rfc6749#section-4.4
 func TestClientCredentialsGrant(t *testing.T) {
 	cfg := setupTest()
@@ -153,6 +218,52 @@ func TestClientCredentialsGrant(t *testing.T) {
 	equals(t, "0", w.Header().Get("Expires"))
 }
 
+// TestClientCredentialsScope tests that clients are only granted the scope
+// registered for them with client credentials, and get it by default.
+func TestClientCredentialsScope(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	provider.Client.ClientCredentialsScope = "read identity"
+	cfg.provider = provider
+
+	tests := []struct {
+		scope  string
+		status int
+		want   string
+	}{
+		{"", http.StatusOK, "read identity"},
+		{"read", http.StatusOK, "read"},
+		{"read write", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		values := url.Values{"grant_type": {"client_credentials"}}
+		if tt.scope != "" {
+			values.Set("scope", tt.scope)
+		}
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", bytes.NewBufferString(values.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+
+		if tt.status != http.StatusOK {
+			var e types.AuthzError
+			ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+			equals(t, types.ErrorInvalidScope, e.Code)
+			continue
+		}
+
+		var token types.Token
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		equals(t, "", token.RefreshToken)
+		equals(t, tt.want, provider.AccessTokens[token.Value].Scopes.Encode())
+	}
+}
+
 // TestRefreshToken tests happy path for http://tools.ietf.org/html/rfc6749#section-6
 func TestRefreshToken(t *testing.T) {
 	cfg := setupTest()
@@ -202,6 +313,88 @@ func TestRefreshToken(t *testing.T) {
 	equals(t, "0", w.Header().Get("Expires"))
 }
 
+// TestRefreshTokenErrors tests the validation of refresh token requests, in
+// accordance with http://tools.ietf.org/html/rfc6749#section-6
+func TestRefreshTokenErrors(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	grant := types.Grant{
+		Scopes: types.Scopes{
+			types.Scope{ID: "read"},
+			types.Scope{ID: "identity"},
+		},
+	}
+
+	issue := func(client types.Client) string {
+		token, err := provider.GenToken(grant, client, true, cfg.tokenExpiration)
+		ok(t, err)
+		return token.RefreshToken
+	}
+
+	revoked := issue(provider.Client)
+	info := provider.RefreshTokens[revoked]
+	info.Status = types.TokenRevoked
+	provider.RefreshTokens[revoked] = info
+
+	expired := issue(provider.Client)
+	info = provider.RefreshTokens[expired]
+	info.Status = types.TokenExpired
+	provider.RefreshTokens[expired] = info
+
+	accessToken, err := provider.GenToken(grant, provider.Client, false, cfg.tokenExpiration)
+	ok(t, err)
+
+	tests := []struct {
+		desc         string
+		refreshToken string
+		scope        string
+		status       int
+		err          types.AuthzError
+		scopes       string
+	}{
+		{"missing", "", "", http.StatusBadRequest, ErrRefreshTokenRequired, ""},
+		{"unknown", "unknown", "", http.StatusBadRequest, ErrRefreshTokenInvalid, ""},
+		{"revoked", revoked, "", http.StatusBadRequest, ErrRefreshTokenInvalid, ""},
+		{"expired", expired, "", http.StatusBadRequest, ErrRefreshTokenInvalid, ""},
+		{"access token", accessToken.Value, "", http.StatusBadRequest, ErrRefreshTokenInvalid, ""},
+		{"other client", issue(types.Client{ID: "boo"}), "", http.StatusBadRequest, ErrRefreshClientIDMismatch, ""},
+		{"broadened", issue(provider.Client), "read write", http.StatusBadRequest, ErrInvalidScope, ""},
+		{"scope prefix", issue(provider.Client), "rea", http.StatusBadRequest, ErrInvalidScope, ""},
+		{"downscoped", issue(provider.Client), "read", http.StatusOK, types.AuthzError{}, "read"},
+		{"same scope", issue(provider.Client), "", http.StatusOK, types.AuthzError{}, "read identity"},
+	}
+
+	for _, tt := range tests {
+		buffer := bytes.NewBufferString(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {tt.refreshToken},
+			"scope":         {tt.scope},
+		}.Encode())
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		assert(t, w.Code == tt.status, "%s: expected status %d, got %d", tt.desc, tt.status, w.Code)
+
+		if tt.status != http.StatusOK {
+			authzErr := types.AuthzError{}
+			ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+			assert(t, authzErr.Code == tt.err.Code && authzErr.Description == tt.err.Description,
+				"%s: expected %s, got %s", tt.desc, tt.err.Description, authzErr.Description)
+			continue
+		}
+
+		token := types.Token{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+		equals(t, tt.scopes, provider.AccessTokens[token.Value].Scopes.Encode())
+	}
+}
+
 // TestAuthzCodeOwnership tests that the authorization code was issued to the client
 // requesting the access token.
 func TestAuthzCodeOwnership(t *testing.T) {
@@ -219,7 +412,58 @@ func TestAuthzCodeOwnership(t *testing.T) {
 	err := json.Unmarshal(w.Body.Bytes(), &authzErr)
 	ok(t, err)
 	equals(t, "invalid_grant", authzErr.Code)
-	equals(t, "Grant code was generated for a different redirect URI.", authzErr.Description)
+	equals(t, "Grant code was generated for a different client ID.", authzErr.Description)
+}
+
+// TestAuthzCodeRedirectURI tests that the redirect_uri sent in the authorization
+// request is also required at the token endpoint, in accordance with
+// http://tools.ietf.org/html/rfc6749#section-4.1.3
+func TestAuthzCodeRedirectURI(t *testing.T) {
+	cfg, authzCode := getTestAuthzCode(t)
+
+	tests := []struct {
+		redirectURI string
+		status      int
+	}{
+		{"", http.StatusBadRequest},
+		{"https://example.com/oauth2/callback?other=1", http.StatusBadRequest},
+		{"https://example.com/oauth2/callback", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req := AuthzGrantTokenRequestTest(t, "authorization_code", authzCode)
+		req.SetBasicAuth("testclient", "testclient")
+		req.ParseForm()
+		req.Form.Set("redirect_uri", tt.redirectURI)
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, tt.status, w.Code)
+
+		if tt.status != http.StatusOK {
+			authzErr := types.AuthzError{}
+			ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+			equals(t, "invalid_grant", authzErr.Code)
+		}
+	}
+}
+
+// TestAuthzCodeExpiration makes sure expired authorization codes are rejected.
+func TestAuthzCodeExpiration(t *testing.T) {
+	cfg, authzCode := getTestAuthzCode(t)
+	cfg.clock = &fakeClock{now: time.Now().Add(cfg.authzExpiration)}
+
+	req := AuthzGrantTokenRequestTest(t, "authorization_code", authzCode)
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	authzErr := types.AuthzError{}
+	err := json.Unmarshal(w.Body.Bytes(), &authzErr)
+	ok(t, err)
+	equals(t, "invalid_grant", authzErr.Code)
 }
 
 // TestRevokeToken tests happy path for revoking refresh and access tokens.
@@ -247,3 +491,127 @@ func TestRevokeToken(t *testing.T) {
 	RevokeToken(w2, r2, cfg)
 	equals(t, http.StatusOK, w2.Code)
 }
+
+// TestScopePolicy tests that per-scope lifetimes and refresh policies are
+// enforced when issuing and refreshing tokens.
+func TestScopePolicy(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+	SetScopePolicy("payments:write", time.Duration(5)*time.Minute, false)(&cfg)
+
+	queryStr := url.Values{
+		"grant_type": {"password"},
+		"username":   {"test_user"},
+		"password":   {"test_password"},
+		"scope":      {"identity payments:write"},
+	}
+
+	buffer := bytes.NewBufferString(queryStr.Encode())
+	req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w := httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+
+	token := types.Token{}
+	err = json.Unmarshal(w.Body.Bytes(), &token)
+	ok(t, err)
+	equals(t, "300", token.ExpiresIn)
+	equals(t, "", token.RefreshToken)
+
+	// Refresh tokens issued before the policy was in place can't be used either.
+	grant := types.Grant{
+		Scopes: types.Scopes{
+			types.Scope{ID: "payments:write"},
+		},
+	}
+	oldToken, err := provider.GenToken(grant, provider.Client, true, cfg.tokenExpiration)
+	ok(t, err)
+
+	queryStr = url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {oldToken.RefreshToken},
+	}
+
+	buffer = bytes.NewBufferString(queryStr.Encode())
+	req, err = http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+	ok(t, err)
+	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("testclient", "testclient")
+
+	w = httptest.NewRecorder()
+	IssueToken(w, req, cfg)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	authzErr := types.AuthzError{}
+	err = json.Unmarshal(w.Body.Bytes(), &authzErr)
+	ok(t, err)
+	equals(t, "invalid_grant", authzErr.Code)
+}
+
+// baselineProvider only implements the methods of Provider.
+type baselineProvider struct {
+	Provider
+}
+
+// TestRefreshTokenExpiration tests that refreshed access tokens get the
+// expiration of scope policies, whether the provider implements
+// ExpiringRefreshProvider or not.
+func TestRefreshTokenExpiration(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	SetScopePolicy("read", time.Duration(2)*time.Minute, true)(&cfg)
+
+	refresh := func(p Provider) string {
+		cfg.provider = p
+		grant := types.Grant{Scopes: types.Scopes{types.Scope{ID: "read"}}}
+		token, err := provider.GenToken(grant, provider.Client, true, cfg.tokenExpiration)
+		ok(t, err)
+
+		buffer := bytes.NewBufferString(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {token.RefreshToken},
+		}.Encode())
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		refreshed := types.Token{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &refreshed))
+		return refreshed.ExpiresIn
+	}
+
+	equals(t, "120", refresh(provider))
+	equals(t, "120", refresh(baselineProvider{provider}))
+}
+
+// TestMethodOverride tests that token requests trying to override their
+// method are rejected.
+func TestMethodOverride(t *testing.T) {
+	handler := Handler(nil, SetProvider(test.NewProvider(true)), SetTokenExpiration(time.Minute))
+
+	for _, h := range []string{"X-HTTP-Method-Override", "X-HTTP-Method", "X-Method-Override"} {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials&scope=read"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.Header.Set(h, "DELETE")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		equals(t, http.StatusBadRequest, w.Code)
+
+		e := types.AuthzError{}
+		ok(t, json.Unmarshal(w.Body.Bytes(), &e))
+		equals(t, ErrMethodOverride.Code, e.Code)
+	}
+}
@@ -0,0 +1,63 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// renderToken sends a successful token response back to the client, signed
+// as a JWS if the client registered an algorithm for it. See
+// types.Client.TokenResponseSignedAlg. Error responses are never signed.
+func renderToken(w http.ResponseWriter, req *http.Request, cfg config, client types.Client, token types.Token) {
+	if client.TokenResponseSignedAlg == "" {
+		render.JSON(w, render.Options{
+			Status: http.StatusOK,
+			Data:   tokenResponse(cfg, token),
+		})
+		return
+	}
+
+	signed, err := signTokenResponse(req, cfg, client, token)
+	if err != nil {
+		render.JSON(w, render.Options{
+			Status: http.StatusInternalServerError,
+			Data:   serverError(req, cfg, "", err),
+		})
+		return
+	}
+
+	render.JOSE(w, render.Options{
+		Status: http.StatusOK,
+		Data:   signed,
+	})
+}
+
+// signTokenResponse returns the token response as the claims of a JWS,
+// along with the authorization server as issuer and the client as audience,
+// so responses can not be replayed to other clients.
+func signTokenResponse(req *http.Request, cfg config, client types.Client, token types.Token) (string, error) {
+	body, err := json.Marshal(tokenResponse(cfg, token))
+	if err != nil {
+		return "", err
+	}
+
+	claims := make(map[string]interface{})
+	d := json.NewDecoder(bytes.NewReader(body))
+	d.UseNumber()
+	if err := d.Decode(&claims); err != nil {
+		return "", err
+	}
+
+	claims["iss"] = issuerURL(req, cfg)
+	claims["aud"] = client.ID
+	claims["iat"] = now(cfg).Unix()
+	return signJWT(cfg, client.TokenResponseSignedAlg, claims)
+}
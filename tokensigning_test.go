@@ -0,0 +1,76 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestSignedTokenResponses tests that clients registering an algorithm for
+// it get their token responses signed, while others keep getting JSON.
+func TestSignedTokenResponses(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ok(t, err)
+
+	provider := test.NewProvider(true)
+	cfg := setupTest()
+	cfg.provider = provider
+	SetSigningKey(types.SigningKey{ID: "1", Algorithm: jwt.ES256, Signer: key})(&cfg)
+
+	issueToken := func() *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens",
+			bytes.NewBufferString("grant_type=client_credentials&scope=read"))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	w := issueToken()
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "application/json; charset=utf-8", w.Header().Get("Content-Type"))
+
+	provider.Client.TokenResponseSignedAlg = jwt.ES256
+	w = issueToken()
+	equals(t, http.StatusOK, w.Code)
+	equals(t, "application/jose", w.Header().Get("Content-Type"))
+	equals(t, "no-store", w.Header().Get("Cache-Control"))
+
+	token, err := jwt.Parse(w.Body.String())
+	ok(t, err)
+	ok(t, token.Verify(key.Public()))
+
+	var claims struct {
+		jwt.Claims
+		AccessToken string      `json:"access_token"`
+		TokenType   string      `json:"token_type"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+	ok(t, token.Decode(&claims))
+	equals(t, "https://example.com", claims.Issuer)
+	assert(t, claims.Audience.Contains(provider.Client.ID), "expected the client as audience, got %v", claims.Audience)
+	assert(t, claims.AccessToken != "", "expected an access token")
+	equals(t, "bearer", claims.TokenType)
+	equals(t, json.Number("600"), claims.ExpiresIn)
+
+	// Algorithms the authorization server has no key for fail the request.
+	provider.Client.TokenResponseSignedAlg = jwt.RS256
+	w = issueToken()
+	equals(t, http.StatusInternalServerError, w.Code)
+}
@@ -0,0 +1,176 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package types
+
+import "net/http"
+
+// Error codes defined by http://tools.ietf.org/html/rfc6749#section-4.1.2.1,
+// http://tools.ietf.org/html/rfc6749#section-5.2,
+// http://tools.ietf.org/html/rfc6750#section-3.1,
+// http://tools.ietf.org/html/rfc7009#section-2.2.1,
+// http://tools.ietf.org/html/rfc8707#section-2,
+// http://tools.ietf.org/html/rfc8628#section-3.5,
+// http://tools.ietf.org/html/rfc9101#section-6.4 and
+// http://tools.ietf.org/html/rfc9449#section-5, along with the ones this
+// package defines.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorAccessDenied            = "access_denied"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorServerError             = "server_error"
+	ErrorTemporarilyUnavailable  = "temporarily_unavailable"
+	ErrorInvalidToken            = "invalid_token"
+	ErrorInsufficientScope       = "insufficient_scope"
+	ErrorUnsupportedTokenType    = "unsupported_token_type"
+	ErrorInvalidTarget           = "invalid_target"
+	ErrorInvalidRequestObject    = "invalid_request_object"
+	ErrorAuthorizationPending    = "authorization_pending"
+	ErrorSlowDown                = "slow_down"
+	ErrorExpiredToken            = "expired_token"
+	ErrorInvalidDPoPProof        = "invalid_dpop_proof"
+	ErrorNotFound                = "not_found"
+)
+
+// ErrorCodeInfo describes an error code.
+type ErrorCodeInfo struct {
+	// HTTP status of error responses with this code, when they are not
+	// redirected to the client.
+	Status int
+	// Default description, paraphrasing the specification.
+	Description string
+	// Specification defining the error code.
+	Spec string
+}
+
+// ErrorCodes is the catalog of error codes.
+var ErrorCodes = map[string]ErrorCodeInfo{
+	ErrorInvalidRequest: {
+		Status:      http.StatusBadRequest,
+		Description: "The request is missing a required parameter, includes an invalid parameter value, includes a parameter more than once, or is otherwise malformed.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorInvalidClient: {
+		Status:      http.StatusUnauthorized,
+		Description: "Client authentication failed.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorInvalidGrant: {
+		Status:      http.StatusBadRequest,
+		Description: "The provided authorization grant or refresh token is invalid, expired, revoked, does not match the redirection URI used in the authorization request, or was issued to another client.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorUnauthorizedClient: {
+		Status:      http.StatusBadRequest,
+		Description: "The client is not authorized to use this authorization grant type.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorAccessDenied: {
+		Status:      http.StatusForbidden,
+		Description: "The resource owner or authorization server denied the request.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-4.1.2.1",
+	},
+	ErrorUnsupportedResponseType: {
+		Status:      http.StatusBadRequest,
+		Description: "The authorization server does not support obtaining an authorization code or access token using this method.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-4.1.2.1",
+	},
+	ErrorUnsupportedGrantType: {
+		Status:      http.StatusBadRequest,
+		Description: "The authorization grant type is not supported by the authorization server.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorInvalidScope: {
+		Status:      http.StatusBadRequest,
+		Description: "The requested scope is invalid, unknown, malformed, or exceeds the scope granted by the resource owner.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-5.2",
+	},
+	ErrorServerError: {
+		Status:      http.StatusInternalServerError,
+		Description: "The authorization server encountered an unexpected condition that prevented it from fulfilling the request.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-4.1.2.1",
+	},
+	ErrorTemporarilyUnavailable: {
+		Status:      http.StatusServiceUnavailable,
+		Description: "The authorization server is currently unable to handle the request due to a temporary overloading or maintenance.",
+		Spec:        "http://tools.ietf.org/html/rfc6749#section-4.1.2.1",
+	},
+	ErrorInvalidToken: {
+		Status:      http.StatusUnauthorized,
+		Description: "The access token provided is expired, revoked, malformed, or invalid for other reasons.",
+		Spec:        "http://tools.ietf.org/html/rfc6750#section-3.1",
+	},
+	ErrorInsufficientScope: {
+		Status:      http.StatusForbidden,
+		Description: "The request requires higher privileges than provided by the access token.",
+		Spec:        "http://tools.ietf.org/html/rfc6750#section-3.1",
+	},
+	ErrorUnsupportedTokenType: {
+		Status:      http.StatusBadRequest,
+		Description: "The authorization server does not support the revocation of the presented token type.",
+		Spec:        "http://tools.ietf.org/html/rfc7009#section-2.2.1",
+	},
+	ErrorInvalidTarget: {
+		Status:      http.StatusBadRequest,
+		Description: "The requested resource is invalid, missing, unknown, or malformed.",
+		Spec:        "http://tools.ietf.org/html/rfc8707#section-2",
+	},
+	ErrorInvalidRequestObject: {
+		Status:      http.StatusBadRequest,
+		Description: "The request parameter contains an invalid request object.",
+		Spec:        "http://tools.ietf.org/html/rfc9101#section-6.4",
+	},
+	ErrorAuthorizationPending: {
+		Status:      http.StatusBadRequest,
+		Description: "The authorization request is still pending as the end user hasn't yet completed the user-interaction steps.",
+		Spec:        "http://tools.ietf.org/html/rfc8628#section-3.5",
+	},
+	ErrorSlowDown: {
+		Status:      http.StatusBadRequest,
+		Description: "The authorization request is still pending and polling should continue, but the interval must be increased by 5 seconds.",
+		Spec:        "http://tools.ietf.org/html/rfc8628#section-3.5",
+	},
+	ErrorExpiredToken: {
+		Status:      http.StatusBadRequest,
+		Description: "The device code has expired, and the device authorization session has concluded.",
+		Spec:        "http://tools.ietf.org/html/rfc8628#section-3.5",
+	},
+	ErrorInvalidDPoPProof: {
+		Status:      http.StatusBadRequest,
+		Description: "The DPoP proof is invalid.",
+		Spec:        "http://tools.ietf.org/html/rfc9449#section-5",
+	},
+	ErrorNotFound: {
+		Status:      http.StatusNotFound,
+		Description: "The requested resource was not found.",
+	},
+}
+
+// NewAuthzError returns an error with the given code, state and description.
+// The description defaults to the one in ErrorCodes.
+func NewAuthzError(code, state, description string) AuthzError {
+	if description == "" {
+		description = ErrorCodes[code].Description
+	}
+
+	return AuthzError{
+		Code:        code,
+		Description: description,
+		State:       state,
+	}
+}
+
+// ErrorStatus returns the HTTP status of error responses with the given
+// code, defaulting to 400 Bad Request for codes not in ErrorCodes.
+func ErrorStatus(code string) int {
+	if info, ok := ErrorCodes[code]; ok {
+		return info.Status
+	}
+	return http.StatusBadRequest
+}
@@ -6,8 +6,12 @@
 package types
 
 import (
+	"crypto"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"time"
 )
 
@@ -28,6 +32,604 @@ type Client struct {
 	HomepageURL *url.URL `db:"homepage_url" json:"homepage_url"`
 	// Redirect URL registered for this client.
 	RedirectURL *url.URL `db:"redirect_url" json:"redirect_url"`
+	// Additional redirect URLs registered for this client. Clients with
+	// several redirect URLs have to send the redirect_uri parameter in
+	// authorization requests.
+	RedirectURLs []*url.URL `db:"redirect_urls" json:"redirect_urls,omitempty"`
+	// URL of the terms of service resource owners agree to when authorizing
+	// this client. See http://tools.ietf.org/html/rfc7591#section-2
+	TermsOfServiceURL *url.URL `db:"tos_uri" json:"tos_uri,omitempty"`
+	// URL of the client's privacy policy, describing how resource owners'
+	// data is used. See http://tools.ietf.org/html/rfc7591#section-2
+	PolicyURL *url.URL `db:"policy_uri" json:"policy_uri,omitempty"`
+	// Name of the organization publishing the client.
+	Publisher string `json:"publisher,omitempty"`
+	// Whether the authorization server verified the publisher's identity.
+	PublisherVerified bool `db:"publisher_verified" json:"publisher_verified,omitempty"`
+	// Format of the access tokens issued to this client, either
+	// TokenFormatOpaque or TokenFormatJWT. Defaults to TokenFormatOpaque.
+	TokenFormat string `db:"token_format" json:"token_format,omitempty"`
+	// Lifecycle status of the client. Clients with no status are considered
+	// approved.
+	Status ClientStatus `json:"status,omitempty"`
+	// Algorithm ID tokens issued to the client are signed with, as registered
+	// with id_token_signed_response_alg. Either RS256, ES256 or EdDSA.
+	// Defaults to the algorithm of the authorization server's current signing
+	// key. JWT access tokens are signed as set by
+	// oauth2.SetAccessTokenSigningAlgorithm instead.
+	IDTokenSignedResponseAlg string `db:"id_token_signed_response_alg" json:"id_token_signed_response_alg,omitempty"`
+	// Algorithm successful token endpoint responses to the client are signed
+	// with, as a JWS sent with the application/jose content type, so it can
+	// verify they come from the authorization server even through
+	// intercepting proxies. Either RS256, ES256 or EdDSA. Responses are
+	// plain JSON if empty.
+	TokenResponseSignedAlg string `db:"token_response_signed_alg" json:"token_response_signed_alg,omitempty"`
+	// Space-delimited scope given to authorization requests without one, if
+	// default scopes are enabled by the authorization server.
+	DefaultScope string `db:"default_scope" json:"default_scope,omitempty"`
+	// Time at which a soft-deleted client is deleted for good, along with
+	// its grants and tokens.
+	PurgeAt time.Time `db:"purge_at" json:"purge_at,omitempty"`
+	// Public keys the client signs request objects with.
+	PublicKeys []PublicKey `db:"-" json:"-"`
+	// Origins of the client's JavaScript apps, such as
+	// "https://app.example.com", allowed to call the token endpoint from
+	// browsers.
+	AllowedOrigins []string `db:"allowed_origins" json:"allowed_origins,omitempty"`
+	// Native apps of the client, claiming its HTTPS redirect URLs as
+	// Android App Links or iOS Universal Links.
+	AppAssociations []AppAssociation `db:"app_associations" json:"app_associations,omitempty"`
+	// Space-delimited scope the client can be granted on its own behalf,
+	// with the client credentials grant, which is also the scope of
+	// requests without one. Any scope can be requested if empty.
+	ClientCredentialsScope string `db:"client_credentials_scope" json:"client_credentials_scope,omitempty"`
+	// Whether refresh tokens issued to the client are bound to a key it
+	// proves possession of with DPoP proofs, so stolen refresh tokens are
+	// useless without the key. Meant for public clients keeping tokens in
+	// browser storage. http://tools.ietf.org/html/rfc9449#section-5
+	DPoPBoundRefreshTokens bool `db:"dpop_bound_refresh_tokens" json:"dpop_bound_refresh_tokens,omitempty"`
+	// Whether the client is a first-party app of the authorization server,
+	// trusted with the passwords of resource owners. See
+	// oauth2.PasswordGrantFirstParty.
+	FirstParty bool `db:"first_party" json:"first_party,omitempty"`
+}
+
+// Platforms of native apps.
+const (
+	PlatformAndroid = "android"
+	PlatformIOS     = "ios"
+)
+
+// AppAssociation asserts that a client is a native app opened by the HTTPS
+// redirect URLs it registers, instead of the browser.
+// http://tools.ietf.org/html/rfc8252#section-7.2
+type AppAssociation struct {
+	// Either PlatformAndroid or PlatformIOS.
+	Platform string `json:"platform"`
+	// Android package name, or iOS app identifier: "<team id>.<bundle id>".
+	AppID string `db:"app_id" json:"app_id"`
+	// SHA-256 fingerprints of the Android app signing certificates, as
+	// listed in Digital Asset Links documents. Required for Android apps.
+	CertFingerprints []string `db:"cert_fingerprints" json:"cert_fingerprints,omitempty"`
+}
+
+// ErrRedirectURLInvalid is returned for redirect URLs that are not absolute or
+// include a fragment. http://tools.ietf.org/html/rfc6749#section-3.1.2
+var ErrRedirectURLInvalid = errors.New("types: redirect URL has to be absolute and without fragment")
+
+// ParseRedirectURLs parses redirect URLs, typically stored as strings, making
+// sure they are absolute and without fragment.
+func ParseRedirectURLs(rawURLs ...string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(rawURLs))
+	for _, raw := range rawURLs {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+
+		if !validRedirectURL(u) {
+			return nil, ErrRedirectURLInvalid
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+func validRedirectURL(u *url.URL) bool {
+	return u != nil && u.IsAbs() && u.Fragment == ""
+}
+
+// RegisteredRedirectURLs returns every redirect URL registered for the client.
+func (c Client) RegisteredRedirectURLs() []*url.URL {
+	urls := make([]*url.URL, 0, len(c.RedirectURLs)+1)
+	if c.RedirectURL != nil {
+		urls = append(urls, c.RedirectURL)
+	}
+	for _, u := range c.RedirectURLs {
+		if u != nil {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// MatchRedirectURL returns the redirect URL registered for the client that is
+// identical to the given one, if any. URLs are compared as strings, as
+// required by http://tools.ietf.org/html/rfc6749#section-3.1.2.3
+func (c Client) MatchRedirectURL(rawURL string) (*url.URL, bool) {
+	for _, u := range c.RegisteredRedirectURLs() {
+		if u.String() == rawURL {
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// Validate checks that the client has an ID and that its redirect URLs are
+// absolute and without fragment.
+func (c Client) Validate() error {
+	if c.ID == "" {
+		return ErrClientIDRequired
+	}
+
+	for _, u := range c.RegisteredRedirectURLs() {
+		if !validRedirectURL(u) {
+			return ErrRedirectURLInvalid
+		}
+	}
+	return nil
+}
+
+// ClientStatus defines a type for the lifecycle statuses of a client.
+type ClientStatus string
+
+const (
+	// Registered but not reviewed yet, it can not be authorized nor get tokens.
+	ClientPending ClientStatus = "pending"
+	// Reviewed and allowed to operate.
+	ClientApproved ClientStatus = "approved"
+	// No longer allowed to operate, until approved again.
+	ClientSuspended ClientStatus = "suspended"
+	// Soft-deleted, it can not be authorized anymore but its existing tokens
+	// keep working, and can be refreshed, until the client is purged.
+	ClientDeleted ClientStatus = "deleted"
+)
+
+// Access token formats.
+const (
+	// Reference tokens, they have to be looked up in order to be validated.
+	TokenFormatOpaque = "opaque"
+	// Self-contained JWTs signed by the authorization server, resource
+	// servers can validate them on their own.
+	TokenFormatJWT = "jwt"
+)
+
+// ServiceAccount defines a non-interactive client that obtains access tokens
+// by presenting JWT assertions signed with its own private key, in accordance
+// with http://tools.ietf.org/html/rfc7523#section-2.1
+type ServiceAccount struct {
+	// Service account's identifier. Assertions must use it as "iss" and "sub".
+	ID string
+	// Service account's name.
+	Name string
+	// Identifier of the registered key, if set, it has to match the "kid"
+	// header of the assertions.
+	KeyID string `db:"key_id" json:"key_id"`
+	// Public key registered for this service account. Either *rsa.PublicKey
+	// or *ecdsa.PublicKey.
+	PublicKey crypto.PublicKey `db:"public_key" json:"-"`
+	// Maximum set of scopes this service account can be granted.
+	Scopes Scopes
+}
+
+// ResourceServer defines a protected resource registered with the
+// authorization server, in order to restrict tokens to it and let it
+// introspect them.
+type ResourceServer struct {
+	// Resource server's identifier, used along with Secret to introspect tokens.
+	ID string `json:"id"`
+	// Resource server's name.
+	Name string `json:"name,omitempty"`
+	// Absolute URI identifying the resource server, clients request tokens
+	// for it with the "resource" parameter. http://tools.ietf.org/html/rfc8707#section-2
+	Audience string `json:"audience"`
+	// Introspection secret. Only set when registering the resource server,
+	// providers should store a hash of it.
+	Secret string `json:"secret,omitempty"`
+	// Scopes that can be granted for this resource server. Any scope if empty.
+	Scopes Scopes `json:"scopes,omitempty"`
+	// Claims the resource server receives when introspecting tokens. Every
+	// claim if nil. See oauth2.SetIntrospectionClaims.
+	IntrospectionClaims []string `db:"introspection_claims" json:"introspection_claims,omitempty"`
+}
+
+// User identifies the resource owner.
+type User struct {
+	// User's identifier.
+	ID string
+	// User's name.
+	Name string
+	// Methods the user authenticated with in the current session, such as
+	// "pwd", "otp", "hwk" for WebAuthn or "fed" for an upstream identity
+	// provider. See http://tools.ietf.org/html/rfc8176#section-2
+	AMR []string
+	// Issuer of the upstream identity provider the user logged in with, if any.
+	IdentityProvider string
+}
+
+// SigningKey is a private key used by the authorization server to sign JWTs.
+type SigningKey struct {
+	// Key identifier, sent along in the "kid" header of signed tokens.
+	// Defaults to the JWK thumbprint of the key.
+	ID string
+	// Signing algorithm. Either RS256, ES256 or EdDSA.
+	Algorithm string
+	// Signer holding the private key. It does not need to be in memory, it
+	// can be backed by a HSM or a remote key management service.
+	Signer crypto.Signer `json:"-"`
+}
+
+// PublicKey is a public key clients and resource servers can use to verify
+// JWTs signed by the authorization server.
+type PublicKey struct {
+	// Key identifier, matching the "kid" header of signed tokens.
+	ID string
+	// Signing algorithm.
+	Algorithm string
+	// Either *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey.
+	Key crypto.PublicKey `json:"-"`
+	// Time after which tokens signed with the key are no longer accepted and
+	// the key is no longer published. Zero means it does not expire.
+	ExpiresAt time.Time `json:"-"`
+}
+
+// DecryptionKey is a private key used by the authorization server to decrypt
+// request objects encrypted by clients.
+type DecryptionKey struct {
+	// Key identifier, matching the "kid" header of encrypted request objects.
+	// Defaults to the JWK thumbprint of the key.
+	ID string
+	// Key encryption algorithm. Either RSA-OAEP or RSA-OAEP-256.
+	Algorithm string
+	// Decrypter holding the RSA private key. Like signers, it can be backed
+	// by a HSM or a remote key management service.
+	Decrypter crypto.Decrypter `json:"-"`
+}
+
+// ConsentDecision is what the resource owner decided about an authorization
+// request.
+type ConsentDecision string
+
+// Consent decisions.
+const (
+	ConsentApproved ConsentDecision = "approved"
+	ConsentDenied   ConsentDecision = "denied"
+)
+
+// ConsentReceipt records the approval, or denial, of an authorization request
+// by the resource owner.
+type ConsentReceipt struct {
+	// Receipt's identifier.
+	ID string `json:"id"`
+	// Resource owner that decided on the request.
+	UserID string `db:"user_id" json:"user_id"`
+	// Client that asked for authorization.
+	ClientID string `db:"client_id" json:"client_id"`
+	// Scopes approved, or denied, by the resource owner.
+	Scopes Scopes `json:"scopes"`
+	// Whether the request was approved or denied. Receipts recorded before
+	// denials were, have no decision and are approvals.
+	Decision ConsentDecision `json:"decision,omitempty"`
+	// Time the authorization request was decided on.
+	IssuedAt time.Time `db:"issued_at" json:"issued_at"`
+	// Version of the consent policy shown to the resource owner.
+	PolicyVersion string `db:"policy_version" json:"policy_version,omitempty"`
+	// The receipt signed by the authorization server, as a JWT.
+	Receipt string `json:"receipt"`
+}
+
+// Consent holds the scopes a resource owner has approved so far for a
+// client. There is at most one per resource owner and client.
+type Consent struct {
+	// Resource owner that gave consent.
+	UserID string `db:"user_id" json:"user_id"`
+	// Client that was authorized.
+	ClientID string `db:"client_id" json:"client_id"`
+	// Every scope approved by the resource owner for the client.
+	Scopes Scopes `json:"scopes"`
+	// Time the client was first authorized.
+	GrantedAt time.Time `db:"granted_at" json:"granted_at"`
+	// Time consent was last given, for instance to additional scopes.
+	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+}
+
+// CredentialEventType defines a type for events that invalidate everything
+// a resource owner has authorized so far.
+type CredentialEventType string
+
+const (
+	EventPasswordChanged  CredentialEventType = "password_changed"
+	EventUserDeactivated  CredentialEventType = "user_deactivated"
+	EventCredentialsReset CredentialEventType = "credentials_reset"
+)
+
+// CredentialEvent is reported by the host application when a resource owner's
+// credentials change or their account is deactivated.
+type CredentialEvent struct {
+	// Type of event. Host applications can use their own types besides the
+	// predefined ones.
+	Type CredentialEventType `json:"type"`
+	// Resource owner affected by the event.
+	UserID string `db:"user_id" json:"user_id"`
+	// Time the event occurred.
+	OccurredAt time.Time `db:"occurred_at" json:"occurred_at"`
+}
+
+// Stats are approximate figures about the usage of the authorization server,
+// for capacity planning and dashboards.
+type Stats struct {
+	// Active access and refresh tokens, by client ID.
+	ActiveTokensByClient map[string]int64 `json:"active_tokens_by_client"`
+	// Grants issued per day, oldest first.
+	GrantsPerDay []DailyCount `json:"grants_per_day"`
+	// Scopes granted the most, with the number of times they were granted.
+	TopScopes []ScopeCount `json:"top_scopes"`
+}
+
+// TokenCacheStats are counters of the token cache of a resource server, to
+// monitor its hit rate.
+type TokenCacheStats struct {
+	// Lookups answered with a valid token from the cache.
+	Hits int64 `json:"hits"`
+	// Lookups answered from the cache with a token known not to exist.
+	NegativeHits int64 `json:"negative_hits"`
+	// Lookups sent to the provider.
+	Misses int64 `json:"misses"`
+	// Tokens removed to make room for others.
+	Evictions int64 `json:"evictions"`
+	// Tokens currently cached, valid or not.
+	Size int `json:"size"`
+}
+
+// HitRate returns the fraction of lookups answered from the cache.
+func (s TokenCacheStats) HitRate() float64 {
+	total := s.Hits + s.NegativeHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.NegativeHits) / float64(total)
+}
+
+// DailyCount is a count for a given day.
+type DailyCount struct {
+	// Day in YYYY-MM-DD format, UTC.
+	Day string `json:"day"`
+	// Count for the day.
+	Count int64 `json:"count"`
+}
+
+// ScopeCount is a count for a given scope.
+type ScopeCount struct {
+	// Scope's identifier.
+	Scope string `json:"scope"`
+	// Count for the scope.
+	Count int64 `json:"count"`
+}
+
+// ClientCheck is the outcome of revalidating the endpoints registered by a
+// client, such as its redirect URLs, which stop working when domains expire
+// or change hands.
+type ClientCheck struct {
+	// Client's identifier.
+	ClientID string `db:"client_id" json:"client_id"`
+	// When the endpoints were last checked.
+	CheckedAt time.Time `db:"checked_at" json:"checked_at"`
+	// Endpoints that could not be reached. Empty if all of them were.
+	Failures []EndpointFailure `db:"failures" json:"failures,omitempty"`
+	// Since when the endpoints of the client have been failing. Zero if
+	// they all work.
+	FailingSince time.Time `db:"failing_since" json:"failing_since,omitempty"`
+	// Whether the client was suspended for its endpoints failing for too long.
+	Suspended bool `json:"suspended,omitempty"`
+}
+
+// EndpointFailure is an endpoint registered by a client that could not be
+// reached.
+type EndpointFailure struct {
+	// Client metadata the endpoint was registered as, such as
+	// "redirect_uris" or "logo_uri".
+	Metadata string `json:"metadata"`
+	// Endpoint's URL.
+	URL string `json:"url"`
+	// Why it could not be reached, such as a DNS or TLS error.
+	Error string `json:"error"`
+}
+
+// Simulation is the outcome of evaluating a hypothetical token request
+// against the configuration of the authorization server, without issuing
+// anything.
+type Simulation struct {
+	// Whether the request would succeed.
+	Allowed bool `json:"allowed"`
+	// Error the request would be answered with, if denied.
+	Error *AuthzError `json:"error,omitempty"`
+	// Scope that would be granted, once defaults are applied.
+	Scope string `json:"scope,omitempty"`
+	// Lifetime of the access token, in seconds, if allowed.
+	ExpiresIn int64 `json:"expires_in,omitempty"`
+	// Whether a refresh token would come along with the access token.
+	RefreshToken bool `json:"refresh_token"`
+	// Checks evaluated, in order. Evaluation stops at the first one failing.
+	Trace []SimulationStep `json:"trace"`
+}
+
+// SimulationStep is a check evaluated by a simulation.
+type SimulationStep struct {
+	// Check's name, such as "client_status" or "policy".
+	Check string `json:"check"`
+	// Whether the request passed it.
+	Passed bool `json:"passed"`
+	// What was found.
+	Detail string `json:"detail,omitempty"`
+}
+
+// DeprecatedUse counts the requests of a client relying on a deprecated flow
+// or endpoint.
+type DeprecatedUse struct {
+	// Grant type of the flow, or path of the endpoint.
+	Target string `json:"target"`
+	// Client's ID, empty if unknown.
+	ClientID string `json:"client_id,omitempty"`
+	// Number of requests.
+	Count int64 `json:"count"`
+	// Time of the last request.
+	LastUsed time.Time `json:"last_used"`
+}
+
+// Capabilities describes what an authorization server supports, as
+// configured, so applications can check it is configured as expected.
+type Capabilities struct {
+	// Grant types accepted by the token and authorization endpoints.
+	GrantTypes []string `json:"grant_types"`
+	// Response types accepted by the authorization endpoint.
+	ResponseTypes []string `json:"response_types"`
+	// How authorization responses can be returned to clients.
+	ResponseModes []string `json:"response_modes"`
+	// How clients authenticate to the token endpoint.
+	TokenEndpointAuthMethods []string `json:"token_endpoint_auth_methods"`
+	// Formats access tokens can be issued in, TokenFormatOpaque or TokenFormatJWT.
+	TokenFormats []string `json:"token_formats"`
+	// PKCE code challenge methods accepted.
+	CodeChallengeMethods []string `json:"code_challenge_methods"`
+	// Policies enabled.
+	Policies CapabilityPolicies `json:"policies"`
+}
+
+// CapabilityPolicies tells which policies an authorization server enforces.
+type CapabilityPolicies struct {
+	// Whether authorization code requests require a PKCE code challenge.
+	PKCERequired bool `json:"pkce_required"`
+	// Whether only first-party clients can use the password grant.
+	PasswordGrantFirstPartyOnly bool `json:"password_grant_first_party_only"`
+	// Whether refresh tokens are rotated when refreshing access tokens.
+	RefreshTokenRotation bool `json:"refresh_token_rotation"`
+	// Whether scopes already approved skip the authorization form.
+	RememberConsent bool `json:"remember_consent"`
+	// Whether grants are quarantined when redirect URLs suspiciously change.
+	RedirectQuarantine bool `json:"redirect_quarantine"`
+	// Whether codes and refresh tokens are looked up by their hash.
+	SecretHashing bool `json:"secret_hashing"`
+	// Whether configurations unsafe in production are refused.
+	Strict bool `json:"strict"`
+	// Whether new grants and tokens are refused for the time being.
+	ReadOnly bool `json:"read_only"`
+	// Whether the behavior of OAuth 2.1 is enforced.
+	OAuth21 bool `json:"oauth21"`
+}
+
+// AuditEventType defines a type for security relevant events.
+type AuditEventType string
+
+const (
+	// A client's redirect URL was changed. Details include "old_redirect_url",
+	// "new_redirect_url", "suspicious" and "quarantined".
+	AuditRedirectURLChanged AuditEventType = "client.redirect_url_changed"
+	// A leaked token was reported and revoked. Details include "partner",
+	// "token_type", "source" and "url", where the token was found.
+	AuditTokenLeaked AuditEventType = "token.leaked"
+	// The oldest refresh tokens of a client and resource owner were revoked
+	// for going over the quota. Details include "quota" and "revoked", the
+	// number of refresh tokens revoked.
+	AuditTokenQuotaExceeded AuditEventType = "token.quota_exceeded"
+	// The resource owner denied an authorization request. Details include
+	// "scope", the scope requested.
+	AuditConsentDenied AuditEventType = "consent.denied"
+	// The resource owner revoked the authorization of a client from the
+	// grants page. Details include "scope", the scope approved so far.
+	AuditGrantRevoked AuditEventType = "grant.revoked"
+	// A refresh token was used again after being rotated, meaning it was
+	// likely stolen. Details include "family_id", "generation" of the reused
+	// token, "current_generation" and "access_token_count" of its family, if
+	// known, "scope", "presented_by", the client that used it, and
+	// "family_revoked". Its severity is AuditSeverityHigh.
+	AuditRefreshTokenReused AuditEventType = "token.refresh_reused"
+	// A resource owner logged in through an upstream identity provider.
+	// Details include "upstream", "issuer" and "subject", the identifier of
+	// the resource owner at the upstream.
+	AuditUpstreamLogin AuditEventType = "user.upstream_login"
+	// A local account was created for a resource owner logging in through
+	// an upstream identity provider for the first time. Details include
+	// "upstream", "issuer" and "subject".
+	AuditUserProvisioned AuditEventType = "user.provisioned"
+	// The endpoints registered by a client started failing revalidation, or
+	// the client was suspended for it. Details include "failures", the
+	// number of endpoints failing, and "suspended".
+	AuditClientEndpointsFailing AuditEventType = "client.endpoints_failing"
+)
+
+// AuditSeverity tells how urgently an audit event calls for a response.
+type AuditSeverity string
+
+const (
+	// Events part of the audit trail. It is the severity of events without one.
+	AuditSeverityInfo AuditSeverity = "info"
+	// Events that are likely an attack in progress, such as
+	// AuditRefreshTokenReused, for incident response tooling to react to.
+	AuditSeverityHigh AuditSeverity = "high"
+)
+
+// AuditEvent describes a security relevant event.
+type AuditEvent struct {
+	// Type of event.
+	Type AuditEventType `json:"type"`
+	// How urgent the event is. Empty means AuditSeverityInfo.
+	Severity AuditSeverity `json:"severity,omitempty"`
+	// Client involved, if any.
+	ClientID string `db:"client_id" json:"client_id,omitempty"`
+	// Resource owner involved, if any.
+	UserID string `db:"user_id" json:"user_id,omitempty"`
+	// Time the event occurred.
+	Time time.Time `json:"time"`
+	// Correlation ID of the request that caused the event.
+	RequestID string `db:"request_id" json:"request_id,omitempty"`
+	// Additional information, depending on the type of event.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// NotificationType defines a type for events resource owners are notified of.
+type NotificationType string
+
+const (
+	// The resource owner authorized a client for the first time.
+	NotificationNewClient NotificationType = "client.authorized"
+	// A token was issued to the resource owner from a device not seen before.
+	NotificationNewDevice NotificationType = "device.new"
+)
+
+// Notification describes an event host applications can let resource owners
+// know about, for instance, with a "new app connected to your account" email.
+type Notification struct {
+	// Type of notification.
+	Type NotificationType `json:"type"`
+	// Resource owner to notify.
+	UserID string `json:"user_id"`
+	// Client authorized or issued a token.
+	ClientID string `json:"client_id"`
+	// Client's name, as shown to the resource owner.
+	ClientName string `json:"client_name"`
+	// Scopes authorized or granted.
+	Scopes Scopes `json:"scopes"`
+	// IP address the request came from.
+	IP string `json:"ip"`
+	// User agent the request came from.
+	UserAgent string `json:"user_agent,omitempty"`
+	// Approximate location of the IP address, such as "Lisbon, Portugal", if
+	// a Locator is set.
+	Location string `json:"location,omitempty"`
+	// Time the event occurred.
+	Time time.Time `json:"time"`
+	// Correlation ID of the request that caused the notification.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // Scope defines a type for manipulating OAuth2 scopes.
@@ -53,6 +655,16 @@ func (s Scopes) Encode() string {
 	return scope[:len(scope)-1] // removes last space
 }
 
+// Contains returns whether a scope with the given identifier is part of the group.
+func (s Scopes) Contains(id string) bool {
+	for _, v := range s {
+		if v.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // GrantStatus defines a type for possible statuses of an authorization grant.
 type GrantStatus string
 
@@ -60,6 +672,9 @@ const (
 	GrantRevoked GrantStatus = "revoked"
 	GrantExpired GrantStatus = "expired"
 	GrantUsed    GrantStatus = "used"
+	// Issued before a suspicious change to the client's registration, it can
+	// not be exchanged anymore.
+	GrantQuarantined GrantStatus = "quarantined"
 )
 
 // Grant represents an authorization grant code.
@@ -70,12 +685,116 @@ type Grant struct {
 	ExpiresIn time.Time `db:"expires_in" json:"expires_in"`
 	// Client's identifier to which this code was emitted to.
 	ClientID string `db:"client_id" json:"client_id"`
+	// Resource owner the tokens are issued to, as authenticated by the
+	// provider's session or by this package. Empty for grants clients get on
+	// their own behalf, such as client credentials. Providers are expected
+	// to copy it to tokens.
+	UserID string `db:"user_id" json:"-"`
 	// Redirect URL associated with the authorization code.
 	RedirectURL *url.URL `db:"redirect_url" json:"redirect_url"`
+	// redirect_uri parameter sent in the authorization request, if any. The
+	// token request has to send the same value.
+	RequestedRedirectURI string `db:"requested_redirect_uri" json:"-"`
 	// List of authorization scopes for which this authorization code was generated.
 	Scopes Scopes
+	// Resource servers the tokens issued with this grant are restricted to,
+	// identified by their audience URI. Unrestricted if empty.
+	Audience []string `json:"-"`
 	// The status of this authorization grant code
 	Status GrantStatus `json:"-"`
+	// PKCE code challenge the code is bound to, if any. http://tools.ietf.org/html/rfc7636
+	CodeChallenge string `db:"code_challenge" json:"-"`
+	// Method used to derive the code challenge, either "plain" or "S256".
+	CodeChallengeMethod string `db:"code_challenge_method" json:"-"`
+	// Extension parameters of the authorization request, by name.
+	Extensions map[string]string `db:"extensions" json:"-"`
+	// How the resource owner authenticated before approving the request.
+	// See User.AMR and User.IdentityProvider.
+	AMR              []string `db:"amr" json:"-"`
+	IdentityProvider string   `db:"idp" json:"-"`
+	// JWK thumbprint of the key the client proved possession of when
+	// exchanging the grant, if any. Set by this package right before
+	// issuing tokens. See Token.KeyThumbprint.
+	KeyThumbprint string `db:"jkt" json:"-"`
+	// Token family the tokens issued with this grant join, set by this
+	// package when refreshing access tokens without rotating refresh tokens.
+	// Empty starts a new family. Providers are expected to copy it to tokens.
+	FamilyID string `db:"family_id" json:"-"`
+}
+
+// DeviceAuthorizationStatus defines a type for possible statuses of a device
+// authorization.
+type DeviceAuthorizationStatus string
+
+const (
+	// Waiting for the resource owner to enter the user code.
+	DeviceAuthorizationPending DeviceAuthorizationStatus = "pending"
+	// Approved by the resource owner, the device can exchange it for tokens.
+	DeviceAuthorizationApproved DeviceAuthorizationStatus = "approved"
+	// Denied by the resource owner.
+	DeviceAuthorizationDenied DeviceAuthorizationStatus = "denied"
+)
+
+// DeviceAuthorization is an authorization request of a device unable to
+// redirect resource owners to the authorization endpoint, such as a TV or a
+// CLI, approved by the resource owner on another device by entering the
+// user code. http://tools.ietf.org/html/rfc8628
+type DeviceAuthorization struct {
+	// Code the device polls the token endpoint with.
+	DeviceCode string `db:"device_code" json:"-"`
+	// Short code the resource owner enters on the verification page.
+	UserCode string `db:"user_code" json:"-"`
+	// Client the device authorization was issued to.
+	ClientID string `db:"client_id" json:"client_id"`
+	// Requested scopes.
+	Scopes Scopes `db:"scopes" json:"scopes"`
+	// Expiration time of both codes.
+	ExpiresAt time.Time `db:"expires_at" json:"expires_at"`
+	// Minimum number of seconds the device has to wait between polls.
+	Interval int `db:"interval" json:"-"`
+	// When the device last polled the token endpoint.
+	LastPolledAt time.Time `db:"last_polled_at" json:"-"`
+	// Whether the resource owner approved or denied it yet.
+	Status DeviceAuthorizationStatus `db:"status" json:"status"`
+	// Resource owner who approved or denied it, and how they authenticated.
+	UserID           string   `db:"user_id" json:"user_id,omitempty"`
+	AMR              []string `db:"amr" json:"-"`
+	IdentityProvider string   `db:"idp" json:"-"`
+}
+
+// Errors violating the invariants of grants and tokens.
+var (
+	ErrGrantCodeRequired  = errors.New("types: grant code is required")
+	ErrTokenValueRequired = errors.New("types: token value is required")
+	ErrTokenTypeRequired  = errors.New("types: token type is required")
+	ErrClientIDRequired   = errors.New("types: client ID is required")
+	ErrExpirationRequired = errors.New("types: expiration is required")
+)
+
+// NewGrant returns an authorization grant bound to the given client and its
+// redirect URL, expiring at the given time.
+func NewGrant(code string, client Client, scopes Scopes, expiresAt time.Time) (Grant, error) {
+	g := Grant{
+		Code:        code,
+		ClientID:    client.ID,
+		RedirectURL: client.RedirectURL,
+		Scopes:      scopes,
+		ExpiresIn:   expiresAt,
+	}
+	return g, g.Validate()
+}
+
+// Validate checks that the grant has a code, is bound to a client and expires.
+func (g Grant) Validate() error {
+	switch {
+	case g.Code == "":
+		return ErrGrantCodeRequired
+	case g.ClientID == "":
+		return ErrClientIDRequired
+	case g.ExpiresIn.IsZero():
+		return ErrExpirationRequired
+	}
+	return nil
 }
 
 // TokenStatus defines a type for possible statuses of an authorization grant.
@@ -84,24 +803,144 @@ type TokenStatus string
 const (
 	TokenExpired TokenStatus = "expired"
 	TokenRevoked TokenStatus = "revoked"
+	// The refresh token was replaced by rotating it. Providers keeping
+	// rotated refresh tokens with this status let reuses of them be
+	// detected, as they are a sign of theft.
+	TokenRotated TokenStatus = "rotated"
 )
 
 // Token represents an access token.
 type Token struct {
 	// client associated to this token
 	ClientID string `db:"client_id" json:"-"`
+	// Resource owner that authorized this token, if any.
+	UserID string `db:"user_id" json:"-"`
 	// The actual token value
 	Value string `json:"access_token"`
 	// Whether it is a bearer, MAC, SAML, etc
 	Type string `json:"token_type"`
 	// Expiration time for this token
 	ExpiresIn string `db:"expires_in" json:"expires_in"`
+	// Time at which this token expires. Zero means that only Status is
+	// taken into account.
+	ExpiresAt time.Time `db:"expires_at" json:"-"`
 	// Refresh token optionally emitted along with access token
 	RefreshToken string `db:"refresh_token" json:"refresh_token,omitempty"`
+	// Type of the token issued by a token exchange, sent back in the token
+	// response. http://tools.ietf.org/html/rfc8693#section-2.2.1
+	IssuedTokenType string `db:"-" json:"issued_token_type,omitempty"`
 	// Authorization scope allowed for this token
 	Scopes Scopes `json:"-"`
+	// Resource servers this token is restricted to, identified by their
+	// audience URI. Unrestricted if empty. Providers are expected to copy it
+	// from the grant the token is issued with.
+	Audience []string `json:"-"`
+	// Extension parameters of the authorization request the token is issued
+	// from, by name. Providers are expected to copy them from the grant, and
+	// from the refresh token when refreshing it.
+	Extensions map[string]string `db:"extensions" json:"-"`
+	// How the resource owner authenticated before authorizing the client,
+	// sent as the amr and idp claims of JWTs and introspection responses.
+	// Providers are expected to copy them from the grant, and from the
+	// refresh token when refreshing it.
+	AMR              []string `db:"amr" json:"-"`
+	IdentityProvider string   `db:"idp" json:"-"`
 	// The status of this token
 	Status TokenStatus `json:"-"`
+	// Family of the token. Every access and refresh token issued by rotating
+	// the refresh tokens of a grant belongs to the same family.
+	FamilyID string `db:"family_id" json:"-"`
+	// Number of times the refresh token of the family was rotated when this
+	// token was issued, starting at 0.
+	Generation int `db:"generation" json:"-"`
+	// JWK thumbprint of the key the refresh token is bound to, if any. It
+	// can only be refreshed with a DPoP proof signed by that key. Providers
+	// are expected to copy it from the grant, and from the refresh token
+	// when refreshing it. http://tools.ietf.org/html/rfc7638
+	KeyThumbprint string `db:"jkt" json:"-"`
+}
+
+// UnmarshalJSON decodes token responses, with expires_in sent either as a
+// number, as required by http://tools.ietf.org/html/rfc6749#section-5.1, or
+// as a string, as some authorization servers do.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	type token Token
+	v := struct {
+		*token
+		ExpiresIn json.Number `json:"expires_in"`
+	}{token: (*token)(t)}
+
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	t.ExpiresIn = v.ExpiresIn.String()
+	return nil
+}
+
+// TokenFamily describes the tokens issued by rotating the refresh tokens of
+// a grant, for incident response.
+type TokenFamily struct {
+	// Family's identifier.
+	ID string `json:"id"`
+	// Client the family was issued to.
+	ClientID string `db:"client_id" json:"client_id"`
+	// Resource owner that authorized the family, if any.
+	UserID string `db:"user_id" json:"user_id,omitempty"`
+	// Generation of the current refresh token.
+	Generation int `json:"generation"`
+	// Number of access tokens issued to the family.
+	AccessTokenCount int `db:"access_token_count" json:"access_token_count"`
+	// Status of the family, revoked families can not be refreshed anymore.
+	Status TokenStatus `json:"status,omitempty"`
+}
+
+// NewToken returns a bearer access token issued to the given client at the
+// given time, expiring after the given duration.
+func NewToken(value string, client Client, scopes Scopes, issuedAt time.Time, expiration time.Duration) (Token, error) {
+	if expiration <= 0 {
+		return Token{}, ErrExpirationRequired
+	}
+
+	t := Token{
+		Value:     value,
+		Type:      "bearer",
+		ClientID:  client.ID,
+		Scopes:    scopes,
+		ExpiresIn: strconv.FormatFloat(expiration.Seconds(), 'f', -1, 64),
+		ExpiresAt: issuedAt.Add(expiration),
+	}
+	return t, t.Validate()
+}
+
+// Validate checks that the token has a value and a type, and is bound to a
+// client.
+func (t Token) Validate() error {
+	switch {
+	case t.Value == "":
+		return ErrTokenValueRequired
+	case t.Type == "":
+		return ErrTokenTypeRequired
+	case t.ClientID == "":
+		return ErrClientIDRequired
+	}
+	return nil
+}
+
+// TokenUsage describes how an access token has been used.
+type TokenUsage struct {
+	// Identifier of the token as known by the provider. For self-contained
+	// tokens, their JWT ID.
+	TokenID string `db:"token_id" json:"-"`
+	// Client the token was issued to.
+	ClientID string `db:"client_id" json:"client_id"`
+	// Resource owner that authorized the token, if known.
+	UserID string `db:"user_id" json:"user_id,omitempty"`
+	// Time the token was last used.
+	LastUsedAt time.Time `db:"last_used_at" json:"last_used_at"`
+	// Number of times the token was used.
+	UseCount int64 `db:"use_count" json:"use_count"`
+	// IP address the token was last used from.
+	LastIP string `db:"last_ip" json:"last_ip"`
 }
 
 type AuthzError struct {
@@ -109,6 +948,11 @@ type AuthzError struct {
 	Description string `json:"error_description"`
 	URI         string `json:"error_uri,omitempty"`
 	State       string `json:"state,omitempty"`
+	// Correlation ID of the request that failed.
+	RequestID string `json:"request_id,omitempty"`
+	// Identifies the description in message catalogs, for errors sharing
+	// the same code. Defaults to Code.
+	MessageID string `json:"-"`
 }
 
 func (a *AuthzError) Error() string {
@@ -0,0 +1,296 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/internal/jwt"
+	"github.com/hooklift/oauth2/internal/render"
+	"github.com/hooklift/oauth2/types"
+)
+
+// UsageProvider is an optional interface that providers can implement in
+// order to keep track of token usage. It is required by SetUsageTracking,
+// SetIdleTimeout and SetRefreshIdleTimeout.
+type UsageProvider interface {
+	// RecordUsage stores a batch of token uses. UseCount is the number of
+	// uses within the batch, to be added to the stored count, and the other
+	// fields replace the stored ones.
+	RecordUsage(usage []types.TokenUsage) error
+
+	// TokenUsage returns the usage of a token. An empty types.TokenUsage is
+	// expected if it was never used.
+	TokenUsage(tokenID string) (types.TokenUsage, error)
+
+	// UserTokenUsage returns the usage of every token of a resource owner.
+	UserTokenUsage(userID string) ([]types.TokenUsage, error)
+}
+
+// ErrUsageProviderRequired is returned when enforcing idle timeouts with a
+// provider that does not implement UsageProvider.
+var ErrUsageProviderRequired = errors.New("oauth2: provider does not implement oauth2.UsageProvider")
+
+// SetUsageTracking records the use of access tokens accepted by AuthzHandler,
+// so resource owners can see through the self-service grants API when their
+// tokens were last used and from where. Uses are recorded in the background
+// and sent to the provider in batches, once the given number of tokens were
+// used or the given interval elapses, whichever comes first. It requires the
+// provider to implement UsageProvider.
+func SetUsageTracking(batchSize int, interval time.Duration) option {
+	return func(c *config) {
+		c.usageTracking.batchSize = batchSize
+		c.usageTracking.interval = interval
+	}
+}
+
+// SetIdleTimeout makes access tokens that were not used for the given
+// duration be treated as expired, by AuthzHandler as well as by the
+// introspection endpoint. Idle time is counted from issuance for tokens never
+// used, if Handler is given this option too. As uses are recorded in batches,
+// the timeout is expected to be much longer than the SetUsageTracking
+// interval. It requires the provider to implement UsageProvider.
+func SetIdleTimeout(timeout time.Duration) option {
+	return func(c *config) {
+		c.idleTimeout = timeout
+	}
+}
+
+// SetRefreshIdleTimeout makes refresh tokens that were not used for the given
+// duration be treated as expired. As refresh tokens are rotated on every use,
+// idle time is counted from issuance. Providers are expected to set
+// types.Token.RefreshToken when looking up refresh tokens, so they can be told
+// apart from access tokens. It requires the provider to implement UsageProvider.
+func SetRefreshIdleTimeout(timeout time.Duration) option {
+	return func(c *config) {
+		c.refreshIdleTimeout = timeout
+	}
+}
+
+// usageRecorder merges token uses and sends them to the provider in batches.
+// It is a Worker of the ResourceServer returned by AuthzHandler.
+type usageRecorder struct {
+	provider  UsageProvider
+	batchSize int
+	interval  time.Duration
+	uses      chan types.TokenUsage
+	pending   map[string]types.TokenUsage
+	started   sync.Once
+	stopped   sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func newUsageRecorder(provider UsageProvider, batchSize int, interval time.Duration) *usageRecorder {
+	return &usageRecorder{
+		provider:  provider,
+		batchSize: batchSize,
+		interval:  interval,
+		uses:      make(chan types.TokenUsage, batchSize),
+		pending:   make(map[string]types.TokenUsage),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// record queues a use without blocking the request. Uses are dropped if the
+// queue is full, as usage is informative.
+func (r *usageRecorder) record(use types.TokenUsage) {
+	select {
+	case r.uses <- use:
+	default:
+		log.Printf("[WARN] Usage queue is full, dropping use of a token of client %s", use.ClientID)
+	}
+}
+
+// Start sends queued uses to the provider in the background, until Shutdown.
+func (r *usageRecorder) Start() {
+	r.started.Do(func() {
+		go r.run()
+	})
+}
+
+// Shutdown sends the uses still queued to the provider and stops.
+func (r *usageRecorder) Shutdown(ctx context.Context) error {
+	// Never started, nothing was sent in the background.
+	r.started.Do(func() {
+		close(r.done)
+	})
+	r.stopped.Do(func() {
+		close(r.stop)
+	})
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *usageRecorder) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case use := <-r.uses:
+			r.add(use)
+			if len(r.pending) >= r.batchSize {
+				r.flush()
+			}
+		case <-ticker.C:
+			r.flush()
+		case <-r.stop:
+			for {
+				select {
+				case use := <-r.uses:
+					r.add(use)
+				default:
+					r.flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// add merges a use with the pending uses of the same token.
+func (r *usageRecorder) add(use types.TokenUsage) {
+	if prev, ok := r.pending[use.TokenID]; ok {
+		use.UseCount += prev.UseCount
+		if prev.LastUsedAt.After(use.LastUsedAt) {
+			use.LastUsedAt, use.LastIP = prev.LastUsedAt, prev.LastIP
+		}
+	}
+	r.pending[use.TokenID] = use
+}
+
+// flush sends pending uses to the provider.
+func (r *usageRecorder) flush() {
+	if len(r.pending) == 0 {
+		return
+	}
+
+	batch := make([]types.TokenUsage, 0, len(r.pending))
+	for _, use := range r.pending {
+		batch = append(batch, use)
+	}
+	r.pending = make(map[string]types.TokenUsage)
+
+	if err := r.provider.RecordUsage(batch); err != nil {
+		log.Printf("[ERROR] Error recording usage of %d tokens: %+v", len(batch), err)
+	}
+}
+
+// tokenID returns the identifier of an already validated token, which for
+// self-contained tokens is their JWT ID.
+func tokenID(token types.Token) string {
+	if !isJWT(token.Value) {
+		return token.Value
+	}
+
+	parsed, err := jwt.Parse(token.Value)
+	if err != nil {
+		return token.Value
+	}
+	return parsed.Claims.ID
+}
+
+// tokenUse describes the use of a token by the given request.
+func tokenUse(req *http.Request, cfg config, token types.Token) types.TokenUsage {
+	return types.TokenUsage{
+		TokenID:    tokenID(token),
+		ClientID:   token.ClientID,
+		UserID:     token.UserID,
+		LastUsedAt: now(cfg),
+		UseCount:   1,
+		LastIP:     clientIP(req),
+	}
+}
+
+// recordIssuance records the issuance of a token as its last activity, if
+// idle timeouts apply to it. Failures are only logged, tokens not recorded
+// are then only subject to their expiration.
+func recordIssuance(req *http.Request, cfg config, token types.Token) {
+	var issued []types.TokenUsage
+	if cfg.idleTimeout > 0 {
+		use := tokenUse(req, cfg, token)
+		use.UseCount = 0
+		issued = append(issued, use)
+	}
+
+	if cfg.refreshIdleTimeout > 0 && token.RefreshToken != "" {
+		use := tokenUse(req, cfg, token)
+		use.TokenID = secretID(cfg, token.RefreshToken)
+		use.UseCount = 0
+		issued = append(issued, use)
+	}
+
+	if len(issued) == 0 {
+		return
+	}
+
+	provider, ok := asUsageProvider(cfg.provider)
+	if !ok {
+		log.Printf("[ERROR] request_id=%s Error recording token issuance: %+v", RequestID(req), ErrUsageProviderRequired)
+		return
+	}
+
+	if err := provider.RecordUsage(issued); err != nil {
+		log.Printf("[ERROR] request_id=%s Error recording token issuance: %+v", RequestID(req), err)
+	}
+}
+
+// idle tells whether the token with the given identifier was not used within
+// the given timeout. Tokens with no recorded activity are never idle.
+func idle(cfg config, p Provider, id string, timeout time.Duration) (bool, error) {
+	if timeout <= 0 {
+		return false, nil
+	}
+
+	provider, ok := asUsageProvider(p)
+	if !ok {
+		return false, ErrUsageProviderRequired
+	}
+
+	usage, err := provider.TokenUsage(id)
+	if err != nil {
+		return false, err
+	}
+
+	if usage.LastUsedAt.IsZero() {
+		return false, nil
+	}
+	return now(cfg).Sub(usage.LastUsedAt) > timeout, nil
+}
+
+// listUsage returns the usage of the resource owner's tokens.
+func listUsage(w http.ResponseWriter, req *http.Request, cfg config, userID string) {
+	usage := []types.TokenUsage{}
+	if provider, ok := asUsageProvider(cfg.provider); ok {
+		var err error
+		usage, err = provider.UserTokenUsage(userID)
+		if err != nil {
+			render.JSON(w, render.Options{
+				Status: http.StatusInternalServerError,
+				Data:   serverError(req, cfg, "", err),
+			})
+			return
+		}
+	}
+
+	render.JSON(w, render.Options{
+		Status: http.StatusOK,
+		Data:   usage,
+	})
+}
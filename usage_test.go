@@ -0,0 +1,184 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package oauth2
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/providers/test"
+	"github.com/hooklift/oauth2/types"
+)
+
+// TestUsageRecorder tests that uses of the same token are merged before
+// being sent to the provider.
+func TestUsageRecorder(t *testing.T) {
+	provider := test.NewProvider(true)
+	start := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	r := newUsageRecorder(provider, 10, time.Minute)
+	r.record(types.TokenUsage{TokenID: "a", UseCount: 1, LastUsedAt: start.Add(time.Second), LastIP: "10.0.0.2"})
+	r.record(types.TokenUsage{TokenID: "a", UseCount: 1, LastUsedAt: start, LastIP: "10.0.0.1"})
+	r.record(types.TokenUsage{TokenID: "b", UseCount: 1, LastUsedAt: start})
+
+	for i := 0; i < 3; i++ {
+		r.add(<-r.uses)
+	}
+	r.flush()
+
+	equals(t, 2, len(provider.Usage))
+	equals(t, int64(2), provider.Usage["a"].UseCount)
+	equals(t, "10.0.0.2", provider.Usage["a"].LastIP)
+	equals(t, start.Add(time.Second), provider.Usage["a"].LastUsedAt)
+
+	// Counts are added up across batches.
+	r.add(types.TokenUsage{TokenID: "a", UseCount: 1, LastUsedAt: start.Add(time.Minute)})
+	r.flush()
+	equals(t, int64(3), provider.Usage["a"].UseCount)
+	equals(t, 0, len(r.pending))
+}
+
+// TestIdleTimeout tests that access tokens left unused for too long are
+// rejected before they expire.
+func TestIdleTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	provider.AccessTokens["idle-token"] = types.Token{
+		ClientID:  provider.Client.ID,
+		Value:     "idle-token",
+		Type:      "bearer",
+		ExpiresAt: clock.now.Add(24 * time.Hour),
+		Scopes:    types.Scopes{types.Scope{ID: "read"}},
+	}
+
+	handler := AuthzHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("success!"))
+	}), provider, SetClock(clock), SetIdleTimeout(time.Hour))
+
+	use := func() int {
+		req, err := http.NewRequest("GET", "https://example.com/protected_resource", nil)
+		ok(t, err)
+		req.Header.Set("Authorization", "Bearer idle-token")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Never used, only its expiration counts.
+	equals(t, http.StatusOK, use())
+
+	ok(t, provider.RecordUsage([]types.TokenUsage{{TokenID: "idle-token", UseCount: 1, LastUsedAt: clock.now}}))
+	clock.Advance(30 * time.Minute)
+	equals(t, http.StatusOK, use())
+
+	clock.Advance(time.Hour)
+	equals(t, http.StatusUnauthorized, use())
+}
+
+// TestIdleTokensExpiration tests that idle time is counted from issuance,
+// that idle access tokens are reported as inactive by the introspection
+// endpoint and that idle refresh tokens can not be used anymore.
+func TestIdleTokensExpiration(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)}
+	provider := test.NewProvider(true)
+	provider.Clock = clock
+
+	cfg := setupTest()
+	cfg.provider = provider
+	SetClock(clock)(&cfg)
+	SetTokenExpiration(72 * time.Hour)(&cfg)
+	SetIdleTimeout(time.Hour)(&cfg)
+	SetRefreshIdleTimeout(24 * time.Hour)(&cfg)
+
+	w := httptest.NewRecorder()
+	IssueToken(w, passwordGrantRequest(t, "test_password"), cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	token := types.Token{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+	equals(t, clock.now, provider.Usage[token.Value].LastUsedAt)
+	equals(t, clock.now, provider.Usage[token.RefreshToken].LastUsedAt)
+
+	introspect := func(value string) bool {
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/introspect",
+			bytes.NewBufferString(url.Values{"token": {value}}.Encode()))
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IntrospectToken(w, req, cfg)
+		equals(t, http.StatusOK, w.Code)
+
+		claims := make(map[string]interface{})
+		ok(t, json.Unmarshal(w.Body.Bytes(), &claims))
+		return claims["active"].(bool)
+	}
+
+	refresh := func(value string) *httptest.ResponseRecorder {
+		buffer := bytes.NewBufferString(url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {value},
+		}.Encode())
+		req, err := http.NewRequest("POST", "https://example.com/oauth2/tokens", buffer)
+		ok(t, err)
+		req.Header.Set("Content-type", "application/x-www-form-urlencoded")
+		req.SetBasicAuth("testclient", "testclient")
+
+		w := httptest.NewRecorder()
+		IssueToken(w, req, cfg)
+		return w
+	}
+
+	assert(t, introspect(token.Value), "access token should be active")
+
+	clock.Advance(2 * time.Hour)
+	assert(t, !introspect(token.Value), "idle access token should be inactive")
+	assert(t, introspect(token.RefreshToken), "refresh token should still be active")
+
+	w = refresh(token.RefreshToken)
+	equals(t, http.StatusOK, w.Code)
+	ok(t, json.Unmarshal(w.Body.Bytes(), &token))
+
+	clock.Advance(25 * time.Hour)
+	w = refresh(token.RefreshToken)
+	equals(t, http.StatusBadRequest, w.Code)
+
+	authzErr := types.AuthzError{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &authzErr))
+	equals(t, "invalid_grant", authzErr.Code)
+}
+
+// TestUsageGrantsAPI tests that resource owners can see how their tokens
+// have been used.
+func TestUsageGrantsAPI(t *testing.T) {
+	cfg := setupTest()
+	provider := test.NewProvider(true)
+	cfg.provider = provider
+
+	ok(t, provider.RecordUsage([]types.TokenUsage{
+		{TokenID: "a", ClientID: provider.Client.ID, UserID: "test_user", UseCount: 4, LastIP: "10.0.0.1"},
+		{TokenID: "b", ClientID: provider.Client.ID, UserID: "someone_else", UseCount: 1},
+	}))
+
+	req, err := http.NewRequest("GET", "https://example.com/oauth2/grants/usage", nil)
+	ok(t, err)
+
+	w := httptest.NewRecorder()
+	ListGrants(w, req, cfg)
+	equals(t, http.StatusOK, w.Code)
+
+	usage := []types.TokenUsage{}
+	ok(t, json.Unmarshal(w.Body.Bytes(), &usage))
+	equals(t, 1, len(usage))
+	equals(t, int64(4), usage[0].UseCount)
+	equals(t, "10.0.0.1", usage[0].LastIP)
+}
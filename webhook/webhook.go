@@ -0,0 +1,140 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+// Package webhook delivers the audit events of the oauth2 package to an
+// HTTP endpoint, so incident response tooling can react to them. A
+// Dispatcher implements oauth2.Auditor.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+// SignatureHeader is the header carrying the signature of each delivery:
+// "sha256=" followed by the hex encoded HMAC-SHA256 of the body, keyed with
+// the shared secret.
+const SignatureHeader = "X-Oauth2-Signature"
+
+// SeverityHeader is the header carrying the severity of each event, so high
+// severity ones, such as reused refresh tokens, can be routed to incident
+// response without decoding the body. Events without one are sent as
+// types.AuditSeverityInfo.
+const SeverityHeader = "X-Oauth2-Severity"
+
+// Dispatcher sends events to a webhook endpoint in the background, one POST
+// request with a JSON encoded types.AuditEvent per event.
+type Dispatcher struct {
+	url    string
+	secret []byte
+	client *http.Client
+	events chan types.AuditEvent
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewDispatcher returns a Dispatcher delivering events to the given URL,
+// signed with the given secret. Up to queueSize events wait to be delivered,
+// events beyond that are dropped and logged.
+func NewDispatcher(url, secret string, queueSize int) *Dispatcher {
+	d := &Dispatcher{
+		url:    url,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: time.Duration(10) * time.Second},
+		events: make(chan types.AuditEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Audit queues an event for delivery, without blocking.
+func (d *Dispatcher) Audit(event types.AuditEvent) {
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("[WARN] Webhook queue is full, dropping %s event", event.Type)
+	}
+}
+
+// Close delivers the queued events and stops the dispatcher. Events audited
+// afterwards cause a panic.
+func (d *Dispatcher) Close() {
+	d.Shutdown(context.Background())
+}
+
+// Shutdown is like Close, but it stops waiting for the queued events to be
+// delivered when the context is done. It makes Dispatcher an oauth2.Worker,
+// so it is stopped along with the handler auditing to it. See oauth2.SetWorker.
+func (d *Dispatcher) Shutdown(ctx context.Context) error {
+	d.once.Do(func() {
+		close(d.events)
+	})
+
+	select {
+	case <-d.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for event := range d.events {
+		if err := d.deliver(event); err != nil {
+			log.Printf("[ERROR] request_id=%s Error delivering %s event: %+v", event.RequestID, event.Type, err)
+		}
+	}
+}
+
+func (d *Dispatcher) deliver(event types.AuditEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", d.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(d.secret, body))
+
+	severity := event.Severity
+	if severity == "" {
+		severity = types.AuditSeverityInfo
+	}
+	req.Header.Set(SeverityHeader, string(severity))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook: unexpected status %d", res.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of a body, for receivers to
+// verify deliveries with.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
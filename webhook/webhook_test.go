@@ -0,0 +1,84 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, version 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hooklift/oauth2/types"
+)
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var received []types.AuditEvent
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if req.Header.Get(SignatureHeader) != "sha256="+Sign([]byte("s3cr3t"), body) {
+			t.Errorf("invalid signature %q", req.Header.Get(SignatureHeader))
+		}
+
+		var event types.AuditEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatal(err)
+		}
+
+		severity := types.AuditSeverityInfo
+		if event.Severity != "" {
+			severity = event.Severity
+		}
+		if req.Header.Get(SeverityHeader) != string(severity) {
+			t.Errorf("unexpected severity %q for %s", req.Header.Get(SeverityHeader), event.ClientID)
+		}
+
+		mu.Lock()
+		received = append(received, event)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	d := NewDispatcher(ts.URL, "s3cr3t", 10)
+	d.Audit(types.AuditEvent{Type: types.AuditRedirectURLChanged, ClientID: "a"})
+	d.Audit(types.AuditEvent{Type: types.AuditRefreshTokenReused, Severity: types.AuditSeverityHigh, ClientID: "b"})
+	d.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(received))
+	}
+	if received[0].ClientID != "a" || received[1].ClientID != "b" {
+		t.Errorf("unexpected events %+v", received)
+	}
+}
+
+func TestDispatcherShutdownTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-unblock
+	}))
+	defer ts.Close()
+	defer close(unblock)
+
+	d := NewDispatcher(ts.URL, "s3cr3t", 10)
+	d.Audit(types.AuditEvent{Type: types.AuditRedirectURLChanged, ClientID: "a"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected shutdown to time out, got %v", err)
+	}
+}